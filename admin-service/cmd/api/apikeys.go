@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"admin-service/data"
+	"apikey"
+)
+
+// ListAPIKeys returns the authenticated admin's active API keys, without their secrets.
+func (app *Config) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing admin ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := app.Models.APIKey.GetAllForAdmin(adminID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d API keys", len(keys)),
+		Data:    keys,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// CreateAPIKey creates a named, scoped API key. The key itself is only returned in this response.
+func (app *Config) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing admin ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload apikey.Request
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = requestPayload.Validate(time.Now())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	key, err := app.Models.APIKey.Insert(adminID, requestPayload.Name, requestPayload.Scopes, requestPayload.ExpiresAt)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logRequest("create_api_key", fmt.Sprintf("Admin %d created API key %d (%s)", adminID, key.ID, key.Prefix))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "API key created, store it now as it will not be shown again",
+		Data:    key,
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// RevokeAPIKey revokes one of the authenticated admin's API keys.
+func (app *Config) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing admin ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "key_id"), 10, 64)
+	if err != nil || keyID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid key ID"), http.StatusBadRequest)
+		return
+	}
+
+	app.revokeAPIKey(w, adminID, keyID)
+}
+
+// ListAdminAPIKeys returns the active API keys of any admin within the caller's tenant.
+func (app *Config) ListAdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	adminID, err := strconv.ParseInt(chi.URLParam(r, "admin_id"), 10, 64)
+	if err != nil || adminID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid admin ID"), http.StatusBadRequest)
+		return
+	}
+
+	keys, err := app.Models.APIKey.GetAllForAdmin(adminID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d API keys for admin %d", len(keys), adminID),
+		Data:    keys,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// RevokeAdminAPIKey revokes a single API key of any admin within the caller's tenant.
+func (app *Config) RevokeAdminAPIKey(w http.ResponseWriter, r *http.Request) {
+	adminID, err := strconv.ParseInt(chi.URLParam(r, "admin_id"), 10, 64)
+	if err != nil || adminID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid admin ID"), http.StatusBadRequest)
+		return
+	}
+
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "key_id"), 10, 64)
+	if err != nil || keyID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid key ID"), http.StatusBadRequest)
+		return
+	}
+
+	app.revokeAPIKey(w, adminID, keyID)
+}
+
+// RevokeAllAdminAPIKeys revokes every API key of the admin given in the admin_id query
+// parameter, or of all admins when it is omitted. Tenant admins only revoke the keys of
+// admins within their tenant.
+func (app *Config) RevokeAllAdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantOf(r)
+
+	var adminID int64
+	if idStr := r.URL.Query().Get("admin_id"); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || id < 1 {
+			app.errorJSON(w, fmt.Errorf("invalid admin ID"), http.StatusBadRequest)
+			return
+		}
+		adminID = id
+	}
+
+	revoked, err := app.Models.APIKey.RevokeAll(adminID, tenant)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	requesterID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	scope := "all admins"
+	if tenant != "" {
+		scope = fmt.Sprintf("all admins of tenant %s", tenant)
+	}
+	if adminID != 0 {
+		scope = fmt.Sprintf("admin %d", adminID)
+	}
+
+	err = app.logRequest("revoke_all_api_keys", fmt.Sprintf("Admin %d revoked %d API keys of %s", requesterID, revoked, scope))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Revoked %d API keys of %s", revoked, scope),
+		Data:    map[string]int64{"revoked": revoked},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+func (app *Config) revokeAPIKey(w http.ResponseWriter, adminID, keyID int64) {
+	err := app.Models.APIKey.Revoke(keyID, adminID)
+	if err != nil {
+		if errors.Is(err, data.ErrAPIKeyNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logRequest("revoke_api_key", fmt.Sprintf("API key %d of admin %d revoked", keyID, adminID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("API key %d revoked successfully", keyID),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
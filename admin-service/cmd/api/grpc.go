@@ -3,14 +3,18 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 
-	"admin-service/admins"
 	"admin-service/data"
+	"password"
+	"proto/admins"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type AdminServer struct {
@@ -20,7 +24,6 @@ type AdminServer struct {
 
 func (s *AdminServer) ValidateAdmin(ctx context.Context, req *admins.ValidateAdminRequest) (*admins.ValidateAdminResponse, error) {
 	email := req.GetEmail()
-	password := req.GetPassword()
 
 	// Retrieve admin from the database by email
 	admin, err := s.Models.Admin.GetAdminByEmail(email)
@@ -35,7 +38,13 @@ func (s *AdminServer) ValidateAdmin(ctx context.Context, req *admins.ValidateAdm
 	}
 
 	// Verify password
-	valid, err := s.Models.Admin.PasswordMatches(admin.ID, password)
+	valid, err := s.Models.Admin.PasswordMatches(ctx, admin.ID, req.GetPassword())
+	if errors.Is(err, password.ErrBusy) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if ctx.Err() != nil {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
 	if err != nil || !valid {
 		return &admins.ValidateAdminResponse{
 			IsValid: false,
@@ -51,6 +60,52 @@ func (s *AdminServer) ValidateAdmin(ctx context.Context, req *admins.ValidateAdm
 	}, nil
 }
 
+// GetAdminTenants tells auth-service which tenants an admin's tokens can be for.
+func (s *AdminServer) GetAdminTenants(ctx context.Context, req *admins.GetAdminTenantsRequest) (*admins.GetAdminTenantsResponse, error) {
+	tenants, err := s.Models.Admin.Tenants(req.GetAdminId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "admin not found")
+		}
+		return nil, err
+	}
+
+	return &admins.GetAdminTenantsResponse{
+		Platform: tenants.Platform,
+		Tenants:  tenants.Tenants,
+	}, nil
+}
+
+// SAMLProviderServer lets auth-service look up the tenants' SAML identity providers.
+type SAMLProviderServer struct {
+	admins.SAMLProviderServiceServer
+	Models data.Models
+}
+
+func (s *SAMLProviderServer) GetSAMLProvider(ctx context.Context, req *admins.GetSAMLProviderRequest) (*admins.SAMLProvider, error) {
+	provider, err := s.Models.SAML.GetByTenant(req.GetTenant())
+	if err != nil {
+		if errors.Is(err, data.ErrSAMLProviderNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, err
+	}
+	if !provider.Enabled {
+		return nil, status.Errorf(codes.NotFound, "single sign-on is disabled for tenant %s", provider.Tenant)
+	}
+
+	return &admins.SAMLProvider{
+		Tenant:             provider.Tenant,
+		EntityId:           provider.EntityID,
+		SsoUrl:             provider.SSOURL,
+		Certificate:        provider.Certificate,
+		EmailAttribute:     provider.EmailAttribute,
+		FirstNameAttribute: provider.FirstNameAttribute,
+		LastNameAttribute:  provider.LastNameAttribute,
+		TrustEmail:         provider.TrustEmail,
+	}, nil
+}
+
 // gRPCListen starts the gRPC server for the admin service
 func (app *Config) gRPCListen() {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", gRPCPort))
@@ -60,6 +115,7 @@ func (app *Config) gRPCListen() {
 
 	s := grpc.NewServer()
 	admins.RegisterAdminServiceServer(s, &AdminServer{Models: app.Models})
+	admins.RegisterSAMLProviderServiceServer(s, &SAMLProviderServer{Models: app.Models})
 
 	log.Printf("gRPC Server started on port %s", gRPCPort)
 
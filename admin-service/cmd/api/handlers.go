@@ -9,10 +9,14 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+
 	"admin-service/data"
+	"password"
 )
 
-// Register handles the registration of new admin
+// Register handles the registration of new admin. Only invited emails can register, and
+// they manage the tenant they were invited to, or the platform.
 func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Email     string `json:"email"`
@@ -37,21 +41,44 @@ func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	invite, err := app.Models.NewAdmin.GetNewAdmin(requestPayload.Email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			app.errorJSON(w, fmt.Errorf("%s has not been invited to become an admin", requestPayload.Email), http.StatusForbidden)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.checkPassword(w, requestPayload.Password, password.Identity{Email: requestPayload.Email, Username: requestPayload.AdminName}) {
+		return
+	}
+
 	newAdmin := data.Admin{
 		Email:        requestPayload.Email,
 		AdminName:    requestPayload.AdminName,
 		PasswordHash: requestPayload.Password,
+		Platform:     invite.Tenant == "",
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
 
 	adminID, err := app.Models.Admin.InsertAdmin(newAdmin)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.hashError(w, err)
 		return
 	}
 
-	err = app.logRequest("registration", fmt.Sprintf("Admin %s registered", newAdmin.Email))
+	if invite.Tenant != "" {
+		err = app.Models.Admin.AddTenant(int64(adminID), invite.Tenant)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	err = app.logRequest("registration", fmt.Sprintf("Admin %s registered for %s", newAdmin.Email, tenantName(invite.Tenant)))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -207,12 +234,22 @@ func (app *Config) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.Admin.UpdateAdminPassword(requestPayload.Email, requestPayload.Password)
+	admin, err := app.Models.Admin.GetAdminByEmail(requestPayload.Email)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	if !app.checkPassword(w, requestPayload.Password, password.Identity{Email: admin.Email, Username: admin.AdminName}) {
+		return
+	}
+
+	err = app.Models.Admin.UpdateAdminPassword(requestPayload.Email, requestPayload.Password)
+	if err != nil {
+		app.hashError(w, err)
+		return
+	}
+
 	payload := jsonResponse{
 		Error:   false,
 		Message: "Password changed successfully",
@@ -226,8 +263,8 @@ func (app *Config) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 
 // UpdateAdmin handles the update of an admin's information based on their ID passed in the URL.
 func (app *Config) UpdateAdmin(w http.ResponseWriter, r *http.Request) {
-	vars := r.URL.Query()
-	idStr := vars.Get("admin_id")
+	// The ID is the one RequireAdminInScope checked
+	idStr := chi.URLParam(r, "admin_id")
 
 	adminID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil || adminID < 1 {
@@ -278,8 +315,7 @@ func (app *Config) UpdateAdmin(w http.ResponseWriter, r *http.Request) {
 
 // DeleteAdmin handles the deletion of an admin based on their ID passed in the URL.
 func (app *Config) DeleteAdmin(w http.ResponseWriter, r *http.Request) {
-	vars := r.URL.Query()
-	idStr := vars.Get("admin_id")
+	idStr := chi.URLParam(r, "admin_id")
 
 	adminID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil || adminID < 1 {
@@ -310,10 +346,13 @@ func (app *Config) DeleteAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// InsertNewAdminHandler handles the insertion of a new admin email.
+// InsertNewAdminHandler handles the insertion of a new admin email. Tenant admins invite
+// admins for their own tenant; platform admins name the tenant, or leave it out to invite
+// another platform admin.
 func (app *Config) AddNewAdmin(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Email string `json:"email"`
+		Email  string `json:"email"`
+		Tenant string `json:"tenant"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -322,7 +361,19 @@ func (app *Config) AddNewAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.NewAdmin.InsertNewAdmin(requestPayload.Email)
+	if tenant := tenantOf(r); tenant != "" {
+		if requestPayload.Tenant != "" && requestPayload.Tenant != tenant {
+			app.errorJSON(w, fmt.Errorf("you can only invite admins for tenant %s", tenant), http.StatusForbidden)
+			return
+		}
+		requestPayload.Tenant = tenant
+	}
+	if requestPayload.Tenant != "" && !data.ValidTenant(requestPayload.Tenant) {
+		app.errorJSON(w, fmt.Errorf("tenant must be 1-63 lowercase letters, digits or dashes"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.NewAdmin.InsertNewAdmin(requestPayload.Email, requestPayload.Tenant)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
@@ -330,7 +381,7 @@ func (app *Config) AddNewAdmin(w http.ResponseWriter, r *http.Request) {
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("New admin email %s inserted successfully", requestPayload.Email),
+		Message: fmt.Sprintf("New admin email %s inserted successfully for %s", requestPayload.Email, tenantName(requestPayload.Tenant)),
 	}
 
 	err = app.writeJSON(w, http.StatusOK, payload)
@@ -341,7 +392,7 @@ func (app *Config) AddNewAdmin(w http.ResponseWriter, r *http.Request) {
 
 // GetAllNewAdminsHandler retrieves all new admin emails and returns them as JSON.
 func (app *Config) GetAllNewAdmins(w http.ResponseWriter, r *http.Request) {
-	newAdmins, err := app.Models.NewAdmin.GetAllNewAdmins()
+	newAdmins, err := app.Models.NewAdmin.GetAllNewAdmins(tenantOf(r))
 	if err != nil {
 		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
@@ -373,7 +424,7 @@ func (app *Config) DeleteNewAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.Models.NewAdmin.DeleteNewAdmin(requestPayload.Email)
+	err = app.Models.NewAdmin.DeleteNewAdmin(requestPayload.Email, tenantOf(r))
 	if err != nil {
 		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
@@ -10,6 +10,8 @@ import (
 
 	"admin-service/data"
 	"admin-service/keys"
+	"password"
+	"proto/sessions"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -25,9 +27,13 @@ var counts int64
 
 // Config i a structure of a application
 type Config struct {
-	DB     *sql.DB
-	Models data.Models
-	KeyManager  *keys.KeyManager 
+	DB             *sql.DB
+	Models         data.Models
+	KeyManager     *keys.KeyManager
+	PasswordPolicy *password.Policy
+
+	// Sessions is auth-service, which knows which tokens were logged out
+	Sessions sessions.SessionServiceClient
 }
 
 func main() {
@@ -43,17 +49,36 @@ func main() {
 		log.Fatalf("failed to initialize key manager: %v", err)
 	}
 
+	passwordPolicy, err := password.PolicyFromEnv()
+	if err != nil {
+		log.Fatalf("failed to load password policy: %v", err)
+	}
+
+	passwordHasher, err := password.HasherFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure password hashing: %v", err)
+	}
+	stats := passwordHasher.Pool.Stats()
+	log.Printf("Hashing passwords with %s, %d at a time and up to %d waiting", passwordHasher.Algorithm, stats.Workers, stats.QueueSize)
+
 	// connect to DB
 	conn := connectToDB()
 	if conn == nil {
 		log.Panic("Can't connect to Postgres!")
 	}
 
+	sessionClient, err := dialAuthService()
+	if err != nil {
+		log.Fatalf("failed to configure auth-service client: %v", err)
+	}
+
 	// set up config
 	app := Config{
-		DB:     conn,
-		Models: data.New(conn),
-		KeyManager:  keyManager,
+		DB:             conn,
+		Models:         data.New(conn, passwordHasher),
+		KeyManager:     keyManager,
+		PasswordPolicy: passwordPolicy,
+		Sessions:       sessionClient,
 	}
 
 	// Start HTTP server
@@ -69,6 +94,16 @@ func main() {
 		}
 	}()
 
+	// Serve metrics on the internal network
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		go func() {
+			err := passwordHasher.Pool.ServeMetrics(addr)
+			if err != nil {
+				log.Panic(err)
+			}
+		}()
+	}
+
 	// Start gRPC server
 	go app.gRPCListen()
 
@@ -98,7 +133,7 @@ func connectToDB() *sql.DB {
 	for {
 		connection, err := openDB(dsn)
 		if err != nil {
-			fmt.Errorf("Error opening database: %w", err)
+			log.Printf("Error opening database: %v", err)
 
 			counts++
 		} else {
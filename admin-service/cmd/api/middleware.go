@@ -2,11 +2,20 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
+
+	"admin-service/data"
+	"apikey"
+	"stepup"
 )
 
 type contextKey string
@@ -14,6 +23,16 @@ type contextKey string
 // ContextKeyUserID is key to store userID
 const ContextKeyUserID = contextKey("userID")
 
+// scopeAuthentication is the scope of access tokens; refresh tokens have another
+const scopeAuthentication = "authentication"
+
+// ContextKeyAPIKey is key to store the API key a request was authenticated with, if any
+const ContextKeyAPIKey = contextKey("apiKey")
+
+// ContextKeyTenant is key to store the tenant the admin acts in; platform admins acting
+// across tenants have none
+const ContextKeyTenant = contextKey("tenant")
+
 // AuthMiddleware checks Authentication Header for both users and admins
 func (app *Config) AuthMiddleware(requiredRole string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -31,6 +50,12 @@ func (app *Config) AuthMiddleware(requiredRole string) func(http.Handler) http.H
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
+			// API keys are accepted alongside JWTs
+			if strings.HasPrefix(tokenString, data.APIKeyPrefix) {
+				app.authenticateAPIKey(w, r, next, tokenString, requiredRole)
+				return
+			}
+
 			// Parse the token and verify it
 			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 				if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
@@ -60,6 +85,12 @@ func (app *Config) AuthMiddleware(requiredRole string) func(http.Handler) http.H
 				return
 			}
 
+			// Refresh tokens are only for getting new access tokens from auth-service
+			if scope, _ := claims["scope"].(string); scope != scopeAuthentication {
+				app.errorJSON(w, fmt.Errorf("invalid token scope"), http.StatusUnauthorized)
+				return
+			}
+
 			// Check the role
 			role, ok := claims["role"].(string)
 			if !ok || role != requiredRole {
@@ -74,11 +105,152 @@ func (app *Config) AuthMiddleware(requiredRole string) func(http.Handler) http.H
 				return
 			}
 
+			// Logouts are recorded by auth-service
+			revoked, err := app.tokenRevoked(r.Context(), tokenString)
+			if err != nil {
+				app.errorJSON(w, fmt.Errorf("unable to check whether the token was revoked"), http.StatusServiceUnavailable)
+				return
+			}
+			if revoked {
+				app.errorJSON(w, fmt.Errorf("token has been revoked"), http.StatusUnauthorized)
+				return
+			}
+
 			// Store the user ID in the context
 			ctx := context.WithValue(r.Context(), ContextKeyUserID, int64(userID))
 
+			if authTime, ok := claims["auth_time"].(float64); ok {
+				ctx = stepup.WithAuthTime(ctx, time.Unix(int64(authTime), 0))
+			}
+
+			// The admin may have lost the tenant, or the platform, since the token was issued
+			tenant, _ := claims["tenant"].(string)
+			tenants, err := app.Models.Admin.Tenants(int64(userID))
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					app.errorJSON(w, fmt.Errorf("admin not found"), http.StatusUnauthorized)
+					return
+				}
+				app.errorJSON(w, err)
+				return
+			}
+			if !tenants.Manages(tenant) {
+				app.errorJSON(w, fmt.Errorf("you no longer manage %s, switch to another tenant", tenantName(tenant)), http.StatusForbidden)
+				return
+			}
+			ctx = context.WithValue(ctx, ContextKeyTenant, tenant)
+
 			// Call the next handler with the new context
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// authenticateAPIKey serves the request on behalf of the owner of an API key. Read-only keys
+// are limited to safe methods.
+func (app *Config) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plainText, requiredRole string) {
+	if requiredRole != "admin" {
+		app.errorJSON(w, fmt.Errorf("unauthorized: API keys cannot be used with role %s", requiredRole), http.StatusForbidden)
+		return
+	}
+
+	key, err := app.Models.APIKey.Authenticate(plainText)
+	if err != nil {
+		if errors.Is(err, data.ErrAPIKeyNotFound) {
+			app.errorJSON(w, fmt.Errorf("invalid API key"), http.StatusUnauthorized)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	if scope := apikey.RequiredScope(r.Method); !key.HasScope(scope) {
+		app.errorJSON(w, fmt.Errorf("API key does not have the %s scope", scope), http.StatusForbidden)
+		return
+	}
+
+	// API keys act in the tenant their owner logs in to
+	tenants, err := app.Models.Admin.Tenants(key.AdminID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	var tenant string
+	if !tenants.Platform {
+		if len(tenants.Tenants) == 0 {
+			app.errorJSON(w, fmt.Errorf("the owner of this API key does not belong to any tenant"), http.StatusForbidden)
+			return
+		}
+		tenant = tenants.Tenants[0]
+	}
+
+	ctx := context.WithValue(r.Context(), ContextKeyUserID, key.AdminID)
+	ctx = context.WithValue(ctx, ContextKeyAPIKey, key)
+	ctx = context.WithValue(ctx, ContextKeyTenant, tenant)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// tenantOf returns the tenant the request's admin acts in, or "" for platform admins.
+func tenantOf(r *http.Request) string {
+	tenant, _ := r.Context().Value(ContextKeyTenant).(string)
+	return tenant
+}
+
+// tenantName names a tenant in messages, with the platform as the empty tenant.
+func tenantName(tenant string) string {
+	if tenant == "" {
+		return "the platform"
+	}
+	return "tenant " + tenant
+}
+
+// RequirePlatform guards actions that span tenants, for platform admins only.
+func (app *Config) RequirePlatform(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenantOf(r) != "" {
+			app.errorJSON(w, fmt.Errorf("only platform admins can do this"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdminInScope guards the routes for one admin, given as the admin_id URL parameter:
+// tenant admins only reach admins who manage nothing but their tenant. Others are answered
+// as if they did not exist.
+func (app *Config) RequireAdminInScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminID, err := strconv.ParseInt(chi.URLParam(r, "admin_id"), 10, 64)
+		if err != nil || adminID < 1 {
+			app.errorJSON(w, fmt.Errorf("invalid admin ID"), http.StatusBadRequest)
+			return
+		}
+
+		within, err := app.Models.Admin.WithinTenant(adminID, tenantOf(r))
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		if !within {
+			app.errorJSON(w, fmt.Errorf("admin %d not found", adminID), http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// DenyAPIKey rejects requests authenticated with an API key. It guards key management and
+// account actions, so a leaked key cannot be used to mint new keys or take over the account.
+func (app *Config) DenyAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(ContextKeyAPIKey).(*data.APIKey); ok {
+			app.errorJSON(w, fmt.Errorf("action not allowed with an API key"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+
+	"admin-service/data"
+	"admin-service/keys"
+	"proto/sessions"
+	"stepup"
+)
+
+// newMockApp returns the service with a mocked database.
+func newMockApp(t *testing.T) (*Config, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	return &Config{DB: db, Models: data.New(db, nil)}, mock
+}
+
+// expectTenants expects an admin's tenants to be loaded. A nil tenants stands for an admin
+// that does not exist.
+func expectTenants(mock sqlmock.Sqlmock, adminID int64, platform bool, tenants []string) {
+	query := mock.ExpectQuery(`SELECT platform FROM admins WHERE id = \$1`).WithArgs(adminID)
+	if tenants == nil {
+		query.WillReturnRows(sqlmock.NewRows([]string{"platform"}))
+		return
+	}
+	query.WillReturnRows(sqlmock.NewRows([]string{"platform"}).AddRow(platform))
+
+	rows := sqlmock.NewRows([]string{"tenant"})
+	for _, tenant := range tenants {
+		rows.AddRow(tenant)
+	}
+	mock.ExpectQuery(`SELECT tenant FROM admin_tenants WHERE admin_id = \$1`).WithArgs(adminID).WillReturnRows(rows)
+}
+
+func TestRequireAdminInScope(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		tenant   string
+		platform bool
+		tenants  []string
+		want     int
+	}{
+		{"admin of the tenant", "acme", false, []string{"acme"}, http.StatusNoContent},
+		{"admin of another tenant", "acme", false, []string{"globex"}, http.StatusNotFound},
+		{"admin shared with another tenant", "acme", false, []string{"acme", "globex"}, http.StatusNotFound},
+		{"platform admin", "acme", true, []string{}, http.StatusNotFound},
+		{"admin that does not exist", "acme", false, nil, http.StatusNotFound},
+		{"platform scope, shared admin", "", false, []string{"acme", "globex"}, http.StatusNoContent},
+		{"platform scope, platform admin", "", true, []string{}, http.StatusNoContent},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newMockApp(t)
+			expectTenants(mock, 5, tt.platform, tt.tenants)
+
+			mux := chi.NewRouter()
+			mux.With(app.RequireAdminInScope).Put("/update/{admin_id}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			r := httptest.NewRequest(http.MethodPut, "/update/5", nil)
+			r = r.WithContext(context.WithValue(r.Context(), ContextKeyTenant, tt.tenant))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+// notRevoked stands in for auth-service when no token has been logged out.
+type notRevoked struct {
+	sessions.SessionServiceClient
+}
+
+func (notRevoked) IsTokenRevoked(ctx context.Context, in *sessions.IsTokenRevokedRequest, opts ...grpc.CallOption) (*sessions.IsTokenRevokedResponse, error) {
+	return &sessions.IsTokenRevokedResponse{}, nil
+}
+
+// withSigningKey gives the app a key manager backed by a fake Vault serving key, and signs
+// admin tokens with it.
+func withSigningKey(t *testing.T, app *Config) func(claims jwt.MapClaims) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := map[string]map[string]string{
+		"/v1/jwt_keys/private_key": {"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))},
+		"/v1/jwt_keys/public_keys": {"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))},
+	}
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := secrets[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": secret})
+	}))
+	t.Cleanup(vault.Close)
+
+	app.KeyManager, err = keys.NewKeyManager(keys.VaultConfig{Address: vault.URL, Token: "test"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.KeyManager.Stop)
+	app.Sessions = notRevoked{}
+
+	return func(extra jwt.MapClaims) string {
+		t.Helper()
+
+		issuedAt := time.Now().Add(-time.Minute)
+		claims := jwt.MapClaims{
+			"user_id":   5,
+			"role":      "admin",
+			"scope":     scopeAuthentication,
+			"iat":       issuedAt.Unix(),
+			"auth_time": issuedAt.Unix(),
+			"exp":       issuedAt.Add(15 * time.Minute).Unix(),
+		}
+		for name, value := range extra {
+			if value == nil {
+				delete(claims, name)
+				continue
+			}
+			claims[name] = value
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "default"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+}
+
+func TestStepUpAfterAuthMiddleware(t *testing.T) {
+	const challenge = `Bearer error="insufficient_user_authentication", error_description="A more recent authentication is required", max_age=300`
+
+	for _, tt := range []struct {
+		name   string
+		claims jwt.MapClaims
+		want   int
+	}{
+		{"recent login", nil, http.StatusNoContent},
+		{"login older than the limit", jwt.MapClaims{"auth_time": time.Now().Add(-stepup.MaxAge - time.Minute).Unix()}, http.StatusUnauthorized},
+		{"token without auth_time", jwt.MapClaims{"auth_time": nil}, http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app, mock := newMockApp(t)
+			sign := withSigningKey(t, app)
+			expectTenants(mock, 5, true, []string{})
+
+			handler := app.AuthMiddleware("admin")(stepup.Require(stepup.MaxAge)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})))
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+sign(tt.claims))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != challenge {
+				t.Fatalf("WWW-Authenticate = %q, want the step-up challenge", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
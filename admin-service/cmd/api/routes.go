@@ -6,6 +6,8 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+
+	"stepup"
 )
 
 func (app *Config) routes() http.Handler {
@@ -28,12 +30,61 @@ func (app *Config) routes() http.Handler {
 	mux.Route("/api/admin", func(mux chi.Router) {
 		mux.Use(app.AuthMiddleware("admin"))
 
-		mux.Post("/new-add", app.AddNewAdmin)
 		mux.Get("/new-admins", app.GetAllNewAdmins)
-		mux.Delete("/delete/{admin_id}", app.DeleteAdmin)
 		mux.Delete("/delete-new/", app.DeleteNewAdmin)
-		mux.Put("/update/{admin_id}", app.UpdateAdmin)
 
+		// Tenant admins only reach the admins of their tenant
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.RequireAdminInScope)
+
+			mux.Put("/update/{admin_id}", app.UpdateAdmin)
+		})
+
+		// Granting admin access and removing admins need a recent login, not just a refreshed token
+		mux.Group(func(mux chi.Router) {
+			mux.Use(stepup.Require(stepup.MaxAge))
+
+			mux.Post("/new-add", app.AddNewAdmin)
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(app.RequireAdminInScope)
+
+				mux.Delete("/delete/{admin_id}", app.DeleteAdmin)
+			})
+		})
+
+		// Key management is not available to API keys, so a leaked key cannot mint new ones
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.DenyAPIKey)
+
+			mux.Get("/api-keys", app.ListAPIKeys)
+			mux.Post("/api-keys", app.CreateAPIKey)
+			mux.Delete("/api-keys/{key_id}", app.RevokeAPIKey)
+			mux.Delete("/all-api-keys", app.RevokeAllAdminAPIKeys)
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(app.RequireAdminInScope)
+
+				mux.Get("/admins/{admin_id}/api-keys", app.ListAdminAPIKeys)
+				mux.Delete("/admins/{admin_id}/api-keys/{key_id}", app.RevokeAdminAPIKey)
+			})
+		})
+
+		// Users, managed through user-service, which hides users outside the admin's tenant
+		mux.Get("/users/{user_id}", app.GetUser)
+		mux.Put("/users/{user_id}/status", app.UpdateUserStatus)
+
+		// Tenants' SAML identity providers. Whoever controls a provider's certificate can log
+		// in as that tenant's users, so changes need a recent login.
+		mux.Get("/saml-providers", app.ListSAMLProviders)
+		mux.Get("/saml-providers/{tenant}", app.GetSAMLProvider)
+		mux.Group(func(mux chi.Router) {
+			mux.Use(stepup.Require(stepup.MaxAge))
+
+			mux.Post("/saml-providers", app.CreateSAMLProvider)
+			mux.Put("/saml-providers/{tenant}", app.UpdateSAMLProvider)
+			mux.Delete("/saml-providers/{tenant}", app.DeleteSAMLProvider)
+		})
 	})
 
 	return mux
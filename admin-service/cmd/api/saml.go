@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"admin-service/data"
+)
+
+// samlProviderPayload is the body of the create and update requests. The tenant of an
+// update comes from the URL.
+type samlProviderPayload struct {
+	Tenant             string `json:"tenant"`
+	EntityID           string `json:"entity_id"`
+	SSOURL             string `json:"sso_url"`
+	Certificate        string `json:"certificate"`
+	EmailAttribute     string `json:"email_attribute"`
+	FirstNameAttribute string `json:"first_name_attribute"`
+	LastNameAttribute  string `json:"last_name_attribute"`
+	TrustEmail         bool   `json:"trust_email"`
+	Enabled            *bool  `json:"enabled"`
+}
+
+func (p *samlProviderPayload) provider() *data.SAMLProvider {
+	provider := &data.SAMLProvider{
+		Tenant:             strings.ToLower(strings.TrimSpace(p.Tenant)),
+		EntityID:           strings.TrimSpace(p.EntityID),
+		SSOURL:             strings.TrimSpace(p.SSOURL),
+		Certificate:        strings.TrimSpace(p.Certificate),
+		EmailAttribute:     strings.TrimSpace(p.EmailAttribute),
+		FirstNameAttribute: strings.TrimSpace(p.FirstNameAttribute),
+		LastNameAttribute:  strings.TrimSpace(p.LastNameAttribute),
+		TrustEmail:         p.TrustEmail,
+		Enabled:            true,
+	}
+	if p.Enabled != nil {
+		provider.Enabled = *p.Enabled
+	}
+
+	return provider
+}
+
+// samlTenantAllowed answers 404 for the providers of tenants the admin does not act in.
+func (app *Config) samlTenantAllowed(w http.ResponseWriter, r *http.Request, tenant string) bool {
+	if own := tenantOf(r); own != "" && own != tenant {
+		app.errorJSON(w, data.ErrSAMLProviderNotFound, http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// ListSAMLProviders returns the SAML identity providers of all tenants, or of the tenant
+// admin's own.
+func (app *Config) ListSAMLProviders(w http.ResponseWriter, r *http.Request) {
+	providers, err := app.Models.SAML.GetAll(tenantOf(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d SAML identity providers", len(providers)),
+		Data:    providers,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// GetSAMLProvider returns the SAML identity provider of one tenant.
+func (app *Config) GetSAMLProvider(w http.ResponseWriter, r *http.Request) {
+	tenant := chi.URLParam(r, "tenant")
+	if !app.samlTenantAllowed(w, r, tenant) {
+		return
+	}
+
+	provider, err := app.Models.SAML.GetByTenant(tenant)
+	if err != nil {
+		if errors.Is(err, data.ErrSAMLProviderNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("SAML identity provider of tenant %s", provider.Tenant),
+		Data:    provider,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// CreateSAMLProvider configures single sign-on for a tenant.
+func (app *Config) CreateSAMLProvider(w http.ResponseWriter, r *http.Request) {
+	var requestPayload samlProviderPayload
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	provider := requestPayload.provider()
+	err = provider.Validate()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if own := tenantOf(r); own != "" && own != provider.Tenant {
+		app.errorJSON(w, fmt.Errorf("you can only configure single sign-on for tenant %s", own), http.StatusForbidden)
+		return
+	}
+
+	err = app.Models.SAML.Insert(provider)
+	if err != nil {
+		if errors.Is(err, data.ErrSAMLProviderExists) {
+			app.errorJSON(w, err, http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	err = app.logRequest("create_saml_provider", fmt.Sprintf("Admin %d configured SAML identity provider %s for tenant %s", adminID, provider.EntityID, provider.Tenant))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("SAML identity provider for tenant %s created", provider.Tenant),
+		Data:    provider,
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// UpdateSAMLProvider replaces a tenant's SAML identity provider configuration, for example
+// to roll over the signing certificate.
+func (app *Config) UpdateSAMLProvider(w http.ResponseWriter, r *http.Request) {
+	var requestPayload samlProviderPayload
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	requestPayload.Tenant = chi.URLParam(r, "tenant")
+	if !app.samlTenantAllowed(w, r, requestPayload.Tenant) {
+		return
+	}
+
+	provider := requestPayload.provider()
+	err = provider.Validate()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.SAML.Update(provider)
+	if err != nil {
+		if errors.Is(err, data.ErrSAMLProviderNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	err = app.logRequest("update_saml_provider", fmt.Sprintf("Admin %d updated the SAML identity provider of tenant %s", adminID, provider.Tenant))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("SAML identity provider for tenant %s updated", provider.Tenant),
+		Data:    provider,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// DeleteSAMLProvider turns off single sign-on for a tenant.
+func (app *Config) DeleteSAMLProvider(w http.ResponseWriter, r *http.Request) {
+	tenant := chi.URLParam(r, "tenant")
+	if !app.samlTenantAllowed(w, r, tenant) {
+		return
+	}
+
+	err := app.Models.SAML.Delete(tenant)
+	if err != nil {
+		if errors.Is(err, data.ErrSAMLProviderNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	err = app.logRequest("delete_saml_provider", fmt.Sprintf("Admin %d deleted the SAML identity provider of tenant %s", adminID, tenant))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("SAML identity provider for tenant %s deleted", tenant),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"proto/sessions"
+)
+
+// authServiceAddress is where auth-service serves gRPC.
+const authServiceAddress = "authentication-service:50004"
+
+// dialAuthService returns a client for auth-service's session service. The connection is
+// made on first use.
+func dialAuthService() (sessions.SessionServiceClient, error) {
+	conn, err := grpc.NewClient(authServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions.NewSessionServiceClient(conn), nil
+}
+
+// tokenRevoked asks auth-service whether a verified token has been logged out since it was
+// issued.
+func (app *Config) tokenRevoked(ctx context.Context, token string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	response, err := app.Sessions.IsTokenRevoked(ctx, &sessions.IsTokenRevokedRequest{Token: token})
+	if err != nil {
+		return false, err
+	}
+
+	return response.GetRevoked(), nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"proto/users"
+)
+
+// userServiceAddress is where user-service serves gRPC.
+const userServiceAddress = "user-service:50001"
+
+// callUserService connects to user-service and runs fn with a client, giving it a few
+// seconds to finish.
+func callUserService(fn func(ctx context.Context, c users.UserServiceClient) error) error {
+	conn, err := grpc.Dial(userServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return fn(ctx, users.NewUserServiceClient(conn))
+}
+
+// userServiceError sends the error user-service returned, with the matching HTTP status.
+func (app *Config) userServiceError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+
+	switch st.Code() {
+	case codes.NotFound:
+		app.errorJSON(w, fmt.Errorf("%s", st.Message()), http.StatusNotFound)
+	case codes.InvalidArgument:
+		app.errorJSON(w, fmt.Errorf("%s", st.Message()), http.StatusBadRequest)
+	case codes.PermissionDenied:
+		app.errorJSON(w, fmt.Errorf("%s", st.Message()), http.StatusForbidden)
+	case codes.Unavailable, codes.DeadlineExceeded:
+		app.errorJSON(w, fmt.Errorf("user-service is unavailable"), http.StatusBadGateway)
+	default:
+		app.errorJSON(w, fmt.Errorf("%s", st.Message()), http.StatusInternalServerError)
+	}
+}
+
+// GetUser returns a user's profile from user-service. Tenant admins only see the members
+// of their tenant.
+func (app *Config) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		return
+	}
+
+	var user *users.User
+	err = callUserService(func(ctx context.Context, c users.UserServiceClient) error {
+		user, err = c.GetUser(ctx, &users.GetUserRequest{UserId: userID, Tenant: tenantOf(r)})
+		return err
+	})
+	if err != nil {
+		app.userServiceError(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User retrieved successfully",
+		Data:    user,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// UpdateUserStatus activates, deactivates, suspends or bans a user. Suspensions need a
+// reason and an RFC 3339 suspended_until in the future, bans a reason.
+func (app *Config) UpdateUserStatus(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+	if err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		return
+	}
+
+	var requestPayload struct {
+		Status         string `json:"status"`
+		Reason         string `json:"reason"`
+		SuspendedUntil string `json:"suspended_until"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var suspendedUntil int64
+	if requestPayload.SuspendedUntil != "" {
+		until, err := time.Parse(time.RFC3339, requestPayload.SuspendedUntil)
+		if err != nil {
+			app.errorJSON(w, fmt.Errorf("suspended_until must be an RFC 3339 timestamp"), http.StatusBadRequest)
+			return
+		}
+		suspendedUntil = until.Unix()
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+
+	var user *users.User
+	err = callUserService(func(ctx context.Context, c users.UserServiceClient) error {
+		user, err = c.UpdateUserStatus(ctx, &users.UpdateUserStatusRequest{
+			UserId:         userID,
+			Status:         requestPayload.Status,
+			Reason:         requestPayload.Reason,
+			SuspendedUntil: suspendedUntil,
+			Actor:          fmt.Sprintf("Admin %d", adminID),
+			Tenant:         tenantOf(r),
+		})
+		return err
+	})
+	if err != nil {
+		app.userServiceError(w, err)
+		return
+	}
+
+	err = app.logRequest("update_user_status", fmt.Sprintf("Admin %d set the status of user %d to %s", adminID, userID, user.Status))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("User %d is now %s", userID, user.Status),
+		Data:    user,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"apikey"
+)
+
+// APIKeyPrefix marks admin API keys, so middleware can tell them apart from JWTs.
+const APIKeyPrefix = "ak_"
+
+// ErrAPIKeyNotFound is returned when a key does not exist, is revoked or has expired.
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKeyModel represents the model for working with API keys in the database.
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// APIKey is a named, scoped credential an admin can hand to scripts instead of their password.
+// Only the SHA-256 hash of the key is stored; the plain text is returned once, on creation.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	AdminID    int64      `json:"admin_id"`
+	Name       string     `json:"name"`
+	PlainText  string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the key was granted the scope. Write keys can also read.
+func (k *APIKey) HasScope(scope string) bool {
+	return apikey.HasScope(k.Scopes, scope)
+}
+
+// Insert creates a new API key for the admin and returns it with its plain text set.
+func (m *APIKeyModel) Insert(adminID int64, name string, scopes []string, expiresAt *time.Time) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	plainText, shown, err := apikey.Generate(APIKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		AdminID:   adminID,
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		PlainText: plainText,
+		Prefix:    shown,
+	}
+
+	query := `INSERT INTO admin_api_keys (admin_id, name, key_hash, prefix, scopes, expires_at)
+			  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+
+	err = m.DB.QueryRowContext(ctx, query,
+		adminID,
+		name,
+		apikey.Hash(key.PlainText),
+		key.Prefix,
+		apikey.JoinScopes(scopes),
+		expiresAt,
+	).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Authenticate looks up an active key by its plain text and records that it was used.
+func (m *APIKeyModel) Authenticate(plainText string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE admin_api_keys SET last_used_at = NOW()
+			  WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+			  RETURNING id, admin_id, name, prefix, scopes, expires_at, last_used_at, created_at`
+
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, apikey.Hash(plainText)))
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	return key, err
+}
+
+// GetAllForAdmin returns the admin's active keys, newest first.
+func (m *APIKeyModel) GetAllForAdmin(adminID int64) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, admin_id, name, prefix, scopes, expires_at, last_used_at, created_at
+			  FROM admin_api_keys WHERE admin_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC`
+
+	rows, err := m.DB.QueryContext(ctx, query, adminID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Revoke revokes one of the admin's keys.
+func (m *APIKeyModel) Revoke(id, adminID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE admin_api_keys SET revoked_at = NOW() WHERE id = $1 AND admin_id = $2 AND revoked_at IS NULL`
+
+	result, err := m.DB.ExecContext(ctx, query, id, adminID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// RevokeAll revokes every active key of an admin, or of all admins when adminID is 0, and
+// returns how many keys were revoked. With a tenant, only keys of admins within it are
+// revoked.
+func (m *APIKeyModel) RevokeAll(adminID int64, tenant string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE admin_api_keys SET revoked_at = NOW() WHERE revoked_at IS NULL AND ($1 = 0 OR admin_id = $1)
+			  AND ($2 = '' OR ` + adminsWithinTenantCondition("admin_id", "$2") + `)`
+
+	result, err := m.DB.ExecContext(ctx, query, adminID, tenant)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&key.AdminID,
+		&key.Name,
+		&key.Prefix,
+		&scopes,
+		&expiresAt,
+		&lastUsedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = apikey.SplitScopes(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &key, nil
+}
@@ -7,13 +7,14 @@ import (
 	"log"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"password"
 )
 
 const dbTimeout = time.Second * 3
 
 type AdminModel struct {
-	DB *sql.DB
+	DB     *sql.DB
+	Hasher *password.Hasher
 }
 
 type NewAdminModel struct {
@@ -24,13 +25,17 @@ type Models struct {
 	Admin    AdminModel
 	NewAdmin NewAdminModel
 	Token    TokenModel
+	APIKey   APIKeyModel
+	SAML     SAMLProviderModel
 }
 
-func New(db *sql.DB) Models {
+func New(db *sql.DB, hasher *password.Hasher) Models {
 	return Models{
-		Admin:    AdminModel{DB: db},
+		Admin:    AdminModel{DB: db, Hasher: hasher},
 		NewAdmin: NewAdminModel{DB: db},
 		Token:    TokenModel{DB: db},
+		APIKey:   APIKeyModel{DB: db},
+		SAML:     SAMLProviderModel{DB: db},
 	}
 }
 
@@ -39,13 +44,16 @@ type Admin struct {
 	AdminName    string    `json:"admin_name"`
 	Email        string    `json:"email"`
 	PasswordHash string    `json:"passwordhash"`
+	Platform     bool      `json:"platform"` // manages every tenant
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
-// NewAdmin struct for storing email addresses of admins who can create accounts
+// NewAdmin struct for storing email addresses of admins who can create accounts. Tenant is
+// the tenant they will manage, or empty for platform admins.
 type NewAdmin struct {
-	Email string `json:"email"`
+	Email  string `json:"email"`
+	Tenant string `json:"tenant,omitempty"`
 }
 
 // GetAllAdmins returns a slice of all admins, sorted by their admin name
@@ -85,14 +93,15 @@ func (a *AdminModel) GetAllAdmins() ([]*Admin, error) {
 	return admins, nil
 }
 
-// InsertNewAdmin inserts a new admin email into the database
-func (n *NewAdminModel) InsertNewAdmin(email string) error {
+// InsertNewAdmin inserts a new admin email into the database, for the tenant they will
+// manage or, if tenant is empty, for the platform
+func (n *NewAdminModel) InsertNewAdmin(email, tenant string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `INSERT INTO new_admins (email, created_at) VALUES ($1, $2)`
+	query := `INSERT INTO new_admins (email, tenant, created_at) VALUES ($1, NULLIF($2, ''), $3)`
 
-	_, err := n.DB.ExecContext(ctx, query, email, time.Now())
+	_, err := n.DB.ExecContext(ctx, query, email, tenant, time.Now())
 	if err != nil {
 		return err
 	}
@@ -100,13 +109,14 @@ func (n *NewAdminModel) InsertNewAdmin(email string) error {
 	return nil
 }
 
-// GetAllNewAdmins retrieves all new admin emails from the database
-func (n *NewAdminModel) GetAllNewAdmins() ([]NewAdmin, error) {
+// GetAllNewAdmins retrieves the new admin emails for a tenant from the database, or all of
+// them if tenant is empty
+func (n *NewAdminModel) GetAllNewAdmins(tenant string) ([]NewAdmin, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `SELECT email FROM new_admins`
-	rows, err := n.DB.QueryContext(ctx, query)
+	query := `SELECT email, COALESCE(tenant, '') FROM new_admins WHERE $1 = '' OR tenant = $1`
+	rows, err := n.DB.QueryContext(ctx, query, tenant)
 	if err != nil {
 		return nil, err
 	}
@@ -115,7 +125,7 @@ func (n *NewAdminModel) GetAllNewAdmins() ([]NewAdmin, error) {
 	var newAdmins []NewAdmin
 	for rows.Next() {
 		var newAdmin NewAdmin
-		if err := rows.Scan(&newAdmin.Email); err != nil {
+		if err := rows.Scan(&newAdmin.Email, &newAdmin.Tenant); err != nil {
 			return nil, err
 		}
 		newAdmins = append(newAdmins, newAdmin)
@@ -124,14 +134,31 @@ func (n *NewAdminModel) GetAllNewAdmins() ([]NewAdmin, error) {
 	return newAdmins, nil
 }
 
-// DeleteNewAdmin removes an admin email from the new admins table
-func (n *NewAdminModel) DeleteNewAdmin(email string) error {
+// GetNewAdmin returns the invitation of an email, if there is one
+func (n *NewAdminModel) GetNewAdmin(email string) (*NewAdmin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT email, COALESCE(tenant, '') FROM new_admins WHERE email = $1 ORDER BY created_at DESC LIMIT 1`
+
+	var newAdmin NewAdmin
+	err := n.DB.QueryRowContext(ctx, query, email).Scan(&newAdmin.Email, &newAdmin.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	return &newAdmin, nil
+}
+
+// DeleteNewAdmin removes an admin email from the new admins table, only for the tenant if
+// one is given
+func (n *NewAdminModel) DeleteNewAdmin(email, tenant string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `DELETE FROM new_admins WHERE email = $1`
+	query := `DELETE FROM new_admins WHERE email = $1 AND ($2 = '' OR tenant = $2)`
 
-	_, err := n.DB.ExecContext(ctx, query, email)
+	_, err := n.DB.ExecContext(ctx, query, email, tenant)
 	if err != nil {
 		return err
 	}
@@ -196,19 +223,20 @@ func (a *AdminModel) InsertAdmin(admin Admin) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(admin.PasswordHash), 12)
+	hashedPassword, err := a.Hasher.Hash(ctx, admin.PasswordHash)
 	if err != nil {
 		return 0, err
 	}
 
 	var newID int
-	query := `INSERT INTO admins (email, admin_name, passwordhash, created_at, updated_at)
-			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	query := `INSERT INTO admins (email, admin_name, passwordhash, platform, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
 
 	err = a.DB.QueryRowContext(ctx, query,
 		admin.Email,
 		admin.AdminName,
 		hashedPassword,
+		admin.Platform,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -259,11 +287,11 @@ func (a *AdminModel) UpdateAdmin(admin Admin) error {
 	return nil
 }
 
-func (a *AdminModel) ResetAdminPassword(adminID int64, password string) error {
+func (a *AdminModel) ResetAdminPassword(adminID int64, plainText string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := a.Hasher.Hash(ctx, plainText)
 	if err != nil {
 		return err
 	}
@@ -277,9 +305,11 @@ func (a *AdminModel) ResetAdminPassword(adminID int64, password string) error {
 	return nil
 }
 
-// PasswordMatches uses Go's bcrypt package to compare an admin supplied password
-func (a *AdminModel) PasswordMatches(adminID int64, plainText string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// PasswordMatches compares an admin supplied password with the stored hash. A matching
+// hash made with another algorithm or older parameters is replaced by a current one. It
+// fails with password.ErrBusy when too many passwords are being hashed already.
+func (a *AdminModel) PasswordMatches(ctx context.Context, adminID int64, plainText string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	var hashedPassword string
@@ -289,28 +319,47 @@ func (a *AdminModel) PasswordMatches(adminID int64, plainText string) (bool, err
 		return false, err
 	}
 
-	log.Println("MODELS: Request password:", plainText)
-
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainText))
+	valid, err := a.Hasher.Verify(ctx, hashedPassword, plainText)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			log.Println("MODELS: Error Mismatched password:", err)
-			// niepoprawne hasło
-			return false, nil
+		if !errors.Is(err, password.ErrBusy) {
+			log.Println("MODELS: Error verifying password:", err)
 		}
-		log.Println("MODELS: Other error in CompareHashAndPassword:", err)
 		return false, err
 	}
+	if !valid {
+		return false, nil
+	}
+
+	if a.Hasher.NeedsRehash(hashedPassword) {
+		err = a.rehashPassword(ctx, adminID, hashedPassword, plainText)
+		// The old hash keeps working, a later login tries again. Under load that is expected.
+		if err != nil && !errors.Is(err, password.ErrBusy) {
+			log.Printf("MODELS: Error rehashing password of admin %d: %v", adminID, err)
+		}
+	}
 
 	return true, nil
 }
 
+// rehashPassword replaces an outdated hash, unless the password was changed since it was
+// read.
+func (a *AdminModel) rehashPassword(ctx context.Context, adminID int64, oldHash, plainText string) error {
+	hashedPassword, err := a.Hasher.Hash(ctx, plainText)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE admins SET passwordhash = $1 WHERE id = $2 AND passwordhash = $3`
+	_, err = a.DB.ExecContext(ctx, stmt, hashedPassword, adminID, oldHash)
+	return err
+}
+
 // UpdateAdminPassword updates the admin's password in the database.
-func (a *AdminModel) UpdateAdminPassword(email string, password string) error {
+func (a *AdminModel) UpdateAdminPassword(email string, plainText string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := a.Hasher.Hash(ctx, plainText)
 	if err != nil {
 		return err
 	}
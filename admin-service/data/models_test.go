@@ -0,0 +1,88 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/crypto/bcrypt"
+
+	"password"
+)
+
+// newHash matches the hash written by a rehash, and keeps it.
+type newHash struct {
+	hash *string
+}
+
+func (n newHash) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*n.hash = s
+	return ok
+}
+
+func TestPasswordMatchesRehashesBcrypt(t *testing.T) {
+	ctx := context.Background()
+	hasher := &password.Hasher{
+		Algorithm: password.AlgorithmArgon2id,
+		Argon2:    password.Argon2Params{Memory: 64, Time: 1, Threads: 1, SaltLength: 16, KeyLength: 32},
+	}
+
+	old, err := bcrypt.GenerateFromPassword([]byte("correct horse"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		plainText string
+		match     bool
+	}{
+		{"right password", "correct horse", true},
+		{"wrong password", "battery staple", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			admins := &AdminModel{DB: db, Hasher: hasher}
+
+			mock.ExpectQuery(`SELECT passwordhash FROM admins WHERE id = \$1`).
+				WithArgs(int64(5)).
+				WillReturnRows(sqlmock.NewRows([]string{"passwordhash"}).AddRow(string(old)))
+
+			var rehashed string
+			if tt.match {
+				mock.ExpectExec(`UPDATE admins SET passwordhash = \$1 WHERE id = \$2 AND passwordhash = \$3`).
+					WithArgs(newHash{&rehashed}, int64(5), string(old)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			match, err := admins.PasswordMatches(ctx, 5, tt.plainText)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match != tt.match {
+				t.Fatalf("match = %v, want %v", match, tt.match)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.match {
+				return
+			}
+
+			if !strings.HasPrefix(rehashed, "$argon2id$") || hasher.NeedsRehash(rehashed) {
+				t.Fatalf("rehashed to %q, want a current Argon2id hash", rehashed)
+			}
+			valid, err := hasher.Verify(ctx, rehashed, tt.plainText)
+			if err != nil || !valid {
+				t.Fatalf("new hash does not verify: %v, %v", valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package data
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// Errors returned by SAMLProviderModel.
+var (
+	ErrSAMLProviderNotFound = errors.New("saml identity provider not found")
+	ErrSAMLProviderExists   = errors.New("the tenant already has a saml identity provider")
+)
+
+// tenantPattern matches tenant names, which appear in auth-service URLs and entity IDs.
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// SAMLProviderModel represents the model for the tenants' SAML identity providers. The SAML
+// flow itself runs in auth-service, which reads the configuration over gRPC.
+type SAMLProviderModel struct {
+	DB *sql.DB
+}
+
+// SAMLProvider is a tenant's SAML 2.0 identity provider. Assertions must be signed with
+// Certificate, and the attribute names say where auth-service finds the user's details.
+type SAMLProvider struct {
+	ID                 int64     `json:"id"`
+	Tenant             string    `json:"tenant"`
+	EntityID           string    `json:"entity_id"`
+	SSOURL             string    `json:"sso_url"`
+	Certificate        string    `json:"certificate"`
+	EmailAttribute     string    `json:"email_attribute"`
+	FirstNameAttribute string    `json:"first_name_attribute"`
+	LastNameAttribute  string    `json:"last_name_attribute"`
+	TrustEmail         bool      `json:"trust_email"` // link accounts by the asserted email
+	Enabled            bool      `json:"enabled"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// Validate checks the configuration before it is stored.
+func (p *SAMLProvider) Validate() error {
+	if !tenantPattern.MatchString(p.Tenant) {
+		return fmt.Errorf("tenant must be 1-63 lowercase letters, digits or dashes")
+	}
+	if p.EntityID == "" || len(p.EntityID) > 255 {
+		return fmt.Errorf("entity_id is required and must be at most 255 characters")
+	}
+
+	u, err := url.Parse(p.SSOURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(p.SSOURL) > 255 {
+		return fmt.Errorf("sso_url must be an absolute http(s) URL of at most 255 characters")
+	}
+
+	block, _ := pem.Decode([]byte(p.Certificate))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("certificate must be a PEM encoded X.509 certificate")
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return fmt.Errorf("invalid certificate: %v", err)
+	}
+
+	if p.EmailAttribute == "" {
+		p.EmailAttribute = "email"
+	}
+
+	return nil
+}
+
+// GetByTenant returns the tenant's identity provider.
+func (m *SAMLProviderModel) GetByTenant(tenant string) (*SAMLProvider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, tenant, entity_id, sso_url, certificate, email_attribute, first_name_attribute,
+			  last_name_attribute, trust_email, enabled, created_at, updated_at
+			  FROM saml_identity_providers WHERE tenant = $1`
+
+	provider, err := scanSAMLProvider(m.DB.QueryRowContext(ctx, query, tenant))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSAMLProviderNotFound
+	}
+
+	return provider, err
+}
+
+// GetAll returns every tenant's identity provider, sorted by tenant, or only the tenant's
+// if one is given.
+func (m *SAMLProviderModel) GetAll(tenant string) ([]*SAMLProvider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, tenant, entity_id, sso_url, certificate, email_attribute, first_name_attribute,
+			  last_name_attribute, trust_email, enabled, created_at, updated_at
+			  FROM saml_identity_providers WHERE $1 = '' OR tenant = $1 ORDER BY tenant`
+
+	rows, err := m.DB.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	providers := []*SAMLProvider{}
+	for rows.Next() {
+		provider, err := scanSAMLProvider(rows)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, rows.Err()
+}
+
+// Insert stores a new identity provider. A tenant has at most one.
+func (m *SAMLProviderModel) Insert(p *SAMLProvider) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `INSERT INTO saml_identity_providers (tenant, entity_id, sso_url, certificate, email_attribute,
+			  first_name_attribute, last_name_attribute, trust_email, enabled)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			  ON CONFLICT (tenant) DO NOTHING RETURNING id, created_at, updated_at`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		p.Tenant,
+		p.EntityID,
+		p.SSOURL,
+		p.Certificate,
+		p.EmailAttribute,
+		p.FirstNameAttribute,
+		p.LastNameAttribute,
+		p.TrustEmail,
+		p.Enabled,
+	).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSAMLProviderExists
+	}
+
+	return err
+}
+
+// Update replaces the configuration of the tenant's identity provider.
+func (m *SAMLProviderModel) Update(p *SAMLProvider) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE saml_identity_providers SET entity_id = $2, sso_url = $3, certificate = $4,
+			  email_attribute = $5, first_name_attribute = $6, last_name_attribute = $7,
+			  trust_email = $8, enabled = $9, updated_at = NOW()
+			  WHERE tenant = $1 RETURNING id, created_at, updated_at`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		p.Tenant,
+		p.EntityID,
+		p.SSOURL,
+		p.Certificate,
+		p.EmailAttribute,
+		p.FirstNameAttribute,
+		p.LastNameAttribute,
+		p.TrustEmail,
+		p.Enabled,
+	).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSAMLProviderNotFound
+	}
+
+	return err
+}
+
+// Delete removes the tenant's identity provider.
+func (m *SAMLProviderModel) Delete(tenant string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM saml_identity_providers WHERE tenant = $1`, tenant)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSAMLProviderNotFound
+	}
+
+	return nil
+}
+
+func scanSAMLProvider(row rowScanner) (*SAMLProvider, error) {
+	var p SAMLProvider
+
+	err := row.Scan(
+		&p.ID,
+		&p.Tenant,
+		&p.EntityID,
+		&p.SSOURL,
+		&p.Certificate,
+		&p.EmailAttribute,
+		&p.FirstNameAttribute,
+		&p.LastNameAttribute,
+		&p.TrustEmail,
+		&p.Enabled,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// adminsWithinTenant selects the admins a tenant's admins may manage: those who manage
+// only that tenant, given as $n. Admins who also manage other tenants, or the platform,
+// are left to platform admins.
+const adminsWithinTenant = `SELECT a.id FROM admins a WHERE NOT a.platform
+	AND EXISTS (SELECT 1 FROM admin_tenants t WHERE t.admin_id = a.id AND t.tenant = %[1]s)
+	AND NOT EXISTS (SELECT 1 FROM admin_tenants t WHERE t.admin_id = a.id AND t.tenant <> %[1]s)`
+
+// AdminTenants says what an admin manages: every tenant for platform admins, otherwise the
+// listed ones.
+type AdminTenants struct {
+	Platform bool     `json:"platform"`
+	Tenants  []string `json:"tenants"`
+}
+
+// Manages reports whether tokens for tenant can be issued to the admin. An empty tenant
+// stands for the whole platform.
+func (t *AdminTenants) Manages(tenant string) bool {
+	return t.Platform || (tenant != "" && slices.Contains(t.Tenants, tenant))
+}
+
+// Within reports whether an admin acting in tenant may manage this admin. Platform scope,
+// the empty tenant, covers everyone.
+func (t *AdminTenants) Within(tenant string) bool {
+	if tenant == "" {
+		return true
+	}
+	return !t.Platform && len(t.Tenants) == 1 && t.Tenants[0] == tenant
+}
+
+// ValidTenant reports whether tenant can name a tenant.
+func ValidTenant(tenant string) bool {
+	return tenantPattern.MatchString(tenant)
+}
+
+// Tenants returns what an admin manages.
+func (a *AdminModel) Tenants(adminID int64) (*AdminTenants, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var tenants AdminTenants
+	err := a.DB.QueryRowContext(ctx, `SELECT platform FROM admins WHERE id = $1`, adminID).Scan(&tenants.Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := a.DB.QueryContext(ctx, `SELECT tenant FROM admin_tenants WHERE admin_id = $1 ORDER BY created_at, tenant`, adminID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants.Tenants = []string{}
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants.Tenants = append(tenants.Tenants, tenant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &tenants, nil
+}
+
+// WithinTenant reports whether an admin acting in tenant may manage the admin with the ID.
+// Admins that do not exist are not within any tenant.
+func (a *AdminModel) WithinTenant(adminID int64, tenant string) (bool, error) {
+	tenants, err := a.Tenants(adminID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return tenants.Within(tenant), nil
+}
+
+// AddTenant lets an admin manage a tenant.
+func (a *AdminModel) AddTenant(adminID int64, tenant string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `INSERT INTO admin_tenants (admin_id, tenant) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+
+	_, err := a.DB.ExecContext(ctx, query, adminID, tenant)
+	return err
+}
+
+// adminsWithinTenantCondition returns an SQL condition that holds for admin IDs in column
+// that an admin acting in the tenant given as arg may manage.
+func adminsWithinTenantCondition(column, arg string) string {
+	return fmt.Sprintf("%s IN (%s)", column, fmt.Sprintf(adminsWithinTenant, arg))
+}
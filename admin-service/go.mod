@@ -3,6 +3,7 @@ module admin-service
 go 1.23.2
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/go-chi/chi/v5 v5.1.0
 	github.com/go-chi/cors v1.2.1
 	github.com/golang-jwt/jwt/v5 v5.2.1
@@ -40,4 +41,16 @@ require (
 	golang.org/x/text v0.19.0 // indirect
 	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240814211410-ddb44dafa142 // indirect
+	apikey v1.0.0
+	password v1.0.0
+	proto v1.0.0
+	stepup v1.0.0
 )
+
+replace apikey => ../apikey
+
+replace password => ../password
+
+replace proto => ../proto
+
+replace stepup => ../stepup
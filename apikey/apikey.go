@@ -0,0 +1,115 @@
+// Package apikey holds what user-service and admin-service share about API keys: how keys are
+// generated and hashed, the scopes they carry and the rules for creating them. Each service
+// stores its keys in its own table and decides who they authenticate as.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// API key scopes. Read keys may only make safe (GET) requests.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// shownLength is how many characters after the prefix are stored in the clear, so owners can
+// tell their keys apart.
+const shownLength = 8
+
+// maxNameLength is the longest name a key can be given.
+const maxNameLength = 100
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generate returns a new random key starting with prefix, and the start of it that is shown
+// in listings.
+func Generate(prefix string) (plainText, shown string, err error) {
+	randomBytes := make([]byte, 32)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	plainText = prefix + strings.ToLower(encoding.EncodeToString(randomBytes))
+	return plainText, plainText[:len(prefix)+shownLength], nil
+}
+
+// Hash returns the SHA-256 hash of a key. Only the hash is stored, and keys are looked up by it.
+func Hash(plainText string) []byte {
+	hash := sha256.Sum256([]byte(plainText))
+	return hash[:]
+}
+
+// ValidScope reports whether scope is one of the known API key scopes.
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeWrite
+}
+
+// HasScope reports whether scopes grant scope. Write keys can also read.
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || (scope == ScopeRead && s == ScopeWrite) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiredScope returns the scope a key needs to make a request with method.
+func RequiredScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeRead
+	default:
+		return ScopeWrite
+	}
+}
+
+// JoinScopes returns scopes the way they are stored.
+func JoinScopes(scopes []string) string {
+	return strings.Join(scopes, ",")
+}
+
+// SplitScopes reads scopes stored by JoinScopes.
+func SplitScopes(stored string) []string {
+	return strings.Split(stored, ",")
+}
+
+// Request is what a key is created from.
+type Request struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Validate trims the name and defaults the scopes to read-only, then checks the request. Keys
+// must expire after now, if at all.
+func (req *Request) Validate(now time.Time) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxNameLength {
+		return errors.New("name is required and must be at most 100 characters")
+	}
+
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{ScopeRead}
+	}
+	for _, scope := range req.Scopes {
+		if !ValidScope(scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package apikey
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	plainText, shown, err := Generate("uk_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(plainText, "uk_") || len(plainText) != len("uk_")+52 {
+		t.Fatalf("key = %q, want uk_ and 52 base32 characters", plainText)
+	}
+	if shown != plainText[:len("uk_")+8] {
+		t.Fatalf("shown = %q, want the prefix and 8 characters of %q", shown, plainText)
+	}
+
+	other, _, err := Generate("uk_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == plainText {
+		t.Fatal("two keys are the same")
+	}
+}
+
+func TestHash(t *testing.T) {
+	want := sha256.Sum256([]byte("uk_secret"))
+	if !bytes.Equal(Hash("uk_secret"), want[:]) {
+		t.Fatal("Hash is not the SHA-256 of the key")
+	}
+}
+
+func TestScopes(t *testing.T) {
+	for _, tt := range []struct {
+		scopes []string
+		method string
+		want   bool
+	}{
+		{[]string{ScopeRead}, http.MethodGet, true},
+		{[]string{ScopeRead}, http.MethodHead, true},
+		{[]string{ScopeRead}, http.MethodPost, false},
+		{[]string{ScopeRead}, http.MethodDelete, false},
+		{[]string{ScopeWrite}, http.MethodGet, true},
+		{[]string{ScopeWrite}, http.MethodPatch, true},
+		{SplitScopes(JoinScopes([]string{ScopeRead, ScopeWrite})), http.MethodPut, true},
+	} {
+		if got := HasScope(tt.scopes, RequiredScope(tt.method)); got != tt.want {
+			t.Errorf("%v %s: allowed = %v, want %v", tt.scopes, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestRequestValidate(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+
+	for _, tt := range []struct {
+		name    string
+		request Request
+		wantErr string
+	}{
+		{"defaults to read", Request{Name: " ci "}, ""},
+		{"write", Request{Name: "ci", Scopes: []string{ScopeWrite}, ExpiresAt: &future}, ""},
+		{"blank name", Request{Name: "  "}, "name is required"},
+		{"long name", Request{Name: strings.Repeat("a", 101)}, "name is required"},
+		{"unknown scope", Request{Name: "ci", Scopes: []string{"admin"}}, `unknown scope "admin"`},
+		{"expired", Request{Name: "ci", ExpiresAt: &past}, "expires_at must be in the future"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.request.Validate(now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.request.Name != "ci" || len(tt.request.Scopes) == 0 {
+					t.Fatalf("request = %+v, want a trimmed name and scopes", tt.request)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+module apikey
+
+go 1.23.1
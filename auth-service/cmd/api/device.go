@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"auth/data"
+)
+
+// The device authorization grant (RFC 8628) lets CLIs and other clients without a browser
+// log in: the client shows a short user code, the user approves it from a device where they
+// are already logged in, and the client polls for tokens in the meantime. Client-facing
+// endpoints take form-encoded requests and answer in the OAuth 2.0 JSON format.
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// defaultDeviceVerificationURI is where users enter their code unless DEVICE_VERIFICATION_URI is set.
+const defaultDeviceVerificationURI = "http://localhost:8080/device"
+
+// DeviceAuthorization issues a device code and user code to a client.
+func (app *Config) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+
+	clientID := strings.TrimSpace(r.PostForm.Get("client_id"))
+	if clientID == "" {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", "client_id is required")
+		return
+	}
+
+	auth, deviceCode, err := app.Models.Device.Create(r.Context(), clientID)
+	if err != nil {
+		app.oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	err = app.logRequest("DeviceAuthorization", fmt.Sprintf("Device code issued to client %s", clientID))
+	if err != nil {
+		app.oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	verificationURI := app.DeviceVerificationURI
+	if verificationURI == "" {
+		verificationURI = defaultDeviceVerificationURI
+	}
+
+	app.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 auth.UserCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?user_code=" + url.QueryEscape(auth.UserCode),
+		"expires_in":                int(data.DeviceCodeTTL.Seconds()),
+		"interval":                  auth.Interval,
+	}, noStoreHeaders())
+}
+
+// DeviceToken is polled by the client until the user has approved or denied its code.
+func (app *Config) DeviceToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != deviceCodeGrantType {
+		app.oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be "+deviceCodeGrantType)
+		return
+	}
+
+	deviceCode := r.PostForm.Get("device_code")
+	clientID := r.PostForm.Get("client_id")
+	if deviceCode == "" || clientID == "" {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", "device_code and client_id are required")
+		return
+	}
+
+	ctx := r.Context()
+
+	auth, err := app.Models.Device.Poll(ctx, deviceCode, clientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAuthorizationPending),
+			errors.Is(err, data.ErrSlowDown),
+			errors.Is(err, data.ErrAccessDenied),
+			errors.Is(err, data.ErrDeviceNotFound):
+			app.oauthError(w, http.StatusBadRequest, err.Error(), "")
+		default:
+			app.oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		}
+		return
+	}
+
+	// The client did not see the user log in, so the tokens carry no auth_time and
+	// operations that need a recent login stay out of reach
+	var login data.Authentication
+	err = loginTenant(ctx, &login, auth.Role, auth.UserID)
+	if err != nil {
+		if errors.Is(err, errNoAdminTenant) {
+			app.oauthError(w, http.StatusForbidden, "access_denied", err.Error())
+			return
+		}
+		app.oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	accessToken, err := app.Models.Token.GenerateToken(ctx, int(auth.UserID), auth.Role, data.AccessTokenTTL, data.ScopeAuthentication, "user-key", login)
+	if err != nil {
+		app.oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	refreshToken, err := app.Models.Token.GenerateToken(ctx, int(auth.UserID), auth.Role, data.RefreshTokenTTL, data.ScopeRefresh, "user-key", login)
+	if err != nil {
+		app.oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	err = app.logRequest("DeviceToken", fmt.Sprintf("Device tokens issued to client %s for %s %d", clientID, auth.Role, auth.UserID))
+	if err != nil {
+		app.oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(data.AccessTokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+	}, noStoreHeaders())
+}
+
+// GetDeviceAuthorization shows a logged in user or admin which client is asking for access,
+// before they approve it.
+func (app *Config) GetDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	_, ok := app.verifiedClaims(w, r)
+	if !ok {
+		return
+	}
+
+	auth, err := app.Models.Device.GetByUserCode(r.Context(), r.URL.Query().Get("user_code"))
+	if err != nil {
+		app.deviceLookupError(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Client %s is requesting access", auth.ClientID),
+		Status:  http.StatusOK,
+		Data: map[string]interface{}{
+			"user_code":  auth.UserCode,
+			"client_id":  auth.ClientID,
+			"status":     auth.Status,
+			"expires_at": auth.ExpiresAt,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// DecideDeviceAuthorization approves or denies a user code on behalf of the logged in
+// user or admin. Approved clients get tokens for that account on their next poll.
+func (app *Config) DecideDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	claims, ok := app.verifiedClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		UserCode string `json:"user_code"`
+		Approve  bool   `json:"approve"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	auth, err := app.Models.Device.GetByUserCode(ctx, requestPayload.UserCode)
+	if err != nil {
+		app.deviceLookupError(w, err)
+		return
+	}
+
+	err = app.Models.Device.Decide(ctx, auth, requestPayload.Approve, claims.UserID, claims.Role)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	}
+
+	err = app.logRequest("DecideDeviceAuthorization", fmt.Sprintf("%s %d %s device code for client %s", claims.Role, claims.UserID, auth.Status, auth.ClientID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Device code %s", auth.Status),
+		Status:  http.StatusOK,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+func (app *Config) deviceLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, data.ErrDeviceNotFound) {
+		app.errorJSON(w, fmt.Errorf("unknown or expired user code"), http.StatusNotFound)
+		return
+	}
+	app.errorJSON(w, err)
+}
+
+// oauthError writes an error in the OAuth 2.0 format (RFC 6749 section 5.2).
+func (app *Config) oauthError(w http.ResponseWriter, status int, code, description string) {
+	payload := map[string]string{"error": code}
+	if description != "" {
+		payload["error_description"] = description
+	}
+
+	app.writeJSON(w, status, payload, noStoreHeaders())
+}
+
+func noStoreHeaders() http.Header {
+	return http.Header{
+		"Cache-Control": []string{"no-store"},
+		"Pragma":        []string{"no-cache"},
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"auth/data"
+	"proto/sessions"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SessionServer lets other services end user sessions, for example when an account is erased,
+// and check whether a token is still good.
+type SessionServer struct {
+	sessions.UnimplementedSessionServiceServer
+	Models data.Models
+}
+
+// RevokeUserSessions rejects every token issued to the user so far, so they have to log in again.
+func (s *SessionServer) RevokeUserSessions(ctx context.Context, req *sessions.RevokeUserSessionsRequest) (*sessions.RevokeUserSessionsResponse, error) {
+	if req.UserId < 1 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	cutoff, err := s.Models.Token.RevokeUserSessions(ctx, req.UserId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("Revoked sessions of user %d issued before %s", req.UserId, cutoff.Format(time.RFC3339))
+
+	return &sessions.RevokeUserSessionsResponse{RevokedBefore: cutoff.Unix()}, nil
+}
+
+// IsTokenRevoked lets services that verify tokens themselves honour logouts and session
+// revocations.
+func (s *SessionServer) IsTokenRevoked(ctx context.Context, req *sessions.IsTokenRevokedRequest) (*sessions.IsTokenRevokedResponse, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	revoked, err := s.Models.Token.IsTokenRevoked(ctx, req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &sessions.IsTokenRevokedResponse{Revoked: revoked}, nil
+}
+
+func (app *Config) gRPCListen() {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", gRPCPort))
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+
+	s := grpc.NewServer()
+	sessions.RegisterSessionServiceServer(s, &SessionServer{Models: app.Models})
+
+	log.Printf("gRPC Server started on port %s", gRPCPort)
+
+	if err := s.Serve(lis); err != nil {
+		log.Fatalf("Failed to serve gRPC: %v", err)
+	}
+}
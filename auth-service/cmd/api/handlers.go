@@ -2,31 +2,70 @@ package main
 
 import (
 	"auth/data"
-	"auth/users"
-	"auth/admin"
+	"proto/admins"
+	"proto/users"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	userServiceAddress = "user-service:50001"
 	adminServiceAddress = "admin-service:50002"
+
+	// loginBusyRetryAfter is how many seconds clients wait before retrying a login the user
+	// or admin service was too busy to check.
+	loginBusyRetryAfter = 1
 )
 
+// loginBusy answers a login whose password the user or admin service shed because too many
+// passwords were being hashed, or did not get to within LoginTimeout, reporting whether it
+// did. The client is asked to retry rather than told its credentials are wrong.
+func (app *Config) loginBusy(w http.ResponseWriter, err error) bool {
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.DeadlineExceeded:
+	default:
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(loginBusyRetryAfter))
+	app.errorJSON(w, fmt.Errorf("too many logins in progress, try again later"), http.StatusServiceUnavailable)
+	return true
+}
+
+// refuseLogin answers a login ValidateUser turned down. Users with the right password who
+// are blocked from logging in are told why; everyone else gets the same generic error.
+func (app *Config) refuseLogin(w http.ResponseWriter, response *users.ValidateUserResponse) {
+	switch response.GetDenial() {
+	case users.LoginDenial_LOGIN_DENIAL_DEACTIVATED:
+		app.errorJSON(w, fmt.Errorf("your account has been deactivated"), http.StatusForbidden)
+	case users.LoginDenial_LOGIN_DENIAL_SUSPENDED:
+		until := time.Unix(response.GetSuspendedUntil(), 0).UTC().Format(time.RFC3339)
+		app.errorJSON(w, fmt.Errorf("your account is suspended until %s: %s", until, response.GetStatusReason()), http.StatusForbidden)
+	case users.LoginDenial_LOGIN_DENIAL_BANNED:
+		app.errorJSON(w, fmt.Errorf("your account has been banned: %s", response.GetStatusReason()), http.StatusForbidden)
+	default:
+		app.errorJSON(w, fmt.Errorf("invalid credentials"), http.StatusUnauthorized)
+	}
+}
+
 // AuthenticateUser handles user authentication by validating email and password.
 // It assigns the "user" role if authentication is successful.
 func (app *Config) AuthenticateUser(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Mode     string `json:"mode"` // "browser" keeps the refresh token in an HttpOnly cookie
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -44,26 +83,45 @@ func (app *Config) AuthenticateUser(w http.ResponseWriter, r *http.Request) {
 	defer conn.Close()
 
 	c := users.NewUserServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	// A login the client gives up on leaves user-service's hashing queue
+	ctx, cancel := context.WithTimeout(r.Context(), app.LoginTimeout)
 	defer cancel()
 
 	response, err := c.ValidateUser(ctx, &users.ValidateUserRequest{
 		Email:    requestPayload.Email,
 		Password: requestPayload.Password,
 	})
-	if err != nil || !response.IsValid {
-		app.errorJSON(w, fmt.Errorf("invalid credentials"), http.StatusUnauthorized)
+	if err != nil {
+		if !app.loginBusy(w, err) {
+			app.errorJSON(w, fmt.Errorf("invalid credentials"), http.StatusUnauthorized)
+		}
+		return
+	}
+	if !response.IsValid {
+		app.refuseLogin(w, response)
 		return
 	}
 
 	// Generowanie tokenu z rolą "user"
-	accessToken, err := app.Models.Token.GenerateToken(ctx, int(response.UserId), data.RoleUser, 15*time.Minute, data.ScopeAuthentication, "user-key")
+	accessTokenTTL := data.AccessTokenTTL
+	if requestPayload.Mode == sessionModeBrowser {
+		accessTokenTTL = data.BrowserAccessTokenTTL
+	}
+
+	auth := data.NewAuthentication(data.AMRPassword)
+	auth.Tenant, auth.TenantRole, err = userTenant(ctx, c, response.UserId)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	accessToken, err := app.Models.Token.GenerateToken(ctx, int(response.UserId), data.RoleUser, accessTokenTTL, data.ScopeAuthentication, "user-key", auth)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	refreshToken, err := app.Models.Token.GenerateToken(ctx, int(response.UserId), data.RoleUser, 7*24*time.Hour, data.ScopeRefresh, "user-key")
+	refreshToken, err := app.Models.Token.GenerateToken(ctx, int(response.UserId), data.RoleUser, data.RefreshTokenTTL, data.ScopeRefresh, "user-key", auth)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -76,14 +134,17 @@ func (app *Config) AuthenticateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tokens, err := app.tokenPairData(w, requestPayload.Mode, accessToken, refreshToken)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("User %s authenticated successfully", requestPayload.Email),
 		Status:  http.StatusOK,
-		Data: map[string]interface{}{
-			"access_token":  accessToken,
-			"refresh_token": refreshToken,
-		},
+		Data:    tokens,
 	}
 
 	err = app.writeJSON(w, http.StatusOK, payload)
@@ -97,6 +158,7 @@ func (app *Config) AuthenticateAdmin(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Mode     string `json:"mode"` // "browser" keeps the refresh token in an HttpOnly cookie
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -112,26 +174,41 @@ func (app *Config) AuthenticateAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	c := admin.NewAdminServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	c := admins.NewAdminServiceClient(conn)
+	ctx, cancel := context.WithTimeout(r.Context(), app.LoginTimeout)
 	defer cancel()
 
-	response, err := c.ValidateAdmin(ctx, &admin.ValidateAdminRequest{
+	response, err := c.ValidateAdmin(ctx, &admins.ValidateAdminRequest{
 		Email:    requestPayload.Email,
 		Password: requestPayload.Password,
 	})
+	if app.loginBusy(w, err) {
+		return
+	}
 	if err != nil || !response.IsValid {
 		app.errorJSON(w, fmt.Errorf("invalid admin credentials"), http.StatusUnauthorized)
 		return
 	}
 
-	accessToken, err := app.Models.Token.GenerateToken(ctx, int(response.AdminId), data.RoleAdmin, 15*time.Minute, data.ScopeAuthentication, "user-key")
+	accessTokenTTL := data.AccessTokenTTL
+	if requestPayload.Mode == sessionModeBrowser {
+		accessTokenTTL = data.BrowserAccessTokenTTL
+	}
+
+	auth := data.NewAuthentication(data.AMRPassword)
+	auth.Tenant, err = adminTenant(ctx, c, response.AdminId)
+	if err != nil {
+		app.loginTenantError(w, err)
+		return
+	}
+
+	accessToken, err := app.Models.Token.GenerateToken(ctx, int(response.AdminId), data.RoleAdmin, accessTokenTTL, data.ScopeAuthentication, "user-key", auth)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	refreshToken, err := app.Models.Token.GenerateToken(ctx, int(response.AdminId), data.RoleAdmin, 7*24*time.Hour, data.ScopeRefresh, "user-key")
+	refreshToken, err := app.Models.Token.GenerateToken(ctx, int(response.AdminId), data.RoleAdmin, data.RefreshTokenTTL, data.ScopeRefresh, "user-key", auth)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -143,14 +220,17 @@ func (app *Config) AuthenticateAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tokens, err := app.tokenPairData(w, requestPayload.Mode, accessToken, refreshToken)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("Admin %s authenticated successfully", requestPayload.Email),
 		Status:  http.StatusOK,
-		Data: map[string]interface{}{
-			"access_token":  accessToken,
-			"refresh_token": refreshToken,
-		},
+		Data:    tokens,
 	}
 
 	err = app.writeJSON(w, http.StatusOK, payload)
@@ -171,7 +251,7 @@ func (app *Config) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, err := app.Models.Token.RefreshAccessToken(context.Background(), requestPayload.RefreshToken, "user-key")
+	accessToken, err := app.Models.Token.RefreshAccessToken(context.Background(), requestPayload.RefreshToken, "user-key", data.AccessTokenTTL)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusUnauthorized)
 		return
@@ -290,6 +370,226 @@ func (app *Config) RevokeToken(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ImpersonateUser issues a short-lived, non-refreshable access token that lets the
+// calling admin act as the given user. The token carries an "act" claim naming the admin.
+// Tenant admins can only impersonate members of their tenant, and act in it.
+func (app *Config) ImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("unable to retrieve admin ID from context"), http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		UserID int64  `json:"user_id"`
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.UserID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(requestPayload.Reason) == "" {
+		app.errorJSON(w, fmt.Errorf("a reason is required to impersonate a user"), http.StatusBadRequest)
+		return
+	}
+
+	// The user has to exist, and for tenant admins be a member of their tenant, before a
+	// token is minted for them
+	tenant, _ := r.Context().Value(ContextKeyTenant).(string)
+	client, conn, err := dialUserService()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	_, err = client.GetUser(ctx, &users.GetUserRequest{UserId: requestPayload.UserID, Tenant: tenant})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			app.errorJSON(w, fmt.Errorf("user %d not found", requestPayload.UserID), http.StatusNotFound)
+		case codes.PermissionDenied:
+			app.errorJSON(w, fmt.Errorf("user %d cannot be impersonated", requestPayload.UserID), http.StatusForbidden)
+		default:
+			app.errorJSON(w, err)
+		}
+		return
+	}
+
+	accessToken, err := app.Models.Token.GenerateImpersonationToken(r.Context(), requestPayload.UserID, adminID, tenant, "user-key")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logRequest("ImpersonateUser", fmt.Sprintf("Admin %d started impersonating user %d: %s", adminID, requestPayload.UserID, requestPayload.Reason))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Impersonation token issued for user %d", requestPayload.UserID),
+		Status:  http.StatusOK,
+		Data: map[string]interface{}{
+			"access_token": accessToken,
+			"expires_in":   int(data.ImpersonationTTL.Seconds()),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// Reauthenticate checks the password of an already logged in user or admin and issues a
+// new access token with a fresh auth_time, for operations that demand a recent login. The
+// refresh token, and so the session, stays the same.
+func (app *Config) Reauthenticate(w http.ResponseWriter, r *http.Request) {
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing or invalid Authorization header"), http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := app.Models.Token.ParseToken(r.Context(), tokenString, data.ScopeAuthentication)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	if claims.Act != nil {
+		app.errorJSON(w, fmt.Errorf("impersonation tokens cannot be re-authenticated"), http.StatusForbidden)
+		return
+	}
+
+	var requestPayload struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Mode     string `json:"mode"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.LoginTimeout)
+	defer cancel()
+
+	var validatedID int64
+	var userClient users.UserServiceClient
+	switch claims.Role {
+	case data.RoleUser:
+		conn, err := grpc.Dial(userServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		defer conn.Close()
+
+		userClient = users.NewUserServiceClient(conn)
+		response, err := userClient.ValidateUser(ctx, &users.ValidateUserRequest{
+			Email:    requestPayload.Email,
+			Password: requestPayload.Password,
+		})
+		if app.loginBusy(w, err) {
+			return
+		}
+		if err == nil && response.IsValid {
+			validatedID = response.UserId
+		}
+	case data.RoleAdmin:
+		conn, err := grpc.Dial(adminServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		defer conn.Close()
+
+		response, err := admins.NewAdminServiceClient(conn).ValidateAdmin(ctx, &admins.ValidateAdminRequest{
+			Email:    requestPayload.Email,
+			Password: requestPayload.Password,
+		})
+		if app.loginBusy(w, err) {
+			return
+		}
+		if err == nil && response.IsValid {
+			validatedID = response.AdminId
+		}
+	}
+
+	// The credentials have to belong to the account the token was issued to
+	if validatedID == 0 || validatedID != claims.UserID {
+		app.errorJSON(w, fmt.Errorf("invalid credentials"), http.StatusUnauthorized)
+		return
+	}
+
+	accessTokenTTL := data.AccessTokenTTL
+	if requestPayload.Mode == sessionModeBrowser {
+		accessTokenTTL = data.BrowserAccessTokenTTL
+	}
+
+	// The new token stays in the tenant the old one was for, with the role the user has
+	// there now, looked up as at login
+	auth := data.NewAuthentication(data.AMRPassword)
+	auth.Tenant = claims.Tenant
+	if userClient != nil && claims.Tenant != "" {
+		var member bool
+		auth.TenantRole, member, err = tenantRole(ctx, userClient, claims.UserID, claims.Tenant)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		if !member {
+			app.errorJSON(w, fmt.Errorf("you are no longer a member of tenant %s, switch to another tenant", claims.Tenant), http.StatusForbidden)
+			return
+		}
+	}
+
+	accessToken, err := app.Models.Token.GenerateToken(ctx, int(claims.UserID), claims.Role, accessTokenTTL, data.ScopeAuthentication, "user-key", auth)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logRequest("Reauthenticate", fmt.Sprintf("%s %d re-authenticated", claims.Role, claims.UserID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Re-authenticated successfully",
+		Status:  http.StatusOK,
+		Data: map[string]interface{}{
+			"access_token": accessToken,
+			"expires_in":   int(accessTokenTTL.Seconds()),
+			"auth_time":    auth.Time.Unix(),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
 // Logout handles the process of logging out a user or admin by revoking their access token.
 func (app *Config) Logout(w http.ResponseWriter, r *http.Request) {
 
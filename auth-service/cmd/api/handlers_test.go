@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoginBusy(t *testing.T) {
+	app := &Config{}
+
+	for _, tt := range []struct {
+		name string
+		err  error
+		busy bool
+	}{
+		{"shed", status.Error(codes.ResourceExhausted, "busy"), true},
+		{"still queued at the deadline", status.Error(codes.DeadlineExceeded, "deadline exceeded"), true},
+		{"wrong password", nil, false},
+		{"service down", status.Error(codes.Unavailable, "unavailable"), false},
+		{"other error", errors.New("boom"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if busy := app.loginBusy(w, tt.err); busy != tt.busy {
+				t.Fatalf("loginBusy = %v, want %v", busy, tt.busy)
+			}
+			if !tt.busy {
+				return
+			}
+			if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+				t.Fatalf("status = %d, Retry-After = %q, want 503 and 1", w.Code, w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
@@ -3,8 +3,12 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
+
+	"auth/data"
 )
 
 type jsonResponse struct {
@@ -109,3 +113,27 @@ func (app *Config) invalidCredentials(w http.ResponseWriter) error {
 	}
 	return nil
 }
+
+// verifiedClaims verifies the bearer access token of a request for handlers that hand out
+// new credentials. Besides checking the signature, it makes sure the token has not been
+// revoked and was not issued for impersonation.
+func (app *Config) verifiedClaims(w http.ResponseWriter, r *http.Request) (*data.JWTClaims, bool) {
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing or invalid Authorization header"), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	claims, err := app.Models.Token.ParseToken(r.Context(), tokenString, data.ScopeAuthentication)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if claims.Act != nil {
+		app.errorJSON(w, fmt.Errorf("not allowed with an impersonation token"), http.StatusForbidden)
+		return nil, false
+	}
+
+	return claims, true
+}
@@ -13,13 +13,28 @@ import (
 	"github.com/go-redis/redis/v8"
 )
 
-const webPort = "80"
+const (
+	webPort  = "80"
+	gRPCPort = "50004"
+
+	// defaultLoginTimeout is how long logins wait for a password check unless LOGIN_TIMEOUT
+	// is set. Checks queue in user-service and admin-service while their hashing workers are
+	// busy, so this leaves time to get through the queue, and stays below the server's
+	// WriteTimeout so the answer still reaches the client.
+	defaultLoginTimeout = 4 * time.Second
+)
 
 // Config is a structure of an application
 type Config struct {
 	RedisClient *redis.Client
 	Models      data.Models
 	KeyManager  *data.KeyManager
+
+	// DeviceVerificationURI is the page where users enter device user codes
+	DeviceVerificationURI string
+
+	// LoginTimeout bounds the password check of a login or re-authentication
+	LoginTimeout time.Duration
 }
 
 func main() {
@@ -40,12 +55,43 @@ func main() {
 		log.Fatalf("failed to initialize key manager: %v", err)
 	}
 
+	models := data.New(redisClient, keyManager)
+
+	models.WebAuthn.WebAuthn, err = data.NewWebAuthnFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure WebAuthn: %v", err)
+	}
+
+	models.OIDC.Providers, err = data.OIDCProvidersFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure OIDC providers: %v", err)
+	}
+
+	models.SAML.ServiceProvider, err = data.SAMLServiceProviderFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure the SAML service provider: %v", err)
+	}
+	models.SAML.Providers = adminSAMLProviders{}
+
+	loginTimeout := defaultLoginTimeout
+	if v := os.Getenv("LOGIN_TIMEOUT"); v != "" {
+		loginTimeout, err = time.ParseDuration(v)
+		if err != nil || loginTimeout <= 0 {
+			log.Fatalf("invalid LOGIN_TIMEOUT: %q", v)
+		}
+	}
+
 	app := Config{
 		RedisClient: redisClient,
-		Models:      data.New(redisClient, keyManager),
+		Models:      models,
 		KeyManager:  keyManager,
+
+		DeviceVerificationURI: os.Getenv("DEVICE_VERIFICATION_URI"),
+		LoginTimeout:          loginTimeout,
 	}
 
+	go app.gRPCListen()
+
 	srv := &http.Server{
 		Addr:              fmt.Sprintf(":%s", webPort),
 		Handler:           app.routes(),
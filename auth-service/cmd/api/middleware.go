@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strings"
@@ -14,6 +15,9 @@ type contextKey string
 // ContextKeyUserID is key to store userID
 const ContextKeyUserID = contextKey("userID")
 
+// ContextKeyTenant is key to store the tenant the token is for, if any
+const ContextKeyTenant = contextKey("tenant")
+
 // AuthMiddleware checks Authentication Header for both users and admins
 func (app *Config) AuthMiddleware(requiredRole string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -76,9 +80,34 @@ func (app *Config) AuthMiddleware(requiredRole string) func(http.Handler) http.H
 
 			// Store the user ID in the context
 			ctx := context.WithValue(r.Context(), ContextKeyUserID, int64(userID))
+			if tenant, ok := claims["tenant"].(string); ok && tenant != "" {
+				ctx = context.WithValue(ctx, ContextKeyTenant, tenant)
+			}
 
 			// Call the next handler with the new context
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// CSRFProtect enforces the double-submit check on state-changing requests: the
+// X-CSRF-Token header has to match the csrf_token cookie set at login.
+func (app *Config) CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(csrfCookieName)
+		header := r.Header.Get(csrfHeaderName)
+		if err != nil || cookie.Value == "" || header == "" ||
+			subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			app.errorJSON(w, fmt.Errorf("missing or invalid CSRF token"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
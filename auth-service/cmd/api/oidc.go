@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"auth/data"
+	"proto/users"
+
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Users can log in through an external OpenID Connect provider. The provider's subject is
+// linked to a users row by user-service: automatically when the provider has verified an
+// email address that matches an account, or explicitly by redeeming a link token while
+// logged in to the account.
+
+// OIDCLogin redirects the user to the provider's login page.
+func (app *Config) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	url, err := app.Models.OIDC.AuthCodeURL(r.Context(), chi.URLParam(r, "provider"), r.URL.Query().Get("mode"))
+	if err != nil {
+		if errors.Is(err, data.ErrOIDCProviderNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// OIDCCallback completes a login when the provider redirects back. A linked user gets the
+// usual token pair; otherwise the response carries a link token for LinkOIDCIdentity.
+func (app *Config) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	providerName := chi.URLParam(r, "provider")
+	query := r.URL.Query()
+
+	if e := query.Get("error"); e != "" {
+		app.errorJSON(w, fmt.Errorf("%s: %s", e, query.Get("error_description")), http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	identity, mode, err := app.Models.OIDC.Exchange(ctx, providerName, query.Get("state"), query.Get("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrOIDCProviderNotFound):
+			app.errorJSON(w, err, http.StatusNotFound)
+		case errors.Is(err, data.ErrOIDCStateNotFound):
+			app.errorJSON(w, err, http.StatusBadRequest)
+		default:
+			app.errorJSON(w, err, http.StatusUnauthorized)
+		}
+		return
+	}
+
+	app.completeExternalLogin(ctx, w, "OIDCCallback", identity, mode)
+}
+
+// completeExternalLogin resolves an identity confirmed by an external provider to a user
+// and answers with the usual token pair, or with a link token when no account claims it.
+func (app *Config) completeExternalLogin(ctx context.Context, w http.ResponseWriter, action string, identity *data.ExternalIdentity, mode string) {
+	client, conn, err := dialUserService()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer conn.Close()
+
+	response, err := client.ResolveExternalIdentity(ctx, &users.ResolveExternalIdentityRequest{
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Tenant:        identity.Tenant,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			app.accountNotLinked(ctx, w, identity)
+		case codes.AlreadyExists:
+			app.errorJSON(w, fmt.Errorf("the account with this email is already linked to another %s login", identity.Provider), http.StatusConflict)
+		case codes.PermissionDenied:
+			app.errorJSON(w, fmt.Errorf("this account has been deactivated"), http.StatusForbidden)
+		default:
+			app.errorJSON(w, err)
+		}
+		return
+	}
+
+	accessTokenTTL := data.AccessTokenTTL
+	if mode == sessionModeBrowser {
+		accessTokenTTL = data.BrowserAccessTokenTTL
+	}
+
+	// The login happened at the provider, so its time and methods are carried over
+	auth := data.Authentication{Time: identity.AuthTime, Methods: identity.AMR}
+
+	// SAML logins act in the tenant whose identity provider the user came from, and only
+	// its members can log in through it
+	if identity.Tenant != "" {
+		var member bool
+		auth.Tenant = identity.Tenant
+		auth.TenantRole, member, err = tenantRole(ctx, client, response.UserId, identity.Tenant)
+		if err == nil && !member {
+			app.errorJSON(w, fmt.Errorf("you are not a member of tenant %q", identity.Tenant), http.StatusForbidden)
+			return
+		}
+	} else {
+		auth.Tenant, auth.TenantRole, err = userTenant(ctx, client, response.UserId)
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	accessToken, err := app.Models.Token.GenerateToken(ctx, int(response.UserId), data.RoleUser, accessTokenTTL, data.ScopeAuthentication, "user-key", auth)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	refreshToken, err := app.Models.Token.GenerateToken(ctx, int(response.UserId), data.RoleUser, data.RefreshTokenTTL, data.ScopeRefresh, "user-key", auth)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	message := fmt.Sprintf("User %d authenticated with %s", response.UserId, identity.Provider)
+	if response.Linked {
+		message = fmt.Sprintf("User %d authenticated with %s, linked by verified email", response.UserId, identity.Provider)
+	}
+
+	err = app.logRequest(action, message)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	tokens, err := app.tokenPairData(w, mode, accessToken, refreshToken)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Status:  http.StatusOK,
+		Data:    tokens,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// accountNotLinked answers an external login that no account claims yet with a link token.
+func (app *Config) accountNotLinked(ctx context.Context, w http.ResponseWriter, identity *data.ExternalIdentity) {
+	linkToken, err := app.Models.OIDC.CreateLinkToken(ctx, identity)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   true,
+		Message: fmt.Sprintf("This %s login is not linked to an account. Log in and link it to continue.", identity.Provider),
+		Status:  http.StatusConflict,
+		Data: map[string]interface{}{
+			"error":      "account_not_linked",
+			"provider":   identity.Provider,
+			"email":      identity.Email,
+			"link_token": linkToken,
+			"expires_in": int(data.OIDCLinkTokenTTL.Seconds()),
+		},
+	}
+
+	app.writeJSON(w, http.StatusConflict, payload)
+}
+
+// LinkOIDCIdentity links the external login behind a link token to the logged in user. SAML
+// logins hand out the same link tokens.
+func (app *Config) LinkOIDCIdentity(w http.ResponseWriter, r *http.Request) {
+	claims, ok := app.verifiedClaims(w, r)
+	if !ok {
+		return
+	}
+
+	if claims.Role != data.RoleUser {
+		app.errorJSON(w, fmt.Errorf("external logins can only be linked to users"), http.StatusForbidden)
+		return
+	}
+
+	if claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > recentAuthMaxAge {
+		app.insufficientAuthentication(w, recentAuthMaxAge)
+		return
+	}
+
+	var requestPayload struct {
+		LinkToken string `json:"link_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	identity, err := app.Models.OIDC.ConsumeLinkToken(ctx, requestPayload.LinkToken)
+	if err != nil {
+		if errors.Is(err, data.ErrOIDCLinkNotFound) {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	client, conn, err := dialUserService()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer conn.Close()
+
+	response, err := client.LinkExternalIdentity(ctx, &users.LinkExternalIdentityRequest{
+		UserId:   claims.UserID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			app.errorJSON(w, fmt.Errorf("this %s login or your account is already linked", identity.Provider), http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logRequest("LinkOIDCIdentity", fmt.Sprintf("User %d linked a %s login", claims.UserID, identity.Provider))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%s login linked", identity.Provider),
+		Status:  http.StatusCreated,
+		Data: map[string]interface{}{
+			"id":       response.Id,
+			"provider": identity.Provider,
+			"email":    identity.Email,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
@@ -25,11 +25,44 @@ func (app *Config) routes() http.Handler {
 	mux.Post("/api/auth/login", app.AuthenticateUser)
 	mux.Post("/api/admin/login", app.AuthenticateAdmin)
 	mux.Post("/api/auth/refresh", app.RefreshToken) 
+	mux.Post("/api/auth/reauthenticate", app.Reauthenticate)
+	mux.Post("/api/auth/switch-tenant", app.SwitchTenant)
+
+	// Device authorization grant (RFC 8628)
+	mux.Post("/api/auth/device/code", app.DeviceAuthorization)
+	mux.Post("/api/auth/device/token", app.DeviceToken)
+	mux.Get("/api/auth/device/verify", app.GetDeviceAuthorization)
+	mux.Post("/api/auth/device/verify", app.DecideDeviceAuthorization)
+
+	// Passkeys (WebAuthn)
+	mux.Post("/api/auth/webauthn/register/begin", app.BeginPasskeyRegistration)
+	mux.Post("/api/auth/webauthn/register/finish", app.FinishPasskeyRegistration)
+	mux.Post("/api/auth/webauthn/login/begin", app.BeginPasskeyLogin)
+	mux.Post("/api/auth/webauthn/login/finish", app.FinishPasskeyLogin)
+
+	// External identity providers (OpenID Connect)
+	mux.Get("/api/auth/oidc/{provider}/login", app.OIDCLogin)
+	mux.Get("/api/auth/oidc/{provider}/callback", app.OIDCCallback)
+	mux.Post("/api/auth/oidc/link", app.LinkOIDCIdentity)
+
+	// Enterprise single sign-on (SAML 2.0), one service provider per tenant
+	mux.Get("/api/auth/saml/{tenant}/metadata", app.SAMLMetadata)
+	mux.Get("/api/auth/saml/{tenant}/login", app.SAMLLogin)
+	mux.Post("/api/auth/saml/{tenant}/acs", app.SAMLAssertionConsumer)
+
+	// Browser sessions authenticate with the refresh token cookie, so they need CSRF protection
+	mux.Route("/api/auth/session", func(mux chi.Router) {
+		mux.Use(app.CSRFProtect)
+
+		mux.Post("/refresh", app.RefreshSession)
+		mux.Post("/logout", app.EndSession)
+	})
 
 	mux.Route("/api/admin", func(mux chi.Router) {
 		mux.Use(app.AuthMiddleware("admin"))
 		
 		mux.Post("/api/auth/revoke", app.RevokeToken)
+		mux.Post("/impersonate", app.ImpersonateUser)
 		//mux.Post("/all-tokens/{id}", app.OneToken) 
 	})
 
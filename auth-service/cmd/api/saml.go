@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"auth/data"
+	"proto/admins"
+
+	"github.com/crewjam/saml"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Enterprise tenants can log in through their own SAML 2.0 identity provider, configured in
+// admin-service. Logins are SP-initiated; the NameID is linked to a users row by user-service
+// the same way as OIDC subjects, under the provider name "saml:<tenant>".
+
+// SAMLMetadata serves the service provider metadata for a tenant's identity provider.
+func (app *Config) SAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	metadata, err := app.Models.SAML.Metadata(ctx, chi.URLParam(r, "tenant"))
+	if err != nil {
+		app.samlError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(metadata)
+}
+
+// SAMLLogin redirects the user to the tenant's identity provider with an AuthnRequest.
+func (app *Config) SAMLLogin(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	url, err := app.Models.SAML.AuthnRequestURL(ctx, chi.URLParam(r, "tenant"), r.URL.Query().Get("mode"))
+	if err != nil {
+		app.samlError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// SAMLAssertionConsumer is the Assertion Consumer Service the identity provider posts its
+// response to. A linked user gets the usual token pair; otherwise the response carries a
+// link token for LinkOIDCIdentity.
+func (app *Config) SAMLAssertionConsumer(w http.ResponseWriter, r *http.Request) {
+	tenant := chi.URLParam(r, "tenant")
+
+	err := r.ParseForm()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	identity, mode, err := app.Models.SAML.ParseResponse(ctx, tenant, r.PostForm.Get("RelayState"), r.PostForm.Get("SAMLResponse"))
+	if err != nil {
+		// The reason a response was rejected goes to the log only
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			app.logRequest("SAMLAssertionConsumer", fmt.Sprintf("Rejected SAML response for tenant %s: %v", tenant, invalid.PrivateErr))
+		}
+		app.samlError(w, err)
+		return
+	}
+
+	app.completeExternalLogin(ctx, w, "SAMLAssertionConsumer", identity, mode)
+}
+
+func (app *Config) samlError(w http.ResponseWriter, err error) {
+	var badStatus saml.ErrBadStatus
+
+	switch {
+	case errors.Is(err, data.ErrSAMLNotConfigured), errors.Is(err, data.ErrSAMLProviderNotFound):
+		app.errorJSON(w, err, http.StatusNotFound)
+	case errors.Is(err, data.ErrSAMLStateNotFound):
+		app.errorJSON(w, err, http.StatusBadRequest)
+	case errors.Is(err, data.ErrSAMLAssertionReplayed), errors.Is(err, data.ErrSAMLUnsupportedIdentity):
+		app.errorJSON(w, err, http.StatusUnauthorized)
+	case errors.As(err, &badStatus):
+		app.errorJSON(w, fmt.Errorf("the identity provider did not log you in: %s", badStatus.Status), http.StatusUnauthorized)
+	default:
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			app.errorJSON(w, fmt.Errorf("invalid SAML response"), http.StatusUnauthorized)
+			return
+		}
+		app.errorJSON(w, err, http.StatusBadGateway)
+	}
+}
+
+// adminSAMLProviders reads the tenants' identity providers from admin-service.
+type adminSAMLProviders struct{}
+
+func (adminSAMLProviders) GetSAMLProvider(ctx context.Context, tenant string) (*data.SAMLIdentityProvider, error) {
+	conn, err := grpc.DialContext(ctx, adminServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	response, err := admins.NewSAMLProviderServiceClient(conn).GetSAMLProvider(ctx, &admins.GetSAMLProviderRequest{Tenant: tenant})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, data.ErrSAMLProviderNotFound
+		}
+		return nil, err
+	}
+
+	cert, err := data.ParseSAMLCertificate(response.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate for tenant %s: %v", tenant, err)
+	}
+
+	return &data.SAMLIdentityProvider{
+		Tenant:             response.Tenant,
+		EntityID:           response.EntityId,
+		SSOURL:             response.SsoUrl,
+		Certificate:        cert,
+		EmailAttribute:     response.EmailAttribute,
+		FirstNameAttribute: response.FirstNameAttribute,
+		LastNameAttribute:  response.LastNameAttribute,
+		TrustEmail:         response.TrustEmail,
+	}, nil
+}
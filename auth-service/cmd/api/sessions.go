@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"auth/data"
+)
+
+// Browser sessions keep the refresh token out of JavaScript's reach: it lives in an
+// HttpOnly cookie scoped to the session endpoints, while the short-lived access token is
+// returned in the response body and kept in memory by the frontend. Requests relying on
+// the cookie are protected against CSRF with a double-submit token.
+const (
+	sessionModeBrowser = "browser"
+
+	refreshCookieName = "__Secure-refresh_token"
+	csrfCookieName    = "csrf_token"
+	csrfHeaderName    = "X-CSRF-Token"
+	sessionPath       = "/api/auth/session"
+)
+
+// tokenPairData builds the token part of a login response. In browser mode the refresh
+// token is set as a cookie instead of being returned, together with a fresh CSRF token.
+func (app *Config) tokenPairData(w http.ResponseWriter, mode, accessToken, refreshToken string) (map[string]interface{}, error) {
+	if mode != sessionModeBrowser {
+		return map[string]interface{}{
+			"access_token":  accessToken,
+			"refresh_token": refreshToken,
+		}, nil
+	}
+
+	csrfToken, err := generateCSRFToken()
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    refreshToken,
+		Path:     sessionPath,
+		MaxAge:   int(data.RefreshTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	// Readable by the frontend, which echoes it back in the X-CSRF-Token header
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   int(data.RefreshTokenTTL.Seconds()),
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return map[string]interface{}{
+		"access_token": accessToken,
+		"expires_in":   int(data.BrowserAccessTokenTTL.Seconds()),
+		"csrf_token":   csrfToken,
+	}, nil
+}
+
+// RefreshSession issues a new short-lived access token for a browser session, using the
+// refresh token from the session cookie. The refresh token is rotated: the cookie gets a new
+// one and the old one stops working.
+func (app *Config) RefreshSession(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(refreshCookieName)
+	if err != nil || cookie.Value == "" {
+		app.errorJSON(w, fmt.Errorf("missing session cookie"), http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, refreshToken, err := app.Models.Token.RotateRefreshToken(r.Context(), cookie.Value, "user-key", data.BrowserAccessTokenTTL)
+	if err != nil {
+		app.clearSessionCookies(w)
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	// The new refresh token replaces the cookie, with a new CSRF token alongside
+	tokens, err := app.tokenPairData(w, sessionModeBrowser, accessToken, refreshToken)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logRequest("RefreshSession", "Browser session access token refreshed successfully")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Access token refreshed successfully",
+		Status:  http.StatusOK,
+		Data:    tokens,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// EndSession logs a browser session out by revoking the refresh token from the session
+// cookie (and the access token, if one is sent) and clearing the cookies.
+func (app *Config) EndSession(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(refreshCookieName)
+	if err != nil || cookie.Value == "" {
+		app.errorJSON(w, fmt.Errorf("missing session cookie"), http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+
+	err = app.Models.Token.InsertDeactivatedToken(ctx, cookie.Value, data.RefreshTokenTTL)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		err = app.Models.Token.InsertDeactivatedToken(ctx, accessToken, data.BrowserAccessTokenTTL)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	app.clearSessionCookies(w)
+
+	err = app.logRequest("EndSession", "Browser session logged out successfully")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Logged out successfully",
+		Status:  http.StatusOK,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// clearSessionCookies expires the refresh and CSRF cookies in the browser.
+func (app *Config) clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookieName,
+		Path:     sessionPath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// generateCSRFToken returns a random token for the double-submit cookie.
+func generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate CSRF token: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
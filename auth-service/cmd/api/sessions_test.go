@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+
+	"auth/data"
+)
+
+// loggerTransport answers logger-service's requests, so handlers can log without it.
+type loggerTransport struct{}
+
+func (loggerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusAccepted, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+}
+
+// sessionTestApp is auth-service with Redis, a signing key served by a fake Vault, and
+// logger-service stubbed out.
+type sessionTestApp struct {
+	*Config
+	key *rsa.PrivateKey
+}
+
+func newSessionTestApp(t *testing.T) *sessionTestApp {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := map[string]map[string]string{
+		"/v1/jwt_keys/private_key": {"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))},
+		"/v1/jwt_keys/public_keys": {"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))},
+	}
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := secrets[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": secret})
+	}))
+	t.Cleanup(vault.Close)
+
+	keyManager, err := data.NewKeyManager(data.VaultConfig{Address: vault.URL, Token: "test"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(keyManager.Stop)
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	transport := http.DefaultTransport
+	http.DefaultTransport = loggerTransport{}
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	return &sessionTestApp{
+		Config: &Config{RedisClient: client, Models: data.New(client, keyManager), KeyManager: keyManager},
+		key:    key,
+	}
+}
+
+// refreshToken signs a refresh token for user 7 from a login a minute ago.
+func (a *sessionTestApp) refreshToken(t *testing.T) string {
+	t.Helper()
+
+	loggedIn := time.Now().Add(-time.Minute)
+	claims := data.JWTClaims{UserID: 7, Role: data.RoleUser, Scope: data.ScopeRefresh, AMR: []string{"pwd"}}
+	claims.AuthTime = jwt.NewNumericDate(loggedIn)
+	claims.IssuedAt = jwt.NewNumericDate(loggedIn)
+	claims.ExpiresAt = jwt.NewNumericDate(loggedIn.Add(data.RefreshTokenTTL))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "default"
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// session serves a state-changing request on a browser session with the refresh token
+// cookie and a matching CSRF token.
+func (a *sessionTestApp) session(path, refreshToken, accessToken string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, nil)
+	r.AddCookie(&http.Cookie{Name: refreshCookieName, Value: refreshToken})
+	r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "csrf"})
+	r.Header.Set(csrfHeaderName, "csrf")
+	if accessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	w := httptest.NewRecorder()
+	a.routes().ServeHTTP(w, r)
+	return w
+}
+
+// cookie returns the cookie set by a response, or fails.
+func cookie(t *testing.T, w *httptest.ResponseRecorder, name string) *http.Cookie {
+	t.Helper()
+
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("no %s cookie set", name)
+	return nil
+}
+
+func TestCSRFProtect(t *testing.T) {
+	app := &Config{}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		want   int
+	}{
+		{"matching token", http.MethodPost, "csrf", "csrf", http.StatusNoContent},
+		{"missing header", http.MethodPost, "csrf", "", http.StatusForbidden},
+		{"missing cookie", http.MethodPost, "", "csrf", http.StatusForbidden},
+		{"mismatched token", http.MethodPost, "csrf", "other", http.StatusForbidden},
+		{"safe method", http.MethodGet, "", "", http.StatusNoContent},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, sessionPath+"/refresh", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set(csrfHeaderName, tt.header)
+			}
+			w := httptest.NewRecorder()
+			app.CSRFProtect(next).ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestBrowserSessionCookies(t *testing.T) {
+	w := httptest.NewRecorder()
+	tokens, err := (&Config{}).tokenPairData(w, sessionModeBrowser, "access", "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := cookie(t, w, refreshCookieName)
+	if refresh.Value != "refresh" || refresh.Path != "/api/auth/session" || refresh.SameSite != http.SameSiteStrictMode || !refresh.Secure || !refresh.HttpOnly {
+		t.Fatalf("refresh cookie = %+v, want HttpOnly, Secure and SameSite=Strict on /api/auth/session", refresh)
+	}
+
+	// The CSRF token is read by the frontend, so it is not HttpOnly
+	csrf := cookie(t, w, csrfCookieName)
+	if csrf.Value == "" || csrf.Value != tokens["csrf_token"] || csrf.HttpOnly || !csrf.Secure || csrf.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("CSRF cookie = %+v, want the token from the response body", csrf)
+	}
+
+	if _, ok := tokens["refresh_token"]; ok {
+		t.Fatal("the refresh token is in the response body")
+	}
+}
+
+func TestRefreshSessionRotatesRefreshToken(t *testing.T) {
+	app := newSessionTestApp(t)
+	old := app.refreshToken(t)
+
+	w := app.session(sessionPath+"/refresh", old, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	var response struct {
+		Data struct {
+			AccessToken string `json:"access_token"`
+			CSRFToken   string `json:"csrf_token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Data.AccessToken == "" || response.Data.CSRFToken != cookie(t, w, csrfCookieName).Value {
+		t.Fatalf("response = %s, want an access token and the new CSRF token", w.Body)
+	}
+
+	// The new refresh token is for the same login and does not outlive the old one
+	rotated := cookie(t, w, refreshCookieName).Value
+	if rotated == "" || rotated == old {
+		t.Fatal("the refresh token was not rotated")
+	}
+	var oldClaims, newClaims data.JWTClaims
+	keyFunc := func(*jwt.Token) (interface{}, error) { return &app.key.PublicKey, nil }
+	if _, err := jwt.ParseWithClaims(old, &oldClaims, keyFunc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.ParseWithClaims(rotated, &newClaims, keyFunc); err != nil {
+		t.Fatal(err)
+	}
+	if newClaims.UserID != 7 || newClaims.Scope != data.ScopeRefresh || !newClaims.AuthTime.Equal(oldClaims.AuthTime.Time) {
+		t.Errorf("rotated claims = %+v, want a refresh token of the same login", newClaims)
+	}
+	if newClaims.ExpiresAt.After(oldClaims.ExpiresAt.Time) {
+		t.Errorf("rotated token expires at %v, after the old one at %v", newClaims.ExpiresAt, oldClaims.ExpiresAt)
+	}
+
+	// The old refresh token only works once
+	w = app.session(sessionPath+"/refresh", old, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("reusing the old refresh token: status = %d, want 401", w.Code)
+	}
+	if c := cookie(t, w, refreshCookieName); c.MaxAge >= 0 {
+		t.Fatalf("refresh cookie = %+v, want it cleared", c)
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	app := newSessionTestApp(t)
+	refreshToken := app.refreshToken(t)
+
+	w := app.session(sessionPath+"/logout", refreshToken, "access")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	for _, name := range []string{refreshCookieName, csrfCookieName} {
+		if c := cookie(t, w, name); c.MaxAge >= 0 || c.Value != "" {
+			t.Errorf("%s cookie = %+v, want it cleared", name, c)
+		}
+	}
+	if c := cookie(t, w, refreshCookieName); c.Path != sessionPath {
+		t.Errorf("refresh cookie cleared on %q, want %q", c.Path, sessionPath)
+	}
+
+	for _, token := range []string{refreshToken, "access"} {
+		deactivated, err := app.Models.Token.IsTokenDeactivated(context.Background(), token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deactivated {
+			t.Errorf("token %.10s... is still active", token)
+		}
+	}
+
+	if w := app.session(sessionPath+"/refresh", refreshToken, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh after logout: status = %d, want 401", w.Code)
+	}
+}
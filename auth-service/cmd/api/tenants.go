@@ -0,0 +1,263 @@
+package main
+
+import (
+	"auth/data"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"proto/admins"
+	"proto/users"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// errNoAdminTenant refuses tokens to admins who manage neither the platform nor a tenant.
+var errNoAdminTenant = errors.New("your admin account does not belong to any tenant")
+
+func dialAdminService() (admins.AdminServiceClient, *grpc.ClientConn, error) {
+	conn, err := grpc.Dial(adminServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return admins.NewAdminServiceClient(conn), conn, nil
+}
+
+// userTenant returns the tenant a user's tokens are for at login, and their role there: the
+// tenant they joined first. Users who belong to no tenant get tokens without one.
+func userTenant(ctx context.Context, client users.UserServiceClient, userID int64) (string, string, error) {
+	response, err := client.GetUserTenants(ctx, &users.GetUserTenantsRequest{UserId: userID})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load tenants: %w", err)
+	}
+
+	memberships := response.GetMemberships()
+	if len(memberships) == 0 {
+		return "", "", nil
+	}
+
+	return memberships[0].GetTenant(), memberships[0].GetRole(), nil
+}
+
+// tenantRole returns a user's role in tenant, and false if they are not a member of it.
+func tenantRole(ctx context.Context, client users.UserServiceClient, userID int64, tenant string) (string, bool, error) {
+	response, err := client.GetUserTenants(ctx, &users.GetUserTenantsRequest{UserId: userID})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to load tenants: %w", err)
+	}
+
+	for _, m := range response.GetMemberships() {
+		if m.GetTenant() == tenant {
+			return m.GetRole(), true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// adminTenant returns the tenant an admin's tokens are for at login: none for platform
+// admins, who manage every tenant, otherwise the first tenant they manage.
+func adminTenant(ctx context.Context, client admins.AdminServiceClient, adminID int64) (string, error) {
+	response, err := client.GetAdminTenants(ctx, &admins.GetAdminTenantsRequest{AdminId: adminID})
+	if err != nil {
+		return "", fmt.Errorf("failed to load tenants: %w", err)
+	}
+
+	if response.GetPlatform() {
+		return "", nil
+	}
+	if len(response.GetTenants()) == 0 {
+		return "", errNoAdminTenant
+	}
+
+	return response.GetTenants()[0], nil
+}
+
+// loginTenant fills in the tenant of a new login by a user or an admin.
+func loginTenant(ctx context.Context, auth *data.Authentication, role string, id int64) error {
+	switch role {
+	case data.RoleUser:
+		client, conn, err := dialUserService()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		auth.Tenant, auth.TenantRole, err = userTenant(ctx, client, id)
+		return err
+	case data.RoleAdmin:
+		client, conn, err := dialAdminService()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		auth.Tenant, err = adminTenant(ctx, client, id)
+		return err
+	}
+
+	return nil
+}
+
+// loginTenantError answers a login whose tenant could not be determined.
+func (app *Config) loginTenantError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNoAdminTenant) {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+	app.errorJSON(w, err)
+}
+
+// SwitchTenant issues a new token pair for another tenant the user or admin belongs to.
+// The pair descends from the same login, so auth_time and amr carry over and operations
+// that need a recent login stay available. The old access token is deactivated, and so is
+// the old refresh token if it is sent along.
+func (app *Config) SwitchTenant(w http.ResponseWriter, r *http.Request) {
+	claims, ok := app.verifiedClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Tenant       string `json:"tenant"`
+		RefreshToken string `json:"refresh_token"`
+		Mode         string `json:"mode"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	auth := data.Authentication{Methods: claims.AMR}
+	if claims.AuthTime != nil {
+		auth.Time = claims.AuthTime.Time
+	}
+
+	var member bool
+	switch claims.Role {
+	case data.RoleUser:
+		if requestPayload.Tenant == "" {
+			app.errorJSON(w, fmt.Errorf("tenant is required"), http.StatusBadRequest)
+			return
+		}
+
+		client, conn, err := dialUserService()
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		defer conn.Close()
+
+		auth.Tenant = requestPayload.Tenant
+		auth.TenantRole, member, err = tenantRole(ctx, client, claims.UserID, requestPayload.Tenant)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	case data.RoleAdmin:
+		client, conn, err := dialAdminService()
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		defer conn.Close()
+
+		response, err := client.GetAdminTenants(ctx, &admins.GetAdminTenantsRequest{AdminId: claims.UserID})
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		// Platform admins can narrow their tokens down to any tenant, and widen them again
+		auth.Tenant = requestPayload.Tenant
+		member = response.GetPlatform() || slices.Contains(response.GetTenants(), requestPayload.Tenant)
+	}
+
+	if !member {
+		app.errorJSON(w, fmt.Errorf("you are not a member of tenant %q", requestPayload.Tenant), http.StatusForbidden)
+		return
+	}
+
+	// Only a refresh token of the same account is deactivated
+	if requestPayload.RefreshToken != "" {
+		refreshClaims, err := app.Models.Token.ParseToken(ctx, requestPayload.RefreshToken, data.ScopeRefresh)
+		if err != nil || refreshClaims.UserID != claims.UserID || refreshClaims.Role != claims.Role {
+			app.errorJSON(w, fmt.Errorf("invalid refresh token"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	accessTokenTTL := data.AccessTokenTTL
+	if requestPayload.Mode == sessionModeBrowser {
+		accessTokenTTL = data.BrowserAccessTokenTTL
+	}
+
+	accessToken, err := app.Models.Token.GenerateToken(ctx, int(claims.UserID), claims.Role, accessTokenTTL, data.ScopeAuthentication, "user-key", auth)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	refreshToken, err := app.Models.Token.GenerateToken(ctx, int(claims.UserID), claims.Role, data.RefreshTokenTTL, data.ScopeRefresh, "user-key", auth)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	oldAccessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	err = app.Models.Token.InsertDeactivatedToken(ctx, oldAccessToken, data.AccessTokenTTL)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if requestPayload.RefreshToken != "" {
+		err = app.Models.Token.InsertDeactivatedToken(ctx, requestPayload.RefreshToken, data.RefreshTokenTTL)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	tenant := auth.Tenant
+	if tenant == "" {
+		tenant = "the platform"
+	}
+
+	err = app.logRequest("SwitchTenant", fmt.Sprintf("%s %d switched to %s", claims.Role, claims.UserID, tenant))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	tokens, err := app.tokenPairData(w, requestPayload.Mode, accessToken, refreshToken)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	tokens["tenant"] = auth.Tenant
+	if auth.TenantRole != "" {
+		tokens["tenant_role"] = auth.TenantRole
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Switched to %s", tenant),
+		Status:  http.StatusOK,
+		Data:    tokens,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"proto/users"
+)
+
+// tenantsClient answers GetUserTenants with fixed memberships.
+type tenantsClient struct {
+	users.UserServiceClient
+	memberships []*users.TenantMembership
+}
+
+func (c tenantsClient) GetUserTenants(ctx context.Context, in *users.GetUserTenantsRequest, opts ...grpc.CallOption) (*users.GetUserTenantsResponse, error) {
+	return &users.GetUserTenantsResponse{Memberships: c.memberships}, nil
+}
+
+func TestTenantRoleDoesNotFallBack(t *testing.T) {
+	client := tenantsClient{memberships: []*users.TenantMembership{
+		{Tenant: "globex", Role: "owner"},
+		{Tenant: "acme", Role: "member"},
+	}}
+
+	for _, tt := range []struct {
+		tenant string
+		role   string
+		member bool
+	}{
+		{"acme", "member", true},
+		{"globex", "owner", true},
+		{"initech", "", false},
+	} {
+		t.Run(tt.tenant, func(t *testing.T) {
+			role, member, err := tenantRole(context.Background(), client, 7, tt.tenant)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if role != tt.role || member != tt.member {
+				t.Fatalf("tenantRole = %q, %v, want %q, %v", role, member, tt.role, tt.member)
+			}
+		})
+	}
+
+	tenant, role, err := userTenant(context.Background(), client, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant != "globex" || role != "owner" {
+		t.Fatalf("userTenant = %q, %q, want the first tenant joined", tenant, role)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"auth/data"
+	"proto/users"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Passkeys (WebAuthn) let users log in with a platform or roaming authenticator instead of
+// a password. The credentials are stored by user-service; auth-service runs the ceremonies
+// and keeps the challenges in Redis between the begin and finish steps.
+
+// recentAuthMaxAge is how recent the login must be before a new way to log in, such as a
+// passkey or an external identity, can be added to the account.
+const recentAuthMaxAge = 5 * time.Minute
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create() to the
+// logged in user.
+func (app *Config) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	claims, ok := app.verifiedClaims(w, r)
+	if !ok {
+		return
+	}
+
+	if claims.Role != data.RoleUser {
+		app.errorJSON(w, fmt.Errorf("passkeys are only available to users"), http.StatusForbidden)
+		return
+	}
+
+	// A stolen access token must not be enough to plant a passkey on the account
+	if claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > recentAuthMaxAge {
+		app.insufficientAuthentication(w, recentAuthMaxAge)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	client, conn, err := dialUserService()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer conn.Close()
+
+	user, err := getWebAuthnUser(ctx, client, &users.GetWebAuthnUserRequest{UserId: claims.UserID})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	creation, sessionID, err := app.Models.WebAuthn.BeginRegistration(ctx, user)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Passkey registration started",
+		Status:  http.StatusOK,
+		Data: map[string]interface{}{
+			"session_id": sessionID,
+			"options":    creation,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response and stores the new
+// passkey. The body is the PublicKeyCredential returned by the browser; the session ID and
+// an optional display name are passed in the query string.
+func (app *Config) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	claims, ok := app.verifiedClaims(w, r)
+	if !ok {
+		return
+	}
+
+	if claims.Role != data.RoleUser {
+		app.errorJSON(w, fmt.Errorf("passkeys are only available to users"), http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	client, conn, err := dialUserService()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer conn.Close()
+
+	user, err := getWebAuthnUser(ctx, client, &users.GetWebAuthnUserRequest{UserId: claims.UserID})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+
+	credential, err := app.Models.WebAuthn.FinishRegistration(ctx, user, r.URL.Query().Get("session_id"), r.Body)
+	if err != nil {
+		app.errorJSON(w, webAuthnError(err), http.StatusBadRequest)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Passkey"
+	}
+
+	pc := credentialToProto(credential)
+	pc.Name = name
+
+	response, err := client.AddWebAuthnCredential(ctx, &users.AddWebAuthnCredentialRequest{
+		UserId:     claims.UserID,
+		Credential: pc,
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logRequest("FinishPasskeyRegistration", fmt.Sprintf("User %d registered passkey %d", claims.UserID, response.Id))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Passkey registered",
+		Status:  http.StatusCreated,
+		Data: map[string]interface{}{
+			"id":   response.Id,
+			"name": name,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get(). Without a body any
+// discoverable passkey may be used; with {"email": ...} only that user's passkeys are offered.
+func (app *Config) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &requestPayload)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	var user *data.WebAuthnUser
+	if requestPayload.Email != "" {
+		client, conn, err := dialUserService()
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		defer conn.Close()
+
+		user, err = getWebAuthnUser(ctx, client, &users.GetWebAuthnUserRequest{Email: requestPayload.Email})
+		if err != nil {
+			// Do not reveal which email addresses have accounts, or which are deactivated
+			if status.Code(err) == codes.NotFound || status.Code(err) == codes.PermissionDenied {
+				app.errorJSON(w, fmt.Errorf("no passkeys available"), http.StatusBadRequest)
+				return
+			}
+			app.errorJSON(w, err)
+			return
+		}
+
+		if len(user.Credentials) == 0 {
+			app.errorJSON(w, fmt.Errorf("no passkeys available"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	assertion, sessionID, err := app.Models.WebAuthn.BeginLogin(ctx, user)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Passkey login started",
+		Status:  http.StatusOK,
+		Data: map[string]interface{}{
+			"session_id": sessionID,
+			"options":    assertion,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// FinishPasskeyLogin verifies the authenticator's assertion and issues a token pair, just
+// like a password login. The session ID and the optional "browser" mode are passed in the
+// query string.
+func (app *Config) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	client, conn, err := dialUserService()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	defer conn.Close()
+
+	lookup := func(ctx context.Context, userID int64) (*data.WebAuthnUser, error) {
+		return getWebAuthnUser(ctx, client, &users.GetWebAuthnUserRequest{UserId: userID})
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+
+	user, credential, err := app.Models.WebAuthn.FinishLogin(ctx, r.URL.Query().Get("session_id"), r.Body, lookup)
+	if err != nil {
+		if errors.Is(err, data.ErrWebAuthnCloneWarning) {
+			app.logRequest("FinishPasskeyLogin", "Rejected a passkey login: "+err.Error())
+		}
+		app.errorJSON(w, fmt.Errorf("invalid credentials"), http.StatusUnauthorized)
+		return
+	}
+
+	_, err = client.UpdateWebAuthnCredential(ctx, &users.UpdateWebAuthnCredentialRequest{
+		CredentialId: credential.ID,
+		SignCount:    credential.Authenticator.SignCount,
+		BackupState:  credential.Flags.BackupState,
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+
+	accessTokenTTL := data.AccessTokenTTL
+	if mode == sessionModeBrowser {
+		accessTokenTTL = data.BrowserAccessTokenTTL
+	}
+
+	methods := []string{data.AMRHardwareKey}
+	if credential.Flags.UserVerified {
+		methods = append(methods, data.AMRMultiFactor)
+	}
+	auth := data.NewAuthentication(methods...)
+	auth.Tenant, auth.TenantRole, err = userTenant(ctx, client, user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	accessToken, err := app.Models.Token.GenerateToken(ctx, int(user.ID), data.RoleUser, accessTokenTTL, data.ScopeAuthentication, "user-key", auth)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	refreshToken, err := app.Models.Token.GenerateToken(ctx, int(user.ID), data.RoleUser, data.RefreshTokenTTL, data.ScopeRefresh, "user-key", auth)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logRequest("FinishPasskeyLogin", fmt.Sprintf("User %s authenticated with a passkey", user.Email))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	tokens, err := app.tokenPairData(w, mode, accessToken, refreshToken)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("User %s authenticated successfully", user.Email),
+		Status:  http.StatusOK,
+		Data:    tokens,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// insufficientAuthentication asks the client to log in again (RFC 9470), the same way
+// user-service and admin-service do for sensitive operations.
+func (app *Config) insufficientAuthentication(w http.ResponseWriter, maxAge time.Duration) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+		`Bearer error="insufficient_user_authentication", error_description="A more recent authentication is required", max_age=%d`,
+		int(maxAge.Seconds()),
+	))
+
+	payload := jsonResponse{
+		Error:   true,
+		Message: "a more recent authentication is required",
+		Status:  http.StatusUnauthorized,
+		Data: map[string]interface{}{
+			"error":   "insufficient_user_authentication",
+			"max_age": int(maxAge.Seconds()),
+		},
+	}
+
+	app.writeJSON(w, http.StatusUnauthorized, payload)
+}
+
+func dialUserService() (users.UserServiceClient, *grpc.ClientConn, error) {
+	conn, err := grpc.Dial(userServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return users.NewUserServiceClient(conn), conn, nil
+}
+
+func getWebAuthnUser(ctx context.Context, client users.UserServiceClient, req *users.GetWebAuthnUserRequest) (*data.WebAuthnUser, error) {
+	response, err := client.GetWebAuthnUser(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &data.WebAuthnUser{
+		ID:       response.UserId,
+		Email:    response.Email,
+		Username: response.Username,
+	}
+	for _, c := range response.Credentials {
+		user.Credentials = append(user.Credentials, credentialFromProto(c))
+	}
+
+	return user, nil
+}
+
+func credentialFromProto(c *users.WebAuthnCredential) webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+	for _, t := range c.Transports {
+		transports = append(transports, protocol.AuthenticatorTransport(t))
+	}
+
+	return webauthn.Credential{
+		ID:              c.Id,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			UserPresent:    true,
+			UserVerified:   c.UserVerified,
+			BackupEligible: c.BackupEligible,
+			BackupState:    c.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.Aaguid,
+			SignCount: c.SignCount,
+		},
+	}
+}
+
+func credentialToProto(c *webauthn.Credential) *users.WebAuthnCredential {
+	transports := make([]string, 0, len(c.Transport))
+	for _, t := range c.Transport {
+		transports = append(transports, string(t))
+	}
+
+	return &users.WebAuthnCredential{
+		Id:              c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transports:      transports,
+		Aaguid:          c.Authenticator.AAGUID,
+		SignCount:       c.Authenticator.SignCount,
+		BackupEligible:  c.Flags.BackupEligible,
+		BackupState:     c.Flags.BackupState,
+		UserVerified:    c.Flags.UserVerified,
+	}
+}
+
+// webAuthnError turns the library's protocol errors into something a client can act on.
+func webAuthnError(err error) error {
+	var perr *protocol.Error
+	if errors.As(err, &perr) && perr.Details != "" {
+		return fmt.Errorf("%s", perr.Details)
+	}
+	return err
+}
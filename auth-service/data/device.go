@@ -0,0 +1,344 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Device authorization grant (RFC 8628) settings.
+const (
+	DeviceCodeTTL       = 10 * time.Minute
+	DevicePollInterval  = 5 * time.Second
+	deviceSlowDownDelta = 5 * time.Second
+
+	// User codes use consonants only, so they cannot spell words and are easy to read aloud.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// Device authorization states.
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+)
+
+// Errors returned while polling for a device token. Their text is the RFC 8628 error code.
+var (
+	ErrDeviceNotFound       = errors.New("expired_token")
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+)
+
+// DeviceModel stores pending device authorizations in Redis.
+type DeviceModel struct {
+	RedisClient *redis.Client
+}
+
+// DeviceAuthorization is a device code waiting to be approved by a logged in user.
+type DeviceAuthorization struct {
+	DeviceHash   string
+	UserCode     string
+	ClientID     string
+	Status       string
+	UserID       int64
+	Role         string
+	Interval     int // seconds between polls
+	LastPolledAt time.Time
+	ExpiresAt    time.Time
+}
+
+// An authorization is kept as a Redis hash, so that polling and deciding each write only
+// their own fields. The scripts below make the writes that depend on the status atomic.
+var (
+	// decideScript moves a pending authorization to approved or denied and removes its user
+	// code. It returns 1, or the status the authorization already had.
+	decideScript = redis.NewScript(`
+local status = redis.call('HGET', KEYS[1], 'status')
+if not status then
+	return false
+end
+if status ~= ARGV[1] then
+	return status
+end
+redis.call('HSET', KEYS[1], 'status', ARGV[2], 'user_id', ARGV[3], 'role', ARGV[4])
+redis.call('DEL', KEYS[2])
+return 1
+`)
+
+	// pollScript records a poll of a pending authorization, adding to its interval when the
+	// poll came too soon. It returns 1 for too soon, 0 for in time, and -1 when the
+	// authorization is gone or no longer pending.
+	pollScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'status') ~= ARGV[1] then
+	return -1
+end
+local now = tonumber(ARGV[2])
+local last = tonumber(redis.call('HGET', KEYS[1], 'last_polled_at'))
+local interval = tonumber(redis.call('HGET', KEYS[1], 'interval'))
+redis.call('HSET', KEYS[1], 'last_polled_at', now)
+if now - last < interval * 1000 then
+	redis.call('HINCRBY', KEYS[1], 'interval', ARGV[3])
+	return 1
+end
+return 0
+`)
+)
+
+// Create starts a new device authorization for clientID and returns it with the device
+// code, which is only ever known to the client.
+func (m *DeviceModel) Create(ctx context.Context, clientID string) (*DeviceAuthorization, string, error) {
+	deviceBytes := make([]byte, 32)
+	_, err := rand.Read(deviceBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	deviceCode := base64.RawURLEncoding.EncodeToString(deviceBytes)
+
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, "", err
+	}
+
+	auth := &DeviceAuthorization{
+		DeviceHash: hashDeviceCode(deviceCode),
+		UserCode:   userCode,
+		ClientID:   clientID,
+		Status:     DeviceStatusPending,
+		Interval:   int(DevicePollInterval.Seconds()),
+		ExpiresAt:  time.Now().Add(DeviceCodeTTL),
+	}
+
+	// Claim the user code first, so two pending authorizations never share one
+	ok, err := m.RedisClient.SetNX(ctx, userCodeKey(userCode), auth.DeviceHash, DeviceCodeTTL).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to store user code: %v", err)
+	}
+	if !ok {
+		return nil, "", fmt.Errorf("user code collision, try again")
+	}
+
+	err = m.save(ctx, auth)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return auth, deviceCode, nil
+}
+
+// GetByUserCode returns the pending authorization a user typed in, accepting the code in any
+// case and with or without the separator.
+func (m *DeviceModel) GetByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	deviceHash, err := m.RedisClient.Get(ctx, userCodeKey(NormalizeUserCode(userCode))).Result()
+	if err == redis.Nil {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user code: %v", err)
+	}
+
+	return m.get(ctx, deviceHash)
+}
+
+// Decide approves or denies a pending authorization on behalf of the logged in user. Of two
+// decisions made at once, only the first counts.
+func (m *DeviceModel) Decide(ctx context.Context, auth *DeviceAuthorization, approve bool, userID int64, role string) error {
+	status := DeviceStatusDenied
+	if approve {
+		status = DeviceStatusApproved
+	} else {
+		userID, role = 0, ""
+	}
+
+	keys := []string{deviceCodeKey(auth.DeviceHash), userCodeKey(auth.UserCode)}
+	result, err := decideScript.Run(ctx, m.RedisClient, keys, DeviceStatusPending, status, userID, role).Result()
+	if err == redis.Nil {
+		return ErrDeviceNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decide device authorization: %v", err)
+	}
+	if current, ok := result.(string); ok {
+		return fmt.Errorf("device code has already been %s", current)
+	}
+
+	auth.Status = status
+	auth.UserID = userID
+	auth.Role = role
+
+	return nil
+}
+
+// Poll is called by the client with its device code. It returns the approved authorization
+// exactly once; until then it returns one of the RFC 8628 polling errors.
+func (m *DeviceModel) Poll(ctx context.Context, deviceCode, clientID string) (*DeviceAuthorization, error) {
+	deviceHash := hashDeviceCode(deviceCode)
+
+	auth, err := m.get(ctx, deviceHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth.ClientID != clientID {
+		return nil, ErrDeviceNotFound
+	}
+
+	switch auth.Status {
+	case DeviceStatusDenied:
+		m.RedisClient.Del(ctx, deviceCodeKey(deviceHash))
+		return nil, ErrAccessDenied
+	case DeviceStatusApproved:
+		// Only the poll that deletes the code gets the tokens
+		n, err := m.RedisClient.Del(ctx, deviceCodeKey(deviceHash)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to redeem device code: %v", err)
+		}
+		if n == 0 {
+			return nil, ErrDeviceNotFound
+		}
+		return auth, nil
+	}
+
+	keys := []string{deviceCodeKey(deviceHash)}
+	now := time.Now().UnixMilli()
+	tooSoon, err := pollScript.Run(ctx, m.RedisClient, keys, DeviceStatusPending, now, int(deviceSlowDownDelta.Seconds())).Int()
+	if err != nil {
+		return nil, fmt.Errorf("failed to record device poll: %v", err)
+	}
+
+	// A decision made since the authorization was read is picked up by the next poll
+	if tooSoon == 1 {
+		return nil, ErrSlowDown
+	}
+
+	return nil, ErrAuthorizationPending
+}
+
+func (m *DeviceModel) get(ctx context.Context, deviceHash string) (*DeviceAuthorization, error) {
+	fields, err := m.RedisClient.HGetAll(ctx, deviceCodeKey(deviceHash)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load device authorization: %v", err)
+	}
+	if len(fields) == 0 {
+		return nil, ErrDeviceNotFound
+	}
+
+	auth := DeviceAuthorization{
+		DeviceHash: deviceHash,
+		UserCode:   fields["user_code"],
+		ClientID:   fields["client_id"],
+		Status:     fields["status"],
+		Role:       fields["role"],
+	}
+
+	var lastPolledAt, expiresAt int64
+	for _, field := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"user_id", &auth.UserID},
+		{"interval", &auth.Interval},
+		{"last_polled_at", &lastPolledAt},
+		{"expires_at", &expiresAt},
+	} {
+		_, err = fmt.Sscan(fields[field.name], field.value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode device authorization %s: %v", field.name, err)
+		}
+	}
+	if lastPolledAt > 0 {
+		auth.LastPolledAt = time.UnixMilli(lastPolledAt)
+	}
+	auth.ExpiresAt = time.UnixMilli(expiresAt)
+
+	return &auth, nil
+}
+
+func (m *DeviceModel) save(ctx context.Context, auth *DeviceAuthorization) error {
+	ttl := time.Until(auth.ExpiresAt)
+	if ttl <= 0 {
+		return ErrDeviceNotFound
+	}
+
+	var lastPolledAt int64
+	if !auth.LastPolledAt.IsZero() {
+		lastPolledAt = auth.LastPolledAt.UnixMilli()
+	}
+
+	key := deviceCodeKey(auth.DeviceHash)
+	_, err := m.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"user_code", auth.UserCode,
+			"client_id", auth.ClientID,
+			"status", auth.Status,
+			"user_id", auth.UserID,
+			"role", auth.Role,
+			"interval", auth.Interval,
+			"last_polled_at", lastPolledAt,
+			"expires_at", auth.ExpiresAt.UnixMilli(),
+		)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store device authorization: %v", err)
+	}
+
+	return nil
+}
+
+// NormalizeUserCode uppercases a user code and formats it as XXXX-XXXX.
+func NormalizeUserCode(userCode string) string {
+	code := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+
+	if len(code) != userCodeLength {
+		return code
+	}
+
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+func generateUserCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+
+	for i := 0; i < userCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+
+	return NormalizeUserCode(b.String()), nil
+}
+
+// Only a hash of the device code is kept, so reading Redis is not enough to redeem one.
+func hashDeviceCode(deviceCode string) string {
+	sum := sha256.Sum256([]byte(deviceCode))
+	return hex.EncodeToString(sum[:])
+}
+
+func deviceCodeKey(deviceHash string) string {
+	return "device_code:" + deviceHash
+}
+
+func userCodeKey(userCode string) string {
+	return "device_user_code:" + userCode
+}
@@ -0,0 +1,193 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestDeviceModel(t *testing.T) (*DeviceModel, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return &DeviceModel{RedisClient: client}, server
+}
+
+func TestDeviceFlow(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestDeviceModel(t)
+
+	auth, deviceCode, err := m.Create(ctx, "ops-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Poll(ctx, deviceCode, "ops-cli"); !errors.Is(err, ErrAuthorizationPending) {
+		t.Fatalf("first poll: expected authorization_pending, got %v", err)
+	}
+
+	if _, err := m.Poll(ctx, deviceCode, "ops-cli"); !errors.Is(err, ErrSlowDown) {
+		t.Fatalf("immediate second poll: expected slow_down, got %v", err)
+	}
+
+	if _, err := m.Poll(ctx, deviceCode, "other-client"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("poll from another client: expected expired_token, got %v", err)
+	}
+
+	// Users may type the code in lower case and without the dash
+	lookup, err := m.GetByUserCode(ctx, " "+strings.ToLower(auth.UserCode[:4]+auth.UserCode[5:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookup.ClientID != "ops-cli" || lookup.Interval != int((DevicePollInterval+deviceSlowDownDelta).Seconds()) {
+		t.Fatalf("unexpected authorization: %+v", lookup)
+	}
+
+	if err := m.Decide(ctx, lookup, true, 42, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.GetByUserCode(ctx, auth.UserCode); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("user code should be single use, got %v", err)
+	}
+
+	approved, err := m.Poll(ctx, deviceCode, "ops-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved.UserID != 42 || approved.Role != RoleAdmin {
+		t.Fatalf("unexpected approved authorization: %+v", approved)
+	}
+
+	if _, err := m.Poll(ctx, deviceCode, "ops-cli"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("device code should only be redeemed once, got %v", err)
+	}
+}
+
+func TestDeviceFlowDeniedAndExpired(t *testing.T) {
+	ctx := context.Background()
+	m, server := newTestDeviceModel(t)
+
+	auth, deviceCode, err := m.Create(ctx, "ops-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Decide(ctx, auth, false, 7, RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Poll(ctx, deviceCode, "ops-cli"); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected access_denied, got %v", err)
+	}
+
+	_, deviceCode, err = m.Create(ctx, "ops-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.FastForward(DeviceCodeTTL + time.Second)
+
+	if _, err := m.Poll(ctx, deviceCode, "ops-cli"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("expected expired_token, got %v", err)
+	}
+}
+
+func TestDeviceFlowConcurrentPollAndDecide(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestDeviceModel(t)
+
+	for round := 0; round < 20; round++ {
+		auth, deviceCode, err := m.Create(ctx, "ops-cli")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The client keeps polling while the user approves
+		var redeemed atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					approved, err := m.Poll(ctx, deviceCode, "ops-cli")
+					if err == nil && approved.UserID == 42 {
+						redeemed.Add(1)
+					}
+				}
+			}()
+		}
+		if err := m.Decide(ctx, auth, true, 42, RoleUser); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+
+		approved, err := m.Poll(ctx, deviceCode, "ops-cli")
+		if err == nil && approved.UserID == 42 {
+			redeemed.Add(1)
+		}
+
+		if n := redeemed.Load(); n != 1 {
+			t.Fatalf("round %d: approved authorization redeemed %d times, want once", round, n)
+		}
+	}
+}
+
+func TestDeviceFlowStaleDecision(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestDeviceModel(t)
+
+	auth, deviceCode, err := m.Create(ctx, "ops-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two tabs show the same pending code; the second decision must not replace the first
+	first, err := m.GetByUserCode(ctx, auth.UserCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.GetByUserCode(ctx, auth.UserCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Decide(ctx, first, true, 42, RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Poll(ctx, deviceCode, "ops-cli"); err != nil {
+		t.Fatalf("expected the approval to be redeemable, got %v", err)
+	}
+
+	if err := m.Decide(ctx, second, false, 7, RoleUser); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("decision on a redeemed code: expected expired_token, got %v", err)
+	}
+
+	auth, deviceCode, err = m.Create(ctx, "ops-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := m.GetByUserCode(ctx, auth.UserCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Decide(ctx, auth, false, 7, RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Decide(ctx, stale, true, 42, RoleUser); err == nil {
+		t.Fatal("a second decision should be refused")
+	}
+	if _, err := m.Poll(ctx, deviceCode, "ops-cli"); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected the first decision to stand, got %v", err)
+	}
+}
@@ -0,0 +1,326 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// OIDCStateTTL is how long a user has to log in at the provider and come back.
+	OIDCStateTTL = 10 * time.Minute
+
+	// OIDCLinkTokenTTL is how long an unlinked external login can be linked to an account.
+	OIDCLinkTokenTTL = 10 * time.Minute
+)
+
+// Errors returned by the OIDC login flow.
+var (
+	ErrOIDCProviderNotFound = errors.New("unknown identity provider")
+	ErrOIDCStateNotFound    = errors.New("login state not found or expired")
+	ErrOIDCLinkNotFound     = errors.New("link token not found or expired")
+)
+
+// OIDCProviderConfig is an OpenID Connect provider users can log in with.
+type OIDCProviderConfig struct {
+	Name         string // used in URLs and stored with linked identities
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// OIDCProvidersFromEnv reads the providers listed in OIDC_PROVIDERS (comma separated names).
+// For a provider named "corp" the settings are OIDC_CORP_ISSUER, OIDC_CORP_CLIENT_ID,
+// OIDC_CORP_CLIENT_SECRET, OIDC_CORP_REDIRECT_URL and optionally OIDC_CORP_SCOPES.
+func OIDCProvidersFromEnv() (map[string]*OIDCProvider, error) {
+	providers := map[string]*OIDCProvider{}
+
+	names := os.Getenv("OIDC_PROVIDERS")
+	if names == "" {
+		return providers, nil
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		config := OIDCProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}
+		if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("%sISSUER, %sCLIENT_ID and %sREDIRECT_URL are required", prefix, prefix, prefix)
+		}
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			config.Scopes = strings.Split(scopes, ",")
+		}
+
+		providers[name] = &OIDCProvider{Config: config}
+	}
+
+	return providers, nil
+}
+
+// OIDCProvider discovers the provider's endpoints and keys on first use, so auth-service
+// can start while a provider is unreachable.
+type OIDCProvider struct {
+	Config OIDCProviderConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(ctx, p.Config.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover %s: %v", p.Config.Name, err)
+		}
+		p.provider = provider
+	}
+
+	return p.provider, nil
+}
+
+func (p *OIDCProvider) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	scopes := p.Config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.Config.ClientID,
+		ClientSecret: p.Config.ClientSecret,
+		RedirectURL:  p.Config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+	}
+}
+
+// ExternalIdentity is a user as identified by an OIDC provider's ID token.
+type ExternalIdentity struct {
+	Provider      string    `json:"provider"`
+	Subject       string    `json:"subject"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Name          string    `json:"name"`
+	AuthTime      time.Time `json:"auth_time"`
+	AMR           []string  `json:"amr"`
+
+	// Tenant is set for SAML logins, to the tenant whose identity provider vouched for
+	// the user.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// oidcState is what auth-service remembers between the redirect and the callback.
+type oidcState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+	Mode     string `json:"mode"`
+}
+
+// OIDCModel runs the authorization code flow against the configured providers.
+type OIDCModel struct {
+	RedisClient *redis.Client
+	Providers   map[string]*OIDCProvider
+}
+
+// AuthCodeURL starts a login at the named provider and returns the URL to send the user to.
+// mode is handed back by Exchange, so the callback can answer like the login that started it.
+func (m *OIDCModel) AuthCodeURL(ctx context.Context, providerName, mode string) (string, error) {
+	p, ok := m.Providers[providerName]
+	if !ok {
+		return "", ErrOIDCProviderNotFound
+	}
+
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	s := oidcState{
+		Provider: providerName,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+		Mode:     mode,
+	}
+
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	err = m.RedisClient.Set(ctx, oidcStateKey(state), raw, OIDCStateTTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to store login state: %v", err)
+	}
+
+	return p.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(s.Verifier)), nil
+}
+
+// Exchange redeems the authorization code from the provider's callback and verifies the ID
+// token's signature, issuer, audience, expiry and nonce. It returns the identity and the
+// mode passed to AuthCodeURL.
+func (m *OIDCModel) Exchange(ctx context.Context, providerName, state, code string) (*ExternalIdentity, string, error) {
+	p, ok := m.Providers[providerName]
+	if !ok {
+		return nil, "", ErrOIDCProviderNotFound
+	}
+
+	raw, err := m.RedisClient.GetDel(ctx, oidcStateKey(state)).Bytes()
+	if err == redis.Nil {
+		return nil, "", ErrOIDCStateNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load login state: %v", err)
+	}
+
+	var s oidcState
+	err = json.Unmarshal(raw, &s)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode login state: %v", err)
+	}
+	if s.Provider != providerName {
+		return nil, "", ErrOIDCStateNotFound
+	}
+
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := p.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(s.Verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to redeem authorization code: %v", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", fmt.Errorf("token response has no id_token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.Config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid id_token: %v", err)
+	}
+	if idToken.Nonce != s.Nonce {
+		return nil, "", fmt.Errorf("invalid id_token: nonce mismatch")
+	}
+
+	var claims struct {
+		Email         string   `json:"email"`
+		EmailVerified bool     `json:"email_verified"`
+		Name          string   `json:"name"`
+		AuthTime      int64    `json:"auth_time"`
+		AMR           []string `json:"amr"`
+	}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid id_token claims: %v", err)
+	}
+
+	identity := &ExternalIdentity{
+		Provider:      providerName,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		AuthTime:      idToken.IssuedAt,
+		AMR:           claims.AMR,
+	}
+	switch {
+	case claims.AuthTime > 0:
+		identity.AuthTime = time.Unix(claims.AuthTime, 0)
+	case identity.AuthTime.IsZero():
+		identity.AuthTime = time.Now()
+	}
+
+	return identity, s.Mode, nil
+}
+
+// CreateLinkToken remembers an identity that is not linked to any account yet. A logged in
+// user can redeem the token to link the identity to their account.
+func (m *OIDCModel) CreateLinkToken(ctx context.Context, identity *ExternalIdentity) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := json.Marshal(identity)
+	if err != nil {
+		return "", err
+	}
+
+	err = m.RedisClient.Set(ctx, oidcLinkKey(token), raw, OIDCLinkTokenTTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to store link token: %v", err)
+	}
+
+	return token, nil
+}
+
+// ConsumeLinkToken returns the identity behind a link token and invalidates the token.
+func (m *OIDCModel) ConsumeLinkToken(ctx context.Context, token string) (*ExternalIdentity, error) {
+	raw, err := m.RedisClient.GetDel(ctx, oidcLinkKey(token)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrOIDCLinkNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load link token: %v", err)
+	}
+
+	var identity ExternalIdentity
+	err = json.Unmarshal(raw, &identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode link token: %v", err)
+	}
+
+	return &identity, nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func oidcStateKey(state string) string {
+	return "oidc_state:" + state
+}
+
+// Only a hash of the link token is used as the key, like device codes.
+func oidcLinkKey(token string) string {
+	return "oidc_link:" + hashDeviceCode(token)
+}
@@ -0,0 +1,294 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP is a minimal OpenID Connect provider: discovery, JWKS, an authorization endpoint
+// that logs the configured user in immediately, and a token endpoint that checks PKCE.
+type mockIdP struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+
+	mu       sync.Mutex
+	claims   jwt.MapClaims // merged into every ID token
+	audience string
+	codes    map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	nonce, challenge string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{t: t, key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+// authorize plays the user logging in at the provider and returns the callback query.
+func (idp *mockIdP) authorize(authURL string) url.Values {
+	idp.t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		idp.t.Fatalf("expected a PKCE challenge, got %q", u.RawQuery)
+	}
+
+	code := "code-" + q.Get("state")[:8]
+
+	idp.mu.Lock()
+	idp.codes[code] = mockAuthorization{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	idp.mu.Unlock()
+
+	return url.Values{"state": {q.Get("state")}, "code": {code}}
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	claims := jwt.MapClaims{}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+	audience := idp.audience
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims["iss"] = idp.URL
+	claims["aud"] = audience
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Minute).Unix()
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = auth.nonce
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		idp.t.Error(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "opaque",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func newTestOIDCModel(t *testing.T, idp *mockIdP) *OIDCModel {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	idp.audience = "auth-service"
+
+	return &OIDCModel{
+		RedisClient: client,
+		Providers: map[string]*OIDCProvider{
+			"corp": {Config: OIDCProviderConfig{
+				Name:        "corp",
+				Issuer:      idp.URL,
+				ClientID:    "auth-service",
+				RedirectURL: "http://localhost:8080/api/auth/oidc/corp/callback",
+			}},
+		},
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ctx := context.Background()
+	idp := newMockIdP(t)
+	m := newTestOIDCModel(t, idp)
+
+	idp.claims = jwt.MapClaims{
+		"sub":            "248289761001",
+		"email":          "jane@example.org",
+		"email_verified": true,
+		"auth_time":      time.Now().Add(-time.Minute).Unix(),
+		"amr":            []string{"pwd", "otp"},
+	}
+
+	authURL, err := m.AuthCodeURL(ctx, "corp", "browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, idp.URL+"/authorize?") {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+
+	callback := idp.authorize(authURL)
+
+	identity, mode, err := m.Exchange(ctx, "corp", callback.Get("state"), callback.Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != "browser" {
+		t.Fatalf("expected mode browser, got %q", mode)
+	}
+	if identity.Provider != "corp" || identity.Subject != "248289761001" || identity.Email != "jane@example.org" || !identity.EmailVerified {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+	if len(identity.AMR) != 2 || time.Since(identity.AuthTime) < time.Minute-time.Second {
+		t.Fatalf("expected the provider's auth_time and amr, got %+v", identity)
+	}
+
+	// The state is single use
+	if _, _, err := m.Exchange(ctx, "corp", callback.Get("state"), callback.Get("code")); !errors.Is(err, ErrOIDCStateNotFound) {
+		t.Fatalf("expected a replayed callback to be rejected, got %v", err)
+	}
+
+	if _, err := m.AuthCodeURL(ctx, "unknown", ""); !errors.Is(err, ErrOIDCProviderNotFound) {
+		t.Fatalf("expected ErrOIDCProviderNotFound, got %v", err)
+	}
+}
+
+func TestOIDCRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		audience string
+	}{
+		{"wrong audience", jwt.MapClaims{"sub": "1"}, "another-client"},
+		{"wrong nonce", jwt.MapClaims{"sub": "1", "nonce": "replayed"}, "auth-service"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			idp := newMockIdP(t)
+			m := newTestOIDCModel(t, idp)
+			idp.claims = tt.claims
+			idp.audience = tt.audience
+
+			authURL, err := m.AuthCodeURL(ctx, "corp", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			callback := idp.authorize(authURL)
+
+			if _, _, err := m.Exchange(ctx, "corp", callback.Get("state"), callback.Get("code")); err == nil || !strings.Contains(err.Error(), "invalid id_token") {
+				t.Fatalf("expected an invalid id_token error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsTokensSignedByAnotherKey(t *testing.T) {
+	ctx := context.Background()
+	idp := newMockIdP(t)
+	m := newTestOIDCModel(t, idp)
+	idp.claims = jwt.MapClaims{"sub": "1"}
+
+	authURL, err := m.AuthCodeURL(ctx, "corp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := idp.authorize(authURL)
+
+	// The provider's JWKS still publishes the old key
+	idp.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := m.Exchange(ctx, "corp", callback.Get("state"), callback.Get("code")); err == nil || !strings.Contains(err.Error(), "invalid id_token") {
+		t.Fatalf("expected a signature error, got %v", err)
+	}
+}
+
+func TestOIDCLinkToken(t *testing.T) {
+	ctx := context.Background()
+	m := newTestOIDCModel(t, newMockIdP(t))
+
+	identity := &ExternalIdentity{Provider: "corp", Subject: "42", Email: "jane@example.org"}
+
+	token, err := m.CreateLinkToken(ctx, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.ConsumeLinkToken(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != "42" || got.Provider != "corp" {
+		t.Fatalf("unexpected identity: %+v", got)
+	}
+
+	if _, err := m.ConsumeLinkToken(ctx, token); !errors.Is(err, ErrOIDCLinkNotFound) {
+		t.Fatalf("link tokens should be single use, got %v", err)
+	}
+}
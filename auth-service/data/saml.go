@@ -0,0 +1,372 @@
+package data
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// SAMLRequestTTL is how long a user has to log in at the identity provider and come back.
+const SAMLRequestTTL = 10 * time.Minute
+
+// Errors returned by the SAML login flow.
+var (
+	ErrSAMLNotConfigured       = errors.New("saml single sign-on is not configured")
+	ErrSAMLProviderNotFound    = errors.New("no saml identity provider for this tenant")
+	ErrSAMLStateNotFound       = errors.New("saml login state not found or expired")
+	ErrSAMLAssertionReplayed   = errors.New("saml assertion has already been used")
+	ErrSAMLUnsupportedIdentity = errors.New("saml assertion does not identify a user")
+)
+
+// SAMLServiceProvider is auth-service's own side of SAML: the key it signs AuthnRequests
+// with, and the public URL tenants' identity providers send users back to.
+type SAMLServiceProvider struct {
+	BaseURL     *url.URL
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+}
+
+// SAMLServiceProviderFromEnv reads SAML_BASE_URL, SAML_SP_KEY_FILE and SAML_SP_CERT_FILE. It
+// returns nil when none of them is set, which leaves SAML single sign-on turned off.
+func SAMLServiceProviderFromEnv() (*SAMLServiceProvider, error) {
+	baseURL := os.Getenv("SAML_BASE_URL")
+	keyFile := os.Getenv("SAML_SP_KEY_FILE")
+	certFile := os.Getenv("SAML_SP_CERT_FILE")
+
+	if baseURL == "" && keyFile == "" && certFile == "" {
+		return nil, nil
+	}
+	if baseURL == "" || keyFile == "" || certFile == "" {
+		return nil, fmt.Errorf("SAML_BASE_URL, SAML_SP_KEY_FILE and SAML_SP_CERT_FILE are all required")
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAML_BASE_URL: %v", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SAML service provider key: %w", err)
+	}
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ParseSAMLCertificate(string(certPEM))
+	if err != nil {
+		return nil, err
+	}
+
+	return &SAMLServiceProvider{BaseURL: u, Key: key, Certificate: cert}, nil
+}
+
+// ParseSAMLCertificate parses a PEM encoded X.509 certificate.
+func ParseSAMLCertificate(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("expected a PEM encoded certificate")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// SAMLIdentityProvider is a tenant's identity provider as configured in admin-service.
+// Responses must be signed with Certificate.
+type SAMLIdentityProvider struct {
+	Tenant             string
+	EntityID           string
+	SSOURL             string
+	Certificate        *x509.Certificate
+	EmailAttribute     string
+	FirstNameAttribute string
+	LastNameAttribute  string
+	TrustEmail         bool // the provider is authoritative for its users' email addresses
+}
+
+func (p *SAMLIdentityProvider) metadata() *saml.EntityDescriptor {
+	return &saml.EntityDescriptor{
+		EntityID: p.EntityID,
+		IDPSSODescriptors: []saml.IDPSSODescriptor{{
+			SSODescriptor: saml.SSODescriptor{
+				RoleDescriptor: saml.RoleDescriptor{
+					ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
+					KeyDescriptors: []saml.KeyDescriptor{{
+						Use: "signing",
+						KeyInfo: saml.KeyInfo{
+							X509Data: saml.X509Data{
+								X509Certificates: []saml.X509Certificate{
+									{Data: base64.StdEncoding.EncodeToString(p.Certificate.Raw)},
+								},
+							},
+						},
+					}},
+				},
+			},
+			SingleSignOnServices: []saml.Endpoint{
+				{Binding: saml.HTTPRedirectBinding, Location: p.SSOURL},
+			},
+		}},
+	}
+}
+
+// SAMLProviderStore looks up a tenant's identity provider. It returns
+// ErrSAMLProviderNotFound when the tenant has none, or it is disabled.
+type SAMLProviderStore interface {
+	GetSAMLProvider(ctx context.Context, tenant string) (*SAMLIdentityProvider, error)
+}
+
+// samlState is what auth-service remembers between the AuthnRequest and the response. It is
+// keyed by the RelayState, which the identity provider hands back unchanged.
+type samlState struct {
+	Tenant    string `json:"tenant"`
+	RequestID string `json:"request_id"`
+	Mode      string `json:"mode"`
+}
+
+// SAMLModel runs SP-initiated SAML 2.0 logins against the tenants' identity providers. Each
+// tenant gets its own entity ID and Assertion Consumer Service URL below
+// /api/auth/saml/{tenant}.
+type SAMLModel struct {
+	RedisClient     *redis.Client
+	ServiceProvider *SAMLServiceProvider
+	Providers       SAMLProviderStore
+}
+
+func (m *SAMLModel) serviceProvider(ctx context.Context, tenant string) (*saml.ServiceProvider, *SAMLIdentityProvider, error) {
+	if m.ServiceProvider == nil || m.Providers == nil {
+		return nil, nil, ErrSAMLNotConfigured
+	}
+
+	idp, err := m.Providers.GetSAMLProvider(ctx, tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	base := m.ServiceProvider.BaseURL
+	metadataURL := base.JoinPath("api/auth/saml", tenant, "metadata")
+	acsURL := base.JoinPath("api/auth/saml", tenant, "acs")
+
+	sp := &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               m.ServiceProvider.Key,
+		Certificate:       m.ServiceProvider.Certificate,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idp.metadata(),
+		AuthnNameIDFormat: saml.PersistentNameIDFormat,
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+	}
+
+	return sp, idp, nil
+}
+
+// Metadata returns the service provider metadata to register at the tenant's identity provider.
+func (m *SAMLModel) Metadata(ctx context.Context, tenant string) ([]byte, error) {
+	sp, _, err := m.serviceProvider(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	return xml.MarshalIndent(sp.Metadata(), "", "  ")
+}
+
+// AuthnRequestURL starts a login at the tenant's identity provider and returns the URL to
+// send the user to. mode is handed back by ParseResponse.
+func (m *SAMLModel) AuthnRequestURL(ctx context.Context, tenant, mode string) (string, error) {
+	sp, idp, err := m.serviceProvider(ctx, tenant)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := sp.MakeAuthenticationRequest(idp.SSOURL, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", fmt.Errorf("failed to create AuthnRequest: %v", err)
+	}
+
+	relayState, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := json.Marshal(samlState{Tenant: tenant, RequestID: req.ID, Mode: mode})
+	if err != nil {
+		return "", err
+	}
+
+	err = m.RedisClient.Set(ctx, samlStateKey(relayState), raw, SAMLRequestTTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to store login state: %v", err)
+	}
+
+	u, err := req.Redirect(relayState, sp)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign AuthnRequest: %v", err)
+	}
+
+	return u.String(), nil
+}
+
+// ParseResponse handles the base64 encoded SAMLResponse posted to the Assertion Consumer
+// Service. The response must answer the AuthnRequest behind relayState, be signed by the
+// tenant's identity provider, be addressed to this tenant's service provider and be within
+// its validity window. Each assertion is accepted once. It returns the identity and the mode
+// passed to AuthnRequestURL.
+//
+// Validation failures are *saml.InvalidResponseError, whose Error method hides the details.
+func (m *SAMLModel) ParseResponse(ctx context.Context, tenant, relayState, samlResponse string) (*ExternalIdentity, string, error) {
+	raw, err := m.RedisClient.GetDel(ctx, samlStateKey(relayState)).Bytes()
+	if err == redis.Nil {
+		return nil, "", ErrSAMLStateNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load login state: %v", err)
+	}
+
+	var s samlState
+	err = json.Unmarshal(raw, &s)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode login state: %v", err)
+	}
+	if s.Tenant != tenant {
+		return nil, "", ErrSAMLStateNotFound
+	}
+
+	sp, idp, err := m.serviceProvider(ctx, tenant)
+	if err != nil {
+		return nil, "", err
+	}
+
+	responseXML, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid SAMLResponse encoding: %v", err)
+	}
+
+	assertion, err := sp.ParseXMLResponse(responseXML, []string{s.RequestID})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Remember the assertion until it expires, so it cannot be presented again
+	expires := time.Now().Add(SAMLRequestTTL)
+	if assertion.Conditions != nil && !assertion.Conditions.NotOnOrAfter.IsZero() {
+		expires = assertion.Conditions.NotOnOrAfter.Add(saml.MaxClockSkew)
+	}
+	fresh, err := m.RedisClient.SetNX(ctx, samlAssertionKey(idp.EntityID, assertion.ID), 1, time.Until(expires)).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to record assertion: %v", err)
+	}
+	if !fresh {
+		return nil, "", ErrSAMLAssertionReplayed
+	}
+
+	identity, err := samlIdentity(tenant, idp, assertion)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return identity, s.Mode, nil
+}
+
+// SAMLProviderName is the provider name SAML identities of a tenant are linked under.
+func SAMLProviderName(tenant string) string {
+	return "saml:" + tenant
+}
+
+// samlIdentity maps a validated assertion to an ExternalIdentity. The subject is the NameID,
+// so transient NameIDs, which change on every login, are rejected.
+func samlIdentity(tenant string, idp *SAMLIdentityProvider, assertion *saml.Assertion) (*ExternalIdentity, error) {
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, ErrSAMLUnsupportedIdentity
+	}
+	if assertion.Subject.NameID.Format == string(saml.TransientNameIDFormat) {
+		return nil, fmt.Errorf("%w: transient NameIDs cannot be linked to an account", ErrSAMLUnsupportedIdentity)
+	}
+
+	identity := &ExternalIdentity{
+		Provider:      SAMLProviderName(tenant),
+		Subject:       assertion.Subject.NameID.Value,
+		Email:         samlAttribute(assertion, idp.EmailAttribute),
+		EmailVerified: idp.TrustEmail,
+		Name: strings.TrimSpace(samlAttribute(assertion, idp.FirstNameAttribute) + " " +
+			samlAttribute(assertion, idp.LastNameAttribute)),
+		AuthTime: time.Now(),
+		Tenant:   tenant,
+	}
+	if identity.Email == "" && strings.Contains(identity.Subject, "@") &&
+		assertion.Subject.NameID.Format == string(saml.EmailAddressNameIDFormat) {
+		identity.Email = identity.Subject
+	}
+	identity.EmailVerified = identity.EmailVerified && identity.Email != ""
+
+	if len(assertion.AuthnStatements) > 0 {
+		statement := assertion.AuthnStatements[0]
+		if !statement.AuthnInstant.IsZero() {
+			identity.AuthTime = statement.AuthnInstant
+		}
+		if ref := statement.AuthnContext.AuthnContextClassRef; ref != nil {
+			identity.AMR = samlAMR(ref.Value)
+		}
+	}
+
+	return identity, nil
+}
+
+// samlAttribute returns the first value of the attribute with the given name or friendly name.
+func samlAttribute(assertion *saml.Assertion, name string) string {
+	if name == "" {
+		return ""
+	}
+
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if (attr.Name == name || attr.FriendlyName == name) && len(attr.Values) > 0 {
+				return strings.TrimSpace(attr.Values[0].Value)
+			}
+		}
+	}
+
+	return ""
+}
+
+// samlAMR translates well known authentication context classes to RFC 8176 method names.
+func samlAMR(classRef string) []string {
+	switch classRef {
+	case "urn:oasis:names:tc:SAML:2.0:ac:classes:Password",
+		"urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport":
+		return []string{"pwd"}
+	case "https://refeds.org/profile/mfa",
+		"http://schemas.microsoft.com/claims/multipleauthn":
+		return []string{"mfa"}
+	}
+	return nil
+}
+
+func samlStateKey(relayState string) string {
+	return "saml_state:" + relayState
+}
+
+// Assertion IDs are only unique per issuer.
+func samlAssertionKey(issuer, assertionID string) string {
+	return "saml_assertion:" + hashDeviceCode(issuer+" "+assertionID)
+}
@@ -0,0 +1,366 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/crewjam/saml"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestKeyPair(t *testing.T, name string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, cert
+}
+
+type fakeSAMLProviders map[string]*SAMLIdentityProvider
+
+func (f fakeSAMLProviders) GetSAMLProvider(ctx context.Context, tenant string) (*SAMLIdentityProvider, error) {
+	p, ok := f[tenant]
+	if !ok {
+		return nil, ErrSAMLProviderNotFound
+	}
+	return p, nil
+}
+
+// testIdP is a locally generated SAML identity provider that logs in whoever the test says.
+type testIdP struct {
+	t   *testing.T
+	idp *saml.IdentityProvider
+	sp  *SAMLModel
+
+	plaintext bool // ignore the service provider's encryption key
+}
+
+func newTestSAML(t *testing.T) (*SAMLModel, *testIdP, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	spKey, spCert := newTestKeyPair(t, "auth-service")
+	idpKey, idpCert := newTestKeyPair(t, "idp.acme.example")
+
+	baseURL, _ := url.Parse("https://auth.example.org")
+	metadataURL, _ := url.Parse("https://idp.acme.example/metadata")
+	ssoURL, _ := url.Parse("https://idp.acme.example/sso")
+
+	m := &SAMLModel{
+		RedisClient:     client,
+		ServiceProvider: &SAMLServiceProvider{BaseURL: baseURL, Key: spKey, Certificate: spCert},
+		Providers: fakeSAMLProviders{
+			"acme": {
+				Tenant:             "acme",
+				EntityID:           metadataURL.String(),
+				SSOURL:             ssoURL.String(),
+				Certificate:        idpCert,
+				EmailAttribute:     "eduPersonPrincipalName",
+				FirstNameAttribute: "givenName",
+				LastNameAttribute:  "sn",
+				TrustEmail:         true,
+			},
+		},
+	}
+
+	idp := &testIdP{t: t, sp: m}
+	idp.idp = &saml.IdentityProvider{
+		Key:                     idpKey,
+		Certificate:             idpCert,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		ServiceProviderProvider: idp,
+	}
+
+	return m, idp, server
+}
+
+// GetServiceProvider hands the identity provider the metadata auth-service publishes.
+func (p *testIdP) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	raw, err := p.sp.Metadata(r.Context(), "acme")
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata saml.EntityDescriptor
+	err = xml.Unmarshal(raw, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.EntityID != serviceProviderID {
+		return nil, os.ErrNotExist
+	}
+	if p.plaintext {
+		metadata.SPSSODescriptors[0].KeyDescriptors = nil
+	}
+
+	return &metadata, nil
+}
+
+// login plays the user following the AuthnRequest redirect and logging in at the identity
+// provider. It returns the RelayState and SAMLResponse the browser would post to the ACS.
+func (p *testIdP) login(authURL string, session *saml.Session) (string, string) {
+	p.t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, authURL, nil)
+	req, err := saml.NewIdpAuthnRequest(p.idp, r)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	err = req.Validate()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	err = saml.DefaultAssertionMaker{}.MakeAssertion(req, session)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	form, err := req.PostBinding()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	if form.URL != "https://auth.example.org/api/auth/saml/acme/acs" {
+		p.t.Fatalf("unexpected ACS URL %s", form.URL)
+	}
+
+	return form.RelayState, form.SAMLResponse
+}
+
+var janeSession = &saml.Session{
+	ID:            "session-1",
+	NameID:        "8f0c7a3e-jane",
+	NameIDFormat:  string(saml.PersistentNameIDFormat),
+	UserEmail:     "jane@acme.example",
+	UserGivenName: "Jane",
+	UserSurname:   "Doe",
+}
+
+func TestSAMLMetadata(t *testing.T) {
+	m, _, _ := newTestSAML(t)
+
+	raw, err := m.Metadata(context.Background(), "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var metadata saml.EntityDescriptor
+	err = xml.Unmarshal(raw, &metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.EntityID != "https://auth.example.org/api/auth/saml/acme/metadata" {
+		t.Fatalf("unexpected entity ID %s", metadata.EntityID)
+	}
+
+	sp := metadata.SPSSODescriptors[0]
+	if sp.AssertionConsumerServices[0].Location != "https://auth.example.org/api/auth/saml/acme/acs" {
+		t.Fatalf("unexpected ACS %+v", sp.AssertionConsumerServices)
+	}
+	if !*sp.AuthnRequestsSigned || !*sp.WantAssertionsSigned {
+		t.Fatal("expected signed requests and assertions")
+	}
+
+	if _, err := m.Metadata(context.Background(), "globex"); !errors.Is(err, ErrSAMLProviderNotFound) {
+		t.Fatalf("expected ErrSAMLProviderNotFound, got %v", err)
+	}
+}
+
+func TestSAMLLogin(t *testing.T) {
+	ctx := context.Background()
+	m, idp, server := newTestSAML(t)
+
+	authURL, err := m.AuthnRequestURL(ctx, "acme", "browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, "https://idp.acme.example/sso?SAMLRequest=") || !strings.Contains(authURL, "&Signature=") {
+		t.Fatalf("expected a signed redirect to the identity provider, got %s", authURL)
+	}
+
+	relayState, response := idp.login(authURL, janeSession)
+	state, err := server.Get(samlStateKey(relayState))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, mode, err := m.ParseResponse(ctx, "acme", relayState, response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != "browser" {
+		t.Fatalf("expected mode browser, got %q", mode)
+	}
+
+	want := ExternalIdentity{
+		Provider:      "saml:acme",
+		Subject:       "8f0c7a3e-jane",
+		Email:         "jane@acme.example",
+		EmailVerified: true,
+		Name:          "Jane Doe",
+	}
+	if identity.Provider != want.Provider || identity.Subject != want.Subject || identity.Email != want.Email ||
+		identity.EmailVerified != want.EmailVerified || identity.Name != want.Name {
+		t.Fatalf("got %+v, want %+v", identity, want)
+	}
+	if time.Since(identity.AuthTime) > time.Minute {
+		t.Fatalf("expected the identity provider's AuthnInstant, got %s", identity.AuthTime)
+	}
+
+	// The login state is single use
+	if _, _, err := m.ParseResponse(ctx, "acme", relayState, response); !errors.Is(err, ErrSAMLStateNotFound) {
+		t.Fatalf("expected a replayed response to be rejected, got %v", err)
+	}
+
+	// Even with the state restored, the assertion itself is only accepted once
+	server.Set(samlStateKey(relayState), state)
+	if _, _, err := m.ParseResponse(ctx, "acme", relayState, response); !errors.Is(err, ErrSAMLAssertionReplayed) {
+		t.Fatalf("expected ErrSAMLAssertionReplayed, got %v", err)
+	}
+}
+
+func TestSAMLRejectsInvalidResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, m *SAMLModel, idp *testIdP, relayState, response string) (string, string)
+	}{
+		{
+			name: "modified after signing",
+			tamper: func(t *testing.T, m *SAMLModel, idp *testIdP, relayState, response string) (string, string) {
+				idp.plaintext = true
+				authURL, err := m.AuthnRequestURL(context.Background(), "acme", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				relayState, response = idp.login(authURL, janeSession)
+
+				raw, _ := base64.StdEncoding.DecodeString(response)
+				if !strings.Contains(string(raw), "jane@acme.example") {
+					t.Fatal("expected a plain text assertion")
+				}
+				raw = []byte(strings.ReplaceAll(string(raw), "jane@acme.example", "admin@acme.example"))
+				return relayState, base64.StdEncoding.EncodeToString(raw)
+			},
+		},
+		{
+			name: "signed by another key",
+			tamper: func(t *testing.T, m *SAMLModel, idp *testIdP, relayState, response string) (string, string) {
+				idp.idp.Key, idp.idp.Certificate = newTestKeyPair(t, "attacker")
+				authURL, err := m.AuthnRequestURL(context.Background(), "acme", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return idp.login(authURL, janeSession)
+			},
+		},
+		{
+			name: "answering another request",
+			tamper: func(t *testing.T, m *SAMLModel, idp *testIdP, relayState, response string) (string, string) {
+				authURL, err := m.AuthnRequestURL(context.Background(), "acme", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				otherRelayState, _ := idp.login(authURL, janeSession)
+				return otherRelayState, response
+			},
+		},
+		{
+			name: "expired",
+			tamper: func(t *testing.T, m *SAMLModel, idp *testIdP, relayState, response string) (string, string) {
+				now := saml.TimeNow
+				saml.TimeNow = func() time.Time { return now().Add(time.Hour) }
+				t.Cleanup(func() { saml.TimeNow = now })
+				return relayState, response
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m, idp, _ := newTestSAML(t)
+
+			authURL, err := m.AuthnRequestURL(ctx, "acme", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			relayState, response := idp.login(authURL, janeSession)
+			relayState, response = tt.tamper(t, m, idp, relayState, response)
+
+			var invalid *saml.InvalidResponseError
+			if _, _, err := m.ParseResponse(ctx, "acme", relayState, response); !errors.As(err, &invalid) {
+				t.Fatalf("expected an invalid response error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSAMLRejectsTransientNameIDs(t *testing.T) {
+	ctx := context.Background()
+	m, idp, _ := newTestSAML(t)
+
+	authURL, err := m.AuthnRequestURL(ctx, "acme", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := *janeSession
+	session.NameIDFormat = string(saml.TransientNameIDFormat)
+	relayState, response := idp.login(authURL, &session)
+
+	if _, _, err := m.ParseResponse(ctx, "acme", relayState, response); !errors.Is(err, ErrSAMLUnsupportedIdentity) {
+		t.Fatalf("expected ErrSAMLUnsupportedIdentity, got %v", err)
+	}
+}
+
+func TestSAMLStateIsBoundToTenant(t *testing.T) {
+	ctx := context.Background()
+	m, idp, _ := newTestSAML(t)
+
+	authURL, err := m.AuthnRequestURL(ctx, "acme", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayState, response := idp.login(authURL, janeSession)
+
+	if _, _, err := m.ParseResponse(ctx, "globex", relayState, response); !errors.Is(err, ErrSAMLStateNotFound) {
+		t.Fatalf("expected ErrSAMLStateNotFound, got %v", err)
+	}
+}
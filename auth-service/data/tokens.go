@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -14,6 +15,20 @@ const (
 	ScopeRefresh        = "refresh"
 	RoleAdmin           = "admin"
 	RoleUser            = "user"
+
+	// AccessTokenTTL and RefreshTokenTTL are the lifetimes of the token pair issued at login.
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
+
+	// BrowserAccessTokenTTL is the lifetime of access tokens issued to browser sessions,
+	// which keep the refresh token in a cookie and can cheaply fetch new access tokens.
+	BrowserAccessTokenTTL = 5 * time.Minute
+
+	// ImpersonationTTL is the lifetime of tokens issued to admins acting as a user.
+	ImpersonationTTL = 10 * time.Minute
+
+	// AMRPassword is the RFC 8176 authentication method reference for password logins.
+	AMRPassword = "pwd"
 )
 
 // TokenModel holds the Redis client and KeyManager for token handling.
@@ -24,38 +39,119 @@ type TokenModel struct {
 
 // Models represents all models in the application.
 type Models struct {
-	Token TokenModel
+	Token    TokenModel
+	Device   DeviceModel
+	WebAuthn WebAuthnModel
+	OIDC     OIDCModel
+	SAML     SAMLModel
 }
 
-// New creates a new instance of Models with initialized TokenModel.
+// New creates a new instance of Models with initialized TokenModel. The WebAuthn relying
+// party, the OIDC providers and the SAML service provider are configured separately, see
+// NewWebAuthnFromEnv, OIDCProvidersFromEnv and SAMLServiceProviderFromEnv.
 func New(redisClient *redis.Client, keyManager *KeyManager) Models {
 	return Models{
 		Token: TokenModel{
 			RedisClient: redisClient,
 			KeyManager:  keyManager,
 		},
+		Device: DeviceModel{
+			RedisClient: redisClient,
+		},
+		WebAuthn: WebAuthnModel{
+			RedisClient: redisClient,
+		},
+		OIDC: OIDCModel{
+			RedisClient: redisClient,
+		},
+		SAML: SAMLModel{
+			RedisClient: redisClient,
+		},
 	}
 }
 
 // JWTClaims stores extra JWT information, including role and scope.
 type JWTClaims struct {
-	UserID int64  `json:"user_id"`
-	Role   string `json:"role"`  // Role (admin/user)
-	Scope  string `json:"scope"`
+	UserID int64        `json:"user_id"`
+	Role   string       `json:"role"` // Role (admin/user)
+	Scope  string       `json:"scope"`
+	Act    *ActorClaims `json:"act,omitempty"` // Set when the token was issued to someone acting on behalf of the user
+
+	// AuthTime and AMR record when and how the user last proved their identity. They are
+	// copied on refresh, so services can demand a recent login for sensitive operations.
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
+
+	// Tenant is the organization the token acts in, and TenantRole the user's role there.
+	// Admin tokens without a tenant manage every tenant.
+	Tenant     string `json:"tenant,omitempty"`
+	TenantRole string `json:"tenant_role,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a JWT for the specified user with role, scope, and TTL.
-func (m *TokenModel) GenerateToken(ctx context.Context, userID int, role string, ttl time.Duration, scope, kid string) (string, error) {
+// Authentication describes the login a token pair descends from, and the tenant it acts in.
+type Authentication struct {
+	Time       time.Time
+	Methods    []string
+	Tenant     string
+	TenantRole string
+}
+
+// NewAuthentication returns an Authentication that happened now, using the given methods.
+func NewAuthentication(methods ...string) Authentication {
+	return Authentication{Time: time.Now(), Methods: methods}
+}
+
+// ActorClaims identifies the party acting on behalf of the token subject,
+// following the "act" claim from RFC 8693.
+type ActorClaims struct {
+	Subject string `json:"sub"`
+	Role    string `json:"role"`
+}
+
+// GenerateToken creates a JWT for the specified user with role, scope, and TTL, recording
+// the authentication it was issued for.
+func (m *TokenModel) GenerateToken(ctx context.Context, userID int, role string, ttl time.Duration, scope, kid string, auth Authentication) (string, error) {
 	claims := JWTClaims{
 		UserID: int64(userID),
 		Role:   role,
 		Scope:  scope,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		AMR:    auth.Methods,
+
+		Tenant:     auth.Tenant,
+		TenantRole: auth.TenantRole,
+	}
+	if !auth.Time.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(auth.Time)
+	}
+
+	return m.GenerateTokenWithClaims(ctx, claims, ttl, kid)
+}
+
+// GenerateImpersonationToken creates a short-lived access token for userID that carries
+// an "act" claim naming the admin. No refresh token is issued for it. Tenant admins
+// impersonate users in their own tenant.
+func (m *TokenModel) GenerateImpersonationToken(ctx context.Context, userID, adminID int64, tenant, kid string) (string, error) {
+	claims := JWTClaims{
+		UserID: userID,
+		Role:   RoleUser,
+		Scope:  ScopeAuthentication,
+		Act: &ActorClaims{
+			Subject: strconv.FormatInt(adminID, 10),
+			Role:    RoleAdmin,
 		},
+		Tenant: tenant,
 	}
 
+	return m.GenerateTokenWithClaims(ctx, claims, ImpersonationTTL, kid)
+}
+
+// GenerateTokenWithClaims signs the given claims, setting the expiry from ttl.
+func (m *TokenModel) GenerateTokenWithClaims(ctx context.Context, claims JWTClaims, ttl time.Duration, kid string) (string, error) {
+	now := time.Now()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+
 	privateKey := m.KeyManager.GetPrivateKey()
 	if privateKey == nil {
 		return "", fmt.Errorf("failed to retrieve private key")
@@ -96,59 +192,185 @@ func (m *TokenModel) IsTokenDeactivated(ctx context.Context, tokenString string)
 	return exists > 0, nil
 }
 
+// RevokeUserSessions ends every session of a user by rejecting all tokens issued to them
+// before now. It returns the cutoff.
+func (m *TokenModel) RevokeUserSessions(ctx context.Context, userID int64) (time.Time, error) {
+	key := fmt.Sprintf("sessions_revoked_before:%d", userID)
+	// Token timestamps have a resolution of one second, so tokens issued during the second
+	// of the revocation are rejected too
+	cutoff := time.Now().Truncate(time.Second).Add(time.Second)
+
+	// The cutoff only needs to outlive the longest-lived token
+	err := m.RedisClient.Set(ctx, key, cutoff.Unix(), RefreshTokenTTL).Err()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+
+	return cutoff, nil
+}
+
+// sessionRevoked reports whether the token was issued before its user's sessions were
+// last revoked.
+func (m *TokenModel) sessionRevoked(ctx context.Context, claims *JWTClaims) (bool, error) {
+	key := fmt.Sprintf("sessions_revoked_before:%d", claims.UserID)
+
+	cutoff, err := m.RedisClient.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check session revocation: %v", err)
+	}
+
+	return claims.IssuedAt == nil || claims.IssuedAt.Unix() < cutoff, nil
+}
+
+// IsTokenRevoked reports whether a token, already verified by the service it was presented
+// to, has since been logged out or issued before its user's sessions were revoked.
+func (m *TokenModel) IsTokenRevoked(ctx context.Context, tokenString string) (bool, error) {
+	deactivated, err := m.IsTokenDeactivated(ctx, tokenString)
+	if err != nil || deactivated {
+		return deactivated, err
+	}
+
+	claims := &JWTClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(tokenString, claims)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse token: %v", err)
+	}
+
+	// Revocations are keyed by user ID, and admins are numbered separately
+	if claims.Role != RoleUser {
+		return false, nil
+	}
+
+	return m.sessionRevoked(ctx, claims)
+}
+
 // GetUserIDForToken retrieves the user ID and role from a token, ensuring it is valid and has the correct scope.
 func (m *TokenModel) GetUserIDForToken(ctx context.Context, tokenString, scope string) (int64, string, error) {
+	claims, err := m.ParseToken(ctx, tokenString, scope)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return claims.UserID, claims.Role, nil
+}
+
+// ParseToken verifies a token that has not been deactivated and has the given scope, and returns its claims.
+func (m *TokenModel) ParseToken(ctx context.Context, tokenString, scope string) (*JWTClaims, error) {
 
 	deactivated, err := m.IsTokenDeactivated(ctx, tokenString)
 	if err != nil {
-		return 0, "", err
+		return nil, err
 	}
 	if deactivated {
-		return 0, "", fmt.Errorf("token has been deactivated")
+		return nil, fmt.Errorf("token has been deactivated")
 	}
 
 	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, &JWTClaims{})
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to parse token: %v", err)
+		return nil, fmt.Errorf("failed to parse token: %v", err)
 	}
 
 	kid, ok := token.Header["kid"].(string)
 	if !ok {
-		return 0, "", fmt.Errorf("kid missing from token header")
+		return nil, fmt.Errorf("kid missing from token header")
 	}
 
 	publicKey, err := m.KeyManager.GetPublicKey(kid)
 	if err != nil {
-		return 0, "", fmt.Errorf("public key not found for kid: %s, %v", kid, err)
+		return nil, fmt.Errorf("public key not found for kid: %s, %v", kid, err)
 	}
 
 	token, err = jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return publicKey, nil
 	})
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to verify token: %v", err)
+		return nil, fmt.Errorf("failed to verify token: %v", err)
 	}
 
 	claims, ok := token.Claims.(*JWTClaims)
 	if !ok || !token.Valid || claims.Scope != scope {
-		return 0, "", fmt.Errorf("invalid or unauthorized token")
+		return nil, fmt.Errorf("invalid or unauthorized token")
 	}
 
-	return claims.UserID, claims.Role, nil
+	// Revocations are keyed by user ID, and admins are numbered separately
+	if claims.Role == RoleUser {
+		revoked, err := m.sessionRevoked(ctx, claims)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, fmt.Errorf("session has been revoked")
+		}
+	}
+
+	return claims, nil
 }
 
-// RefreshAccessToken creates a new access token based on a valid refresh token.
-func (m *TokenModel) RefreshAccessToken(ctx context.Context, refreshToken, kid string) (string, error) {
+// RefreshAccessToken creates a new access token, valid for ttl, based on a valid refresh token.
+// The new token keeps the auth_time of the original login and the tenant.
+func (m *TokenModel) RefreshAccessToken(ctx context.Context, refreshToken, kid string, ttl time.Duration) (string, error) {
 
-	userID, role, err := m.GetUserIDForToken(ctx, refreshToken, ScopeRefresh)
+	claims, err := m.ParseToken(ctx, refreshToken, ScopeRefresh)
 	if err != nil {
 		return "", fmt.Errorf("failed to refresh access token: %v", err)
 	}
 
-	accessToken, err := m.GenerateToken(ctx, int(userID), role, 15*time.Minute, ScopeAuthentication, kid)
+	accessToken, err := m.GenerateToken(ctx, int(claims.UserID), claims.Role, ttl, ScopeAuthentication, kid, authenticationOf(claims))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate access token: %v", err)
 	}
 
 	return accessToken, nil
 }
+
+// RotateRefreshToken exchanges a refresh token for a new access token, valid for ttl, and a
+// new refresh token. The old refresh token is deactivated so it works only once, and the new
+// one expires with it, so rotating does not extend the session past its login.
+func (m *TokenModel) RotateRefreshToken(ctx context.Context, refreshToken, kid string, ttl time.Duration) (string, string, error) {
+	claims, err := m.ParseToken(ctx, refreshToken, ScopeRefresh)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to refresh access token: %v", err)
+	}
+
+	remaining := RefreshTokenTTL
+	if claims.ExpiresAt != nil {
+		remaining = time.Until(claims.ExpiresAt.Time)
+	}
+
+	// Of concurrent refreshes with the same token, only the first gets new tokens
+	key := fmt.Sprintf("deactivated_token:%s", refreshToken)
+	first, err := m.RedisClient.SetNX(ctx, key, "deactivated", remaining).Result()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to deactivate refresh token: %v", err)
+	}
+	if !first {
+		return "", "", fmt.Errorf("refresh token has already been used")
+	}
+
+	auth := authenticationOf(claims)
+
+	accessToken, err := m.GenerateToken(ctx, int(claims.UserID), claims.Role, ttl, ScopeAuthentication, kid, auth)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate access token: %v", err)
+	}
+
+	newRefreshToken, err := m.GenerateToken(ctx, int(claims.UserID), claims.Role, remaining, ScopeRefresh, kid, auth)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+
+	return accessToken, newRefreshToken, nil
+}
+
+// authenticationOf returns the login a token was issued for, to carry it over to new tokens.
+func authenticationOf(claims *JWTClaims) Authentication {
+	auth := Authentication{Methods: claims.AMR, Tenant: claims.Tenant, TenantRole: claims.TenantRole}
+	if claims.AuthTime != nil {
+		auth.Time = claims.AuthTime.Time
+	}
+
+	return auth
+}
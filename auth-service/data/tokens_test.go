@@ -0,0 +1,107 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testToken signs claims issued at issuedAt. IsTokenRevoked does not check signatures, so
+// any key will do.
+func testToken(t *testing.T, claims JWTClaims, issuedAt time.Time) string {
+	t.Helper()
+
+	claims.IssuedAt = jwt.NewNumericDate(issuedAt)
+	claims.ExpiresAt = jwt.NewNumericDate(issuedAt.Add(AccessTokenTTL))
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestIsTokenRevoked(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	m := &TokenModel{RedisClient: client}
+
+	before := time.Now().Add(-time.Minute)
+	user := testToken(t, JWTClaims{UserID: 7, Role: RoleUser, Scope: ScopeAuthentication}, before)
+	other := testToken(t, JWTClaims{UserID: 8, Role: RoleUser, Scope: ScopeAuthentication}, before)
+	admin := testToken(t, JWTClaims{UserID: 7, Role: RoleAdmin, Scope: ScopeAuthentication}, before)
+	loggedOut := testToken(t, JWTClaims{UserID: 8, Role: RoleUser, Scope: ScopeAuthentication}, before.Add(time.Second))
+
+	if _, err := m.RevokeUserSessions(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InsertDeactivatedToken(ctx, loggedOut, AccessTokenTTL); err != nil {
+		t.Fatal(err)
+	}
+	after := testToken(t, JWTClaims{UserID: 7, Role: RoleUser, Scope: ScopeAuthentication}, time.Now().Add(2*time.Second))
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{"issued before the revocation", user, true},
+		{"issued after the revocation", after, false},
+		{"another user", other, false},
+		{"admin with the same ID", admin, false},
+		{"logged out", loggedOut, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked, err := m.IsTokenRevoked(ctx, tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked != tt.want {
+				t.Fatalf("revoked = %v, want %v", revoked, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateImpersonationToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &TokenModel{KeyManager: &KeyManager{privateKey: key}}
+
+	signed, err := m.GenerateImpersonationToken(context.Background(), 7, 3, "acme", "user-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims JWTClaims
+	_, err = jwt.ParseWithClaims(signed, &claims, func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token is the user's, with the admin named as the actor
+	if claims.UserID != 7 || claims.Role != RoleUser || claims.Scope != ScopeAuthentication || claims.Tenant != "acme" {
+		t.Errorf("claims = %+v, want user 7 in tenant acme", claims)
+	}
+	if claims.Act == nil || claims.Act.Subject != "3" || claims.Act.Role != RoleAdmin {
+		t.Fatalf("act = %+v, want admin 3", claims.Act)
+	}
+
+	// It cannot outlive ImpersonationTTL, and does not look like a fresh login
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != ImpersonationTTL {
+		t.Errorf("lifetime = %v, want %v", ttl, ImpersonationTTL)
+	}
+	if claims.AuthTime != nil {
+		t.Errorf("auth_time = %v, want none", claims.AuthTime)
+	}
+}
@@ -0,0 +1,263 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	// WebAuthnSessionTTL is how long a client has to complete a ceremony it started.
+	WebAuthnSessionTTL = 5 * time.Minute
+
+	// AMR values (RFC 8176) for passkey logins: proof of possession of a hardware-bound key,
+	// plus "mfa" when the authenticator also verified the user with a PIN or biometric.
+	AMRHardwareKey = "hwk"
+	AMRMultiFactor = "mfa"
+
+	webAuthnRegistration = "registration"
+	webAuthnLogin        = "login"
+)
+
+// Errors returned by the WebAuthn ceremonies.
+var (
+	ErrWebAuthnSessionNotFound = errors.New("webauthn session not found or expired")
+	ErrWebAuthnCloneWarning    = errors.New("authenticator signature counter went backwards, the credential may be cloned")
+)
+
+// NewWebAuthnFromEnv configures the relying party from WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and
+// the comma separated WEBAUTHN_RP_ORIGINS, defaulting to the local frontend.
+func NewWebAuthnFromEnv() (*webauthn.WebAuthn, error) {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "Microservices"
+	}
+
+	origins := []string{"http://localhost:8080"}
+	if v := os.Getenv("WEBAUTHN_RP_ORIGINS"); v != "" {
+		origins = strings.Split(v, ",")
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+	})
+}
+
+// WebAuthnUser adapts a user-service account to the webauthn.User interface. The user
+// handle is the decimal user ID, which lets passkey logins find the account without asking
+// for an email address first.
+type WebAuthnUser struct {
+	ID          int64
+	Email       string
+	Username    string
+	Credentials []webauthn.Credential
+}
+
+func (u *WebAuthnUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatInt(u.ID, 10))
+}
+
+func (u *WebAuthnUser) WebAuthnName() string {
+	return u.Email
+}
+
+func (u *WebAuthnUser) WebAuthnDisplayName() string {
+	if u.Username != "" {
+		return u.Username
+	}
+	return u.Email
+}
+
+func (u *WebAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.Credentials
+}
+
+// WebAuthnUserLookup loads a user and their current credentials by ID.
+type WebAuthnUserLookup func(ctx context.Context, userID int64) (*WebAuthnUser, error)
+
+// WebAuthnModel runs the WebAuthn registration and login ceremonies. Between the begin and
+// finish steps the challenge lives in Redis under a random session ID handed to the client.
+type WebAuthnModel struct {
+	RedisClient *redis.Client
+	WebAuthn    *webauthn.WebAuthn
+}
+
+// BeginRegistration starts registering a new passkey for user. Credentials the user already
+// has are excluded, so an authenticator cannot be registered twice.
+func (m *WebAuthnModel) BeginRegistration(ctx context.Context, user *WebAuthnUser) (*protocol.CredentialCreation, string, error) {
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.Credentials))
+	for _, c := range user.Credentials {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+
+	creation, session, err := m.WebAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionID, err := m.saveSession(ctx, webAuthnRegistration, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return creation, sessionID, nil
+}
+
+// FinishRegistration verifies the authenticator's attestation response for a registration
+// started by the same user, and returns the new credential.
+func (m *WebAuthnModel) FinishRegistration(ctx context.Context, user *WebAuthnUser, sessionID string, body io.Reader) (*webauthn.Credential, error) {
+	session, err := m.loadSession(ctx, webAuthnRegistration, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(session.UserID, user.WebAuthnID()) {
+		return nil, ErrWebAuthnSessionNotFound
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation response: %v", err)
+	}
+
+	return m.WebAuthn.CreateCredential(user, *session, parsed)
+}
+
+// BeginLogin starts a passkey login. With a nil user any discoverable credential is
+// accepted; otherwise only the user's own credentials are allowed.
+func (m *WebAuthnModel) BeginLogin(ctx context.Context, user *WebAuthnUser) (*protocol.CredentialAssertion, string, error) {
+	var assertion *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	var err error
+
+	if user == nil {
+		assertion, session, err = m.WebAuthn.BeginDiscoverableLogin()
+	} else {
+		assertion, session, err = m.WebAuthn.BeginLogin(user)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionID, err := m.saveSession(ctx, webAuthnLogin, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return assertion, sessionID, nil
+}
+
+// FinishLogin verifies the authenticator's assertion and returns the user it belongs to,
+// together with the credential and its updated signature counter.
+func (m *WebAuthnModel) FinishLogin(ctx context.Context, sessionID string, body io.Reader, lookup WebAuthnUserLookup) (*WebAuthnUser, *webauthn.Credential, error) {
+	session, err := m.loadSession(ctx, webAuthnLogin, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid assertion response: %v", err)
+	}
+
+	var user *WebAuthnUser
+	var credential *webauthn.Credential
+
+	if len(session.UserID) == 0 {
+		handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := strconv.ParseInt(string(userHandle), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user handle")
+			}
+			user, err = lookup(ctx, userID)
+			return user, err
+		}
+		_, credential, err = m.WebAuthn.ValidatePasskeyLogin(handler, *session, parsed)
+	} else {
+		userID, parseErr := strconv.ParseInt(string(session.UserID), 10, 64)
+		if parseErr != nil {
+			return nil, nil, ErrWebAuthnSessionNotFound
+		}
+		user, err = lookup(ctx, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+		credential, err = m.WebAuthn.ValidateLogin(user, *session, parsed)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if credential.Authenticator.CloneWarning {
+		return nil, nil, ErrWebAuthnCloneWarning
+	}
+
+	return user, credential, nil
+}
+
+func (m *WebAuthnModel) saveSession(ctx context.Context, kind string, session *webauthn.SessionData) (string, error) {
+	idBytes := make([]byte, 24)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return "", err
+	}
+	sessionID := base64.RawURLEncoding.EncodeToString(idBytes)
+
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	err = m.RedisClient.Set(ctx, webAuthnSessionKey(kind, sessionID), raw, WebAuthnSessionTTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to store webauthn session: %v", err)
+	}
+
+	return sessionID, nil
+}
+
+// loadSession returns a ceremony's session and removes it, so every challenge is used once.
+func (m *WebAuthnModel) loadSession(ctx context.Context, kind, sessionID string) (*webauthn.SessionData, error) {
+	raw, err := m.RedisClient.GetDel(ctx, webAuthnSessionKey(kind, sessionID)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrWebAuthnSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webauthn session: %v", err)
+	}
+
+	var session webauthn.SessionData
+	err = json.Unmarshal(raw, &session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode webauthn session: %v", err)
+	}
+
+	return &session, nil
+}
+
+func webAuthnSessionKey(kind, sessionID string) string {
+	return "webauthn_session:" + kind + ":" + sessionID
+}
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/descope/virtualwebauthn"
+	"github.com/go-redis/redis/v8"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var testRelyingParty = virtualwebauthn.RelyingParty{
+	ID:     "localhost",
+	Name:   "Microservices",
+	Origin: "http://localhost:8080",
+}
+
+func newTestWebAuthnModel(t *testing.T) *WebAuthnModel {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          testRelyingParty.ID,
+		RPDisplayName: testRelyingParty.Name,
+		RPOrigins:     []string{testRelyingParty.Origin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &WebAuthnModel{RedisClient: client, WebAuthn: w}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+// registerTestPasskey runs a registration ceremony for user with a virtual authenticator.
+func registerTestPasskey(t *testing.T, m *WebAuthnModel, user *WebAuthnUser, authenticator *virtualwebauthn.Authenticator, credential virtualwebauthn.Credential) {
+	t.Helper()
+	ctx := context.Background()
+
+	creation, sessionID, err := m.BeginRegistration(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := virtualwebauthn.ParseAttestationOptions(mustJSON(t, creation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := virtualwebauthn.CreateAttestationResponse(testRelyingParty, *authenticator, credential, *options)
+
+	registered, err := m.FinishRegistration(ctx, user, sessionID, strings.NewReader(response))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Credentials = append(user.Credentials, *registered)
+	authenticator.AddCredential(credential)
+}
+
+func loginWithTestPasskey(t *testing.T, m *WebAuthnModel, authenticator virtualwebauthn.Authenticator, credential virtualwebauthn.Credential, lookup WebAuthnUserLookup) (*WebAuthnUser, *webauthn.Credential, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	assertion, sessionID, err := m.BeginLogin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := virtualwebauthn.ParseAssertionOptions(mustJSON(t, assertion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := virtualwebauthn.CreateAssertionResponse(testRelyingParty, authenticator, credential, *options)
+
+	return m.FinishLogin(ctx, sessionID, strings.NewReader(response), lookup)
+}
+
+func TestWebAuthnRegistrationAndDiscoverableLogin(t *testing.T) {
+	ctx := context.Background()
+	m := newTestWebAuthnModel(t)
+
+	user := &WebAuthnUser{ID: 42, Email: "jane@example.com", Username: "jane"}
+	authenticator := virtualwebauthn.NewAuthenticatorWithOptions(virtualwebauthn.AuthenticatorOptions{
+		UserHandle: user.WebAuthnID(),
+	})
+	credential := virtualwebauthn.NewCredential(virtualwebauthn.KeyTypeEC2)
+
+	registerTestPasskey(t, m, user, &authenticator, credential)
+
+	lookup := func(ctx context.Context, userID int64) (*WebAuthnUser, error) {
+		if userID != user.ID {
+			return nil, errors.New("no such user")
+		}
+		return user, nil
+	}
+
+	loggedIn, used, err := loginWithTestPasskey(t, m, authenticator, credential, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loggedIn.ID != 42 {
+		t.Fatalf("expected user 42, got %d", loggedIn.ID)
+	}
+	if !used.Flags.UserVerified {
+		t.Fatal("expected the authenticator to report user verification")
+	}
+
+	// A challenge can only be answered once
+	assertion, sessionID, err := m.BeginLogin(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, err := virtualwebauthn.ParseAssertionOptions(mustJSON(t, assertion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := virtualwebauthn.CreateAssertionResponse(testRelyingParty, authenticator, credential, *options)
+
+	if _, _, err := m.FinishLogin(ctx, sessionID, strings.NewReader(response), lookup); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.FinishLogin(ctx, sessionID, strings.NewReader(response), lookup); !errors.Is(err, ErrWebAuthnSessionNotFound) {
+		t.Fatalf("expected a replayed session to be rejected, got %v", err)
+	}
+}
+
+func TestWebAuthnRegistrationSessionBelongsToUser(t *testing.T) {
+	ctx := context.Background()
+	m := newTestWebAuthnModel(t)
+
+	user := &WebAuthnUser{ID: 42, Email: "jane@example.com"}
+	other := &WebAuthnUser{ID: 7, Email: "john@example.com"}
+
+	_, sessionID, err := m.BeginRegistration(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.FinishRegistration(ctx, other, sessionID, strings.NewReader("{}")); !errors.Is(err, ErrWebAuthnSessionNotFound) {
+		t.Fatalf("expected another user's session to be rejected, got %v", err)
+	}
+}
+
+func TestWebAuthnLoginRejectsClonedAuthenticator(t *testing.T) {
+	m := newTestWebAuthnModel(t)
+
+	user := &WebAuthnUser{ID: 42, Email: "jane@example.com"}
+	authenticator := virtualwebauthn.NewAuthenticatorWithOptions(virtualwebauthn.AuthenticatorOptions{
+		UserHandle: user.WebAuthnID(),
+	})
+	credential := virtualwebauthn.NewCredential(virtualwebauthn.KeyTypeEC2)
+
+	registerTestPasskey(t, m, user, &authenticator, credential)
+
+	lookup := func(ctx context.Context, userID int64) (*WebAuthnUser, error) {
+		return user, nil
+	}
+
+	credential.Counter = 5
+	_, used, err := loginWithTestPasskey(t, m, authenticator, credential, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Credentials[0] = *used
+
+	// A copy of the key still at an older counter value
+	credential.Counter = 3
+	if _, _, err := loginWithTestPasskey(t, m, authenticator, credential, lookup); !errors.Is(err, ErrWebAuthnCloneWarning) {
+		t.Fatalf("expected a clone warning, got %v", err)
+	}
+}
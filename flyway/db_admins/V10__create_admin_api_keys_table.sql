@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS admin_api_keys (
+    id SERIAL PRIMARY KEY,
+    admin_id INTEGER NOT NULL REFERENCES admins(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    key_hash BYTEA UNIQUE NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    scopes VARCHAR(50) NOT NULL DEFAULT 'read',
+    expires_at TIMESTAMPTZ NULL,
+    last_used_at TIMESTAMPTZ NULL,
+    revoked_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS admin_api_keys_admin_id_idx ON admin_api_keys (admin_id) WHERE revoked_at IS NULL;
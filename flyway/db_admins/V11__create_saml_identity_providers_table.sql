@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS saml_identity_providers (
+    id SERIAL PRIMARY KEY,
+    tenant VARCHAR(63) UNIQUE NOT NULL,
+    entity_id VARCHAR(255) NOT NULL,
+    sso_url VARCHAR(255) NOT NULL,
+    certificate TEXT NOT NULL,
+    email_attribute VARCHAR(255) NOT NULL DEFAULT 'email',
+    first_name_attribute VARCHAR(255) NOT NULL DEFAULT '',
+    last_name_attribute VARCHAR(255) NOT NULL DEFAULT '',
+    trust_email BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,17 @@
+-- Admins either manage the whole platform or only the tenants they are added to. Existing
+-- admins keep managing everything.
+ALTER TABLE admins ADD COLUMN IF NOT EXISTS platform BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE admins SET platform = TRUE;
+
+CREATE TABLE IF NOT EXISTS admin_tenants (
+    admin_id INTEGER NOT NULL REFERENCES admins(id) ON DELETE CASCADE,
+    tenant VARCHAR(63) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (admin_id, tenant)
+);
+
+CREATE INDEX IF NOT EXISTS admin_tenants_tenant_idx ON admin_tenants (tenant);
+
+-- Admins invited by a tenant's admin join that tenant when they register; those invited by
+-- platform admins without a tenant become platform admins
+ALTER TABLE new_admins ADD COLUMN IF NOT EXISTS tenant VARCHAR(63) NULL;
@@ -0,0 +1,32 @@
+-- Changes to users, for other services to follow through WatchUserEvents. A trigger
+-- records them, so every code path that writes users is covered. Events only carry the
+-- user ID: watchers look the user up, and a deleted user leaves nothing personal behind.
+CREATE TABLE IF NOT EXISTS user_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('created', 'updated', 'status_changed', 'deleted')),
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_events_occurred_at_idx ON user_events (occurred_at);
+
+CREATE OR REPLACE FUNCTION record_user_event() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO user_events (user_id, type) VALUES (NEW.id, 'created');
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO user_events (user_id, type) VALUES (OLD.id, 'deleted');
+    ELSIF OLD.active IS DISTINCT FROM NEW.active THEN
+        INSERT INTO user_events (user_id, type) VALUES (NEW.id, 'status_changed');
+    -- Password changes and bare timestamp updates are not of interest to other services
+    ELSIF to_jsonb(OLD) - 'passwordhash' - 'updated_at' IS DISTINCT FROM to_jsonb(NEW) - 'passwordhash' - 'updated_at' THEN
+        INSERT INTO user_events (user_id, type) VALUES (NEW.id, 'updated');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_events_trigger ON users;
+CREATE TRIGGER users_events_trigger
+    AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION record_user_event();
@@ -0,0 +1,28 @@
+-- Moderation status of users: suspended users are blocked until a date, banned users for
+-- good. This is separate from active, which tenants' provisioning clients control.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'suspended', 'banned'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_reason TEXT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_until TIMESTAMPTZ NULL;
+
+-- Only suspensions, and all of them, end on a date
+ALTER TABLE users ADD CONSTRAINT users_suspended_until_check CHECK ((status = 'suspended') = (suspended_until IS NOT NULL));
+
+-- Suspensions due to expire
+CREATE INDEX IF NOT EXISTS users_suspended_until_idx ON users (suspended_until) WHERE status = 'suspended';
+
+-- Moderation changes are status changes for WatchUserEvents too
+CREATE OR REPLACE FUNCTION record_user_event() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO user_events (user_id, type) VALUES (NEW.id, 'created');
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO user_events (user_id, type) VALUES (OLD.id, 'deleted');
+    ELSIF (OLD.active, OLD.status, OLD.status_reason, OLD.suspended_until) IS DISTINCT FROM (NEW.active, NEW.status, NEW.status_reason, NEW.suspended_until) THEN
+        INSERT INTO user_events (user_id, type) VALUES (NEW.id, 'status_changed');
+    -- Password changes and bare timestamp updates are not of interest to other services
+    ELSIF to_jsonb(OLD) - 'passwordhash' - 'updated_at' IS DISTINCT FROM to_jsonb(NEW) - 'passwordhash' - 'updated_at' THEN
+        INSERT INTO user_events (user_id, type) VALUES (NEW.id, 'updated');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,28 @@
+-- Bulk imports of users from CSV or NDJSON uploads, run as background jobs. Each row of
+-- the upload is kept with its outcome, as the report of the import.
+CREATE TABLE IF NOT EXISTS user_imports (
+    id SERIAL PRIMARY KEY,
+    format VARCHAR(10) NOT NULL CHECK (format IN ('csv', 'ndjson')),
+    invite BOOLEAN NOT NULL DEFAULT FALSE,
+    client VARCHAR(50) NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    requested_by VARCHAR(50) NOT NULL,
+    error TEXT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_imports_pending_idx ON user_imports (created_at) WHERE status IN ('pending', 'running');
+
+-- The rows of an import. record holds the uploaded fields; the password is removed from it
+-- as soon as the row has been processed.
+CREATE TABLE IF NOT EXISTS user_import_rows (
+    import_id INTEGER NOT NULL REFERENCES user_imports(id) ON DELETE CASCADE,
+    line INTEGER NOT NULL,
+    record JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'created', 'failed')),
+    user_id INTEGER NULL,
+    errors JSONB NULL,
+    PRIMARY KEY (import_id, line)
+);
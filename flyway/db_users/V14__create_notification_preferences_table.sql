@@ -0,0 +1,17 @@
+-- How users want to be notified. Users without a row have the defaults: every channel but
+-- webhooks on, every category opted in, no digest and no quiet hours, in UTC.
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    in_app_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    webhook_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    webhook_url TEXT NULL,
+    categories JSONB NOT NULL DEFAULT '{}'::jsonb,
+    digest VARCHAR(10) NOT NULL DEFAULT 'off' CHECK (digest IN ('off', 'daily', 'weekly')),
+    time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    -- Local times as HH:MM; quiet hours can span midnight
+    quiet_hours_start VARCHAR(5) NULL,
+    quiet_hours_end VARCHAR(5) NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((quiet_hours_start IS NULL) = (quiet_hours_end IS NULL))
+);
@@ -0,0 +1,4 @@
+-- Profile pictures are kept in an object store, and users only records where. The variants
+-- are stored under keys made from avatar_key, such as avatars/1/ab12_256.jpg for
+-- avatars/1/ab12.jpg.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_key TEXT NULL;
@@ -0,0 +1,36 @@
+-- Tenants are the organizations users belong to. Users can be members of several, with a
+-- role in each; tokens name the one they are currently acting in. Tenants are referred to
+-- by the same short names SCIM and SAML already use.
+CREATE TABLE IF NOT EXISTS tenants (
+    name VARCHAR(63) PRIMARY KEY CHECK (name ~ '^[a-z0-9][a-z0-9-]{0,62}$'),
+    display_name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS tenant_members (
+    tenant VARCHAR(63) NOT NULL REFERENCES tenants(name) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(10) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member')),
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS tenant_members_user_id_idx ON tenant_members (user_id);
+
+-- Imports are run by admins of one tenant, whose users they add to it
+ALTER TABLE user_imports ADD COLUMN IF NOT EXISTS tenant VARCHAR(63) NULL REFERENCES tenants(name) ON DELETE CASCADE;
+
+-- Every tenant provisioning clients or SSO already knew becomes a tenant, with the users
+-- provisioned into it as members
+INSERT INTO tenants (name, display_name)
+SELECT DISTINCT tenant, tenant FROM (
+    SELECT scim_tenant AS tenant FROM users WHERE scim_tenant IS NOT NULL
+    UNION SELECT tenant FROM scim_tokens
+    UNION SELECT tenant FROM scim_groups
+) known
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO tenant_members (tenant, user_id)
+SELECT scim_tenant, id FROM users WHERE scim_tenant IS NOT NULL
+ON CONFLICT DO NOTHING;
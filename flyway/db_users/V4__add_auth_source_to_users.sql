@@ -0,0 +1,3 @@
+-- Where a user's password is checked: 'local' for the bcrypt hash in this table, or the
+-- external directory ('ldap') the account was provisioned from on its first login
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_source VARCHAR(20) NOT NULL DEFAULT 'local';
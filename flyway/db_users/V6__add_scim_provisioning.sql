@@ -0,0 +1,38 @@
+-- SCIM 2.0 provisioning: users created by a tenant's HR system carry the tenant and the
+-- system's own ID for them, and can be deactivated without being deleted
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id VARCHAR(255) NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS scim_tenant VARCHAR(63) NULL;
+
+CREATE INDEX IF NOT EXISTS users_scim_tenant_idx ON users (scim_tenant) WHERE scim_tenant IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_scim_external_id_idx ON users (scim_tenant, external_id) WHERE external_id IS NOT NULL;
+
+-- Bearer tokens the provisioning clients authenticate with, one tenant each
+CREATE TABLE IF NOT EXISTS scim_tokens (
+    id SERIAL PRIMARY KEY,
+    tenant VARCHAR(63) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    token_hash BYTEA UNIQUE NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    last_used_at TIMESTAMPTZ NULL,
+    revoked_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS scim_groups (
+    id SERIAL PRIMARY KEY,
+    tenant VARCHAR(63) NOT NULL,
+    display_name VARCHAR(255) NOT NULL,
+    external_id VARCHAR(255) NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (tenant, display_name)
+);
+
+CREATE TABLE IF NOT EXISTS scim_group_members (
+    group_id INTEGER NOT NULL REFERENCES scim_groups(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS scim_group_members_user_id_idx ON scim_group_members (user_id);
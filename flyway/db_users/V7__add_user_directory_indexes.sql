@@ -0,0 +1,12 @@
+-- When the user last proved they own their email address, NULL if they never have
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ NULL;
+
+-- The admin user directory searches email, username and names with ILIKE, which a trigram
+-- index over the same expression can serve
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS users_search_trgm_idx ON users USING gin (
+    (email || ' ' || username || ' ' || COALESCE(first_name, '') || ' ' || COALESCE(last_name, '')) gin_trgm_ops
+);
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at, id);
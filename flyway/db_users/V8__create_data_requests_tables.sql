@@ -0,0 +1,36 @@
+-- Data subject requests: exports of everything stored about a user, and erasures that
+-- remove them from every service once a grace period has passed. The rows are the audit
+-- trail of the requests, so they keep the user ID but are not tied to the users row,
+-- which an erasure deletes.
+CREATE TABLE IF NOT EXISTS data_requests (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('export', 'erasure')),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed', 'cancelled')),
+    requested_by VARCHAR(50) NOT NULL,
+    run_after TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    archive JSONB NULL,
+    archive_expires_at TIMESTAMPTZ NULL,
+    error TEXT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS data_requests_user_id_idx ON data_requests (user_id, created_at);
+CREATE INDEX IF NOT EXISTS data_requests_due_idx ON data_requests (run_after) WHERE status = 'pending';
+
+-- A user has at most one open request of each kind
+CREATE UNIQUE INDEX IF NOT EXISTS data_requests_open_idx ON data_requests (user_id, kind) WHERE status IN ('pending', 'running');
+
+-- The part of a request each service carries out
+CREATE TABLE IF NOT EXISTS data_request_steps (
+    request_id INTEGER NOT NULL REFERENCES data_requests(id) ON DELETE CASCADE,
+    service VARCHAR(50) NOT NULL,
+    position INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'completed', 'failed')),
+    error TEXT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (request_id, service)
+);
@@ -0,0 +1,21 @@
+-- Email changes wait for the new address to be confirmed. The old address gets a link
+-- that cancels the change, or undoes it once confirmed. Only hashes of both tokens are
+-- stored.
+CREATE TABLE IF NOT EXISTS email_changes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    old_email VARCHAR(100) NOT NULL,
+    new_email VARCHAR(100) NOT NULL,
+    confirm_token_hash BYTEA UNIQUE NOT NULL,
+    revert_token_hash BYTEA UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revert_expires_at TIMESTAMPTZ NOT NULL,
+    confirmed_at TIMESTAMPTZ NULL,
+    reverted_at TIMESTAMPTZ NULL,
+    cancelled_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A user has at most one pending change; a new request cancels the previous one
+CREATE UNIQUE INDEX IF NOT EXISTS email_changes_pending_idx ON email_changes (user_id)
+    WHERE confirmed_at IS NULL AND reverted_at IS NULL AND cancelled_at IS NULL;
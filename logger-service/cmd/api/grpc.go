@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"log"
 	"log-service/data"
-	"log-service/logs"
+	"proto/logs"
 	"net"
 
 	"google.golang.org/grpc"
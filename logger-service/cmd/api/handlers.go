@@ -1,13 +1,22 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log-service/data"
 	"net/http"
 )
 
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	UserID int64  `json:"user_id,omitempty"`
+	Name   string `json:"name"`
+	Data   string `json:"data"`
+}
+
+// SubjectPayload identifies the user a data subject request is about.
+type SubjectPayload struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email"`
 }
 
 func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
@@ -17,6 +26,7 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 
 	// insert data
 	event := data.LogEntry{
+		UserID: requestPayload.UserID,
 		Name: requestPayload.Name,
 		Data: requestPayload.Data,
 	}
@@ -33,4 +43,64 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	app.writeJSON(w, http.StatusAccepted, resp)
-}
\ No newline at end of file
+}
+
+// SubjectEntries returns every entry about a user, for data exports.
+func (app *Config) SubjectEntries(w http.ResponseWriter, r *http.Request) {
+	subject, err := app.readSubject(w, r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	entries, err := app.Models.LogEntry.ForSubject(subject.UserID, subject.Email)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error: false,
+		Message: fmt.Sprintf("found %d entries", len(entries)),
+		Data: entries,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// AnonymizeSubject removes a user from every entry about them, for erasure requests.
+func (app *Config) AnonymizeSubject(w http.ResponseWriter, r *http.Request) {
+	subject, err := app.readSubject(w, r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	changed, err := app.Models.LogEntry.AnonymizeSubject(subject.UserID, subject.Email)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error: false,
+		Message: fmt.Sprintf("anonymized %d entries", changed),
+		Data: map[string]int64{"anonymized": changed},
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *Config) readSubject(w http.ResponseWriter, r *http.Request) (SubjectPayload, error) {
+	var subject SubjectPayload
+
+	err := app.readJSON(w, r, &subject)
+	if err != nil {
+		return subject, err
+	}
+	if subject.UserID < 1 {
+		return subject, errors.New("user_id is required")
+	}
+
+	return subject, nil
+}
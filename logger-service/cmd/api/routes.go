@@ -25,5 +25,9 @@ func (app *Config) routes() http.Handler {
 
 	mux.Post("/log", app.WriteLog)
 
+	// Data subject requests, sent by user-service
+	mux.Post("/subject/entries", app.SubjectEntries)
+	mux.Post("/subject/anonymize", app.AnonymizeSubject)
+
 	return mux
 }
\ No newline at end of file
@@ -27,6 +27,7 @@ type Models struct {
 
 type LogEntry struct {
 	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    int64     `bson:"user_id,omitempty" json:"user_id,omitempty"` // The user the entry is about, if any
 	Name      string    `bson:"name" json:"name"`
 	Data      string    `bson:"data" json:"data"`
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
@@ -37,6 +38,7 @@ func (l *LogEntry) Insert(entry LogEntry) error {
 	collection := client.Database("logs").Collection("logs")
 
 	_, err := collection.InsertOne(context.TODO(), LogEntry{
+		UserID: entry.UserID,
 		Name: entry.Name,
 		Data: entry.Data,
 		CreatedAt: time.Now(),
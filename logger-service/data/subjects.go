@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Redacted takes the place of a user's email address in anonymized entries.
+const Redacted = "[erased]"
+
+// subjectFilter matches the entries about a user: those tagged with their ID, and those
+// mentioning their email address, as services log logins before they know the ID.
+func subjectFilter(userID int64, email string) bson.M {
+	or := bson.A{bson.M{"user_id": userID}}
+	if email != "" {
+		or = append(or, bson.M{"data": primitive.Regex{Pattern: regexp.QuoteMeta(email), Options: "i"}})
+	}
+
+	return bson.M{"$or": or}
+}
+
+// ForSubject returns the entries about a user, oldest first.
+func (l *LogEntry) ForSubject(userID int64, email string) ([]*LogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+
+	cursor, err := collection.Find(ctx, subjectFilter(userID, email), opts)
+	if err != nil {
+		log.Println("Finding subject docs error:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	logs := []*LogEntry{}
+
+	for cursor.Next(ctx) {
+		var item LogEntry
+
+		err := cursor.Decode(&item)
+		if err != nil {
+			log.Print("Error decoding log into slice:", err)
+			return nil, err
+		}
+		logs = append(logs, &item)
+	}
+
+	return logs, cursor.Err()
+}
+
+// AnonymizeSubject removes a user from the entries about them, keeping the entries
+// themselves: the user ID is dropped and the email address is replaced with Redacted. It
+// returns the number of entries changed.
+func (l *LogEntry) AnonymizeSubject(userID int64, email string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	cursor, err := collection.Find(ctx, subjectFilter(userID, email))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var changed int64
+
+	for cursor.Next(ctx) {
+		var item LogEntry
+
+		err := cursor.Decode(&item)
+		if err != nil {
+			return changed, err
+		}
+
+		docID, err := primitive.ObjectIDFromHex(item.ID)
+		if err != nil {
+			return changed, err
+		}
+
+		_, err = collection.UpdateOne(ctx,
+			bson.M{"_id": docID},
+			bson.M{
+				"$set":   bson.M{"data": redactEmail(item.Data, email), "updated_at": time.Now()},
+				"$unset": bson.M{"user_id": ""},
+			},
+		)
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	return changed, cursor.Err()
+}
+
+// redactEmail replaces every occurrence of email in s, ignoring case.
+func redactEmail(s, email string) string {
+	if email == "" {
+		return s
+	}
+
+	return regexp.MustCompile("(?i)"+regexp.QuoteMeta(email)).ReplaceAllLiteralString(s, Redacted)
+}
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRedactEmail(t *testing.T) {
+	for _, tt := range []struct {
+		data, email, want string
+	}{
+		{"User jane@example.com logged in", "jane@example.com", "User " + Redacted + " logged in"},
+		{"Login failed for JANE@Example.com, then jane@example.com", "jane@example.com", "Login failed for " + Redacted + ", then " + Redacted},
+		{"User j.ne+x@example.com and jane@example.com", "j.ne+x@example.com", "User " + Redacted + " and jane@example.com"},
+		{"User 7 logged in", "jane@example.com", "User 7 logged in"},
+		{"User jane@example.com logged in", "", "User jane@example.com logged in"},
+	} {
+		if got := redactEmail(tt.data, tt.email); got != tt.want {
+			t.Errorf("redactEmail(%q, %q) = %q, want %q", tt.data, tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestSubjectFilter(t *testing.T) {
+	or := subjectFilter(7, "j.ne@example.com")["$or"].(bson.A)
+	if len(or) != 2 {
+		t.Fatalf("filter = %v, want the user ID and the email address", or)
+	}
+	if id := or[0].(bson.M)["user_id"]; id != int64(7) {
+		t.Errorf("user_id = %v, want 7", id)
+	}
+	regex := or[1].(bson.M)["data"].(primitive.Regex)
+	if regex.Pattern != `j\.ne@example\.com` || regex.Options != "i" {
+		t.Errorf("data = %v, want the quoted address, ignoring case", regex)
+	}
+
+	// Without an address only entries tagged with the ID are about the user
+	if or := subjectFilter(7, "")["$or"].(bson.A); len(or) != 1 {
+		t.Errorf("filter without email = %v", or)
+	}
+}
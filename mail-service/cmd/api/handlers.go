@@ -1,15 +1,30 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"proto/users"
 )
 
+// SendMail sends a message to the address in to or, if that is empty, to the user with
+// the ID in user_id. Messages with a category other than transactional are only sent if
+// the user's notification preferences allow it right now; otherwise the response says
+// whether the message was suppressed, should be retried after quiet hours or belongs in
+// the user's digest, and sending it later is up to the caller.
 func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 	type mailMessage struct {
 		From    string `json:"from"`
 		To      string `json:"to"`
-		Subject string `json:"subject"`
+		UserID   int64  `json:"user_id"`
+		Category string `json:"category"`
+		Subject  string `json:"subject"`
 		Message string `json:"message"`
 	}
 
@@ -22,6 +37,65 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if requestPayload.Category != "" && requestPayload.Category != "transactional" {
+		if requestPayload.UserID < 1 {
+			app.errorJSON(w, errors.New("user_id is required for messages with a category"))
+			return
+		}
+
+		check, err := checkNotification(requestPayload.UserID, requestPayload.Category)
+		if err != nil {
+			log.Println(err)
+			if status.Code(err) == codes.NotFound {
+				app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+				return
+			}
+			app.errorJSON(w, err, http.StatusBadGateway)
+			return
+		}
+
+		if check.GetDecision() != users.NotificationDecision_NOTIFICATION_DECISION_SEND {
+			decision := map[string]any{
+				"decision": strings.ToLower(strings.TrimPrefix(check.GetDecision().String(), "NOTIFICATION_DECISION_")),
+				"reason":   check.GetReason(),
+			}
+			if check.GetDeliverAfter() > 0 {
+				decision["deliver_after"] = time.Unix(check.GetDeliverAfter(), 0).UTC()
+			}
+
+			payload := jsonResponse {
+				Error: false,
+				Message: "not sent: " + check.GetReason(),
+				Data: decision,
+			}
+
+			app.writeJSON(w, http.StatusOK, payload)
+			return
+		}
+
+		if requestPayload.To == "" {
+			requestPayload.To = check.GetAddress()
+		}
+	}
+
+	if requestPayload.To == "" {
+		if requestPayload.UserID < 1 {
+			app.errorJSON(w, errors.New("to or user_id is required"))
+			return
+		}
+
+		requestPayload.To, err = userEmail(requestPayload.UserID)
+		if err != nil {
+			log.Println(err)
+			if status.Code(err) == codes.NotFound {
+				app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+				return
+			}
+			app.errorJSON(w, err, http.StatusBadGateway)
+			return
+		}
+	}
+
 	msg := Message {
 		From: requestPayload.From,
 		To: requestPayload.To,
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"proto/users"
+)
+
+// userServiceAddress is where user-service serves gRPC.
+const userServiceAddress = "user-service:50001"
+
+// userEmail looks up the email address of a user, so other services can mail users by ID
+// without keeping copies of their addresses.
+func userEmail(userID int64) (string, error) {
+	conn, err := grpc.Dial(userServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := users.NewUserServiceClient(conn).GetUser(ctx, &users.GetUserRequest{UserId: userID})
+	if err != nil {
+		return "", err
+	}
+
+	return user.GetEmail(), nil
+}
+
+// checkNotification asks user-service whether a user wants an email in the category now,
+// and at which address.
+func checkNotification(userID int64, category string) (*users.CheckNotificationResponse, error) {
+	conn, err := grpc.Dial(userServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return users.NewUserServiceClient(conn).CheckNotification(ctx, &users.CheckNotificationRequest{
+		UserId:   userID,
+		Channel:  "email",
+		Category: category,
+	})
+}
@@ -0,0 +1,206 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The breached password index is a flat file: an 8 byte magic, a big-endian entry count and
+// then the sorted first 8 bytes of the SHA-1 of every breached password. Eight bytes keep
+// the full HIBP corpus at a few gigabytes while making false positives practically impossible.
+const (
+	breachIndexMagic = "PWBRIDX1"
+	breachHeaderSize = 16
+	breachEntrySize  = 8
+	sha1HexLength    = 40
+	hibpPrefixLength = 5
+)
+
+// BreachIndex looks passwords up in an on-disk index of breached password hashes.
+type BreachIndex struct {
+	file  *os.File
+	count int64
+}
+
+// OpenBreachIndex opens an index created by BreachIndexBuilder.
+func OpenBreachIndex(path string) (*BreachIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password index: %w", err)
+	}
+
+	header := make([]byte, breachHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read breached password index header: %w", err)
+	}
+
+	if string(header[:8]) != breachIndexMagic {
+		f.Close()
+		return nil, errors.New("file is not a breached password index")
+	}
+
+	count := int64(binary.BigEndian.Uint64(header[8:]))
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() != breachHeaderSize+count*breachEntrySize {
+		f.Close()
+		return nil, errors.New("breached password index is truncated")
+	}
+
+	return &BreachIndex{file: f, count: count}, nil
+}
+
+// Len returns the number of hashes in the index.
+func (b *BreachIndex) Len() int64 {
+	return b.count
+}
+
+// Close releases the index file.
+func (b *BreachIndex) Close() error {
+	return b.file.Close()
+}
+
+// Contains reports whether the password appears in the index.
+func (b *BreachIndex) Contains(plainText string) (bool, error) {
+	sum := sha1.Sum([]byte(plainText))
+	key := binary.BigEndian.Uint64(sum[:breachEntrySize])
+
+	buf := make([]byte, breachEntrySize)
+	lo, hi := int64(0), b.count
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		_, err := b.file.ReadAt(buf, breachHeaderSize+mid*breachEntrySize)
+		if err != nil {
+			return false, err
+		}
+
+		entry := binary.BigEndian.Uint64(buf)
+		switch {
+		case entry == key:
+			return true, nil
+		case entry < key:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+
+	return false, nil
+}
+
+// BreachIndexBuilder writes a breached password index from HIBP "Pwned Passwords" files.
+// Input has to be ordered by hash, which is how HIBP publishes both the single file and
+// the per-prefix range files.
+type BreachIndexBuilder struct {
+	// MinCount skips hashes seen fewer times than this in breaches.
+	MinCount int
+
+	w     io.WriteSeeker
+	bw    *bufio.Writer
+	count uint64
+	last  uint64
+}
+
+// NewBreachIndexBuilder starts a new index in w.
+func NewBreachIndexBuilder(w io.WriteSeeker) (*BreachIndexBuilder, error) {
+	b := &BreachIndexBuilder{w: w, bw: bufio.NewWriter(w)}
+
+	header := make([]byte, breachHeaderSize)
+	copy(header, breachIndexMagic)
+	if _, err := b.bw.Write(header); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Add reads "HASH:COUNT" lines from r. For HIBP range files, where each line only holds
+// the hash suffix, prefix is the five character prefix the file was downloaded for.
+func (b *BreachIndexBuilder) Add(r io.Reader, prefix string) error {
+	if prefix != "" && len(prefix) != hibpPrefixLength {
+		return fmt.Errorf("invalid hash prefix %q", prefix)
+	}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		hash, countText, _ := strings.Cut(text, ":")
+		hash = prefix + hash
+
+		if b.MinCount > 0 && countText != "" {
+			count, err := strconv.Atoi(countText)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid count %q", line, countText)
+			}
+			if count < b.MinCount {
+				continue
+			}
+		}
+
+		if len(hash) != sha1HexLength {
+			return fmt.Errorf("line %d: expected a SHA-1 hash, got %q", line, hash)
+		}
+
+		raw, err := hex.DecodeString(hash[:2*breachEntrySize])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		key := binary.BigEndian.Uint64(raw)
+		if b.count > 0 {
+			if key < b.last {
+				return fmt.Errorf("line %d: input is not ordered by hash", line)
+			}
+			if key == b.last {
+				continue
+			}
+		}
+
+		if err := binary.Write(b.bw, binary.BigEndian, key); err != nil {
+			return err
+		}
+		b.last = key
+		b.count++
+	}
+
+	return scanner.Err()
+}
+
+// Len returns the number of hashes written so far.
+func (b *BreachIndexBuilder) Len() uint64 {
+	return b.count
+}
+
+// Close flushes the entries and records their count in the header.
+func (b *BreachIndexBuilder) Close() error {
+	if err := b.bw.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := b.w.Seek(int64(len(breachIndexMagic)), io.SeekStart); err != nil {
+		return err
+	}
+
+	return binary.Write(b.w, binary.BigEndian, b.count)
+}
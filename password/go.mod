@@ -0,0 +1,7 @@
+module password
+
+go 1.23.1
+
+require golang.org/x/crypto v0.28.0
+
+require golang.org/x/sys v0.26.0 // indirect
@@ -0,0 +1,348 @@
+package password
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms new hashes can be created with.
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// ErrUnknownHash is returned for stored hashes in a format Hasher does not recognise, such
+// as the placeholder of accounts without a local password.
+var ErrUnknownHash = errors.New("unrecognised password hash format")
+
+// Argon2Params are the cost parameters of an Argon2id hash, written to its PHC string as
+// m=<memory>,t=<time>,p=<threads>.
+type Argon2Params struct {
+	Memory     uint32 // in KiB
+	Time       uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// String returns the parameters the way PASSWORD_ARGON2_PARAMS takes them.
+func (p Argon2Params) String() string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Time, p.Threads)
+}
+
+// ParseArgon2Params parses "m=<memory>,t=<time>,p=<threads>" on top of the default
+// parameters.
+func ParseArgon2Params(s string) (Argon2Params, error) {
+	p := DefaultHasher().Argon2
+
+	var memory, passes uint32
+	var threads uint8
+	_, err := fmt.Sscanf(s, "m=%d,t=%d,p=%d", &memory, &passes, &threads)
+	if err != nil || threads < 1 || passes < 1 || memory < 8*uint32(threads) {
+		return p, fmt.Errorf("invalid argon2 parameters %q", s)
+	}
+
+	p.Memory, p.Time, p.Threads = memory, passes, threads
+	return p, nil
+}
+
+// Hasher creates password hashes with the configured algorithm and verifies hashes of
+// every supported one. Argon2id hashes are PHC strings, bcrypt ones keep bcrypt's own
+// $2a$ format, so hashes written before Argon2id was introduced stay valid.
+type Hasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+
+	// Pool bounds concurrent hashing, nil runs every hash right away
+	Pool *Pool
+}
+
+// DefaultHasher returns the hasher used when nothing is configured: Argon2id with the
+// OWASP recommended minimum of 19 MiB, two passes and one thread.
+func DefaultHasher() *Hasher {
+	return &Hasher{
+		Algorithm: AlgorithmArgon2id,
+		Argon2: Argon2Params{
+			Memory:     19 * 1024,
+			Time:       2,
+			Threads:    1,
+			SaltLength: 16,
+			KeyLength:  32,
+		},
+		BcryptCost: 12,
+	}
+}
+
+// HasherFromEnv builds a hasher from the default one, overridden by environment variables:
+//
+//	PASSWORD_HASH_ALGORITHM    argon2id or bcrypt
+//	PASSWORD_ARGON2_PARAMS     m=<memory in KiB>,t=<passes>,p=<threads>, as printed by user-service/cmd/pwcalibrate
+//	PASSWORD_BCRYPT_COST       bcrypt cost factor
+//	PASSWORD_HASH_WORKERS      hashes run at once, by default half the CPUs (divided by argon2 threads)
+//	PASSWORD_HASH_QUEUE        callers waiting for a worker before ErrBusy, by default 8 per worker
+func HasherFromEnv() (*Hasher, error) {
+	h := DefaultHasher()
+
+	if v := os.Getenv("PASSWORD_HASH_ALGORITHM"); v != "" {
+		if v != AlgorithmArgon2id && v != AlgorithmBcrypt {
+			return nil, fmt.Errorf("invalid PASSWORD_HASH_ALGORITHM: %q", v)
+		}
+		h.Algorithm = v
+	}
+
+	if v := os.Getenv("PASSWORD_ARGON2_PARAMS"); v != "" {
+		params, err := ParseArgon2Params(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PASSWORD_ARGON2_PARAMS: %w", err)
+		}
+		h.Argon2 = params
+	}
+
+	if v := os.Getenv("PASSWORD_BCRYPT_COST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+			return nil, fmt.Errorf("invalid PASSWORD_BCRYPT_COST: %q", v)
+		}
+		h.BcryptCost = n
+	}
+
+	workers := runtime.GOMAXPROCS(0) / 2
+	if h.Algorithm == AlgorithmArgon2id {
+		workers /= int(h.Argon2.Threads)
+	}
+	workers = max(workers, 1)
+	if v := os.Getenv("PASSWORD_HASH_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid PASSWORD_HASH_WORKERS: %q", v)
+		}
+		workers = n
+	}
+
+	queue := 8 * workers
+	if v := os.Getenv("PASSWORD_HASH_QUEUE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid PASSWORD_HASH_QUEUE: %q", v)
+		}
+		queue = n
+	}
+
+	h.Pool = NewPool(workers, queue)
+	return h, nil
+}
+
+// Hash returns the encoded hash of a password with the current algorithm and parameters.
+// It fails with ErrBusy or the context's error if the pool cannot take the hash.
+func (h *Hasher) Hash(ctx context.Context, plainText string) (string, error) {
+	if h.Algorithm == AlgorithmBcrypt {
+		var hash []byte
+		var err error
+		poolErr := h.run(ctx, func() {
+			hash, err = bcrypt.GenerateFromPassword([]byte(plainText), h.BcryptCost)
+		})
+		if poolErr != nil {
+			return "", poolErr
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, h.Argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	var key []byte
+	err := h.run(ctx, func() {
+		key = argon2Key(plainText, salt, h.Argon2)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return encodeArgon2(h.Argon2, salt, key), nil
+}
+
+// Verify reports whether plainText is the password of an encoded hash, whichever supported
+// algorithm created it. It fails with ErrBusy or the context's error if the pool cannot
+// take the hash.
+func (h *Hasher) Verify(ctx context.Context, encoded, plainText string) (bool, error) {
+	if isBcrypt(encoded) {
+		var err error
+		poolErr := h.run(ctx, func() {
+			err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plainText))
+		})
+		if poolErr != nil {
+			return false, poolErr
+		}
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	params, salt, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	var candidate []byte
+	err = h.run(ctx, func() {
+		candidate = argon2Key(plainText, salt, params)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare(key, candidate) == 1, nil
+}
+
+func (h *Hasher) run(ctx context.Context, f func()) error {
+	if h.Pool == nil {
+		f()
+		return nil
+	}
+	return h.Pool.Do(ctx, f)
+}
+
+// NeedsRehash reports whether an encoded hash was created with another algorithm or other
+// parameters than the current ones, and should be replaced after the next successful
+// verification.
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	if isBcrypt(encoded) {
+		if h.Algorithm != AlgorithmBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != h.BcryptCost
+	}
+
+	params, _, _, err := decodeArgon2(encoded)
+	if err != nil || h.Algorithm != AlgorithmArgon2id {
+		return true
+	}
+
+	return params != h.Argon2
+}
+
+// CalibrateArgon2 picks the number of passes that makes hashing with the given memory and
+// threads take at least target on this machine, halving memory when a single pass is
+// already too slow. It returns the parameters and how long one hash took with them.
+func CalibrateArgon2(target time.Duration, memory uint32, threads uint8) (Argon2Params, time.Duration) {
+	params := DefaultHasher().Argon2
+	params.Memory, params.Time, params.Threads = memory, 1, threads
+
+	elapsed := timeArgon2(params)
+	for elapsed > target && params.Memory/2 >= 8*uint32(threads) {
+		params.Memory /= 2
+		elapsed = timeArgon2(params)
+	}
+
+	for elapsed < target {
+		params.Time++
+		elapsed = timeArgon2(params)
+	}
+
+	return params, elapsed
+}
+
+// CalibrateBcrypt picks the lowest bcrypt cost that takes at least target on this machine,
+// and returns it with how long one hash took.
+func CalibrateBcrypt(target time.Duration) (int, time.Duration) {
+	cost := bcrypt.MinCost
+	elapsed := timeBcrypt(cost)
+	for elapsed < target && cost < bcrypt.MaxCost {
+		cost++
+		elapsed = timeBcrypt(cost)
+	}
+
+	return cost, elapsed
+}
+
+// calibrationRounds is how many hashes a calibration measurement takes the fastest of.
+const calibrationRounds = 3
+
+func timeArgon2(params Argon2Params) time.Duration {
+	salt := make([]byte, params.SaltLength)
+	return fastest(func() { argon2Key("calibration", salt, params) })
+}
+
+func timeBcrypt(cost int) time.Duration {
+	return fastest(func() { bcrypt.GenerateFromPassword([]byte("calibration"), cost) })
+}
+
+func fastest(f func()) time.Duration {
+	var best time.Duration
+	for i := 0; i < calibrationRounds; i++ {
+		start := time.Now()
+		f()
+		if elapsed := time.Since(start); i == 0 || elapsed < best {
+			best = elapsed
+		}
+	}
+	return best
+}
+
+func argon2Key(plainText string, salt []byte, params Argon2Params) []byte {
+	return argon2.IDKey([]byte(plainText), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// encodeArgon2 writes $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>, with
+// salt and key in unpadded base64.
+func encodeArgon2(params Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$%s$v=%d$%s$%s$%s", AlgorithmArgon2id, argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	params, err := ParseArgon2Params(parts[3])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2 hash")
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
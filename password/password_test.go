@@ -0,0 +1,375 @@
+package password
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func codes(violations []Violation) []string {
+	var out []string
+	for _, v := range violations {
+		out = append(out, v.Code)
+	}
+	return out
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{
+		MinLength:     10,
+		MaxLength:     72,
+		RequireUpper:  true,
+		RequireDigit:  true,
+		BlockIdentity: true,
+	}
+	id := Identity{Email: "jane.doe@example.com", Username: "janed"}
+
+	tests := []struct {
+		name      string
+		candidate string
+		want      []string
+	}{
+		{"valid", "Correct-Horse-7", nil},
+		{"empty", "", []string{CodeTooShort, CodeMissingUpper, CodeMissingDigit}},
+		{"too long", "A1" + strings.Repeat("x", 71), []string{CodeTooLong}},
+		{"missing classes", "correct horse battery", []string{CodeMissingUpper, CodeMissingDigit}},
+		{"contains email", "Jane.Doe-2024!", []string{CodeContainsEmail}},
+		{"contains username", "Hello-JANED-99", []string{CodeContainsName}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := codes(policy.Check(tt.candidate, id))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Check(%q) = %v, want %v", tt.candidate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreachIndex(t *testing.T) {
+	breached := []string{"password", "123456", "qwerty", "letmein", "Correct-Horse-7"}
+
+	var lines []string
+	for _, p := range breached {
+		sum := sha1.Sum([]byte(p))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":42")
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "breached.idx")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder, err := NewBreachIndexBuilder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Add(strings.NewReader(strings.Join(lines, "\r\n")), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	index, err := OpenBreachIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	if index.Len() != int64(len(breached)) {
+		t.Fatalf("expected %d entries, got %d", len(breached), index.Len())
+	}
+
+	for _, p := range breached {
+		found, err := index.Contains(p)
+		if err != nil || !found {
+			t.Errorf("expected %q to be in the index (err: %v)", p, err)
+		}
+	}
+
+	found, err := index.Contains("a perfectly unique passphrase")
+	if err != nil || found {
+		t.Errorf("did not expect unique password to be in the index (err: %v)", err)
+	}
+
+	policy := &Policy{MinLength: 8, Breached: index}
+	if got := codes(policy.Check("Correct-Horse-7", Identity{})); len(got) != 1 || got[0] != CodeBreached {
+		t.Errorf("expected breached violation, got %v", got)
+	}
+}
+
+func TestBreachIndexBuilderRangeFile(t *testing.T) {
+	sum := sha1.Sum([]byte("password"))
+	full := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	path := filepath.Join(t.TempDir(), "breached.idx")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder, err := NewBreachIndexBuilder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Add(strings.NewReader(full[5:]+":3861493\n"), full[:5]); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Add(strings.NewReader("0000000000000000000000000000000000000000:1"), ""); err == nil {
+		t.Error("expected unordered input to be rejected")
+	}
+	builder.Close()
+	f.Close()
+
+	index, err := OpenBreachIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	if found, _ := index.Contains("password"); !found {
+		t.Error("expected password from range file to be in the index")
+	}
+}
+
+func testHasher(algorithm string) *Hasher {
+	h := DefaultHasher()
+	h.Algorithm = algorithm
+	h.Argon2.Memory, h.Argon2.Time = 64, 1
+	h.BcryptCost = 4
+	return h
+}
+
+func TestHasherRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+		h := testHasher(algorithm)
+
+		hash, err := h.Hash(context.Background(), "Correct-Horse-7")
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if algorithm == AlgorithmArgon2id && !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+			t.Errorf("argon2id hash = %q, want a PHC string", hash)
+		}
+
+		if ok, err := h.Verify(context.Background(), hash, "Correct-Horse-7"); err != nil || !ok {
+			t.Errorf("%s: Verify(correct) = %v, %v", algorithm, ok, err)
+		}
+		if ok, err := h.Verify(context.Background(), hash, "correct-horse-7"); err != nil || ok {
+			t.Errorf("%s: Verify(wrong) = %v, %v", algorithm, ok, err)
+		}
+		if h.NeedsRehash(hash) {
+			t.Errorf("%s: fresh hash needs a rehash", algorithm)
+		}
+	}
+}
+
+func TestHasherNeedsRehash(t *testing.T) {
+	argon := testHasher(AlgorithmArgon2id)
+	bcryptHasher := testHasher(AlgorithmBcrypt)
+
+	legacy, err := bcryptHasher.Hash(context.Background(), "Correct-Horse-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hashes from before the switch still verify, and are upgraded on the next login
+	if ok, err := argon.Verify(context.Background(), legacy, "Correct-Horse-7"); err != nil || !ok {
+		t.Errorf("Verify(bcrypt hash) = %v, %v", ok, err)
+	}
+	if !argon.NeedsRehash(legacy) {
+		t.Error("bcrypt hash does not need a rehash under argon2id")
+	}
+
+	current, err := argon.Hash(context.Background(), "Correct-Horse-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := testHasher(AlgorithmArgon2id)
+	stronger.Argon2.Time = 2
+	if !stronger.NeedsRehash(current) {
+		t.Error("hash with fewer passes does not need a rehash")
+	}
+	if ok, err := stronger.Verify(context.Background(), current, "Correct-Horse-7"); err != nil || !ok {
+		t.Errorf("Verify with other parameters = %v, %v", ok, err)
+	}
+
+	bcryptHasher.BcryptCost = 5
+	if !bcryptHasher.NeedsRehash(legacy) {
+		t.Error("bcrypt hash with a lower cost does not need a rehash")
+	}
+}
+
+func TestHasherRejectsUnknownHashes(t *testing.T) {
+	h := testHasher(AlgorithmArgon2id)
+
+	for _, encoded := range []string{
+		"!",
+		"",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$",
+	} {
+		if ok, err := h.Verify(context.Background(), encoded, "Correct-Horse-7"); err == nil || ok {
+			t.Errorf("Verify(%q) = %v, %v, want an error", encoded, ok, err)
+		}
+	}
+}
+
+func TestParseArgon2Params(t *testing.T) {
+	p, err := ParseArgon2Params("m=65536,t=3,p=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Memory != 65536 || p.Time != 3 || p.Threads != 4 || p.KeyLength != 32 || p.String() != "m=65536,t=3,p=4" {
+		t.Errorf("ParseArgon2Params = %+v", p)
+	}
+
+	for _, s := range []string{"", "m=65536", "m=16,t=1,p=4", "m=65536,t=0,p=1", "t=3,m=65536,p=4"} {
+		if _, err := ParseArgon2Params(s); err == nil {
+			t.Errorf("ParseArgon2Params(%q) succeeded", s)
+		}
+	}
+}
+
+func TestPoolShedsWhenQueueFull(t *testing.T) {
+	pool := NewPool(1, 1)
+	release := make(chan struct{})
+
+	started := make(chan struct{})
+	go pool.Do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	queued := make(chan error)
+	go func() {
+		queued <- pool.Do(context.Background(), func() {})
+	}()
+	for pool.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := pool.Do(context.Background(), func() { t.Error("shed call ran") }); !errors.Is(err, ErrBusy) {
+		t.Errorf("Do on a full queue = %v, want ErrBusy", err)
+	}
+
+	close(release)
+	if err := <-queued; err != nil {
+		t.Errorf("queued call = %v", err)
+	}
+
+	stats := pool.Stats()
+	if stats.Completed != 2 || stats.Shed != 1 || stats.Running != 0 || stats.Queued != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestPoolCanceledWhileQueued(t *testing.T) {
+	pool := NewPool(1, 4)
+	release := make(chan struct{})
+	defer close(release)
+
+	started := make(chan struct{})
+	go pool.Do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := pool.Do(ctx, func() { t.Error("canceled call ran") }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do = %v, want DeadlineExceeded", err)
+	}
+	if stats := pool.Stats(); stats.Canceled != 1 || stats.Queued != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+// TestPoolLoginStorm sends far more logins at once than the pool takes. The workers are
+// never exceeded, the excess is shed right away, and the slowest accepted login waits no
+// longer than the queue ahead of it.
+func TestPoolLoginStorm(t *testing.T) {
+	const (
+		workers = 2
+		queue   = 4
+		logins  = 64
+		work    = 20 * time.Millisecond
+	)
+	pool := NewPool(workers, queue)
+
+	var running atomic.Int64
+	var mu sync.Mutex
+	var peak int64
+	var accepted, shed []time.Duration
+
+	var wg sync.WaitGroup
+	for i := 0; i < logins; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := pool.Do(context.Background(), func() {
+				n := running.Add(1)
+				mu.Lock()
+				peak = max(peak, n)
+				mu.Unlock()
+
+				time.Sleep(work)
+				running.Add(-1)
+			})
+			elapsed := time.Since(start)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				accepted = append(accepted, elapsed)
+			} else if errors.Is(err, ErrBusy) {
+				shed = append(shed, elapsed)
+			} else {
+				t.Errorf("Do = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > workers {
+		t.Errorf("%d hashes ran at once, want at most %d", peak, workers)
+	}
+	if len(accepted) < workers || len(shed) == 0 {
+		t.Fatalf("%d accepted and %d shed, want both", len(accepted), len(shed))
+	}
+
+	sort.Slice(accepted, func(i, j int) bool { return accepted[i] < accepted[j] })
+	sort.Slice(shed, func(i, j int) bool { return shed[i] < shed[j] })
+	p99 := accepted[len(accepted)*99/100]
+	t.Logf("accepted %d, p50 %s, p99 %s; shed %d, slowest %s", len(accepted), accepted[len(accepted)/2], p99, len(shed), shed[len(shed)-1])
+
+	// A login waits for the queue ahead of it, one batch of workers at a time
+	if limit := (queue/workers+1)*work + 200*time.Millisecond; p99 > limit {
+		t.Errorf("p99 of accepted logins = %s, want under %s", p99, limit)
+	}
+	if slowest := shed[len(shed)-1]; slowest > 50*time.Millisecond {
+		t.Errorf("shedding took %s, want it immediate", slowest)
+	}
+}
@@ -0,0 +1,193 @@
+// Package password holds what user-service and admin-service share about passwords: the
+// policy new passwords are checked against, the breached password index, and hashing.
+package password
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation codes returned by Policy.Check.
+const (
+	CodeTooShort        = "too_short"
+	CodeTooLong         = "too_long"
+	CodeMissingUpper    = "missing_upper"
+	CodeMissingLower    = "missing_lower"
+	CodeMissingDigit    = "missing_digit"
+	CodeMissingSymbol   = "missing_symbol"
+	CodeContainsEmail   = "contains_email"
+	CodeContainsName    = "contains_username"
+	CodeBreached        = "breached"
+	CodeBreachCheckFail = "breach_check_failed"
+)
+
+// Violation describes a single rule a candidate password does not satisfy.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Identity holds the account details a password must not contain.
+type Identity struct {
+	Email    string
+	Username string
+}
+
+// Policy is a set of rules passwords have to satisfy before they are hashed and stored.
+type Policy struct {
+	MinLength     int // in characters
+	MaxLength     int // in bytes, see DefaultPolicy
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	BlockIdentity bool
+	Breached      *BreachIndex
+}
+
+// DefaultPolicy returns the policy used when nothing is configured. Argon2id takes
+// passwords of any length, but MaxLength stays at 72 bytes: bcrypt can still be chosen with
+// PASSWORD_HASH_ALGORITHM and refuses longer passwords, so a limit that depended on the
+// algorithm would let passwords be set that a later switch to bcrypt could not rehash.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength:     10,
+		MaxLength:     72,
+		BlockIdentity: true,
+	}
+}
+
+// PolicyFromEnv builds a policy from the default one, overridden by environment variables:
+//
+//	PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH    length limits
+//	PASSWORD_REQUIRE_CLASSES                    comma separated list of upper, lower, digit, symbol
+//	PASSWORD_BLOCK_IDENTITY                     "false" allows the email or username in the password
+//	PASSWORD_BREACH_INDEX                       path to an index built with user-service/cmd/breachindex
+func PolicyFromEnv() (*Policy, error) {
+	p := DefaultPolicy()
+
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid PASSWORD_MIN_LENGTH: %q", v)
+		}
+		p.MinLength = n
+	}
+
+	if v := os.Getenv("PASSWORD_MAX_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < p.MinLength {
+			return nil, fmt.Errorf("invalid PASSWORD_MAX_LENGTH: %q", v)
+		}
+		p.MaxLength = n
+	}
+
+	if v := os.Getenv("PASSWORD_REQUIRE_CLASSES"); v != "" {
+		for _, class := range strings.Split(v, ",") {
+			switch strings.TrimSpace(class) {
+			case "upper":
+				p.RequireUpper = true
+			case "lower":
+				p.RequireLower = true
+			case "digit":
+				p.RequireDigit = true
+			case "symbol":
+				p.RequireSymbol = true
+			default:
+				return nil, fmt.Errorf("unknown character class in PASSWORD_REQUIRE_CLASSES: %q", class)
+			}
+		}
+	}
+
+	if v := os.Getenv("PASSWORD_BLOCK_IDENTITY"); v != "" {
+		block, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PASSWORD_BLOCK_IDENTITY: %q", v)
+		}
+		p.BlockIdentity = block
+	}
+
+	if path := os.Getenv("PASSWORD_BREACH_INDEX"); path != "" {
+		index, err := OpenBreachIndex(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded breached password index with %d entries", index.Len())
+		p.Breached = index
+	}
+
+	return p, nil
+}
+
+// Check returns every rule the candidate password violates. An empty result means the
+// password is acceptable.
+func (p *Policy) Check(candidate string, id Identity) []Violation {
+	var violations []Violation
+
+	if utf8.RuneCountInString(candidate) < p.MinLength {
+		violations = append(violations, Violation{CodeTooShort, fmt.Sprintf("must be at least %d characters long", p.MinLength)})
+	}
+
+	if p.MaxLength > 0 && len(candidate) > p.MaxLength {
+		violations = append(violations, Violation{CodeTooLong, fmt.Sprintf("must be at most %d bytes long", p.MaxLength)})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range candidate {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, Violation{CodeMissingUpper, "must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, Violation{CodeMissingLower, "must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{CodeMissingDigit, "must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{CodeMissingSymbol, "must contain a symbol"})
+	}
+
+	if p.BlockIdentity {
+		lower := strings.ToLower(candidate)
+
+		email := strings.ToLower(strings.TrimSpace(id.Email))
+		local, _, _ := strings.Cut(email, "@")
+		if (email != "" && strings.Contains(lower, email)) || (len(local) >= 3 && strings.Contains(lower, local)) {
+			violations = append(violations, Violation{CodeContainsEmail, "must not contain your email address"})
+		}
+
+		username := strings.ToLower(strings.TrimSpace(id.Username))
+		if len(username) >= 3 && strings.Contains(lower, username) {
+			violations = append(violations, Violation{CodeContainsName, "must not contain your username"})
+		}
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(candidate)
+		if err != nil {
+			log.Println("Error checking breached password index:", err)
+			violations = append(violations, Violation{CodeBreachCheckFail, "could not be checked against known breaches, try again later"})
+		} else if breached {
+			violations = append(violations, Violation{CodeBreached, "has appeared in a data breach and cannot be used"})
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,123 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBusy is returned instead of queueing a hash when the queue is full. Callers answer it
+// with RESOURCE_EXHAUSTED or 503, so clients back off instead of piling up.
+var ErrBusy = errors.New("too many password checks in progress, try again later")
+
+// Pool bounds how many hashes run at once. Hashing is CPU bound and deliberately slow, so
+// without a bound a burst of logins takes every core and stalls unrelated requests. Up to
+// a fixed number of callers wait for a free worker, the rest are turned away with ErrBusy.
+type Pool struct {
+	workers chan struct{}
+	queue   int64
+
+	running   atomic.Int64
+	queued    atomic.Int64
+	completed atomic.Int64
+	shed      atomic.Int64
+	canceled  atomic.Int64
+	waitNanos atomic.Int64
+}
+
+// PoolStats is a snapshot of a pool's state and counters since it was created.
+type PoolStats struct {
+	Workers   int   `json:"workers"`
+	QueueSize int   `json:"queue_size"`
+	Running   int64 `json:"running"`
+	Queued    int64 `json:"queued"`
+	Completed int64 `json:"completed"`
+	Shed      int64 `json:"shed"`
+	Canceled  int64 `json:"canceled"`
+
+	// QueueWaitSeconds is the time all completed hashes spent waiting for a worker
+	QueueWaitSeconds float64 `json:"queue_wait_seconds"`
+}
+
+// NewPool returns a pool that runs up to workers hashes at once, with up to queue more
+// callers waiting for a worker.
+func NewPool(workers, queue int) *Pool {
+	return &Pool{
+		workers: make(chan struct{}, workers),
+		queue:   int64(queue),
+	}
+}
+
+// Do runs f in the calling goroutine once a worker is free. It returns ErrBusy without
+// running f when the queue is full, and the context's error when it ends while waiting.
+// Once f has started it runs to completion.
+func (p *Pool) Do(ctx context.Context, f func()) error {
+	if err := ctx.Err(); err != nil {
+		p.canceled.Add(1)
+		return err
+	}
+
+	select {
+	case p.workers <- struct{}{}:
+	default:
+		if p.queued.Add(1) > p.queue {
+			p.queued.Add(-1)
+			p.shed.Add(1)
+			return ErrBusy
+		}
+
+		start := time.Now()
+		select {
+		case p.workers <- struct{}{}:
+			p.queued.Add(-1)
+			p.waitNanos.Add(int64(time.Since(start)))
+		case <-ctx.Done():
+			p.queued.Add(-1)
+			p.canceled.Add(1)
+			return ctx.Err()
+		}
+	}
+
+	p.running.Add(1)
+	defer func() {
+		p.running.Add(-1)
+		p.completed.Add(1)
+		<-p.workers
+	}()
+
+	f()
+	return nil
+}
+
+// Stats returns the pool's current state.
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Workers:          cap(p.workers),
+		QueueSize:        int(p.queue),
+		Running:          p.running.Load(),
+		Queued:           p.queued.Load(),
+		Completed:        p.completed.Load(),
+		Shed:             p.shed.Load(),
+		Canceled:         p.canceled.Load(),
+		QueueWaitSeconds: time.Duration(p.waitNanos.Load()).Seconds(),
+	}
+}
+
+// ServeMetrics publishes the pool's stats with expvar as password_hashing and serves them,
+// with the runtime's memory stats, as JSON at /debug/vars on addr. The listener is separate
+// from the API, so it can be kept to the internal network. It returns when serving fails.
+func (p *Pool) ServeMetrics(addr string) error {
+	expvar.Publish("password_hashing", expvar.Func(func() any {
+		return p.Stats()
+	}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	log.Printf("Serving metrics on %s", addr)
+	return http.ListenAndServe(addr, mux)
+}
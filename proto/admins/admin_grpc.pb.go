@@ -19,7 +19,8 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	AdminService_ValidateAdmin_FullMethodName = "/admins.AdminService/ValidateAdmin"
+	AdminService_ValidateAdmin_FullMethodName   = "/admins.AdminService/ValidateAdmin"
+	AdminService_GetAdminTenants_FullMethodName = "/admins.AdminService/GetAdminTenants"
 )
 
 // AdminServiceClient is the client API for AdminService service.
@@ -27,6 +28,7 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type AdminServiceClient interface {
 	ValidateAdmin(ctx context.Context, in *ValidateAdminRequest, opts ...grpc.CallOption) (*ValidateAdminResponse, error)
+	GetAdminTenants(ctx context.Context, in *GetAdminTenantsRequest, opts ...grpc.CallOption) (*GetAdminTenantsResponse, error)
 }
 
 type adminServiceClient struct {
@@ -47,11 +49,22 @@ func (c *adminServiceClient) ValidateAdmin(ctx context.Context, in *ValidateAdmi
 	return out, nil
 }
 
+func (c *adminServiceClient) GetAdminTenants(ctx context.Context, in *GetAdminTenantsRequest, opts ...grpc.CallOption) (*GetAdminTenantsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetAdminTenantsResponse)
+	err := c.cc.Invoke(ctx, AdminService_GetAdminTenants_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // AdminServiceServer is the server API for AdminService service.
 // All implementations must embed UnimplementedAdminServiceServer
 // for forward compatibility.
 type AdminServiceServer interface {
 	ValidateAdmin(context.Context, *ValidateAdminRequest) (*ValidateAdminResponse, error)
+	GetAdminTenants(context.Context, *GetAdminTenantsRequest) (*GetAdminTenantsResponse, error)
 	mustEmbedUnimplementedAdminServiceServer()
 }
 
@@ -65,6 +78,9 @@ type UnimplementedAdminServiceServer struct{}
 func (UnimplementedAdminServiceServer) ValidateAdmin(context.Context, *ValidateAdminRequest) (*ValidateAdminResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ValidateAdmin not implemented")
 }
+func (UnimplementedAdminServiceServer) GetAdminTenants(context.Context, *GetAdminTenantsRequest) (*GetAdminTenantsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAdminTenants not implemented")
+}
 func (UnimplementedAdminServiceServer) mustEmbedUnimplementedAdminServiceServer() {}
 func (UnimplementedAdminServiceServer) testEmbeddedByValue()                      {}
 
@@ -104,6 +120,24 @@ func _AdminService_ValidateAdmin_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AdminService_GetAdminTenants_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAdminTenantsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).GetAdminTenants(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_GetAdminTenants_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).GetAdminTenants(ctx, req.(*GetAdminTenantsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // AdminService_ServiceDesc is the grpc.ServiceDesc for AdminService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,6 +149,10 @@ var AdminService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ValidateAdmin",
 			Handler:    _AdminService_ValidateAdmin_Handler,
 		},
+		{
+			MethodName: "GetAdminTenants",
+			Handler:    _AdminService_GetAdminTenants_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "admin.proto",
@@ -0,0 +1,256 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.35.1
+// 	protoc        v5.28.2
+// source: saml.proto
+
+package admins
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetSAMLProviderRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Tenant string `protobuf:"bytes,1,opt,name=tenant,proto3" json:"tenant,omitempty"`
+}
+
+func (x *GetSAMLProviderRequest) Reset() {
+	*x = GetSAMLProviderRequest{}
+	mi := &file_saml_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSAMLProviderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSAMLProviderRequest) ProtoMessage() {}
+
+func (x *GetSAMLProviderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_saml_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSAMLProviderRequest.ProtoReflect.Descriptor instead.
+func (*GetSAMLProviderRequest) Descriptor() ([]byte, []int) {
+	return file_saml_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetSAMLProviderRequest) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+// SAMLProvider is a tenant's identity provider. Disabled providers are reported as not found.
+type SAMLProvider struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Tenant             string `protobuf:"bytes,1,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	EntityId           string `protobuf:"bytes,2,opt,name=entity_id,json=entityId,proto3" json:"entity_id,omitempty"`
+	SsoUrl             string `protobuf:"bytes,3,opt,name=sso_url,json=ssoUrl,proto3" json:"sso_url,omitempty"`
+	Certificate        string `protobuf:"bytes,4,opt,name=certificate,proto3" json:"certificate,omitempty"` // PEM
+	EmailAttribute     string `protobuf:"bytes,5,opt,name=email_attribute,json=emailAttribute,proto3" json:"email_attribute,omitempty"`
+	FirstNameAttribute string `protobuf:"bytes,6,opt,name=first_name_attribute,json=firstNameAttribute,proto3" json:"first_name_attribute,omitempty"`
+	LastNameAttribute  string `protobuf:"bytes,7,opt,name=last_name_attribute,json=lastNameAttribute,proto3" json:"last_name_attribute,omitempty"`
+	TrustEmail         bool   `protobuf:"varint,8,opt,name=trust_email,json=trustEmail,proto3" json:"trust_email,omitempty"`
+}
+
+func (x *SAMLProvider) Reset() {
+	*x = SAMLProvider{}
+	mi := &file_saml_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SAMLProvider) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SAMLProvider) ProtoMessage() {}
+
+func (x *SAMLProvider) ProtoReflect() protoreflect.Message {
+	mi := &file_saml_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SAMLProvider.ProtoReflect.Descriptor instead.
+func (*SAMLProvider) Descriptor() ([]byte, []int) {
+	return file_saml_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SAMLProvider) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *SAMLProvider) GetEntityId() string {
+	if x != nil {
+		return x.EntityId
+	}
+	return ""
+}
+
+func (x *SAMLProvider) GetSsoUrl() string {
+	if x != nil {
+		return x.SsoUrl
+	}
+	return ""
+}
+
+func (x *SAMLProvider) GetCertificate() string {
+	if x != nil {
+		return x.Certificate
+	}
+	return ""
+}
+
+func (x *SAMLProvider) GetEmailAttribute() string {
+	if x != nil {
+		return x.EmailAttribute
+	}
+	return ""
+}
+
+func (x *SAMLProvider) GetFirstNameAttribute() string {
+	if x != nil {
+		return x.FirstNameAttribute
+	}
+	return ""
+}
+
+func (x *SAMLProvider) GetLastNameAttribute() string {
+	if x != nil {
+		return x.LastNameAttribute
+	}
+	return ""
+}
+
+func (x *SAMLProvider) GetTrustEmail() bool {
+	if x != nil {
+		return x.TrustEmail
+	}
+	return false
+}
+
+var File_saml_proto protoreflect.FileDescriptor
+
+var file_saml_proto_rawDesc = []byte{
+	0x0a, 0x0a, 0x73, 0x61, 0x6d, 0x6c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x61, 0x64,
+	0x6d, 0x69, 0x6e, 0x73, 0x22, 0x30, 0x0a, 0x16, 0x47, 0x65, 0x74, 0x53, 0x41, 0x4d, 0x4c, 0x50,
+	0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16,
+	0x0a, 0x06, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x22, 0xaa, 0x02, 0x0a, 0x0c, 0x53, 0x41, 0x4d, 0x4c, 0x50,
+	0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x65, 0x6e, 0x61, 0x6e,
+	0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x12,
+	0x1b, 0x0a, 0x09, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x08, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0x49, 0x64, 0x12, 0x17, 0x0a, 0x07,
+	0x73, 0x73, 0x6f, 0x5f, 0x75, 0x72, 0x6c, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73,
+	0x73, 0x6f, 0x55, 0x72, 0x6c, 0x12, 0x20, 0x0a, 0x0b, 0x63, 0x65, 0x72, 0x74, 0x69, 0x66, 0x69,
+	0x63, 0x61, 0x74, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x65, 0x72, 0x74,
+	0x69, 0x66, 0x69, 0x63, 0x61, 0x74, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x65, 0x6d, 0x61, 0x69, 0x6c,
+	0x5f, 0x61, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75, 0x74, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0e, 0x65, 0x6d, 0x61, 0x69, 0x6c, 0x41, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75, 0x74, 0x65,
+	0x12, 0x30, 0x0a, 0x14, 0x66, 0x69, 0x72, 0x73, 0x74, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x5f, 0x61,
+	0x74, 0x74, 0x72, 0x69, 0x62, 0x75, 0x74, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x12,
+	0x66, 0x69, 0x72, 0x73, 0x74, 0x4e, 0x61, 0x6d, 0x65, 0x41, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75,
+	0x74, 0x65, 0x12, 0x2e, 0x0a, 0x13, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x5f,
+	0x61, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75, 0x74, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x11, 0x6c, 0x61, 0x73, 0x74, 0x4e, 0x61, 0x6d, 0x65, 0x41, 0x74, 0x74, 0x72, 0x69, 0x62, 0x75,
+	0x74, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x74, 0x72, 0x75, 0x73, 0x74, 0x5f, 0x65, 0x6d, 0x61, 0x69,
+	0x6c, 0x18, 0x08, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x74, 0x72, 0x75, 0x73, 0x74, 0x45, 0x6d,
+	0x61, 0x69, 0x6c, 0x32, 0x5e, 0x0a, 0x13, 0x53, 0x41, 0x4d, 0x4c, 0x50, 0x72, 0x6f, 0x76, 0x69,
+	0x64, 0x65, 0x72, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x47, 0x0a, 0x0f, 0x47, 0x65,
+	0x74, 0x53, 0x41, 0x4d, 0x4c, 0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x12, 0x1e, 0x2e,
+	0x61, 0x64, 0x6d, 0x69, 0x6e, 0x73, 0x2e, 0x47, 0x65, 0x74, 0x53, 0x41, 0x4d, 0x4c, 0x50, 0x72,
+	0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e,
+	0x61, 0x64, 0x6d, 0x69, 0x6e, 0x73, 0x2e, 0x53, 0x41, 0x4d, 0x4c, 0x50, 0x72, 0x6f, 0x76, 0x69,
+	0x64, 0x65, 0x72, 0x42, 0x09, 0x5a, 0x07, 0x2f, 0x61, 0x64, 0x6d, 0x69, 0x6e, 0x73, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_saml_proto_rawDescOnce sync.Once
+	file_saml_proto_rawDescData = file_saml_proto_rawDesc
+)
+
+func file_saml_proto_rawDescGZIP() []byte {
+	file_saml_proto_rawDescOnce.Do(func() {
+		file_saml_proto_rawDescData = protoimpl.X.CompressGZIP(file_saml_proto_rawDescData)
+	})
+	return file_saml_proto_rawDescData
+}
+
+var file_saml_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_saml_proto_goTypes = []any{
+	(*GetSAMLProviderRequest)(nil), // 0: admins.GetSAMLProviderRequest
+	(*SAMLProvider)(nil),           // 1: admins.SAMLProvider
+}
+var file_saml_proto_depIdxs = []int32{
+	0, // 0: admins.SAMLProviderService.GetSAMLProvider:input_type -> admins.GetSAMLProviderRequest
+	1, // 1: admins.SAMLProviderService.GetSAMLProvider:output_type -> admins.SAMLProvider
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_saml_proto_init() }
+func file_saml_proto_init() {
+	if File_saml_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_saml_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_saml_proto_goTypes,
+		DependencyIndexes: file_saml_proto_depIdxs,
+		MessageInfos:      file_saml_proto_msgTypes,
+	}.Build()
+	File_saml_proto = out.File
+	file_saml_proto_rawDesc = nil
+	file_saml_proto_goTypes = nil
+	file_saml_proto_depIdxs = nil
+}
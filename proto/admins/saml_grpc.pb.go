@@ -0,0 +1,121 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.2
+// source: saml.proto
+
+package admins
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SAMLProviderService_GetSAMLProvider_FullMethodName = "/admins.SAMLProviderService/GetSAMLProvider"
+)
+
+// SAMLProviderServiceClient is the client API for SAMLProviderService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SAMLProviderServiceClient interface {
+	GetSAMLProvider(ctx context.Context, in *GetSAMLProviderRequest, opts ...grpc.CallOption) (*SAMLProvider, error)
+}
+
+type sAMLProviderServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSAMLProviderServiceClient(cc grpc.ClientConnInterface) SAMLProviderServiceClient {
+	return &sAMLProviderServiceClient{cc}
+}
+
+func (c *sAMLProviderServiceClient) GetSAMLProvider(ctx context.Context, in *GetSAMLProviderRequest, opts ...grpc.CallOption) (*SAMLProvider, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SAMLProvider)
+	err := c.cc.Invoke(ctx, SAMLProviderService_GetSAMLProvider_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SAMLProviderServiceServer is the server API for SAMLProviderService service.
+// All implementations must embed UnimplementedSAMLProviderServiceServer
+// for forward compatibility.
+type SAMLProviderServiceServer interface {
+	GetSAMLProvider(context.Context, *GetSAMLProviderRequest) (*SAMLProvider, error)
+	mustEmbedUnimplementedSAMLProviderServiceServer()
+}
+
+// UnimplementedSAMLProviderServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSAMLProviderServiceServer struct{}
+
+func (UnimplementedSAMLProviderServiceServer) GetSAMLProvider(context.Context, *GetSAMLProviderRequest) (*SAMLProvider, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSAMLProvider not implemented")
+}
+func (UnimplementedSAMLProviderServiceServer) mustEmbedUnimplementedSAMLProviderServiceServer() {}
+func (UnimplementedSAMLProviderServiceServer) testEmbeddedByValue()                             {}
+
+// UnsafeSAMLProviderServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SAMLProviderServiceServer will
+// result in compilation errors.
+type UnsafeSAMLProviderServiceServer interface {
+	mustEmbedUnimplementedSAMLProviderServiceServer()
+}
+
+func RegisterSAMLProviderServiceServer(s grpc.ServiceRegistrar, srv SAMLProviderServiceServer) {
+	// If the following call pancis, it indicates UnimplementedSAMLProviderServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SAMLProviderService_ServiceDesc, srv)
+}
+
+func _SAMLProviderService_GetSAMLProvider_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSAMLProviderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SAMLProviderServiceServer).GetSAMLProvider(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SAMLProviderService_GetSAMLProvider_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SAMLProviderServiceServer).GetSAMLProvider(ctx, req.(*GetSAMLProviderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// SAMLProviderService_ServiceDesc is the grpc.ServiceDesc for SAMLProviderService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SAMLProviderService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "admins.SAMLProviderService",
+	HandlerType: (*SAMLProviderServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetSAMLProvider",
+			Handler:    _SAMLProviderService_GetSAMLProvider_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "saml.proto",
+}
@@ -0,0 +1,165 @@
+package proto
+
+import (
+	"fmt"
+
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// MarshalSet encodes a FileDescriptorSet the way releases are recorded.
+func MarshalSet(set *descriptorpb.FileDescriptorSet) ([]byte, error) {
+	return gproto.MarshalOptions{Deterministic: true}.Marshal(set)
+}
+
+// UnmarshalSet decodes a recorded FileDescriptorSet.
+func UnmarshalSet(b []byte) (*descriptorpb.FileDescriptorSet, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	err := gproto.Unmarshal(b, set)
+	return set, err
+}
+
+// BreakingChanges lists what clients or servers built against old would trip over in
+// current: removed messages, fields, enum values, services and methods, field numbers
+// that changed type or cardinality, renamed fields, and methods whose request, response
+// or streaming changed. Fields and enum values may be removed if their numbers are
+// reserved.
+func BreakingChanges(old, current *descriptorpb.FileDescriptorSet) ([]string, error) {
+	oldFiles, err := protodesc.NewFiles(old)
+	if err != nil {
+		return nil, fmt.Errorf("old definitions: %w", err)
+	}
+	currentFiles, err := protodesc.NewFiles(current)
+	if err != nil {
+		return nil, fmt.Errorf("current definitions: %w", err)
+	}
+
+	c := &checker{current: currentFiles}
+	oldFiles.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+		c.messages(f.Messages())
+		c.enums(f.Enums())
+		c.services(f.Services())
+		return true
+	})
+
+	return c.problems, nil
+}
+
+type checker struct {
+	current  *protoregistry.Files
+	problems []string
+}
+
+func (c *checker) report(format string, args ...any) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+func (c *checker) messages(messages protoreflect.MessageDescriptors) {
+	for i := 0; i < messages.Len(); i++ {
+		old := messages.Get(i)
+
+		d, err := c.current.FindDescriptorByName(old.FullName())
+		current, ok := d.(protoreflect.MessageDescriptor)
+		if err != nil || !ok {
+			c.report("message %s was removed", old.FullName())
+			continue
+		}
+
+		c.fields(old, current)
+		c.messages(old.Messages())
+		c.enums(old.Enums())
+	}
+}
+
+func (c *checker) fields(old, current protoreflect.MessageDescriptor) {
+	for i := 0; i < old.Fields().Len(); i++ {
+		of := old.Fields().Get(i)
+
+		cf := current.Fields().ByNumber(of.Number())
+		if cf == nil {
+			if !current.ReservedRanges().Has(of.Number()) {
+				c.report("field %s (%d) was removed without reserving its number", of.FullName(), of.Number())
+			}
+			continue
+		}
+
+		if cf.Name() != of.Name() {
+			c.report("field %s (%d) was renamed to %s", of.FullName(), of.Number(), cf.Name())
+		}
+		if cf.Kind() != of.Kind() {
+			c.report("field %s (%d) changed type from %s to %s", of.FullName(), of.Number(), of.Kind(), cf.Kind())
+		} else if typeName(cf) != typeName(of) {
+			c.report("field %s (%d) changed type from %s to %s", of.FullName(), of.Number(), typeName(of), typeName(cf))
+		}
+		if cf.Cardinality() != of.Cardinality() || cf.IsMap() != of.IsMap() {
+			c.report("field %s (%d) changed cardinality from %s to %s", of.FullName(), of.Number(), of.Cardinality(), cf.Cardinality())
+		}
+	}
+}
+
+// typeName returns the message or enum type of a field, if it has one.
+func typeName(f protoreflect.FieldDescriptor) protoreflect.FullName {
+	switch {
+	case f.Message() != nil:
+		return f.Message().FullName()
+	case f.Enum() != nil:
+		return f.Enum().FullName()
+	}
+	return ""
+}
+
+func (c *checker) enums(enums protoreflect.EnumDescriptors) {
+	for i := 0; i < enums.Len(); i++ {
+		old := enums.Get(i)
+
+		d, err := c.current.FindDescriptorByName(old.FullName())
+		current, ok := d.(protoreflect.EnumDescriptor)
+		if err != nil || !ok {
+			c.report("enum %s was removed", old.FullName())
+			continue
+		}
+
+		for j := 0; j < old.Values().Len(); j++ {
+			v := old.Values().Get(j)
+			if current.Values().ByNumber(v.Number()) == nil && !current.ReservedRanges().Has(v.Number()) {
+				c.report("enum value %s (%d) was removed without reserving its number", v.FullName(), v.Number())
+			}
+		}
+	}
+}
+
+func (c *checker) services(services protoreflect.ServiceDescriptors) {
+	for i := 0; i < services.Len(); i++ {
+		old := services.Get(i)
+
+		d, err := c.current.FindDescriptorByName(old.FullName())
+		current, ok := d.(protoreflect.ServiceDescriptor)
+		if err != nil || !ok {
+			c.report("service %s was removed", old.FullName())
+			continue
+		}
+
+		for j := 0; j < old.Methods().Len(); j++ {
+			om := old.Methods().Get(j)
+
+			cm := current.Methods().ByName(om.Name())
+			if cm == nil {
+				c.report("method %s was removed", om.FullName())
+				continue
+			}
+
+			if cm.Input().FullName() != om.Input().FullName() {
+				c.report("method %s changed its request from %s to %s", om.FullName(), om.Input().FullName(), cm.Input().FullName())
+			}
+			if cm.Output().FullName() != om.Output().FullName() {
+				c.report("method %s changed its response from %s to %s", om.FullName(), om.Output().FullName(), cm.Output().FullName())
+			}
+			if cm.IsStreamingClient() != om.IsStreamingClient() || cm.IsStreamingServer() != om.IsStreamingServer() {
+				c.report("method %s changed streaming", om.FullName())
+			}
+		}
+	}
+}
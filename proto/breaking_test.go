@@ -0,0 +1,181 @@
+package proto
+
+import (
+	"strings"
+	"testing"
+
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestNoBreakingChangesSinceLatestRelease(t *testing.T) {
+	latest, set, err := LatestRelease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest == "" {
+		t.Skip("no release recorded")
+	}
+
+	if CompareVersions(Version, latest) < 0 {
+		t.Fatalf("Version %s is older than the latest release %s", Version, latest)
+	}
+
+	problems, err := BreakingChanges(set, Current())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 && MajorVersion(Version) == MajorVersion(latest) {
+		t.Errorf("breaking changes since release %s:\n%s", latest, strings.Join(problems, "\n"))
+	}
+}
+
+// edit returns a copy of the current definitions changed by fn.
+func edit(fn func(files map[string]*descriptorpb.FileDescriptorProto)) *descriptorpb.FileDescriptorSet {
+	set := gproto.Clone(Current()).(*descriptorpb.FileDescriptorSet)
+
+	files := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, f := range set.File {
+		files[f.GetName()] = f
+	}
+	fn(files)
+
+	return set
+}
+
+func message(f *descriptorpb.FileDescriptorProto, name string) *descriptorpb.DescriptorProto {
+	for _, m := range f.MessageType {
+		if m.GetName() == name {
+			return m
+		}
+	}
+	panic("no message " + name)
+}
+
+func TestBreakingChanges(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(files map[string]*descriptorpb.FileDescriptorProto)
+		want []string
+	}{
+		{
+			name: "unchanged",
+			edit: func(files map[string]*descriptorpb.FileDescriptorProto) {},
+		},
+		{
+			name: "swapped field numbers",
+			edit: func(files map[string]*descriptorpb.FileDescriptorProto) {
+				m := message(files["users.proto"], "ValidateUserResponse")
+				m.Field[1].Number, m.Field[2].Number = m.Field[2].Number, m.Field[1].Number
+			},
+			want: []string{
+				"field users.ValidateUserResponse.message (2) was renamed to user_id",
+				"field users.ValidateUserResponse.message (2) changed type from string to int64",
+				"field users.ValidateUserResponse.user_id (3) was renamed to message",
+				"field users.ValidateUserResponse.user_id (3) changed type from int64 to string",
+			},
+		},
+		{
+			name: "removed field",
+			edit: func(files map[string]*descriptorpb.FileDescriptorProto) {
+				m := message(files["users.proto"], "GetUserTenantsRequest")
+				m.Field = nil
+			},
+			want: []string{"field users.GetUserTenantsRequest.user_id (1) was removed without reserving its number"},
+		},
+		{
+			name: "removed field with reserved number",
+			edit: func(files map[string]*descriptorpb.FileDescriptorProto) {
+				m := message(files["users.proto"], "GetUserTenantsRequest")
+				m.Field = nil
+				m.ReservedRange = []*descriptorpb.DescriptorProto_ReservedRange{{Start: gproto.Int32(1), End: gproto.Int32(2)}}
+			},
+		},
+		{
+			name: "added field",
+			edit: func(files map[string]*descriptorpb.FileDescriptorProto) {
+				m := message(files["users.proto"], "GetUserTenantsRequest")
+				m.Field = append(m.Field, &descriptorpb.FieldDescriptorProto{
+					Name:     gproto.String("email"),
+					JsonName: gproto.String("email"),
+					Number:   gproto.Int32(2),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				})
+			},
+		},
+		{
+			name: "repeated field",
+			edit: func(files map[string]*descriptorpb.FileDescriptorProto) {
+				m := message(files["users.proto"], "GetUserTenantsRequest")
+				m.Field[0].Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			},
+			want: []string{"field users.GetUserTenantsRequest.user_id (1) changed cardinality from optional to repeated"},
+		},
+		{
+			name: "renamed package",
+			edit: func(files map[string]*descriptorpb.FileDescriptorProto) {
+				f := files["admin.proto"]
+				f.Package = gproto.String("admin")
+				for _, m := range f.Service[0].Method {
+					m.InputType = gproto.String(strings.Replace(m.GetInputType(), ".admins.", ".admin.", 1))
+					m.OutputType = gproto.String(strings.Replace(m.GetOutputType(), ".admins.", ".admin.", 1))
+				}
+			},
+			want: []string{
+				"message admins.ValidateAdminRequest was removed",
+				"message admins.ValidateAdminResponse was removed",
+				"message admins.GetAdminTenantsRequest was removed",
+				"message admins.GetAdminTenantsResponse was removed",
+				"service admins.AdminService was removed",
+			},
+		},
+		{
+			name: "removed method",
+			edit: func(files map[string]*descriptorpb.FileDescriptorProto) {
+				s := files["sessions.proto"].Service[0]
+				s.Method = s.Method[1:]
+			},
+			want: []string{"method sessions.SessionService.RevokeUserSessions was removed"},
+		},
+		{
+			name: "streaming method",
+			edit: func(files map[string]*descriptorpb.FileDescriptorProto) {
+				s := files["sessions.proto"].Service[0]
+				s.Method[0].ServerStreaming = gproto.Bool(true)
+			},
+			want: []string{"method sessions.SessionService.RevokeUserSessions changed streaming"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := BreakingChanges(Current(), edit(tt.edit))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Join(problems, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got problems:\n%s\nwant:\n%s", strings.Join(problems, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "1.0.1", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "1.99.99", 1},
+	}
+
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
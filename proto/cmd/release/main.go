@@ -0,0 +1,50 @@
+// Command release records the current definitions as release proto.Version, after
+// checking that the version has been bumped to match the changes since the latest one.
+// Run it from the module root.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"proto"
+)
+
+func main() {
+	current := proto.Current()
+
+	latest, set, err := proto.LatestRelease()
+	if err != nil {
+		log.Fatalf("Failed to load the latest release: %v", err)
+	}
+
+	if latest != "" {
+		if proto.CompareVersions(proto.Version, latest) <= 0 {
+			log.Fatalf("Version %s is not newer than the latest release %s", proto.Version, latest)
+		}
+
+		problems, err := proto.BreakingChanges(set, current)
+		if err != nil {
+			log.Fatalf("Failed to compare with release %s: %v", latest, err)
+		}
+		if len(problems) > 0 && proto.MajorVersion(proto.Version) == proto.MajorVersion(latest) {
+			log.Fatalf("Breaking changes since release %s need a new major version:\n%s", latest, strings.Join(problems, "\n"))
+		}
+	}
+
+	b, err := proto.MarshalSet(current)
+	if err != nil {
+		log.Fatalf("Failed to encode the definitions: %v", err)
+	}
+
+	path := filepath.Join("releases", "v"+proto.Version+".binpb")
+	err = os.WriteFile(path, b, 0o644)
+	if err != nil {
+		log.Fatalf("Failed to record the release: %v", err)
+	}
+
+	fmt.Printf("Recorded release %s in %s\n", proto.Version, path)
+}
@@ -1,7 +1,7 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
 // versions:
-// - protoc-gen-go-grpc v1.2.0
-// - protoc             v3.20.0
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.2
 // source: logs.proto
 
 package logs
@@ -15,8 +15,12 @@ import (
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the grpc package it is being compiled against.
-// Requires gRPC-Go v1.32.0 or later.
-const _ = grpc.SupportPackageIsVersion7
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LogService_WriteLog_FullMethodName = "/logs.LogService/WriteLog"
+)
 
 // LogServiceClient is the client API for LogService service.
 //
@@ -34,8 +38,9 @@ func NewLogServiceClient(cc grpc.ClientConnInterface) LogServiceClient {
 }
 
 func (c *logServiceClient) WriteLog(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*LogResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(LogResponse)
-	err := c.cc.Invoke(ctx, "/logs.LogService/WriteLog", in, out, opts...)
+	err := c.cc.Invoke(ctx, LogService_WriteLog_FullMethodName, in, out, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -44,20 +49,24 @@ func (c *logServiceClient) WriteLog(ctx context.Context, in *LogRequest, opts ..
 
 // LogServiceServer is the server API for LogService service.
 // All implementations must embed UnimplementedLogServiceServer
-// for forward compatibility
+// for forward compatibility.
 type LogServiceServer interface {
 	WriteLog(context.Context, *LogRequest) (*LogResponse, error)
 	mustEmbedUnimplementedLogServiceServer()
 }
 
-// UnimplementedLogServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedLogServiceServer struct {
-}
+// UnimplementedLogServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLogServiceServer struct{}
 
 func (UnimplementedLogServiceServer) WriteLog(context.Context, *LogRequest) (*LogResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method WriteLog not implemented")
 }
 func (UnimplementedLogServiceServer) mustEmbedUnimplementedLogServiceServer() {}
+func (UnimplementedLogServiceServer) testEmbeddedByValue()                    {}
 
 // UnsafeLogServiceServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to LogServiceServer will
@@ -67,6 +76,13 @@ type UnsafeLogServiceServer interface {
 }
 
 func RegisterLogServiceServer(s grpc.ServiceRegistrar, srv LogServiceServer) {
+	// If the following call pancis, it indicates UnimplementedLogServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
 	s.RegisterService(&LogService_ServiceDesc, srv)
 }
 
@@ -80,7 +96,7 @@ func _LogService_WriteLog_Handler(srv interface{}, ctx context.Context, dec func
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/logs.LogService/WriteLog",
+		FullMethod: LogService_WriteLog_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
 		return srv.(LogServiceServer).WriteLog(ctx, req.(*LogRequest))
@@ -0,0 +1,129 @@
+// Package proto holds the gRPC contracts between the services. Every service imports the
+// generated code from this module, so a client and its server can never be built from
+// different copies of a definition.
+//
+// Each release is recorded in releases/ as a FileDescriptorSet. The tests check that the
+// current definitions neither break the wire format of the latest release nor remove
+// anything from it; go run ./cmd/release records a new one.
+package proto
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"proto/admins"
+	"proto/logs"
+	"proto/sessions"
+	"proto/users"
+)
+
+// Version is the release of the contracts the current definitions will become. Bump the
+// minor version for additions and the major version for breaking changes.
+const Version = "1.5.0"
+
+//go:embed releases/*.binpb
+var releases embed.FS
+
+// Files returns the descriptors of every proto file in the module.
+func Files() []protoreflect.FileDescriptor {
+	return []protoreflect.FileDescriptor{
+		users.File_users_proto,
+		admins.File_admin_proto,
+		admins.File_saml_proto,
+		sessions.File_sessions_proto,
+		logs.File_logs_proto,
+	}
+}
+
+// Current returns the current definitions as a FileDescriptorSet.
+func Current() *descriptorpb.FileDescriptorSet {
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, f := range Files() {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(f))
+	}
+	return set
+}
+
+// LatestRelease returns the latest recorded release and its version, or an empty version
+// if none has been recorded yet.
+func LatestRelease() (string, *descriptorpb.FileDescriptorSet, error) {
+	entries, err := releases.ReadDir("releases")
+	if err != nil {
+		return "", nil, err
+	}
+
+	var latest string
+	for _, e := range entries {
+		version := strings.TrimSuffix(strings.TrimPrefix(e.Name(), "v"), ".binpb")
+		if _, err := parseVersion(version); err != nil {
+			return "", nil, fmt.Errorf("release %s: %w", e.Name(), err)
+		}
+		if latest == "" || CompareVersions(version, latest) > 0 {
+			latest = version
+		}
+	}
+	if latest == "" {
+		return "", nil, nil
+	}
+
+	set, err := Release(latest)
+	return latest, set, err
+}
+
+// Release returns a recorded release.
+func Release(version string) (*descriptorpb.FileDescriptorSet, error) {
+	b, err := releases.ReadFile(path.Join("releases", "v"+version+".binpb"))
+	if err != nil {
+		return nil, err
+	}
+
+	return UnmarshalSet(b)
+}
+
+// CompareVersions compares two MAJOR.MINOR.PATCH versions like strings.Compare. Versions
+// that do not parse sort first.
+func CompareVersions(a, b string) int {
+	va, _ := parseVersion(a)
+	vb, _ := parseVersion(b)
+
+	for i := range va {
+		switch {
+		case va[i] < vb[i]:
+			return -1
+		case va[i] > vb[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// MajorVersion returns the major part of a MAJOR.MINOR.PATCH version.
+func MajorVersion(version string) int {
+	v, _ := parseVersion(version)
+	return v[0]
+}
+
+func parseVersion(version string) ([3]int, error) {
+	var v [3]int
+
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("version %q is not MAJOR.MINOR.PATCH", version)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("version %q is not MAJOR.MINOR.PATCH", version)
+		}
+		v[i] = n
+	}
+
+	return v, nil
+}
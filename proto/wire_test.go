@@ -0,0 +1,228 @@
+package proto
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"proto/admins"
+	"proto/users"
+)
+
+// populate sets every field of m to a value derived from its number, a few levels deep.
+func populate(m protoreflect.Message, depth int) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+
+		switch {
+		case fd.IsMap():
+			continue
+		case fd.IsList():
+			list := m.Mutable(fd).List()
+			if fd.Message() != nil {
+				if depth > 0 {
+					v := list.NewElement()
+					populate(v.Message(), depth-1)
+					list.Append(v)
+				}
+				continue
+			}
+			list.Append(scalarValue(fd))
+		case fd.Message() != nil:
+			if depth > 0 {
+				populate(m.Mutable(fd).Message(), depth-1)
+			}
+		default:
+			m.Set(fd, scalarValue(fd))
+		}
+	}
+}
+
+func scalarValue(fd protoreflect.FieldDescriptor) protoreflect.Value {
+	n := int64(fd.Number())
+
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(true)
+	case protoreflect.EnumKind:
+		return protoreflect.ValueOfEnum(fd.Enum().Values().Get(fd.Enum().Values().Len() - 1).Number())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(100 + n))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(uint32(100 + n))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(1000 + n)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(uint64(1000 + n))
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(n) + 0.5)
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(float64(n) + 0.5)
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(fmt.Sprintf("field %d", n))
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte{byte(n)})
+	}
+	panic(fmt.Sprintf("unexpected kind %s", fd.Kind()))
+}
+
+// sameByNumber checks that every field set in src that dst's side knows by number arrived
+// in dst with the same value. Fields only one side knows are skipped.
+func sameByNumber(t *testing.T, path string, src, dst protoreflect.Message) {
+	t.Helper()
+
+	srcFields := src.Descriptor().Fields()
+	for i := 0; i < srcFields.Len(); i++ {
+		sf := srcFields.Get(i)
+		df := dst.Descriptor().Fields().ByNumber(sf.Number())
+		if df == nil || !src.Has(sf) {
+			continue
+		}
+		name := path + "." + string(sf.Name())
+
+		if !dst.Has(df) {
+			t.Errorf("%s (%d) was lost on the way", name, sf.Number())
+			continue
+		}
+
+		switch {
+		case sf.IsList():
+			sl, dl := src.Get(sf).List(), dst.Get(df).List()
+			if sl.Len() != dl.Len() {
+				t.Errorf("%s (%d) has %d elements, want %d", name, sf.Number(), dl.Len(), sl.Len())
+				continue
+			}
+			for j := 0; j < sl.Len(); j++ {
+				if sf.Message() != nil {
+					sameByNumber(t, fmt.Sprintf("%s[%d]", name, j), sl.Get(j).Message(), dl.Get(j).Message())
+				} else if sl.Get(j).Interface() != dl.Get(j).Interface() {
+					t.Errorf("%s[%d] (%d) is %v, want %v", name, j, sf.Number(), dl.Get(j), sl.Get(j))
+				}
+			}
+		case sf.Message() != nil:
+			sameByNumber(t, name, src.Get(sf).Message(), dst.Get(df).Message())
+		case sf.Kind() == protoreflect.BytesKind:
+			if string(src.Get(sf).Bytes()) != string(dst.Get(df).Bytes()) {
+				t.Errorf("%s (%d) is %v, want %v", name, sf.Number(), dst.Get(df), src.Get(sf))
+			}
+		default:
+			if src.Get(sf).Interface() != dst.Get(df).Interface() {
+				t.Errorf("%s (%d) is %v, want %v", name, sf.Number(), dst.Get(df), src.Get(sf))
+			}
+		}
+	}
+}
+
+// roundTrip encodes every message type of from with every field set, decodes it as the
+// message type of the same name in to, and checks that the fields arrived intact.
+func roundTrip(t *testing.T, from, to *protoregistry.Files) {
+	from.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+		for i := 0; i < f.Messages().Len(); i++ {
+			md := f.Messages().Get(i)
+
+			d, err := to.FindDescriptorByName(md.FullName())
+			if err != nil {
+				continue
+			}
+			toMD := d.(protoreflect.MessageDescriptor)
+
+			t.Run(string(md.FullName()), func(t *testing.T) {
+				src := dynamicpb.NewMessage(md)
+				populate(src, 2)
+
+				b, err := gproto.Marshal(src)
+				if err != nil {
+					t.Fatalf("encode: %v", err)
+				}
+
+				dst := dynamicpb.NewMessage(toMD)
+				err = gproto.Unmarshal(b, dst)
+				if err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+
+				sameByNumber(t, string(md.Name()), src, dst)
+			})
+		}
+		return true
+	})
+}
+
+func files(t *testing.T, set *descriptorpb.FileDescriptorSet) *protoregistry.Files {
+	t.Helper()
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestWireCompatibleWithLatestRelease(t *testing.T) {
+	latest, set, err := LatestRelease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest == "" {
+		t.Skip("no release recorded")
+	}
+
+	released := files(t, set)
+	current := files(t, Current())
+
+	// Old clients talking to new servers, and the other way around
+	t.Run("released to current", func(t *testing.T) { roundTrip(t, released, current) })
+	t.Run("current to released", func(t *testing.T) { roundTrip(t, current, released) })
+}
+
+// auth-service and user-service once numbered these fields differently, so user IDs were
+// silently dropped between them. Pin the numbers the server has always sent.
+func TestValidateUserResponseWireFormat(t *testing.T) {
+	b, err := gproto.Marshal(&users.ValidateUserResponse{IsValid: true, Message: "ok", UserId: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[protowire.Number]protowire.Type{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		fields[num] = typ
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+
+	want := map[protowire.Number]protowire.Type{
+		1: protowire.VarintType, // is_valid
+		2: protowire.BytesType,  // message
+		3: protowire.VarintType, // user_id
+	}
+	for num, typ := range want {
+		if fields[num] != typ {
+			t.Errorf("field %d has wire type %d, want %d", num, fields[num], typ)
+		}
+	}
+}
+
+// auth-service once called AdminService under the proto package "admin", which
+// admin-service does not serve.
+func TestAdminServiceName(t *testing.T) {
+	if got := admins.AdminService_ServiceDesc.ServiceName; got != "admins.AdminService" {
+		t.Errorf("service name is %s, want admins.AdminService", got)
+	}
+}
@@ -0,0 +1,3 @@
+module stepup
+
+go 1.23.1
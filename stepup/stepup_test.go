@@ -0,0 +1,69 @@
+package stepup
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequire(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := Require(MaxAge)(next)
+
+	for _, tt := range []struct {
+		name     string
+		authTime time.Time
+		want     int
+	}{
+		{"recent login", time.Now().Add(-time.Minute), http.StatusNoContent},
+		{"login at the limit", time.Now().Add(-MaxAge + time.Second), http.StatusNoContent},
+		{"old login", time.Now().Add(-MaxAge - time.Second), http.StatusUnauthorized},
+		{"no auth time", time.Time{}, http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if !tt.authTime.IsZero() {
+				r = r.WithContext(WithAuthTime(r.Context(), tt.authTime))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := Challenge(w, MaxAge); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	want := `Bearer error="insufficient_user_authentication", error_description="A more recent authentication is required", max_age=300`
+	if got := w.Header().Get("WWW-Authenticate"); got != want {
+		t.Errorf("WWW-Authenticate = %q, want %q", got, want)
+	}
+
+	var body struct {
+		Error bool `json:"error"`
+		Data  struct {
+			Error  string `json:"error"`
+			MaxAge int    `json:"max_age"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if !body.Error || body.Data.Error != "insufficient_user_authentication" || body.Data.MaxAge != 300 {
+		t.Errorf("body = %s", w.Body)
+	}
+}
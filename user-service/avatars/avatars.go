@@ -0,0 +1,163 @@
+// Package avatars turns uploaded profile pictures into the square variants users are
+// shown with. Images are decoded and encoded again, so nothing but the pixels survives:
+// EXIF data such as locations and camera details is dropped, after its orientation has
+// been applied.
+package avatars
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Limits on uploads. Images are checked against MaxPixels before they are decoded, so a
+// small file cannot expand into a huge image.
+const (
+	MaxUploadBytes = 5 << 20
+	MaxPixels      = 25_000_000
+	MinDimension   = 64
+)
+
+// Sizes are the widths and heights of the variants, largest first.
+var Sizes = []int{512, 256, 64}
+
+// jpegQuality is the quality variants are encoded as JPEG with.
+const jpegQuality = 85
+
+// Errors for uploads that are refused.
+var (
+	ErrUnsupportedType = errors.New("avatars must be JPEG, PNG or WebP images")
+	ErrTooLarge        = fmt.Errorf("avatars can be at most %d MB and %d megapixels", MaxUploadBytes>>20, MaxPixels/1_000_000)
+	ErrTooSmall        = fmt.Errorf("avatars must be at least %dx%d pixels", MinDimension, MinDimension)
+	ErrInvalidImage    = errors.New("the image could not be read")
+)
+
+// Variant is an avatar in one size.
+type Variant struct {
+	Size        int
+	ContentType string
+	Data        []byte
+}
+
+// Ext returns the file extension for the variant's content type.
+func (v *Variant) Ext() string {
+	if v.ContentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// Process checks an upload and returns its variants, one for each of Sizes. The type is
+// taken from the content, not from what the client claims. PNGs stay PNGs, to keep their
+// transparency, and everything else becomes a JPEG.
+func Process(upload []byte) ([]Variant, error) {
+	if len(upload) > MaxUploadBytes {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(upload)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(upload))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	if config.Width < MinDimension || config.Height < MinDimension {
+		return nil, ErrTooSmall
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(upload))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	if contentType == "image/jpeg" {
+		img = orient(img, exifOrientation(upload))
+	}
+	img = squareCrop(img)
+
+	variants := make([]Variant, 0, len(Sizes))
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		variant := Variant{Size: size}
+
+		if contentType == "image/png" {
+			scaled := image.NewNRGBA(image.Rect(0, 0, size, size))
+			draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+			variant.ContentType = "image/png"
+			err = png.Encode(&buf, scaled)
+		} else {
+			// JPEGs have no transparency, so transparent WebPs go on white
+			scaled := image.NewRGBA(image.Rect(0, 0, size, size))
+			draw.Draw(scaled, scaled.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+			draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Over, nil)
+
+			variant.ContentType = "image/jpeg"
+			err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: jpegQuality})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		variant.Data = buf.Bytes()
+		variants = append(variants, variant)
+	}
+
+	return variants, nil
+}
+
+// NewKey returns a key for a new avatar of the user, with the variants' extension. Keys
+// are random, so the URLs of an old avatar do not show a new one.
+func NewKey(userID int64, ext string) (string, error) {
+	random := make([]byte, 16)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("avatars/%d/%s%s", userID, hex.EncodeToString(random), ext), nil
+}
+
+// VariantKey returns the key the variant of the size is stored under.
+func VariantKey(key string, size int) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(key, ext), size, ext)
+}
+
+// squareCrop returns the largest square in the middle of the image.
+func squareCrop(img image.Image) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+
+	// The decoders' images can share their pixels rather than copy them
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(image.Rect(x, y, x+side, y+side))
+	}
+
+	square := image.NewNRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, image.Point{X: x, Y: y}, draw.Src)
+	return square
+}
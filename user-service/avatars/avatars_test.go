@@ -0,0 +1,205 @@
+package avatars
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// halves returns a w x h image whose left half is red and right half blue.
+func halves(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// withOrientation inserts an EXIF segment with the orientation, and a camera model that
+// must not survive, after a JPEG's start of image marker.
+func withOrientation(jpegData []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(42))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(2))
+	// Model, an ASCII string of 8 bytes stored after the IFD
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0110, 2})
+	binary.Write(&tiff, binary.BigEndian, []uint32{8, 38})
+	// Orientation
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString("SpyCam1\x00")
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(jpegData[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(jpegData[2:])
+	return out.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xC000 && g < 0x4000 && b < 0x4000
+}
+
+func isBlue(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return b > 0xC000 && r < 0x4000 && g < 0x4000
+}
+
+func TestProcessAppliesOrientationAndDropsEXIF(t *testing.T) {
+	upload := withOrientation(encodeJPEG(t, halves(200, 100)), 6)
+	if exifOrientation(upload) != 6 {
+		t.Fatalf("orientation = %d, want 6", exifOrientation(upload))
+	}
+
+	variants, err := Process(upload)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if len(variants) != len(Sizes) {
+		t.Fatalf("got %d variants, want %d", len(variants), len(Sizes))
+	}
+
+	for i, v := range variants {
+		if v.Size != Sizes[i] || v.ContentType != "image/jpeg" || v.Ext() != ".jpg" {
+			t.Errorf("variant %d = %d %s", i, v.Size, v.ContentType)
+		}
+		if bytes.Contains(v.Data, []byte("Exif")) || bytes.Contains(v.Data, []byte("SpyCam")) {
+			t.Errorf("variant %d still has EXIF data", v.Size)
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(v.Data))
+		if err != nil {
+			t.Fatalf("variant %d: %v", v.Size, err)
+		}
+		if b := img.Bounds(); b.Dx() != v.Size || b.Dy() != v.Size {
+			t.Errorf("variant %d is %dx%d", v.Size, b.Dx(), b.Dy())
+		}
+
+		// Turned clockwise, the red left half ends up on top
+		top, bottom := img.At(v.Size/2, v.Size/8), img.At(v.Size/2, v.Size*7/8)
+		if !isRed(top) || !isBlue(bottom) {
+			t.Errorf("variant %d is not turned: top %v, bottom %v", v.Size, top, bottom)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	src := halves(4, 2)
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		redAt       image.Point
+	}{
+		{1, 4, 2, image.Pt(0, 0)},
+		{2, 4, 2, image.Pt(3, 0)},
+		{3, 4, 2, image.Pt(3, 1)},
+		{4, 4, 2, image.Pt(0, 1)},
+		{5, 2, 4, image.Pt(0, 0)},
+		{6, 2, 4, image.Pt(0, 0)},
+		{7, 2, 4, image.Pt(1, 3)},
+		{8, 2, 4, image.Pt(1, 3)},
+	}
+
+	for _, tt := range tests {
+		img := orient(src, tt.orientation)
+		if b := img.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d gave %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if !isRed(img.At(tt.redAt.X, tt.redAt.Y)) {
+			t.Errorf("orientation %d: %v is not red", tt.orientation, tt.redAt)
+		}
+	}
+}
+
+func TestProcessKeepsPNG(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	variants, err := Process(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(variants[0].Data))
+	if err != nil {
+		t.Fatalf("variant is not a PNG: %v", err)
+	}
+	if _, _, _, a := decoded.At(10, 10).RGBA(); a != 0 {
+		t.Errorf("transparency was lost")
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	tiny := encodeJPEG(t, halves(32, 32))
+
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+	broken := append([]byte(nil), encodeJPEG(t, halves(100, 100))[:200]...)
+
+	// A PNG header claiming more pixels than allowed
+	var ihdr bytes.Buffer
+	ihdr.WriteString("IHDR")
+	binary.Write(&ihdr, binary.BigEndian, []uint32{10000, 10000})
+	ihdr.Write([]byte{8, 6, 0, 0, 0})
+	var huge bytes.Buffer
+	huge.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&huge, binary.BigEndian, uint32(13))
+	huge.Write(ihdr.Bytes())
+	binary.Write(&huge, binary.BigEndian, crc32.ChecksumIEEE(ihdr.Bytes()))
+
+	tests := []struct {
+		name   string
+		upload []byte
+		want   error
+	}{
+		{"gif", gif, ErrUnsupportedType},
+		{"text", []byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), ErrUnsupportedType},
+		{"too small", tiny, ErrTooSmall},
+		{"truncated", broken, ErrInvalidImage},
+		{"too many pixels", huge.Bytes(), ErrTooLarge},
+		{"too many bytes", make([]byte, MaxUploadBytes+1), ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Process(tt.upload)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Process = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"apikey"
+	"user-service/data"
+)
+
+// ListAPIKeys returns the authenticated user's active API keys, without their secrets.
+func (app *Config) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := app.Models.APIKey.GetAllForUser(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d API keys", len(keys)),
+		Data:    keys,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// CreateAPIKey creates a named, scoped API key. The key itself is only returned in this response.
+func (app *Config) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload apikey.Request
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = requestPayload.Validate(time.Now())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	key, err := app.Models.APIKey.Insert(userID, requestPayload.Name, requestPayload.Scopes, requestPayload.ExpiresAt)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "create_api_key", fmt.Sprintf("User %d created API key %d (%s)", userID, key.ID, key.Prefix))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "API key created, store it now as it will not be shown again",
+		Data:    key,
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// RevokeAPIKey revokes one of the authenticated user's API keys.
+func (app *Config) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "key_id"), 10, 64)
+	if err != nil || keyID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid key ID"), http.StatusBadRequest)
+		return
+	}
+
+	app.revokeAPIKey(w, userID, keyID)
+}
+
+// AdminListAPIKeys returns the active API keys of any user.
+func (app *Config) AdminListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		return
+	}
+
+	keys, err := app.Models.APIKey.GetAllForUser(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d API keys for user %d", len(keys), userID),
+		Data:    keys,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// AdminRevokeAPIKey revokes a single API key of any user.
+func (app *Config) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		return
+	}
+
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "key_id"), 10, 64)
+	if err != nil || keyID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid key ID"), http.StatusBadRequest)
+		return
+	}
+
+	app.revokeAPIKey(w, userID, keyID)
+}
+
+// AdminRevokeAllAPIKeys revokes every API key of the user given in the user_id query
+// parameter, or of all users in the admin's scope when it is omitted.
+func (app *Config) AdminRevokeAllAPIKeys(w http.ResponseWriter, r *http.Request) {
+	tenants := tenantScope(r)
+
+	var userID int64
+	if idStr := r.URL.Query().Get("user_id"); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || id < 1 {
+			app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+			return
+		}
+		userID = id
+	}
+
+	revoked, err := app.Models.APIKey.RevokeAll(userID, tenants)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	scope := "all users"
+	if !tenants.Platform() {
+		scope = fmt.Sprintf("all users of tenant %s", tenants.Tenant)
+	}
+	if userID != 0 {
+		scope = fmt.Sprintf("user %d", userID)
+	}
+
+	err = app.logRequest("revoke_all_api_keys", fmt.Sprintf("Admin %d revoked %d API keys of %s", adminID, revoked, scope))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Revoked %d API keys of %s", revoked, scope),
+		Data:    map[string]int64{"revoked": revoked},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+func (app *Config) revokeAPIKey(w http.ResponseWriter, userID, keyID int64) {
+	err := app.Models.APIKey.Revoke(keyID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrAPIKeyNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "revoke_api_key", fmt.Sprintf("API key %d of user %d revoked", keyID, userID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("API key %d revoked successfully", keyID),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
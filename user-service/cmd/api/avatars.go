@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"user-service/avatars"
+	"user-service/data"
+)
+
+const (
+	// avatarURLTTL is how long the links to avatars in profiles work
+	avatarURLTTL = time.Hour
+
+	// avatarFormField is the multipart form field avatars are uploaded in
+	avatarFormField = "avatar"
+
+	// objectStoreTimeout bounds the object store requests of one avatar change
+	objectStoreTimeout = 30 * time.Second
+)
+
+// avatarLinks returns signed links to each size of the avatar with the key.
+func (app *Config) avatarLinks(key string) (*data.Avatar, error) {
+	avatar := &data.Avatar{
+		URLs:      make(map[string]string, len(avatars.Sizes)),
+		ExpiresAt: time.Now().Add(avatarURLTTL).UTC().Truncate(time.Second),
+	}
+
+	for _, size := range avatars.Sizes {
+		link, err := app.ObjectStore.SignedURL(avatars.VariantKey(key, size), avatarURLTTL)
+		if err != nil {
+			return nil, err
+		}
+		avatar.URLs[strconv.Itoa(size)] = link
+	}
+
+	return avatar, nil
+}
+
+// withAvatar fills in the links to the user's avatar, if they have one.
+func (app *Config) withAvatar(user *data.User) error {
+	if user.AvatarKey == "" {
+		return nil
+	}
+
+	avatar, err := app.avatarLinks(user.AvatarKey)
+	if err != nil {
+		return err
+	}
+	user.Avatar = avatar
+
+	return nil
+}
+
+// deleteAvatarObjects deletes every size of the avatar with the key from the object store.
+func (app *Config) deleteAvatarObjects(key string) error {
+	if key == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), objectStoreTimeout)
+	defer cancel()
+
+	for _, size := range avatars.Sizes {
+		err := app.ObjectStore.Delete(ctx, avatars.VariantKey(key, size))
+		if err != nil {
+			return fmt.Errorf("failed to delete avatar %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// UploadAvatar replaces the authenticated user's avatar with the image uploaded as a
+// multipart form, and returns links to its sizes.
+func (app *Config) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	// Leave room for the rest of the form around the file
+	r.Body = http.MaxBytesReader(w, r.Body, avatars.MaxUploadBytes+64*1024)
+
+	file, _, err := r.FormFile(avatarFormField)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			app.errorJSON(w, avatars.ErrTooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+		app.errorJSON(w, fmt.Errorf("upload the image as multipart form field %q", avatarFormField), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	upload, err := io.ReadAll(io.LimitReader(file, avatars.MaxUploadBytes+1))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	variants, err := avatars.Process(upload)
+	if err != nil {
+		switch {
+		case errors.Is(err, avatars.ErrTooLarge):
+			app.errorJSON(w, err, http.StatusRequestEntityTooLarge)
+		case errors.Is(err, avatars.ErrUnsupportedType):
+			app.errorJSON(w, err, http.StatusUnsupportedMediaType)
+		case errors.Is(err, avatars.ErrTooSmall), errors.Is(err, avatars.ErrInvalidImage):
+			app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		default:
+			app.errorJSON(w, err)
+		}
+		return
+	}
+
+	key, err := avatars.NewKey(userID, variants[0].Ext())
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.storeAvatar(key, variants)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+
+	previous, err := app.Models.User.SetAvatar(userID, key)
+	if err != nil {
+		if cleanupErr := app.deleteAvatarObjects(key); cleanupErr != nil {
+			log.Println(cleanupErr)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, fmt.Errorf("user %d not found", userID), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	if err := app.deleteAvatarObjects(previous); err != nil {
+		log.Println(err)
+	}
+
+	err = app.logUserRequest(userID, "upload_avatar", fmt.Sprintf("User %d uploaded a new avatar", userID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	avatar, err := app.avatarLinks(key)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Avatar uploaded",
+		Data:    avatar,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// storeAvatar puts every variant in the object store, or none of them.
+func (app *Config) storeAvatar(key string, variants []avatars.Variant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), objectStoreTimeout)
+	defer cancel()
+
+	for _, v := range variants {
+		err := app.ObjectStore.Put(ctx, avatars.VariantKey(key, v.Size), v.ContentType, bytes.NewReader(v.Data), int64(len(v.Data)))
+		if err != nil {
+			if cleanupErr := app.deleteAvatarObjects(key); cleanupErr != nil {
+				log.Println(cleanupErr)
+			}
+			return fmt.Errorf("failed to store avatar: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteAvatar removes the authenticated user's avatar.
+func (app *Config) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	app.deleteAvatar(w, userID, fmt.Sprintf("User %d removed their avatar", userID))
+}
+
+// AdminDeleteAvatar removes any user's avatar, for pictures that break the rules.
+func (app *Config) AdminDeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	app.deleteAvatar(w, userID, fmt.Sprintf("Admin %d removed the avatar of user %d", adminID, userID))
+}
+
+func (app *Config) deleteAvatar(w http.ResponseWriter, userID int64, logMessage string) {
+	previous, err := app.Models.User.SetAvatar(userID, "")
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, fmt.Errorf("user %d not found", userID), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+	if previous == "" {
+		app.errorJSON(w, fmt.Errorf("user %d has no avatar", userID), http.StatusNotFound)
+		return
+	}
+
+	if err := app.deleteAvatarObjects(previous); err != nil {
+		log.Println(err)
+	}
+
+	err = app.logUserRequest(userID, "delete_avatar", logMessage)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Avatar removed",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"user-service/data"
+)
+
+// RequestDataExport starts an export of everything stored about the authenticated user.
+// The archive can be downloaded once the request has completed.
+func (app *Config) RequestDataExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	app.openDataRequest(w, userID, data.DataRequestExport, "user", time.Now(),
+		fmt.Sprintf("User %d requested a data export", userID),
+		"Your data export has been requested")
+}
+
+// RequestErasure schedules the authenticated user's account for erasure from every
+// service. It can be cancelled until the grace period ends.
+func (app *Config) RequestErasure(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	runAfter := time.Now().Add(app.ErasureGracePeriod)
+	app.openDataRequest(w, userID, data.DataRequestErasure, "user", runAfter,
+		fmt.Sprintf("User %d requested the erasure of their account", userID),
+		fmt.Sprintf("Your account will be erased after %s unless you cancel the request", runAfter.Format(time.RFC3339)))
+}
+
+// AdminRequestErasure schedules a user's account for erasure on their behalf, for
+// requests that reached support rather than the API.
+func (app *Config) AdminRequestErasure(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.Models.User.GetProfile(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, fmt.Errorf("user %d not found", userID), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	runAfter := time.Now().Add(app.ErasureGracePeriod)
+	app.openDataRequest(w, userID, data.DataRequestErasure, fmt.Sprintf("admin:%d", adminID), runAfter,
+		fmt.Sprintf("Admin %d requested the erasure of user %d", adminID, userID),
+		fmt.Sprintf("User %d will be erased after %s unless the request is cancelled", userID, runAfter.Format(time.RFC3339)))
+}
+
+func (app *Config) openDataRequest(w http.ResponseWriter, userID int64, kind, requestedBy string, runAfter time.Time, logMessage, message string) {
+	request, err := app.Models.DataRequest.Insert(userID, kind, requestedBy, runAfter)
+	if err != nil {
+		if errors.Is(err, data.ErrDataRequestOpen) {
+			app.errorJSON(w, fmt.Errorf("an %s request is already open", kind), http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "request_"+kind, logMessage)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    request,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// ListDataRequests returns the authenticated user's exports and erasures, newest first,
+// with the status of each service's step.
+func (app *Config) ListDataRequests(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	app.listDataRequests(w, data.DataRequestFilter{UserID: userID}, data.DataRequestMaxLimit)
+}
+
+// AdminListDataRequests returns the data requests of all users in the admin's scope,
+// filtered by the user_id, kind and status query parameters.
+func (app *Config) AdminListDataRequests(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := data.DataRequestFilter{Tenant: tenantScope(r).Tenant}
+	if idStr := query.Get("user_id"); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || id < 1 {
+			app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+			return
+		}
+		filter.UserID = id
+	}
+
+	filter.Kind = query.Get("kind")
+	if filter.Kind != "" && !data.ValidDataRequestKind(filter.Kind) {
+		app.errorJSON(w, fmt.Errorf("unknown kind %q", filter.Kind), http.StatusBadRequest)
+		return
+	}
+
+	filter.Status = query.Get("status")
+	if filter.Status != "" && !data.ValidDataRequestStatus(filter.Status) {
+		app.errorJSON(w, fmt.Errorf("unknown status %q", filter.Status), http.StatusBadRequest)
+		return
+	}
+
+	limit := data.DataRequestDefaultLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > data.DataRequestMaxLimit {
+			app.errorJSON(w, fmt.Errorf("limit must be between 1 and %d", data.DataRequestMaxLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	app.listDataRequests(w, filter, limit)
+}
+
+func (app *Config) listDataRequests(w http.ResponseWriter, filter data.DataRequestFilter, limit int) {
+	requests, err := app.Models.DataRequest.List(filter, limit)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d data requests", len(requests)),
+		Data:    requests,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// GetDataRequest returns one of the authenticated user's data requests.
+func (app *Config) GetDataRequest(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	app.getDataRequest(w, r, userID, data.TenantScope{})
+}
+
+// AdminGetDataRequest returns any data request of a user in the admin's scope.
+func (app *Config) AdminGetDataRequest(w http.ResponseWriter, r *http.Request) {
+	app.getDataRequest(w, r, 0, tenantScope(r))
+}
+
+func (app *Config) getDataRequest(w http.ResponseWriter, r *http.Request, userID int64, scope data.TenantScope) {
+	requestID, err := strconv.ParseInt(chi.URLParam(r, "request_id"), 10, 64)
+	if err != nil || requestID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid request ID"), http.StatusBadRequest)
+		return
+	}
+
+	request, err := app.Models.DataRequest.Get(requestID, userID, scope)
+	if err != nil {
+		if errors.Is(err, data.ErrDataRequestNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Data request retrieved successfully",
+		Data:    request,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// CancelDataRequest cancels one of the authenticated user's requests that has not started
+// yet, such as an erasure still in its grace period.
+func (app *Config) CancelDataRequest(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	app.cancelDataRequest(w, r, userID, data.TenantScope{}, fmt.Sprintf("User %d", userID))
+}
+
+// AdminCancelDataRequest cancels any request of a user in the admin's scope that has not
+// started yet.
+func (app *Config) AdminCancelDataRequest(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	app.cancelDataRequest(w, r, 0, tenantScope(r), fmt.Sprintf("Admin %d", adminID))
+}
+
+func (app *Config) cancelDataRequest(w http.ResponseWriter, r *http.Request, userID int64, scope data.TenantScope, actor string) {
+	requestID, err := strconv.ParseInt(chi.URLParam(r, "request_id"), 10, 64)
+	if err != nil || requestID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid request ID"), http.StatusBadRequest)
+		return
+	}
+
+	request, err := app.Models.DataRequest.Cancel(requestID, userID, scope)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDataRequestNotFound):
+			app.errorJSON(w, err, http.StatusNotFound)
+		case errors.Is(err, data.ErrDataRequestStarted):
+			app.errorJSON(w, err, http.StatusConflict)
+		default:
+			app.errorJSON(w, err)
+		}
+		return
+	}
+
+	err = app.logUserRequest(request.UserID, "cancel_"+request.Kind, fmt.Sprintf("%s cancelled %s request %d of user %d", actor, request.Kind, request.ID, request.UserID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Data request %d cancelled", request.ID),
+		Data:    request,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// DownloadDataExport sends the archive of one of the authenticated user's completed
+// exports, as a ZIP file with one JSON file per part, or with format=json as one JSON
+// document.
+func (app *Config) DownloadDataExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	requestID, err := strconv.ParseInt(chi.URLParam(r, "request_id"), 10, 64)
+	if err != nil || requestID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid request ID"), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "json" {
+		app.errorJSON(w, fmt.Errorf("format must be zip or json"), http.StatusBadRequest)
+		return
+	}
+
+	archive, err := app.Models.DataRequest.Archive(requestID, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrDataRequestNotFound) {
+			app.errorJSON(w, fmt.Errorf("no completed export %d, or its archive has expired", requestID), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	var body bytes.Buffer
+	contentType := "application/json"
+	if format == "zip" {
+		contentType = "application/zip"
+		err = writeExportZip(&body, archive)
+	} else {
+		err = json.NewEncoder(&body).Encode(archive)
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "download_export", fmt.Sprintf("User %d downloaded export %d", userID, requestID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export-%d.%s"`, userID, requestID, format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+// writeExportZip writes an export archive as a ZIP file with one indented JSON file per
+// part, in name order.
+func writeExportZip(w io.Writer, archive map[string]json.RawMessage) error {
+	names := make([]string, 0, len(archive))
+	for name := range archive {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	zw := zip.NewWriter(w)
+
+	for _, name := range names {
+		var part bytes.Buffer
+		err := json.Indent(&part, archive[name], "", "\t")
+		if err != nil {
+			return err
+		}
+
+		f, err := zw.Create(name + ".json")
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(part.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"user-service/data"
+)
+
+const (
+	// dataRequestPollInterval is how often the worker looks for due data requests.
+	dataRequestPollInterval = 30 * time.Second
+
+	// dataRequestMaxAttempts is how often a request is tried before it fails. Retries back
+	// off exponentially, starting at a minute.
+	dataRequestMaxAttempts = 5
+
+	// exportArchiveTTL is how long export archives can be downloaded.
+	exportArchiveTTL = 7 * 24 * time.Hour
+
+	// defaultErasureGracePeriod is how long erasures can be cancelled, unless
+	// ERASURE_GRACE_PERIOD says otherwise.
+	defaultErasureGracePeriod = 7 * 24 * time.Hour
+
+	loggerServiceURL = "http://logger-service"
+)
+
+// runDataRequests runs due data requests, one at a time, until the process exits. Requests
+// are claimed in the database, so several instances can run it side by side.
+func (app *Config) runDataRequests() {
+	ticker := time.NewTicker(dataRequestPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := app.Models.DataRequest.PurgeArchives()
+		if err != nil {
+			log.Printf("Failed to purge expired export archives: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d expired export archives", purged)
+		}
+
+		for {
+			request, err := app.Models.DataRequest.Claim()
+			if err != nil {
+				log.Printf("Failed to claim a data request: %v", err)
+				break
+			}
+			if request == nil {
+				break
+			}
+
+			app.processDataRequest(request)
+		}
+	}
+}
+
+// processDataRequest runs the steps of a claimed request that have not completed yet, in
+// order, and stops at the first that fails.
+func (app *Config) processDataRequest(request *data.DataRequest) {
+	for _, step := range request.Steps {
+		if step.Status == data.DataRequestCompleted {
+			continue
+		}
+
+		archivePart, err := app.runDataRequestStep(request, step.Service)
+		if err != nil {
+			var retryAt *time.Time
+			if request.Attempts < dataRequestMaxAttempts {
+				t := time.Now().Add(time.Minute << (request.Attempts - 1))
+				retryAt = &t
+			}
+
+			log.Printf("Data request %d: %s step failed (attempt %d): %v", request.ID, step.Service, request.Attempts, err)
+
+			err = app.Models.DataRequest.FailStep(request.ID, step.Service, err, retryAt)
+			if err != nil {
+				log.Printf("Data request %d: failed to record the failure: %v", request.ID, err)
+			}
+			return
+		}
+
+		err = app.Models.DataRequest.CompleteStep(request.ID, step.Service, archivePart)
+		if err != nil {
+			log.Printf("Data request %d: failed to record the %s step: %v", request.ID, step.Service, err)
+			return
+		}
+	}
+
+	var archiveExpiresAt *time.Time
+	if request.Kind == data.DataRequestExport {
+		t := time.Now().Add(exportArchiveTTL)
+		archiveExpiresAt = &t
+	}
+
+	err := app.Models.DataRequest.Complete(request.ID, archiveExpiresAt)
+	if err != nil {
+		log.Printf("Data request %d: failed to complete: %v", request.ID, err)
+		return
+	}
+
+	// Not tagged with the user, whose erased entries must stay untagged
+	err = app.logRequest("complete_"+request.Kind, fmt.Sprintf("Completed %s request %d of user %d", request.Kind, request.ID, request.UserID))
+	if err != nil {
+		log.Printf("Data request %d: failed to log completion: %v", request.ID, err)
+	}
+}
+
+// runDataRequestStep carries out one service's part of a request. Export steps return
+// their part of the archive. Steps must be safe to repeat, as a request is retried from
+// the first step that has not completed.
+func (app *Config) runDataRequestStep(request *data.DataRequest, service string) (map[string]any, error) {
+	switch request.Kind + "/" + service {
+	case data.DataRequestExport + "/" + data.ServiceUser:
+		return app.exportUserData(request.UserID)
+
+	case data.DataRequestExport + "/" + data.ServiceLogger:
+		entries, err := app.loggerSubjectRequest("/subject/entries", request.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"logs": entries}, nil
+
+	case data.DataRequestErasure + "/" + data.ServiceAuth:
+		return nil, app.revokeUserSessions(request.UserID)
+
+	case data.DataRequestErasure + "/" + data.ServiceLogger:
+		_, err := app.loggerSubjectRequest("/subject/anonymize", request.UserID)
+		return nil, err
+
+	case data.DataRequestErasure + "/" + data.ServiceUser:
+		// The avatar is in the object store, so it goes first, while its key is known
+		user, err := app.Models.User.GetProfile(request.UserID)
+		if err == nil {
+			err = app.deleteAvatarObjects(user.AvatarKey)
+		} else if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Credentials, identities and group memberships are deleted with the user
+		return nil, app.Models.User.DeleteUserByID(request.UserID)
+	}
+
+	return nil, fmt.Errorf("no %s step for %s requests", service, request.Kind)
+}
+
+// exportUserData collects everything user-service stores about a user.
+func (app *Config) exportUserData(userID int64) (map[string]any, error) {
+	profile, err := app.Models.User.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := app.Models.Identity.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys, err := app.Models.WebAuthn.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := app.Models.APIKey.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := app.Models.SCIM.GroupsOfUsers([]int64{userID})
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := app.Models.DataRequest.List(data.DataRequestFilter{UserID: userID}, data.DataRequestMaxLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications, err := app.Models.NotificationPreference.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"profile":                  profile,
+		"identities":               identities,
+		"passkeys":                 passkeys,
+		"api_keys":                 apiKeys,
+		"groups":                   append([]data.SCIMReference{}, groups[userID]...),
+		"data_requests":            requests,
+		"notification_preferences": notifications,
+	}, nil
+}
+
+// loggerSubjectRequest sends a data subject request about a user to logger-service, which
+// finds their entries by ID and email address, and returns the response data.
+func (app *Config) loggerSubjectRequest(path string, userID int64) (json.RawMessage, error) {
+	user, err := app.Models.User.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]any{"user_id": userID, "email": user.Email})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Post(loggerServiceURL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Error   bool            `json:"error"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("logger-service returned status %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || response.Error {
+		return nil, fmt.Errorf("logger-service returned status %d: %s", resp.StatusCode, response.Message)
+	}
+
+	return response.Data, nil
+}
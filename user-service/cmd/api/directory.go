@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"user-service/data"
+)
+
+// AdminListUsers returns one page of the user directory. It is filtered by the q (search
+// over email, username and names), created_after, created_before, verified and status
+// query parameters, ordered by sort and order, and continued with the next_cursor of the
+// previous page. Admins of a tenant only see its members.
+func (app *Config) AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	query, err := directoryQuery(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	query.Tenant = tenantScope(r).Tenant
+
+	limit := query.Limit
+	if limit == 0 {
+		limit = data.DirectoryDefaultLimit
+	}
+	// One extra row tells whether there is another page
+	query.Limit = limit + 1
+
+	users := make([]*data.User, 0, limit)
+	var cursors []string
+
+	err = app.Models.User.Directory(r.Context(), query, func(user *data.User, cursor string) error {
+		users = append(users, user)
+		cursors = append(cursors, cursor)
+		return nil
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var nextCursor string
+	if len(users) > limit {
+		users = users[:limit]
+		nextCursor = cursors[limit-1]
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d users", len(users)),
+		Data: map[string]interface{}{
+			"users":       users,
+			"next_cursor": nextCursor,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// directoryQuery reads the directory parameters of a request.
+func directoryQuery(values url.Values) (data.DirectoryQuery, error) {
+	query := data.DirectoryQuery{
+		Search: values.Get("q"),
+		Status: values.Get("status"),
+		Sort:   values.Get("sort"),
+		Cursor: values.Get("cursor"),
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > data.DirectoryMaxLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", data.DirectoryMaxLimit)
+		}
+		query.Limit = n
+	}
+
+	for name, dst := range map[string]**time.Time{"created_after": &query.CreatedAfter, "created_before": &query.CreatedBefore} {
+		if v := values.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+			}
+			*dst = &t
+		}
+	}
+
+	if v := values.Get("verified"); v != "" {
+		verified, err := strconv.ParseBool(v)
+		if err != nil {
+			return query, fmt.Errorf("verified must be true or false")
+		}
+		query.Verified = &verified
+	}
+
+	return query, query.Validate()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"user-service/data"
+)
+
+// requestEmailChange starts moving the user to a new email address. The new address gets a
+// confirmation link, and the old one a notice with a link that undoes the change, so a
+// stolen access token cannot take over the account's reset channel. It returns nil if the
+// address is the current one.
+func (app *Config) requestEmailChange(userID int64, newEmail string) (*data.EmailChange, error) {
+	user, err := app.Models.User.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	if newEmail == user.Email {
+		return nil, nil
+	}
+
+	other, err := app.Models.User.GetUserByEmail(newEmail)
+	if err == nil && other.ID != userID {
+		return nil, data.ErrDuplicateEmail
+	}
+
+	change, err := app.Models.EmailChange.Insert(userID, user.Email, newEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.sendMail(change.NewEmail, "Confirm your new email address", fmt.Sprintf(
+		"Please use the following link to confirm %s as the email address of your account: %s\n\nThe link expires in %s.",
+		change.NewEmail, app.accountLink("confirm-email", change.ConfirmToken), data.EmailChangeTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.sendMail(change.OldEmail, "Your email address is being changed", fmt.Sprintf(
+		"Someone asked to change the email address of your account from %s to %s. If this was not you, use the following link to keep your current address and log out everywhere: %s",
+		change.OldEmail, change.NewEmail, app.accountLink("revert-email", change.RevertToken)))
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.logUserRequest(userID, "request_email_change", fmt.Sprintf("User %d requested an email change", userID))
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// accountLink returns the link to a page of the account website that posts the token back
+// to the API.
+func (app *Config) accountLink(page, token string) string {
+	return fmt.Sprintf("%s/%s?token=%s", app.AccountURL, page, url.QueryEscape(token))
+}
+
+// GetEmailChange returns the authenticated user's pending email change.
+func (app *Config) GetEmailChange(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	change, err := app.Models.EmailChange.GetPending(userID)
+	if err != nil {
+		if errors.Is(err, data.ErrEmailChangeNotFound) {
+			app.errorJSON(w, fmt.Errorf("no pending email change"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Pending email change retrieved successfully",
+		Data:    change,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// CancelEmailChange cancels the authenticated user's pending email change.
+func (app *Config) CancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	err := app.Models.EmailChange.Cancel(userID)
+	if err != nil {
+		if errors.Is(err, data.ErrEmailChangeNotFound) {
+			app.errorJSON(w, fmt.Errorf("no pending email change"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "cancel_email_change", fmt.Sprintf("User %d cancelled their email change", userID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Email change cancelled",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// ConfirmEmailChange completes an email change with the token from the confirmation link,
+// and logs the user out everywhere.
+func (app *Config) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	change, err := app.Models.EmailChange.Confirm(requestPayload.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEmailChangeNotFound):
+			app.errorJSON(w, fmt.Errorf("invalid or expired token"), http.StatusBadRequest)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.errorJSON(w, err, http.StatusConflict)
+		default:
+			app.errorJSON(w, err)
+		}
+		return
+	}
+
+	app.finishEmailChange(w, change, "confirm_email_change",
+		fmt.Sprintf("User %d confirmed their new email address", change.UserID),
+		fmt.Sprintf("Your email address is now %s; please log in again", change.NewEmail))
+}
+
+// RevertEmailChange undoes an email change with the token from the notice sent to the old
+// address, and logs the user out everywhere. A change that was not confirmed yet is
+// cancelled.
+func (app *Config) RevertEmailChange(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	change, err := app.Models.EmailChange.Revert(requestPayload.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEmailChangeNotFound):
+			app.errorJSON(w, fmt.Errorf("invalid or expired token"), http.StatusBadRequest)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.errorJSON(w, err, http.StatusConflict)
+		default:
+			app.errorJSON(w, err)
+		}
+		return
+	}
+
+	app.finishEmailChange(w, change, "revert_email_change",
+		fmt.Sprintf("User %d reverted the change of their email address", change.UserID),
+		fmt.Sprintf("Your email address remains %s; please log in again", change.OldEmail))
+}
+
+func (app *Config) finishEmailChange(w http.ResponseWriter, change *data.EmailChange, logName, logMessage, message string) {
+	err := app.revokeUserSessions(change.UserID)
+	if err != nil {
+		log.Printf("Failed to revoke the sessions of user %d after an email change: %v", change.UserID, err)
+		app.errorJSON(w, fmt.Errorf("the email change was saved, but ending your sessions failed"), http.StatusInternalServerError)
+		return
+	}
+
+	err = app.logUserRequest(change.UserID, logName, logMessage)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestConfirmEmailChangeEndsSessions(t *testing.T) {
+	app := newTestApp(t)
+	token := app.token(t, "user", 7, nil)
+
+	// The token works, and the answer from auth-service is remembered
+	app.expectStatus(7, true, "active")
+	if got := app.serveAuthenticated("user", token); got != http.StatusNoContent {
+		t.Fatalf("before the change: status = %d, want %d", got, http.StatusNoContent)
+	}
+
+	now := time.Now()
+	app.mock.ExpectBegin()
+	app.mock.ExpectQuery(`SELECT .+ FROM email_changes\s+WHERE confirm_token_hash = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "old_email", "new_email", "expires_at", "revert_expires_at", "confirmed_at", "created_at"}).
+			AddRow(3, 7, "jane@example.org", "jane@example.com", now.Add(time.Hour), now.Add(24*time.Hour), nil, now))
+	app.mock.ExpectExec(`UPDATE users SET email = \$1`).
+		WithArgs("jane@example.com", int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	app.mock.ExpectQuery(`UPDATE email_changes SET confirmed_at = NOW\(\)`).
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"confirmed_at"}).AddRow(now))
+	app.mock.ExpectCommit()
+
+	w := app.do(http.MethodPost, "/api/login/email-change/confirm", "", `{"token": "confirm-token"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm: status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	if got := app.serveAuthenticated("user", token); got != http.StatusUnauthorized {
+		t.Fatalf("after the change: status = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
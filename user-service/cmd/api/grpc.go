@@ -6,52 +6,747 @@ import (
 	"log"
 	"net"
 	"database/sql"
+	"errors"
+	"strconv"
+	"time"
 
+	"password"
+	"proto/users"
+	"user-service/credentials"
 	"user-service/data"
-	"user-service/users"
 
 	"google.golang.org/grpc"
-	
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// getUsersByIDsMaxIDs is how many users GetUsersByIDs looks up at once.
+	getUsersByIDsMaxIDs = 500
+
+	// userEventPollInterval is how often WatchUserEvents checks for new events.
+	userEventPollInterval = time.Second
+
+	// userEventBatchSize is how many events WatchUserEvents reads at a time.
+	userEventBatchSize = 100
 )
 
 type UserServer struct {
 	users.UserServiceServer
-	Models data.Models
+	Models      data.Models
+	Credentials credentials.Chain
+
+	// ChangeUserStatus applies a status change with its side effects: ending sessions,
+	// notifying the user and recording it in logger-service
+	ChangeUserStatus func(userID int64, change data.StatusChange, actor string) (*data.User, error)
 }
 
-func (s *UserServer) ValidateUser(ctx context.Context, req *users.ValidateUserRequest) (*users.ValidateUserResponse, error) {
-	email := req.GetEmail()
-	password := req.GetPassword()
+// loginBlocked returns the error for users who may not log in, or nil if they may.
+func loginBlocked(user *data.User) error {
+	switch user.AccountStatus() {
+	case data.UserStatusDeactivated:
+		return status.Error(codes.PermissionDenied, "user is deactivated")
+	case data.UserStatusSuspended:
+		return status.Error(codes.PermissionDenied, "user is suspended")
+	case data.UserStatusBanned:
+		return status.Error(codes.PermissionDenied, "user is banned")
+	}
+	return nil
+}
 
-	// Pobranie użytkownika z bazy danych na podstawie emaila
-	user, err := s.Models.User.GetUserByEmail(email)
+// ValidateUser checks an email and password against the credential backends. Users from an
+// external directory get a local row on their first successful login.
+func (s *UserServer) ValidateUser(ctx context.Context, req *users.ValidateUserRequest) (*users.ValidateUserResponse, error) {
+	identity, err := s.Credentials.Authenticate(ctx, req.GetEmail(), req.GetPassword())
 	if err != nil {
-		if err == sql.ErrNoRows {
+		switch {
+		case errors.Is(err, credentials.ErrUnknownUser):
 			return &users.ValidateUserResponse{
 				IsValid: false,
 				Message: "User not found",
+				Denial:  users.LoginDenial_LOGIN_DENIAL_INVALID_CREDENTIALS,
 			}, nil
+		case errors.Is(err, credentials.ErrInvalidCredentials):
+			return &users.ValidateUserResponse{
+				IsValid: false,
+				Message: "Invalid password",
+				Denial:  users.LoginDenial_LOGIN_DENIAL_INVALID_CREDENTIALS,
+			}, nil
+		case errors.Is(err, password.ErrBusy):
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case ctx.Err() != nil:
+			return nil, status.FromContextError(ctx.Err()).Err()
 		}
 		return nil, err
 	}
 
-	// Weryfikacja hasła
-	valid, err := s.Models.User.PasswordMatches(user.ID, password)
-	if err != nil || !valid {
+	userID := identity.UserID
+	if identity.Source != data.AuthSourceLocal {
+		userID, err = s.Models.User.ProvisionExternalUser(data.User{
+			Email:      identity.Email,
+			UserName:   identity.Username,
+			FirstName:  identity.FirstName,
+			LastName:   identity.LastName,
+			AuthSource: identity.Source,
+		})
+		if err != nil {
+			if errors.Is(err, data.ErrAuthSourceConflict) {
+				log.Printf("Refusing %s login for %s: %v", identity.Source, identity.Email, err)
+				return &users.ValidateUserResponse{
+					IsValid: false,
+					Message: "Invalid password",
+					Denial:  users.LoginDenial_LOGIN_DENIAL_INVALID_CREDENTIALS,
+				}, nil
+			}
+			return nil, err
+		}
+	}
+
+	// Users deactivated by their tenant's provisioning client, or suspended or banned by
+	// an admin, cannot log in. They are only told so once their password has been checked.
+	user, err := s.Models.User.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	switch user.AccountStatus() {
+	case data.UserStatusDeactivated:
 		return &users.ValidateUserResponse{
 			IsValid: false,
-			Message: "Invalid password",
+			Message: "User is deactivated",
+			Denial:  users.LoginDenial_LOGIN_DENIAL_DEACTIVATED,
+		}, nil
+	case data.UserStatusSuspended:
+		return &users.ValidateUserResponse{
+			IsValid:        false,
+			Message:        "User is suspended",
+			Denial:         users.LoginDenial_LOGIN_DENIAL_SUSPENDED,
+			StatusReason:   user.StatusReason,
+			SuspendedUntil: user.SuspendedUntil.Unix(),
+		}, nil
+	case data.UserStatusBanned:
+		return &users.ValidateUserResponse{
+			IsValid:      false,
+			Message:      "User is banned",
+			Denial:       users.LoginDenial_LOGIN_DENIAL_BANNED,
+			StatusReason: user.StatusReason,
 		}, nil
 	}
 
 	// Odpowiedź, że użytkownik jest poprawny
 	return &users.ValidateUserResponse{
 		IsValid: true,
-		UserId:  user.ID,
+		UserId:  userID,
 		Message: "User authenticated successfully",
 	}, nil
 }
 
+// GetWebAuthnUser returns a user together with their WebAuthn credentials, for auth-service
+// to run passkey ceremonies against
+func (s *UserServer) GetWebAuthnUser(ctx context.Context, req *users.GetWebAuthnUserRequest) (*users.WebAuthnUser, error) {
+	var user *data.User
+	var err error
+
+	switch {
+	case req.GetUserId() > 0:
+		user, err = s.Models.User.GetUserByID(req.GetUserId())
+	case req.GetEmail() != "":
+		user, err = s.Models.User.GetUserByEmail(req.GetEmail())
+	default:
+		return nil, status.Error(codes.InvalidArgument, "user_id or email is required")
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to load user: %v", err)
+	}
+	if err := loginBlocked(user); err != nil {
+		return nil, err
+	}
+
+	credentials, err := s.Models.WebAuthn.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load credentials: %v", err)
+	}
+
+	response := &users.WebAuthnUser{
+		UserId:   user.ID,
+		Email:    user.Email,
+		Username: user.UserName,
+	}
+
+	for _, c := range credentials {
+		response.Credentials = append(response.Credentials, &users.WebAuthnCredential{
+			Id:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transports:      c.Transports,
+			Aaguid:          c.AAGUID,
+			SignCount:       c.SignCount,
+			BackupEligible:  c.BackupEligible,
+			BackupState:     c.BackupState,
+			UserVerified:    c.UserVerified,
+			Name:            c.Name,
+		})
+	}
+
+	return response, nil
+}
+
+// AddWebAuthnCredential stores a credential registered through auth-service
+func (s *UserServer) AddWebAuthnCredential(ctx context.Context, req *users.AddWebAuthnCredentialRequest) (*users.AddWebAuthnCredentialResponse, error) {
+	c := req.GetCredential()
+	if req.GetUserId() < 1 || c == nil || len(c.GetId()) == 0 || len(c.GetPublicKey()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id and a credential with id and public key are required")
+	}
+
+	id, err := s.Models.WebAuthn.Insert(&data.WebAuthnCredential{
+		UserID:          req.GetUserId(),
+		Name:            c.GetName(),
+		CredentialID:    c.GetId(),
+		PublicKey:       c.GetPublicKey(),
+		AttestationType: c.GetAttestationType(),
+		Transports:      c.GetTransports(),
+		AAGUID:          c.GetAaguid(),
+		SignCount:       c.GetSignCount(),
+		BackupEligible:  c.GetBackupEligible(),
+		BackupState:     c.GetBackupState(),
+		UserVerified:    c.GetUserVerified(),
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store credential: %v", err)
+	}
+
+	return &users.AddWebAuthnCredentialResponse{Id: id}, nil
+}
+
+// UpdateWebAuthnCredential records a successful passkey login
+func (s *UserServer) UpdateWebAuthnCredential(ctx context.Context, req *users.UpdateWebAuthnCredentialRequest) (*users.UpdateWebAuthnCredentialResponse, error) {
+	err := s.Models.WebAuthn.RecordUse(req.GetCredentialId(), req.GetSignCount(), req.GetBackupState())
+	if err != nil {
+		if errors.Is(err, data.ErrCredentialNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to update credential: %v", err)
+	}
+
+	return &users.UpdateWebAuthnCredentialResponse{}, nil
+}
+
+// ResolveExternalIdentity returns the user an identity provider account belongs to. An
+// unknown account is linked to the local user with the same email, but only if the provider
+// has verified that address; otherwise the user has to link it explicitly while logged in.
+// A provider run by a tenant only vouches for addresses of that tenant's members.
+func (s *UserServer) ResolveExternalIdentity(ctx context.Context, req *users.ResolveExternalIdentityRequest) (*users.ResolveExternalIdentityResponse, error) {
+	if req.GetProvider() == "" || req.GetSubject() == "" {
+		return nil, status.Error(codes.InvalidArgument, "provider and subject are required")
+	}
+
+	userID, err := s.Models.Identity.GetUserID(req.GetProvider(), req.GetSubject())
+	if err == nil {
+		user, err := s.Models.User.GetUserByID(userID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to load user: %v", err)
+		}
+		if err := loginBlocked(user); err != nil {
+			return nil, err
+		}
+		return &users.ResolveExternalIdentityResponse{UserId: userID}, nil
+	}
+	if !errors.Is(err, data.ErrIdentityNotFound) {
+		return nil, status.Errorf(codes.Internal, "failed to look up identity: %v", err)
+	}
+
+	if !req.GetEmailVerified() || req.GetEmail() == "" {
+		return nil, status.Error(codes.NotFound, "identity is not linked to a user")
+	}
+
+	user, err := s.Models.User.GetUserByEmail(req.GetEmail())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "identity is not linked to a user")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to load user: %v", err)
+	}
+	if err := loginBlocked(user); err != nil {
+		return nil, err
+	}
+	if req.GetTenant() != "" {
+		_, err := s.Models.Tenant.Membership(req.GetTenant(), user.ID)
+		if err != nil {
+			if errors.Is(err, data.ErrNotTenantMember) {
+				return nil, status.Error(codes.NotFound, "identity is not linked to a user")
+			}
+			return nil, status.Errorf(codes.Internal, "failed to check membership: %v", err)
+		}
+	}
+
+	_, err = s.Models.Identity.Link(user.ID, req.GetProvider(), req.GetSubject(), req.GetEmail())
+	if err != nil {
+		if errors.Is(err, data.ErrIdentityLinked) {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to link identity: %v", err)
+	}
+
+	// The provider has verified the address the account was matched by
+	err = s.Models.User.MarkEmailVerified(user.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to mark email verified: %v", err)
+	}
+
+	return &users.ResolveExternalIdentityResponse{UserId: user.ID, Linked: true}, nil
+}
+
+// LinkExternalIdentity links an identity provider account to a user who has proven both
+// that they own the account and that they are logged in as the user.
+func (s *UserServer) LinkExternalIdentity(ctx context.Context, req *users.LinkExternalIdentityRequest) (*users.LinkExternalIdentityResponse, error) {
+	if req.GetUserId() < 1 || req.GetProvider() == "" || req.GetSubject() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id, provider and subject are required")
+	}
+
+	id, err := s.Models.Identity.Link(req.GetUserId(), req.GetProvider(), req.GetSubject(), req.GetEmail())
+	if err != nil {
+		if errors.Is(err, data.ErrIdentityLinked) {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "failed to link identity: %v", err)
+	}
+
+	return &users.LinkExternalIdentityResponse{Id: id}, nil
+}
+
+// ListUsers streams the user directory to admin tooling, with the same filters and sort
+// orders as the HTTP directory.
+func (s *UserServer) ListUsers(req *users.ListUsersRequest, stream users.UserService_ListUsersServer) error {
+	query := data.DirectoryQuery{
+		Search:     req.GetQuery(),
+		Status:     req.GetStatus(),
+		Sort:       req.GetSort(),
+		Descending: req.GetDescending(),
+		Cursor:     req.GetCursor(),
+		Limit:      int(req.GetLimit()),
+		Tenant:     req.GetTenant(),
+	}
+	if req.GetLimit() < 0 {
+		return status.Error(codes.InvalidArgument, "limit cannot be negative")
+	}
+	if req.GetCreatedAfter() != 0 {
+		t := time.Unix(req.GetCreatedAfter(), 0)
+		query.CreatedAfter = &t
+	}
+	if req.GetCreatedBefore() != 0 {
+		t := time.Unix(req.GetCreatedBefore(), 0)
+		query.CreatedBefore = &t
+	}
+	if req.GetVerified() != "" {
+		verified, err := strconv.ParseBool(req.GetVerified())
+		if err != nil {
+			return status.Error(codes.InvalidArgument, "verified must be true or false")
+		}
+		query.Verified = &verified
+	}
+
+	err := query.Validate()
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = s.Models.User.Directory(stream.Context(), query, func(user *data.User, cursor string) error {
+		summary := &users.UserSummary{
+			UserId:    user.ID,
+			Email:     user.Email,
+			Username:  user.UserName,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Active:    user.Active,
+			Status:    user.AccountStatus(),
+			CreatedAt: user.CreatedAt.Unix(),
+			Cursor:    cursor,
+		}
+		if user.EmailVerifiedAt != nil {
+			summary.EmailVerifiedAt = user.EmailVerifiedAt.Unix()
+		}
+		return stream.Send(summary)
+	})
+	if err != nil {
+		if status.Code(err) != codes.Unknown {
+			return err
+		}
+		return status.Errorf(codes.Internal, "failed to list users: %v", err)
+	}
+
+	return nil
+}
+
+func userToProto(user *data.User) *users.User {
+	u := &users.User{
+		UserId:       user.ID,
+		Email:        user.Email,
+		Username:     user.UserName,
+		FirstName:    user.FirstName,
+		LastName:     user.LastName,
+		Phone:        user.Phone,
+		Address:      user.Address,
+		City:         user.City,
+		State:        user.State,
+		ZipCode:      user.ZipCode,
+		AuthSource:   user.AuthSource,
+		Status:       user.AccountStatus(),
+		StatusReason: user.StatusReason,
+		CreatedAt:    user.CreatedAt.Unix(),
+		UpdatedAt:    user.UpdatedAt.Unix(),
+	}
+	if user.EmailVerifiedAt != nil {
+		u.EmailVerifiedAt = user.EmailVerifiedAt.Unix()
+	}
+	if user.SuspendedUntil != nil {
+		u.SuspendedUntil = user.SuspendedUntil.Unix()
+	}
+	return u
+}
+
+// inTenant answers NotFound for users outside the tenant, if the request is scoped to one.
+func (s *UserServer) inTenant(tenant string, userID int64) error {
+	inScope, err := s.Models.Tenant.InScope(data.TenantScope{Tenant: tenant}, userID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to load user: %v", err)
+	}
+	if !inScope {
+		return status.Error(codes.NotFound, "user not found")
+	}
+	return nil
+}
+
+// GetUser returns a user's profile.
+func (s *UserServer) GetUser(ctx context.Context, req *users.GetUserRequest) (*users.User, error) {
+	if req.GetUserId() < 1 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	err := s.inTenant(req.GetTenant(), req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.Models.User.GetProfile(req.GetUserId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to load user: %v", err)
+	}
+
+	return userToProto(user), nil
+}
+
+// GetUsersByIDs returns the profiles of several users, in the order they were asked for,
+// and the IDs of those that do not exist. Users outside the request's tenant count as
+// missing.
+func (s *UserServer) GetUsersByIDs(ctx context.Context, req *users.GetUsersByIDsRequest) (*users.GetUsersByIDsResponse, error) {
+	ids := req.GetUserIds()
+	if len(ids) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_ids is required")
+	}
+	if len(ids) > getUsersByIDsMaxIDs {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d user_ids can be looked up at once", getUsersByIDsMaxIDs)
+	}
+	for _, id := range ids {
+		if id < 1 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid user_id %d", id)
+		}
+	}
+
+	profiles, err := s.Models.User.GetProfiles(ids)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load users: %v", err)
+	}
+
+	scope := data.TenantScope{Tenant: req.GetTenant()}
+	var tenants map[int64][]string
+	if !scope.Platform() {
+		tenants, err = s.Models.Tenant.TenantsOf(ids...)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to load tenants: %v", err)
+		}
+	}
+
+	byID := make(map[int64]*data.User, len(profiles))
+	for _, user := range profiles {
+		if scope.Allows(tenants[user.ID]) {
+			byID[user.ID] = user
+		}
+	}
+
+	response := &users.GetUsersByIDsResponse{}
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if user, ok := byID[id]; ok {
+			response.Users = append(response.Users, userToProto(user))
+		} else {
+			response.MissingIds = append(response.MissingIds, id)
+		}
+	}
+
+	return response, nil
+}
+
+// UpdateUserStatus activates, deactivates, suspends or bans a user. Only active users can
+// log in.
+func (s *UserServer) UpdateUserStatus(ctx context.Context, req *users.UpdateUserStatusRequest) (*users.User, error) {
+	if req.GetUserId() < 1 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	change := data.StatusChange{
+		Status: req.GetStatus(),
+		Reason: req.GetReason(),
+	}
+	if req.GetSuspendedUntil() != 0 {
+		until := time.Unix(req.GetSuspendedUntil(), 0)
+		change.SuspendedUntil = &until
+	}
+
+	err := change.Validate()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = s.inTenant(req.GetTenant(), req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	// A user's status counts in every tenant they belong to
+	owns, err := s.Models.Tenant.OwnsAccount(data.TenantScope{Tenant: req.GetTenant()}, req.GetUserId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load tenants: %v", err)
+	}
+	if !owns {
+		return nil, status.Error(codes.PermissionDenied, "user also belongs to other tenants, only a platform admin can change their status")
+	}
+
+	actor := req.GetActor()
+	if actor == "" {
+		actor = "unknown"
+	}
+
+	user, err := s.ChangeUserStatus(req.GetUserId(), change, actor)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to update user: %v", err)
+	}
+
+	return userToProto(user), nil
+}
+
+// GetUserTenants returns the tenants a user is a member of, in the order they joined them.
+// The first is the one they log in to.
+func (s *UserServer) GetUserTenants(ctx context.Context, req *users.GetUserTenantsRequest) (*users.GetUserTenantsResponse, error) {
+	if req.GetUserId() < 1 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	memberships, err := s.Models.Tenant.Memberships(req.GetUserId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load tenants: %v", err)
+	}
+
+	response := &users.GetUserTenantsResponse{}
+	for _, m := range memberships {
+		response.Memberships = append(response.Memberships, &users.TenantMembership{
+			Tenant: m.Tenant,
+			Name:   m.DisplayName,
+			Role:   m.Role,
+		})
+	}
+
+	return response, nil
+}
+
+// WatchUserEvents streams changes to users until the client goes away. Events are read
+// from the user_events table, so the stream continues where it left off across instances
+// and restarts, for as long as the events are kept.
+func (s *UserServer) WatchUserEvents(req *users.WatchUserEventsRequest, stream users.UserService_WatchUserEventsServer) error {
+	ctx := stream.Context()
+
+	types := make(map[string]bool, len(req.GetTypes()))
+	for _, t := range req.GetTypes() {
+		if !data.ValidUserEventType(t) {
+			return status.Errorf(codes.InvalidArgument, "unknown event type %q", t)
+		}
+		types[t] = true
+	}
+
+	afterID := req.GetAfterId()
+	if afterID < 0 {
+		return status.Error(codes.InvalidArgument, "after_id cannot be negative")
+	}
+	if afterID == 0 {
+		var err error
+		afterID, err = s.Models.UserEvent.LastID(ctx)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to load events: %v", err)
+		}
+	}
+
+	ticker := time.NewTicker(userEventPollInterval)
+	defer ticker.Stop()
+
+	for {
+		events, err := s.Models.UserEvent.After(ctx, afterID, userEventBatchSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			return status.Errorf(codes.Internal, "failed to load events: %v", err)
+		}
+
+		for _, e := range events {
+			afterID = e.ID
+			if len(types) > 0 && !types[e.Type] {
+				continue
+			}
+
+			err := stream.Send(&users.UserEvent{
+				Id:         e.ID,
+				UserId:     e.UserID,
+				Type:       e.Type,
+				OccurredAt: e.OccurredAt.Unix(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// Catch up without waiting while there is a backlog
+		if len(events) == userEventBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func notificationPreferencesToProto(userID int64, p *data.NotificationPreferences) *users.NotificationPreferences {
+	response := &users.NotificationPreferences{
+		UserId:     userID,
+		Email:      p.Channels.Email,
+		InApp:      p.Channels.InApp,
+		Webhook:    p.Channels.Webhook,
+		WebhookUrl: p.WebhookURL,
+		Categories: p.Categories,
+		Digest:     p.Digest,
+		TimeZone:   p.TimeZone,
+	}
+	if p.QuietHours != nil {
+		response.QuietHoursStart = p.QuietHours.Start
+		response.QuietHoursEnd = p.QuietHours.End
+	}
+	if p.UpdatedAt != nil {
+		response.UpdatedAt = p.UpdatedAt.Unix()
+	}
+	return response
+}
+
+// GetNotificationPreferences returns how a user wants to be notified.
+func (s *UserServer) GetNotificationPreferences(ctx context.Context, req *users.GetNotificationPreferencesRequest) (*users.NotificationPreferences, error) {
+	if req.GetUserId() < 1 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	_, err := s.Models.User.GetProfile(req.GetUserId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to load user: %v", err)
+	}
+
+	preferences, err := s.Models.NotificationPreference.Get(req.GetUserId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load notification preferences: %v", err)
+	}
+
+	return notificationPreferencesToProto(req.GetUserId(), preferences), nil
+}
+
+// CheckNotification tells a sender what to do with a notification for a user right now,
+// and where to deliver it.
+func (s *UserServer) CheckNotification(ctx context.Context, req *users.CheckNotificationRequest) (*users.CheckNotificationResponse, error) {
+	if req.GetUserId() < 1 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	switch req.GetChannel() {
+	case data.NotificationChannelEmail, data.NotificationChannelInApp, data.NotificationChannelWebhook:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown channel %q", req.GetChannel())
+	}
+
+	user, err := s.Models.User.GetProfile(req.GetUserId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to load user: %v", err)
+	}
+
+	preferences, err := s.Models.NotificationPreference.Get(user.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load notification preferences: %v", err)
+	}
+
+	decision := preferences.Decide(req.GetChannel(), req.GetCategory(), time.Now())
+
+	response := &users.CheckNotificationResponse{Reason: decision.Reason}
+	switch decision.Decision {
+	case data.NotificationSend:
+		response.Decision = users.NotificationDecision_NOTIFICATION_DECISION_SEND
+	case data.NotificationSuppress:
+		response.Decision = users.NotificationDecision_NOTIFICATION_DECISION_SUPPRESS
+	case data.NotificationDefer:
+		response.Decision = users.NotificationDecision_NOTIFICATION_DECISION_DEFER
+		response.DeliverAfter = decision.DeliverAfter.Unix()
+	case data.NotificationDigest:
+		response.Decision = users.NotificationDecision_NOTIFICATION_DECISION_DIGEST
+	}
+
+	switch req.GetChannel() {
+	case data.NotificationChannelEmail:
+		response.Address = user.Email
+	case data.NotificationChannelWebhook:
+		response.Address = preferences.WebhookURL
+	}
+
+	return response, nil
+}
+
+// purgeUserEvents deletes old user events once an hour, until the process exits.
+func (app *Config) purgeUserEvents() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := app.Models.UserEvent.Purge()
+		if err != nil {
+			log.Printf("Failed to purge old user events: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d old user events", purged)
+		}
+	}
+}
+
 // gRPCListen starts the gRPC server for the user service
 func (app *Config) gRPCListen() {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", gRPCPort))
@@ -60,7 +755,11 @@ func (app *Config) gRPCListen() {
 	}
 
 	s := grpc.NewServer()
-	users.RegisterUserServiceServer(s, &UserServer{Models: app.Models})
+	users.RegisterUserServiceServer(s, &UserServer{
+		Models:           app.Models,
+		Credentials:      app.Credentials,
+		ChangeUserStatus: app.changeUserStatus,
+	})
 
 	log.Printf("gRPC Server started on port %s", gRPCPort)
 
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"password"
+	"proto/users"
+	"user-service/data"
+)
+
+// arrayConverter passes ID lists through as they are, the way lib/pq takes them for ANY($1).
+type arrayConverter struct{}
+
+func (arrayConverter) ConvertValue(v any) (driver.Value, error) {
+	if ids, ok := v.([]int64); ok {
+		return ids, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func newTestUserServer(t *testing.T) (*UserServer, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return &UserServer{Models: data.New(db, &password.Hasher{Algorithm: password.AlgorithmBcrypt, BcryptCost: 4})}, mock
+}
+
+func profileRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "username", "email", "first_name", "last_name", "phone", "address", "city", "state",
+		"zip_code", "external_id", "active", "status", "status_reason", "suspended_until", "email_verified_at", "auth_source", "scim_tenant", "avatar_key", "created_at", "updated_at"})
+}
+
+func TestGetUsersByIDs(t *testing.T) {
+	server, mock := newTestUserServer(t)
+
+	// More IDs than the cap are refused before the database is asked
+	ids := make([]int64, getUsersByIDsMaxIDs+1)
+	for i := range ids {
+		ids[i] = int64(i + 1)
+	}
+	_, err := server.GetUsersByIDs(context.Background(), &users.GetUsersByIDsRequest{UserIds: ids})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("%d IDs: err = %v, want %v", len(ids), err, codes.InvalidArgument)
+	}
+
+	// Unknown IDs are reported once each, in the order they were asked for
+	now := time.Now()
+	mock.ExpectQuery(`FROM users WHERE id = ANY\(\$1\)`).
+		WithArgs([]int64{9, 7, 8, 9}).
+		WillReturnRows(profileRows().
+			AddRow(7, "jane", "jane@example.com", "Jane", "", "", "", "", "", "", "", true, data.UserStatusActive, "", nil, nil, "local", "", "", now, now))
+
+	response, err := server.GetUsersByIDs(context.Background(), &users.GetUsersByIDsRequest{UserIds: []int64{9, 7, 8, 9}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.GetUsers()) != 1 || response.GetUsers()[0].GetUserId() != 7 {
+		t.Errorf("users = %v, want user 7", response.GetUsers())
+	}
+	if missing := response.GetMissingIds(); len(missing) != 2 || missing[0] != 9 || missing[1] != 8 {
+		t.Errorf("missing_ids = %v, want [9 8]", missing)
+	}
+}
+
+// eventStream collects the events sent to it and ends the call once it has enough.
+type eventStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	cancel context.CancelFunc
+	want   int
+	events []*users.UserEvent
+}
+
+func (s *eventStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *eventStream) Send(e *users.UserEvent) error {
+	s.events = append(s.events, e)
+	if len(s.events) == s.want {
+		s.cancel()
+	}
+	return nil
+}
+
+func newEventStream(want int, timeout time.Duration) *eventStream {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return &eventStream{ctx: ctx, cancel: cancel, want: want}
+}
+
+func eventRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "user_id", "type", "occurred_at"})
+}
+
+func TestWatchUserEventsResumes(t *testing.T) {
+	server, mock := newTestUserServer(t)
+	now := time.Now()
+
+	// The stream continues after the client's cursor, and events it leaves out still move
+	// the cursor on
+	mock.ExpectQuery(`FROM user_events\s+WHERE id > \$1`).
+		WithArgs(int64(5), sqlmock.AnyArg(), userEventBatchSize).
+		WillReturnRows(eventRows().
+			AddRow(6, 7, data.UserEventUpdated, now).
+			AddRow(7, 8, data.UserEventCreated, now))
+	mock.ExpectQuery(`FROM user_events\s+WHERE id > \$1`).
+		WithArgs(int64(7), sqlmock.AnyArg(), userEventBatchSize).
+		WillReturnRows(eventRows().
+			AddRow(8, 7, data.UserEventUpdated, now))
+
+	stream := newEventStream(2, 5*time.Second)
+	defer stream.cancel()
+
+	err := server.WatchUserEvents(&users.WatchUserEventsRequest{AfterId: 5, Types: []string{data.UserEventUpdated}}, stream)
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("err = %v, want %v", err, codes.Canceled)
+	}
+	if len(stream.events) != 2 || stream.events[0].GetId() != 6 || stream.events[1].GetId() != 8 {
+		t.Fatalf("events = %v, want 6 and 8", stream.events)
+	}
+}
+
+func TestWatchUserEventsStartsAtLatest(t *testing.T) {
+	server, mock := newTestUserServer(t)
+
+	// Without a cursor only events from now on are sent
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(id\), 0\) FROM user_events`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(41))
+	mock.ExpectQuery(`FROM user_events\s+WHERE id > \$1`).
+		WithArgs(int64(41), sqlmock.AnyArg(), userEventBatchSize).
+		WillReturnRows(eventRows())
+
+	stream := newEventStream(1, 100*time.Millisecond)
+	defer stream.cancel()
+
+	err := server.WatchUserEvents(&users.WatchUserEventsRequest{}, stream)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, codes.DeadlineExceeded)
+	}
+	if len(stream.events) != 0 {
+		t.Fatalf("events = %v, want none", stream.events)
+	}
+}
+
+func TestResolveExternalIdentityLinksTenantMembersOnly(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		member bool
+		want   codes.Code
+	}{
+		{"member of the tenant", true, codes.OK},
+		{"member of another tenant", false, codes.NotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			server := &UserServer{Models: app.Models}
+			now := time.Now()
+
+			app.mock.ExpectQuery(`UPDATE user_identities SET last_login_at = NOW\(\)`).
+				WithArgs("saml:acme", "jane").
+				WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+			app.mock.ExpectQuery(`SELECT .+ FROM users WHERE email = \$1`).
+				WithArgs("jane@example.com").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "email", "username", "passwordhash", "auth_source", "active", "created_at", "updated_at"}).
+					AddRow(7, "jane@example.com", "jane", "", "local", true, now, now))
+
+			rows := sqlmock.NewRows([]string{"tenant", "display_name", "user_id", "role", "created_at"})
+			if tt.member {
+				rows.AddRow("acme", "Acme", 7, "member", now)
+			}
+			app.mock.ExpectQuery(`FROM tenant_members m JOIN tenants t`).
+				WithArgs("acme", int64(7)).
+				WillReturnRows(rows)
+
+			if tt.member {
+				app.mock.ExpectQuery(`INSERT INTO user_identities`).
+					WithArgs(int64(7), "saml:acme", "jane", "jane@example.com").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				app.mock.ExpectExec(`UPDATE users SET email_verified_at = NOW\(\)`).
+					WithArgs(int64(7)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			response, err := server.ResolveExternalIdentity(context.Background(), &users.ResolveExternalIdentityRequest{
+				Provider:      "saml:acme",
+				Subject:       "jane",
+				Email:         "jane@example.com",
+				EmailVerified: true,
+				Tenant:        "acme",
+			})
+			if code := status.Code(err); code != tt.want {
+				t.Fatalf("code = %v, want %v: %v", code, tt.want, err)
+			}
+			if tt.member && (response.GetUserId() != 7 || !response.GetLinked()) {
+				t.Fatalf("response = %v, want user 7 linked", response)
+			}
+		})
+	}
+}
+
+func TestGetUserTenants(t *testing.T) {
+	server, mock := newTestUserServer(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM tenant_members m JOIN tenants t ON t.name = m.tenant\s+WHERE m.user_id = \$1`).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"tenant", "display_name", "user_id", "role", "created_at"}).
+			AddRow("acme", "Acme", 7, data.TenantRoleAdmin, now).
+			AddRow("globex", "Globex", 7, data.TenantRoleMember, now))
+
+	response, err := server.GetUserTenants(context.Background(), &users.GetUserTenantsRequest{UserId: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memberships := response.GetMemberships()
+	if len(memberships) != 2 {
+		t.Fatalf("memberships = %v, want 2", memberships)
+	}
+	if m := memberships[0]; m.GetTenant() != "acme" || m.GetName() != "Acme" || m.GetRole() != data.TenantRoleAdmin {
+		t.Errorf("first membership = %v", m)
+	}
+
+	// Users outside any tenant have no memberships, which is not an error
+	mock.ExpectQuery(`FROM tenant_members m JOIN tenants t`).
+		WithArgs(int64(8)).
+		WillReturnRows(sqlmock.NewRows([]string{"tenant", "display_name", "user_id", "role", "created_at"}))
+
+	response, err = server.GetUserTenants(context.Background(), &users.GetUserTenantsRequest{UserId: 8})
+	if err != nil || len(response.GetMemberships()) != 0 {
+		t.Fatalf("user without tenants: %v, %v", response, err)
+	}
+
+	_, err = server.GetUserTenants(context.Background(), &users.GetUserTenantsRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("without user_id: err = %v, want %v", err, codes.InvalidArgument)
+	}
+}
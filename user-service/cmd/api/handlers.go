@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+
+	"password"
 	"user-service/data"
 )
 
@@ -29,7 +33,7 @@ func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
 
 	_, err = app.Models.User.GetUserByEmail(requestPayload.Email)
 	if err == nil {
-		app.errorJSON(w, fmt.Errorf("user with email %s already exists"), http.StatusConflict)
+		app.errorJSON(w, fmt.Errorf("user with email %s already exists", requestPayload.Email), http.StatusConflict)
 		return
 	}
 
@@ -38,6 +42,10 @@ func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !app.checkPassword(w, requestPayload.Password, password.Identity{Email: requestPayload.Email, Username: requestPayload.Username}) {
+		return
+	}
+
 	newUser := data.User{
 		Email:        requestPayload.Email,
 		UserName:     requestPayload.Username,
@@ -48,12 +56,12 @@ func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := app.Models.User.InsertUser(newUser)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.hashError(w, err)
 		return
 	}
 
 	start := time.Now()
-	err = app.logRequest("registration", fmt.Sprintf("User %s registered", newUser.Email))
+	err = app.logUserRequest(int64(userID), "registration", fmt.Sprintf("User %s registered", newUser.Email))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -75,29 +83,6 @@ func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GetAll retrieves all users from the database and sends them as a JSON response.
-func (app *Config) GetAll(w http.ResponseWriter, r *http.Request) {
-	// Call the GetAllUsers method to fetch the users from the database.
-	users, err := app.Models.User.GetAllUsers()
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
-
-	payload := jsonResponse{
-		Error:   false,
-		Message: "Users retrieved successfully",
-		Data: map[string]interface{}{
-			"users": users,
-		},
-	}
-
-	err = app.writeJSON(w, http.StatusOK, payload)
-	if err != nil {
-		app.errorJSON(w, err)
-	}
-}
-
 // CheckEmail handles checking if a user with the provided email exists in the database.
 func (app *Config) CheckEmail(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
@@ -137,52 +122,8 @@ func (app *Config) CheckEmail(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// ResetPassword handles the request to reset a user's password by generating a token and sending an email.
-func (app *Config) ResetPassword(w http.ResponseWriter, r *http.Request) {
-	var requestPayload struct {
-		Email string `json:"email"`
-	}
-
-	err := app.readJSON(w, r, &requestPayload)
-	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
-		return
-	}
-
-	user, err := app.Models.User.GetUserByEmail(requestPayload.Email)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			app.errorJSON(w, fmt.Errorf("user with email %s does not exist", requestPayload.Email), http.StatusNotFound)
-			return
-		}
-		app.errorJSON(w, err)
-		return
-	}
-
-	token, err := app.Models.Token.GenerateAndSavePasswordResetToken(requestPayload.Email, int(user.ID))
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
-
-	if err := app.SendResetPasswordEmail(requestPayload.Email, token.PlainText); err != nil {
-		app.errorJSON(w, err)
-		return
-	}
-
-	payload := jsonResponse{
-		Error:   false,
-		Message: "Password reset email sent successfully",
-	}
-
-	err = app.writeJSON(w, http.StatusOK, payload)
-	if err != nil {
-		app.errorJSON(w, err)
-	}
-}
-
-// SendResetPasswordEmail sends a reset password link with a token to the user's email
-func (app *Config) SendResetPasswordEmail(email, token string) error {
+// sendMail sends a plain message through mail-service.
+func (app *Config) sendMail(to, subject, message string) error {
 	type mailMessage struct {
 		From    string `json:"from"`
 		To      string `json:"to"`
@@ -190,13 +131,11 @@ func (app *Config) SendResetPasswordEmail(email, token string) error {
 		Message string `json:"message"`
 	}
 
-	resetLink := fmt.Sprintf("https://fit_new_password.com/reset-password?token=%s", token)
-
 	mailPayload := mailMessage{
 		From:    "no-reply@your-domain.com",
-		To:      email,
-		Subject: "Password Reset Request",
-		Message: fmt.Sprintf("Please use the following link to reset your password: %s", resetLink),
+		To:      to,
+		Subject: subject,
+		Message: message,
 	}
 
 	jsonData, err := json.Marshal(mailPayload)
@@ -226,58 +165,21 @@ func (app *Config) SendResetPasswordEmail(email, token string) error {
 	return nil
 }
 
-// UpdatePassword handles the process of changing the user's password after token verification.
-func (app *Config) UpdatePassword(w http.ResponseWriter, r *http.Request) {
-	var requestPayload struct {
-		Token    string `json:"token"`
-		Password string `json:"password"`
-		Email    string `json:"email"`
-	}
-
-	err := app.readJSON(w, r, &requestPayload)
-	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
-		return
-	}
-
-	isValidToken, err := app.Models.Token.ValidateResetToken(requestPayload.Email, requestPayload.Token)
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
-	if !isValidToken {
-		app.errorJSON(w, fmt.Errorf("invalid or expired token"), http.StatusUnauthorized)
-		return
-	}
-
-	err = app.Models.User.UpdateUserPassword(requestPayload.Email, requestPayload.Password)
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
-
-	payload := jsonResponse{
-		Error:   false,
-		Message: "Password changed successfully",
-	}
-
-	err = app.writeJSON(w, http.StatusOK, payload)
-	if err != nil {
-		app.errorJSON(w, err)
-	}
-}
-
-// UpdateUser handles the update of a user's information based on their ID passed in the URL.
+// UpdateUser handles the update of a user's email and username. The user ID in the URL has
+// to be the authenticated user's; PATCH /me is the preferred way to edit a profile. A new
+// email only takes effect once confirmed, see requestEmailChange.
 func (app *Config) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	vars := r.URL.Query()
-	idStr := vars.Get("user_id")
-
-	userID, err := strconv.ParseInt(idStr, 10, 64)
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
 	if err != nil || userID < 1 {
 		app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
 		return
 	}
 
+	if authUserID, _ := r.Context().Value(ContextKeyUserID).(int64); authUserID != userID {
+		app.errorJSON(w, fmt.Errorf("you can only update your own account"), http.StatusForbidden)
+		return
+	}
+
 	var requestPayload struct {
 		Email    string `json:"email"`
 		Username string `json:"username"`
@@ -289,28 +191,43 @@ func (app *Config) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updatedUser := data.User{
-		ID:        userID,
-		Email:     requestPayload.Email,
-		UserName:  requestPayload.Username,
-		UpdatedAt: time.Now(),
+	update := data.ProfileUpdate{Email: &requestPayload.Email, UserName: &requestPayload.Username}
+	if fields := validateProfile(&update); len(fields) > 0 {
+		app.failedValidation(w, fields)
+		return
+	}
+
+	change, err := app.requestEmailChange(userID, *update.Email)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			app.errorJSON(w, err, http.StatusConflict)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
+	update.Email = nil
 
-	err = app.Models.User.UpdateUser(updatedUser)
+	_, err = app.Models.User.UpdateProfile(userID, update)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	err = app.logRequest("update_user", fmt.Sprintf("User with ID %d updated", userID))
+	err = app.logUserRequest(userID, "update_user", fmt.Sprintf("User with ID %d updated", userID))
 	if err != nil {
 		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
+	message := fmt.Sprintf("User with ID %d updated successfully", userID)
+	if change != nil {
+		message += fmt.Sprintf("; confirm the new email address with the link sent to %s", change.NewEmail)
+	}
+
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("User with ID %d updated successfully", userID),
+		Message: message,
 	}
 
 	err = app.writeJSON(w, http.StatusOK, payload)
@@ -330,13 +247,26 @@ func (app *Config) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The avatar is in the object store, and its key goes with the user
+	user, err := app.Models.User.GetProfile(userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	err = app.Models.User.DeleteUserByID(userID)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	err = app.logRequest("delete_user", fmt.Sprintf("User with ID %d deleted", userID))
+	if user != nil {
+		if err := app.deleteAvatarObjects(user.AvatarKey); err != nil {
+			log.Println(err)
+		}
+	}
+
+	err = app.logUserRequest(userID, "delete_user", fmt.Sprintf("User with ID %d deleted", userID))
 	if err != nil {
 		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
@@ -354,11 +284,19 @@ func (app *Config) DeleteUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Config) logRequest(name, data string) error {
+	return app.logUserRequest(0, name, data)
+}
+
+// logUserRequest logs an entry about a user. Entries are tagged with the user's ID so that
+// data exports and erasures can find them in logger-service.
+func (app *Config) logUserRequest(userID int64, name, data string) error {
 	var entry struct {
-		Name string `json:"name"`
-		Data string `json:"data"`
+		UserID int64  `json:"user_id,omitempty"`
+		Name   string `json:"name"`
+		Data   string `json:"data"`
 	}
 
+	entry.UserID = userID
 	entry.Name = name
 	entry.Data = data
 
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"strconv"
+
+	"password"
 )
 
 type jsonResponse struct {
@@ -72,6 +75,31 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 	return app.writeJSON(w, statusCode, payload)
 }
 
+// failedValidation sends a 422 response listing the problems found with each request field
+func (app *Config) failedValidation(w http.ResponseWriter, fields map[string][]password.Violation) error {
+	payload := jsonResponse{
+		Error:   true,
+		Message: "validation failed",
+		Data: map[string]interface{}{
+			"fields": fields,
+		},
+	}
+
+	return app.writeJSON(w, http.StatusUnprocessableEntity, payload)
+}
+
+// checkPassword runs the password policy against a candidate and, if it is rejected,
+// responds with the violations. It reports whether the password can be used.
+func (app *Config) checkPassword(w http.ResponseWriter, candidate string, id password.Identity) bool {
+	violations := app.PasswordPolicy.Check(candidate, id)
+	if len(violations) == 0 {
+		return true
+	}
+
+	app.failedValidation(w, map[string][]password.Violation{"password": violations})
+	return false
+}
+
 // badRequest sends a JSON response with status http.StatusBadRequest, describing the error
 func (app *Config) badRequest(w http.ResponseWriter, r *http.Request, err error) error {
 	var payload struct {
@@ -107,4 +135,18 @@ func (app *Config) invalidCredentials(w http.ResponseWriter) error {
 		return err
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// busyRetryAfter is how many seconds clients are asked to wait when passwords cannot be
+// hashed because too many are being hashed already.
+const busyRetryAfter = 1
+
+// hashError answers a request that failed while hashing a password. A busy hashing pool is
+// reported as 503, so the client retries instead of giving up.
+func (app *Config) hashError(w http.ResponseWriter, err error) error {
+	if errors.Is(err, password.ErrBusy) {
+		w.Header().Set("Retry-After", strconv.Itoa(busyRetryAfter))
+		return app.errorJSON(w, err, http.StatusServiceUnavailable)
+	}
+	return app.errorJSON(w, err)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+
+	"password"
+	"proto/sessions"
+	"user-service/data"
+	"user-service/keys"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+// testSigningKey returns the key test tokens are signed with, made once per run.
+func testSigningKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testKey = key
+	})
+
+	return testKey
+}
+
+// startTestVault serves the signing key the way the key manager reads it from Vault.
+func startTestVault(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := map[string]map[string]string{
+		"/v1/jwt_keys/private_key": {"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))},
+		"/v1/jwt_keys/public_keys": {"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := secrets[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": secret})
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// fakeSessions stands in for auth-service: it keeps revocation cutoffs like TokenModel does
+// and answers IsTokenRevoked from them.
+type fakeSessions struct {
+	mu            sync.Mutex
+	revokedBefore map[int64]int64
+	checks        int
+	err           error
+}
+
+func (f *fakeSessions) RevokeUserSessions(ctx context.Context, in *sessions.RevokeUserSessionsRequest, opts ...grpc.CallOption) (*sessions.RevokeUserSessionsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cutoff := time.Now().Truncate(time.Second).Add(time.Second).Unix()
+	f.revokedBefore[in.GetUserId()] = cutoff
+
+	return &sessions.RevokeUserSessionsResponse{RevokedBefore: cutoff}, nil
+}
+
+func (f *fakeSessions) IsTokenRevoked(ctx context.Context, in *sessions.IsTokenRevokedRequest, opts ...grpc.CallOption) (*sessions.IsTokenRevokedResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.checks++
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(in.GetToken(), claims)
+	if err != nil {
+		return nil, err
+	}
+	userID, _ := claims["user_id"].(float64)
+	issuedAt, _ := claims["iat"].(float64)
+
+	cutoff, ok := f.revokedBefore[int64(userID)]
+	revoked := ok && claims["role"] == "user" && int64(issuedAt) < cutoff
+
+	return &sessions.IsTokenRevokedResponse{Revoked: revoked}, nil
+}
+
+func (f *fakeSessions) checkCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.checks
+}
+
+// logEntry is an entry sent to logger-service.
+type logEntry struct {
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+	Data   string `json:"data"`
+}
+
+// logRecorder keeps the entries sent to logger-service.
+type logRecorder struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *logRecorder) add(e logEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+}
+
+func (l *logRecorder) named(name string) []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []logEntry
+	for _, e := range l.entries {
+		if e.Name == name {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// serviceTransport answers the requests meant for logger-service and mail-service, which
+// are not there in tests, recording the log entries.
+type serviceTransport struct {
+	next http.RoundTripper
+	logs *logRecorder
+}
+
+func (s serviceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	switch r.URL.Host {
+	case "logger-service", "mail-service":
+	default:
+		return s.next.RoundTrip(r)
+	}
+	if r.Body != nil {
+		if r.URL.Host == "logger-service" {
+			var entry logEntry
+			if json.NewDecoder(r.Body).Decode(&entry) == nil {
+				s.logs.add(entry)
+			}
+		}
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+}
+
+// testApp is the service with a mocked database and a fake auth-service, served through
+// its routes.
+type testApp struct {
+	*Config
+	mock     sqlmock.Sqlmock
+	sessions *fakeSessions
+	logs     *logRecorder
+	handler  http.Handler
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	keyManager, err := keys.NewKeyManager(keys.VaultConfig{Address: startTestVault(t, testSigningKey(t)), Token: "test"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(keyManager.Stop)
+
+	logs := &logRecorder{}
+	transport := http.DefaultTransport
+	http.DefaultTransport = serviceTransport{next: transport, logs: logs}
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	fake := &fakeSessions{revokedBefore: map[int64]int64{}}
+	app := &Config{
+		DB:             db,
+		Models:         data.New(db, &password.Hasher{Algorithm: password.AlgorithmBcrypt, BcryptCost: 4, Pool: password.NewPool(1, 1)}),
+		KeyManager:     keyManager,
+		PasswordPolicy: password.DefaultPolicy(),
+		Sessions:       fake,
+	}
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return &testApp{Config: app, mock: mock, sessions: fake, logs: logs, handler: app.routes()}
+}
+
+// token signs an access token for a user or admin, issued a minute ago. extra claims are
+// added or replace the defaults.
+func (a *testApp) token(t *testing.T, role string, id int64, extra jwt.MapClaims) string {
+	t.Helper()
+
+	issuedAt := time.Now().Add(-time.Minute)
+	claims := jwt.MapClaims{
+		"user_id":   id,
+		"role":      role,
+		"scope":     scopeAuthentication,
+		"iat":       issuedAt.Unix(),
+		"auth_time": issuedAt.Unix(),
+		"exp":       issuedAt.Add(15 * time.Minute).Unix(),
+	}
+	for name, value := range extra {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "default"
+
+	signed, err := token.SignedString(testSigningKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// do serves a request, with token as the bearer token unless it is empty.
+func (a *testApp) do(method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	a.handler.ServeHTTP(w, r)
+	return w
+}
+
+// expectStatus expects the middleware to look up a user's status.
+func (a *testApp) expectStatus(userID int64, active bool, status string) {
+	a.mock.ExpectQuery(`SELECT active, status FROM users WHERE id = \$1`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"active", "status"}).AddRow(active, status))
+}
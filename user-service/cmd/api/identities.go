@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"user-service/data"
+)
+
+// ListIdentities returns the external identity provider accounts linked to the authenticated
+// user. Accounts are linked through auth-service's OIDC login.
+func (app *Config) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	identities, err := app.Models.Identity.GetAllForUser(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d linked identities", len(identities)),
+		Data:    identities,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// DeleteIdentity unlinks one of the authenticated user's external accounts.
+func (app *Config) DeleteIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "identity_id"), 10, 64)
+	if err != nil || id < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid identity ID"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Identity.Delete(id, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrIdentityNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "delete_identity", fmt.Sprintf("User %d unlinked identity %d", userID, id))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Identity %d unlinked successfully", id),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
@@ -8,8 +8,12 @@ import (
 	"os"
 	"time"
 
-	"user-service/keys"
+	"password"
+	"proto/sessions"
+	"user-service/credentials"
 	"user-service/data"
+	"user-service/keys"
+	"user-service/storage"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -19,15 +23,34 @@ import (
 const (
 	webPort  = "80"
 	gRPCPort = "50001"
+
+	// defaultAccountURL is used for links in emails unless ACCOUNT_URL is set
+	defaultAccountURL = "http://localhost:8080"
 )
 
 var counts int64
 
 // Config i a structure of a application
 type Config struct {
-	DB     *sql.DB
-	Models data.Models
-	KeyManager  *keys.KeyManager 
+	DB             *sql.DB
+	Models         data.Models
+	KeyManager     *keys.KeyManager
+	PasswordPolicy *password.Policy
+	Credentials    credentials.Chain
+	ObjectStore    storage.ObjectStore
+
+	// Sessions is auth-service, which revokes sessions and knows which tokens are revoked
+	Sessions    sessions.SessionServiceClient
+	revocations revocationCache
+
+	// ErasureGracePeriod is how long users can cancel the erasure of their account
+	ErasureGracePeriod time.Duration
+
+	// AccountURL is the base URL of the account website that links in emails point to
+	AccountURL string
+
+	// ResetURLs are the pages client apps handle password reset links on, by client
+	ResetURLs map[string]string
 }
 
 func main() {
@@ -43,15 +66,70 @@ func main() {
 		log.Fatalf("failed to initialize key manager: %v", err)
 	}
 
+	passwordPolicy, err := password.PolicyFromEnv()
+	if err != nil {
+		log.Fatalf("failed to load password policy: %v", err)
+	}
+
+	passwordHasher, err := password.HasherFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure password hashing: %v", err)
+	}
+	stats := passwordHasher.Pool.Stats()
+	log.Printf("Hashing passwords with %s, %d at a time and up to %d waiting", passwordHasher.Algorithm, stats.Workers, stats.QueueSize)
+
+	erasureGracePeriod := defaultErasureGracePeriod
+	if v := os.Getenv("ERASURE_GRACE_PERIOD"); v != "" {
+		erasureGracePeriod, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid ERASURE_GRACE_PERIOD: %v", err)
+		}
+	}
+
+	accountURL := os.Getenv("ACCOUNT_URL")
+	if accountURL == "" {
+		accountURL = defaultAccountURL
+	}
+
+	resetURLs, err := parseResetURLs(os.Getenv("RESET_URLS"))
+	if err != nil {
+		log.Fatalf("invalid RESET_URLS: %v", err)
+	}
+
 	conn := connectToDB()
 	if conn == nil {
 		log.Panic("Can't connect to Postgres!")
 	}
 
+	models := data.New(conn, passwordHasher)
+
+	credentialChain, err := credentials.ChainFromEnv(&models.User)
+	if err != nil {
+		log.Fatalf("failed to configure credential backends: %v", err)
+	}
+
+	objectStore, err := storage.FromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure object store: %v", err)
+	}
+
+	sessionClient, err := dialAuthService()
+	if err != nil {
+		log.Fatalf("failed to configure auth-service client: %v", err)
+	}
+
 	app := Config{
-		DB:     conn,
-		Models: data.New(conn),
-		KeyManager:  keyManager,
+		DB:             conn,
+		Models:         models,
+		KeyManager:     keyManager,
+		PasswordPolicy: passwordPolicy,
+		Credentials:    credentialChain,
+		ObjectStore:    objectStore,
+		Sessions:       sessionClient,
+
+		ErasureGracePeriod: erasureGracePeriod,
+		AccountURL:         accountURL,
+		ResetURLs:          resetURLs,
 	}
 
 	srv := &http.Server{
@@ -66,7 +144,20 @@ func main() {
 		}
 	}()
 
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		go func() {
+			err := passwordHasher.Pool.ServeMetrics(addr)
+			if err != nil {
+				log.Panic(err)
+			}
+		}()
+	}
+
 	go app.gRPCListen()
+	go app.runDataRequests()
+	go app.purgeUserEvents()
+	go app.runSuspensionExpiry()
+	go app.runUserImports()
 
 	select {}
 }
@@ -94,7 +185,7 @@ func connectToDB() *sql.DB {
 	for {
 		connection, err := openDB(dsn)
 		if err != nil {
-			fmt.Errorf("Error opening database: %w", err)
+			log.Printf("Error opening database: %v", err)
 
 			counts++
 		} else {
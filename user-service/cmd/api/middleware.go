@@ -2,11 +2,21 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
+
+	"apikey"
+	"stepup"
+	"user-service/data"
 )
 
 type contextKey string
@@ -14,6 +24,27 @@ type contextKey string
 // ContextKeyUserID is key to store userID
 const ContextKeyUserID = contextKey("userID")
 
+// ContextKeyActor is key to store the admin acting on behalf of the user, if any
+const ContextKeyActor = contextKey("actor")
+
+// scopeAuthentication is the scope of access tokens; refresh tokens have another
+const scopeAuthentication = "authentication"
+
+// ContextKeyAPIKey is key to store the API key a request was authenticated with, if any
+const ContextKeyAPIKey = contextKey("apiKey")
+
+// ContextKeyTenant is key to store the user's membership of the tenant their token is for, if any
+const ContextKeyTenant = contextKey("tenant")
+
+// ContextKeyTenantScope is key to store the tenants an admin's token lets them manage
+const ContextKeyTenantScope = contextKey("tenantScope")
+
+// Actor identifies an admin acting on behalf of a user (the RFC 8693 "act" claim).
+type Actor struct {
+	ID   int64
+	Role string
+}
+
 // AuthMiddleware checks Authentication Header for both users and admins
 func (app *Config) AuthMiddleware(requiredRole string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -31,6 +62,12 @@ func (app *Config) AuthMiddleware(requiredRole string) func(http.Handler) http.H
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
+			// API keys are accepted alongside JWTs
+			if strings.HasPrefix(tokenString, data.APIKeyPrefix) {
+				app.authenticateAPIKey(w, r, next, tokenString, requiredRole)
+				return
+			}
+
 			// Parse the token and verify it
 			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 				if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
@@ -60,6 +97,12 @@ func (app *Config) AuthMiddleware(requiredRole string) func(http.Handler) http.H
 				return
 			}
 
+			// Refresh tokens are only for getting new access tokens from auth-service
+			if scope, _ := claims["scope"].(string); scope != scopeAuthentication {
+				app.errorJSON(w, fmt.Errorf("invalid token scope"), http.StatusUnauthorized)
+				return
+			}
+
 			// Check the role
 			role, ok := claims["role"].(string)
 			if !ok || role != requiredRole {
@@ -74,11 +117,236 @@ func (app *Config) AuthMiddleware(requiredRole string) func(http.Handler) http.H
 				return
 			}
 
+			// Logouts and session revocations are recorded by auth-service
+			revoked, err := app.tokenRevoked(r.Context(), tokenString)
+			if err != nil {
+				app.errorJSON(w, fmt.Errorf("unable to check whether the token was revoked"), http.StatusServiceUnavailable)
+				return
+			}
+			if revoked {
+				app.errorJSON(w, fmt.Errorf("token has been revoked"), http.StatusUnauthorized)
+				return
+			}
+
+			if role == "user" && !app.requireActiveUser(w, int64(userID)) {
+				return
+			}
+
 			// Store the user ID in the context
 			ctx := context.WithValue(r.Context(), ContextKeyUserID, int64(userID))
 
+			if authTime, ok := claims["auth_time"].(float64); ok {
+				ctx = stepup.WithAuthTime(ctx, time.Unix(int64(authTime), 0))
+			}
+
+			// Tokens name the tenant their holder is acting in. Admins without one manage
+			// every tenant; users have to still be members of theirs, with the role they
+			// have now rather than the one the token was issued with.
+			tenant, _ := claims["tenant"].(string)
+			switch role {
+			case "admin":
+				ctx = context.WithValue(ctx, ContextKeyTenantScope, data.TenantScope{Tenant: tenant})
+			case "user":
+				if tenant != "" {
+					membership, err := app.Models.Tenant.Membership(tenant, int64(userID))
+					if err != nil {
+						if errors.Is(err, data.ErrNotTenantMember) {
+							app.errorJSON(w, fmt.Errorf("you are no longer a member of tenant %s, switch to another tenant", tenant), http.StatusForbidden)
+							return
+						}
+						app.errorJSON(w, err)
+						return
+					}
+					ctx = context.WithValue(ctx, ContextKeyTenant, membership)
+				}
+			}
+
+			// Impersonated requests are audited under both identities before they are served
+			if act, ok := claims["act"].(map[string]interface{}); ok {
+				actor, err := actorFromClaim(act)
+				if err != nil {
+					app.errorJSON(w, err, http.StatusUnauthorized)
+					return
+				}
+
+				err = app.logUserRequest(int64(userID), "impersonated_request", fmt.Sprintf("Admin %d acting as user %d: %s %s", actor.ID, int64(userID), r.Method, r.URL.Path))
+				if err != nil {
+					app.errorJSON(w, fmt.Errorf("unable to audit impersonated request"), http.StatusServiceUnavailable)
+					return
+				}
+
+				ctx = context.WithValue(ctx, ContextKeyActor, actor)
+			}
+
 			// Call the next handler with the new context
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// authenticateAPIKey serves the request on behalf of the owner of an API key. Read-only keys
+// are limited to safe methods.
+func (app *Config) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plainText, requiredRole string) {
+	if requiredRole != "user" {
+		app.errorJSON(w, fmt.Errorf("unauthorized: API keys cannot be used with role %s", requiredRole), http.StatusForbidden)
+		return
+	}
+
+	key, err := app.Models.APIKey.Authenticate(plainText)
+	if err != nil {
+		if errors.Is(err, data.ErrAPIKeyNotFound) {
+			app.errorJSON(w, fmt.Errorf("invalid API key"), http.StatusUnauthorized)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.requireActiveUser(w, key.UserID) {
+		return
+	}
+
+	if scope := apikey.RequiredScope(r.Method); !key.HasScope(scope) {
+		app.errorJSON(w, fmt.Errorf("API key does not have the %s scope", scope), http.StatusForbidden)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), ContextKeyUserID, key.UserID)
+	ctx = context.WithValue(ctx, ContextKeyAPIKey, key)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// requireActiveUser answers for users who may no longer log in, and reports whether the
+// request can go on.
+func (app *Config) requireActiveUser(w http.ResponseWriter, userID int64) bool {
+	status, err := app.Models.User.GetAccountStatus(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, fmt.Errorf("user no longer exists"), http.StatusUnauthorized)
+			return false
+		}
+		app.errorJSON(w, err)
+		return false
+	}
+	if status != data.UserStatusActive {
+		app.errorJSON(w, fmt.Errorf("account is %s", status), http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// DenyAPIKey rejects requests authenticated with an API key. It guards key management and
+// account actions, so a leaked key cannot be used to mint new keys or take over the account.
+func (app *Config) DenyAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(ContextKeyAPIKey).(*data.APIKey); ok {
+			app.errorJSON(w, fmt.Errorf("action not allowed with an API key"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// DenyImpersonation rejects requests made with an impersonation token. It guards actions
+// such as password changes and account deletion that only the user themselves may perform.
+func (app *Config) DenyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actor, ok := r.Context().Value(ContextKeyActor).(Actor); ok {
+			app.errorJSON(w, fmt.Errorf("action not allowed while admin %d is impersonating this user", actor.ID), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tenantScope returns the tenants an admin request may see and change.
+func tenantScope(r *http.Request) data.TenantScope {
+	scope, _ := r.Context().Value(ContextKeyTenantScope).(data.TenantScope)
+	return scope
+}
+
+// RequireUserInScope guards the admin routes for one user: users outside the admin's
+// tenant are answered as if they did not exist.
+func (app *Config) RequireUserInScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+		if err != nil || userID < 1 {
+			app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+			return
+		}
+
+		inScope, err := app.Models.Tenant.InScope(tenantScope(r), userID)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		if !inScope {
+			app.errorJSON(w, fmt.Errorf("user %d not found", userID), http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAccountInScope guards the admin routes that change a user's account rather than
+// their membership of a tenant. Tenant admins may only use them for users who belong to no
+// other tenant; the others need a platform admin.
+func (app *Config) RequireAccountInScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+		if err != nil || userID < 1 {
+			app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+			return
+		}
+
+		owns, err := app.Models.Tenant.OwnsAccount(tenantScope(r), userID)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		if !owns {
+			app.errorJSON(w, fmt.Errorf("user %d also belongs to other tenants, only a platform admin can change their account", userID), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireTenantRole guards the routes that manage the tenant the user is acting in, for
+// members who have one of the roles.
+func (app *Config) RequireTenantRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			membership, ok := r.Context().Value(ContextKeyTenant).(*data.TenantMembership)
+			if !ok {
+				app.errorJSON(w, fmt.Errorf("your token is not for a tenant, switch to one first"), http.StatusForbidden)
+				return
+			}
+			if !slices.Contains(roles, membership.Role) {
+				app.errorJSON(w, fmt.Errorf("your role in tenant %s is %s, which cannot do this", membership.Tenant, membership.Role), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// actorFromClaim reads the "act" claim of an impersonation token.
+func actorFromClaim(act map[string]interface{}) (Actor, error) {
+	sub, _ := act["sub"].(string)
+	role, _ := act["role"].(string)
+
+	id, err := strconv.ParseInt(sub, 10, 64)
+	if err != nil || id < 1 || role != "admin" {
+		return Actor{}, fmt.Errorf("invalid act claim in token")
+	}
+
+	return Actor{ID: id, Role: role}, nil
+}
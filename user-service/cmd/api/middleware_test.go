@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+
+	"apikey"
+	"proto/sessions"
+	"stepup"
+	"user-service/data"
+)
+
+// serveAuthenticated runs a request with token through AuthMiddleware, answering 204 when
+// it gets through.
+func (a *testApp) serveAuthenticated(role, token string) int {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	a.AuthMiddleware(role)(next).ServeHTTP(w, r)
+
+	return w.Code
+}
+
+func TestAuthMiddlewareChecksToken(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		claims jwt.MapClaims
+		status string
+		active bool
+		want   int
+	}{
+		{"access token", nil, "active", true, http.StatusNoContent},
+		{"refresh token", jwt.MapClaims{"scope": "refresh"}, "", false, http.StatusUnauthorized},
+		{"no scope", jwt.MapClaims{"scope": nil}, "", false, http.StatusUnauthorized},
+		{"suspended user", nil, "suspended", true, http.StatusForbidden},
+		{"banned user", nil, "banned", true, http.StatusForbidden},
+		{"deactivated user", nil, "active", false, http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			if tt.status != "" {
+				app.expectStatus(7, tt.active, tt.status)
+			}
+
+			if got := app.serveAuthenticated("user", app.token(t, "user", 7, tt.claims)); got != tt.want {
+				t.Fatalf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareRejectsRevokedTokens(t *testing.T) {
+	app := newTestApp(t)
+	token := app.token(t, "user", 7, nil)
+
+	// Revoked through auth-service, for example by a logout everywhere
+	_, err := app.sessions.RevokeUserSessions(context.Background(), &sessions.RevokeUserSessionsRequest{UserId: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := app.serveAuthenticated("user", token); got != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", got, http.StatusUnauthorized)
+	}
+
+	app.expectStatus(7, true, "active")
+	if got := app.serveAuthenticated("user", app.token(t, "user", 7, jwt.MapClaims{"iat": time.Now().Add(2 * time.Second).Unix()})); got != http.StatusNoContent {
+		t.Fatalf("token issued after the revocation: status = %d, want %d", got, http.StatusNoContent)
+	}
+}
+
+func TestAuthMiddlewareCachesRevocationChecks(t *testing.T) {
+	app := newTestApp(t)
+	token := app.token(t, "admin", 1, nil)
+
+	for i := 0; i < 3; i++ {
+		if got := app.serveAuthenticated("admin", token); got != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", got, http.StatusNoContent)
+		}
+	}
+	if n := app.sessions.checkCount(); n != 1 {
+		t.Fatalf("auth-service asked %d times, want once", n)
+	}
+}
+
+func TestAuthMiddlewareFailsClosed(t *testing.T) {
+	app := newTestApp(t)
+	app.sessions.err = errors.New("auth-service is down")
+
+	if got := app.serveAuthenticated("user", app.token(t, "user", 7, nil)); got != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+}
+
+func TestImpersonatedRequests(t *testing.T) {
+	app := newTestApp(t)
+	token := app.token(t, "user", 7, jwt.MapClaims{"act": map[string]interface{}{"sub": "3", "role": "admin"}})
+
+	// Sensitive account actions are refused to the admin, but still audited
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPatch, "/api/login/me"},
+		{http.MethodDelete, "/api/login/api-keys/1"},
+		{http.MethodPost, "/api/login/data-requests/export"},
+	} {
+		app.expectStatus(7, true, "active")
+		w := app.do(route.method, route.path, token, `{}`)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status = %d, want %d", route.method, route.path, w.Code, http.StatusForbidden)
+		}
+	}
+
+	entries := app.logs.named("impersonated_request")
+	if len(entries) != 3 {
+		t.Fatalf("audit entries = %v, want one per request", entries)
+	}
+	if e := entries[0]; e.UserID != 7 || e.Data != "Admin 3 acting as user 7: PATCH /api/login/me" {
+		t.Errorf("audit entry = %+v, want the admin acting as user 7", e)
+	}
+
+	// The user's own requests are not audited as impersonated
+	app.expectStatus(7, true, "active")
+	if got := app.serveAuthenticated("user", app.token(t, "user", 7, nil)); got != http.StatusNoContent {
+		t.Fatalf("own token: status = %d, want %d", got, http.StatusNoContent)
+	}
+	if entries := app.logs.named("impersonated_request"); len(entries) != 3 {
+		t.Errorf("audit entries after the user's own request = %d, want 3", len(entries))
+	}
+
+	// An act claim that does not name an admin is refused before anything is served
+	forged := app.token(t, "user", 7, jwt.MapClaims{"act": map[string]interface{}{"sub": "3", "role": "user"}})
+	app.expectStatus(7, true, "active")
+	if got := app.serveAuthenticated("user", forged); got != http.StatusUnauthorized {
+		t.Errorf("act claim of a user: status = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+func TestStepUpAfterAuthMiddleware(t *testing.T) {
+	const challenge = `Bearer error="insufficient_user_authentication", error_description="A more recent authentication is required", max_age=300`
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, tt := range []struct {
+		name   string
+		claims jwt.MapClaims
+		want   int
+	}{
+		{"recent login", nil, http.StatusNoContent},
+		{"login older than the limit", jwt.MapClaims{"auth_time": time.Now().Add(-stepup.MaxAge - time.Minute).Unix()}, http.StatusUnauthorized},
+		{"token without auth_time", jwt.MapClaims{"auth_time": nil}, http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			app.expectStatus(7, true, "active")
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+app.token(t, "user", 7, tt.claims))
+			w := httptest.NewRecorder()
+			app.AuthMiddleware("user")(stepup.Require(stepup.MaxAge)(next)).ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != challenge {
+				t.Fatalf("WWW-Authenticate = %q, want the step-up challenge", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	// The sensitive routes are guarded
+	app := newTestApp(t)
+	app.expectStatus(7, true, "active")
+	old := app.token(t, "user", 7, jwt.MapClaims{"auth_time": time.Now().Add(-time.Hour).Unix()})
+	if w := app.do(http.MethodDelete, "/api/login/delete-user/7", old, ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != challenge {
+		t.Fatalf("delete with an old login: status = %d, WWW-Authenticate = %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestAuthMiddlewareAcceptsAPIKeys(t *testing.T) {
+	plainText, shown, err := apikey.Generate(data.APIKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys are looked up by their SHA-256 hash, and only while unrevoked and unexpired
+	const lookup = `UPDATE api_keys SET last_used_at = NOW\(\)\s+WHERE key_hash = \$1 AND revoked_at IS NULL AND \(expires_at IS NULL OR expires_at > NOW\(\)\)`
+	columns := []string{"id", "user_id", "name", "prefix", "scopes", "expires_at", "last_used_at", "created_at"}
+
+	for _, tt := range []struct {
+		name   string
+		scopes string
+		method string
+		want   int
+	}{
+		{"read key, safe method", "read", http.MethodGet, http.StatusNoContent},
+		{"read key, unsafe method", "read", http.MethodPost, http.StatusForbidden},
+		{"write key, unsafe method", "write", http.MethodDelete, http.StatusNoContent},
+		{"revoked or expired key", "", http.MethodGet, http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			rows := sqlmock.NewRows(columns)
+			if tt.scopes != "" {
+				rows.AddRow(1, 7, "ci", shown, tt.scopes, nil, time.Now(), time.Now())
+			}
+			app.mock.ExpectQuery(lookup).WithArgs(apikey.Hash(plainText)).WillReturnRows(rows)
+			if tt.scopes != "" {
+				app.expectStatus(7, true, "active")
+			}
+
+			var userID int64
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userID, _ = r.Context().Value(ContextKeyUserID).(int64)
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			r := httptest.NewRequest(tt.method, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+plainText)
+			w := httptest.NewRecorder()
+			app.AuthMiddleware("user")(next).ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusNoContent && userID != 7 {
+				t.Fatalf("user ID = %d, want the key's owner", userID)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"user-service/data"
+)
+
+// GetNotificationPreferences returns how the authenticated user wants to be notified.
+// Users who have not set any get the defaults.
+func (app *Config) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	preferences, err := app.Models.NotificationPreference.Get(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Notification preferences",
+		Data:    preferences,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// UpdateNotificationPreferences replaces the authenticated user's notification
+// preferences. Fields left out of the request take their default values.
+func (app *Config) UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	preferences := data.DefaultNotificationPreferences()
+	err := app.readJSON(w, r, preferences)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	preferences.UpdatedAt = nil
+
+	err = preferences.Validate()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.NotificationPreference.Set(userID, preferences)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "update_notification_preferences", fmt.Sprintf("User %d updated their notification preferences", userID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Notification preferences updated",
+		Data:    preferences,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"user-service/data"
+)
+
+// ListPasskeys returns the WebAuthn credentials registered by the authenticated user.
+// New passkeys are registered through auth-service.
+func (app *Config) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := app.Models.WebAuthn.GetAllForUser(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d passkeys", len(credentials)),
+		Data:    credentials,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// DeletePasskey removes one of the authenticated user's WebAuthn credentials.
+func (app *Config) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "passkey_id"), 10, 64)
+	if err != nil || id < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid passkey ID"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.WebAuthn.Delete(id, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrCredentialNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "delete_passkey", fmt.Sprintf("User %d deleted passkey %d", userID, id))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Passkey %d deleted successfully", id),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
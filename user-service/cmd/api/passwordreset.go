@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"password"
+	"user-service/data"
+)
+
+// parseResetURLs reads RESET_URLS, a comma-separated list of client=url pairs giving the
+// page each client app handles reset links on.
+func parseResetURLs(s string) (map[string]string, error) {
+	resetURLs := make(map[string]string)
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		client, link, ok := strings.Cut(pair, "=")
+		if !ok || client == "" {
+			return nil, fmt.Errorf("%q is not a client=url pair", pair)
+		}
+
+		u, err := url.Parse(link)
+		if err != nil || u.Scheme == "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("invalid reset URL for client %s: %q", client, link)
+		}
+
+		resetURLs[client] = link
+	}
+
+	return resetURLs, nil
+}
+
+// resetLink returns the link to a client's reset page. An empty client means the account
+// website.
+func (app *Config) resetLink(client, email, token string) (string, error) {
+	base := app.AccountURL + "/reset-password"
+	if client != "" {
+		var ok bool
+		base, ok = app.ResetURLs[client]
+		if !ok {
+			return "", fmt.Errorf("unknown client %q", client)
+		}
+	}
+
+	query := url.Values{"email": {email}, "token": {token}}
+	return base + "?" + query.Encode(), nil
+}
+
+// ResetPassword handles the request to reset a user's password by generating a token and
+// sending an email with a link to the page of the client that asked for it.
+func (app *Config) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email  string `json:"email"`
+		Client string `json:"client"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := app.resetLink(requestPayload.Client, "", ""); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetUserByEmail(requestPayload.Email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			app.errorJSON(w, fmt.Errorf("user with email %s does not exist", requestPayload.Email), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	token, err := app.Models.Token.CreateResetToken(user.ID, data.ResetTokenTTL)
+	if err != nil {
+		if errors.Is(err, data.ErrResetLimit) {
+			app.errorJSON(w, err, http.StatusTooManyRequests)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	link, _ := app.resetLink(requestPayload.Client, user.Email, token.PlainText)
+	err = app.sendMail(user.Email, "Password Reset Request", fmt.Sprintf(
+		"Please use the following link to reset your password. It is valid for %s and can be used once: %s",
+		data.ResetTokenTTL, link))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(user.ID, "reset_password", fmt.Sprintf("User %d requested a password reset", user.ID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password reset email sent successfully",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// UpdatePassword handles the process of changing the user's password after token
+// verification. The token is used up, all of the user's sessions are ended, and the user
+// is told their password has changed.
+func (app *Config) UpdatePassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetUserByEmail(requestPayload.Email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			app.errorJSON(w, data.ErrResetTokenInvalid, http.StatusUnauthorized)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	// A password the policy rejects does not use up the token
+	if !app.checkPassword(w, requestPayload.Password, password.Identity{Email: user.Email, Username: user.UserName}) {
+		return
+	}
+
+	// Neither does a server too busy to hash it
+	hashedPassword, err := app.Models.User.Hasher.Hash(r.Context(), requestPayload.Password)
+	if err != nil {
+		app.hashError(w, err)
+		return
+	}
+
+	err = app.Models.Token.UseResetToken(user.ID, requestPayload.Token)
+	if err != nil {
+		if errors.Is(err, data.ErrResetTokenInvalid) {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.User.UpdateUserPassword(user.Email, hashedPassword)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.revokeUserSessions(user.ID)
+	if err != nil {
+		log.Printf("Failed to revoke the sessions of user %d after a password reset: %v", user.ID, err)
+		app.errorJSON(w, fmt.Errorf("the password was changed, but ending your sessions failed"), http.StatusInternalServerError)
+		return
+	}
+
+	err = app.sendMail(user.Email, "Your password has been changed", fmt.Sprintf(
+		"The password of your account was reset and you have been logged out everywhere. If this was not you, reset your password again at %s/reset-password and contact support.",
+		app.AccountURL))
+	if err != nil {
+		log.Printf("Failed to notify user %d of their password change: %v", user.ID, err)
+	}
+
+	err = app.logUserRequest(user.ID, "update_password", fmt.Sprintf("User %d reset their password", user.ID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password changed successfully",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestUpdatePasswordEndsSessions(t *testing.T) {
+	app := newTestApp(t)
+	token := app.token(t, "user", 7, nil)
+
+	app.expectStatus(7, true, "active")
+	if got := app.serveAuthenticated("user", token); got != http.StatusNoContent {
+		t.Fatalf("before the reset: status = %d, want %d", got, http.StatusNoContent)
+	}
+
+	now := time.Now()
+	resetHash := sha256.Sum256([]byte("reset-token"))
+	app.mock.ExpectQuery(`SELECT id, email, username, passwordhash, auth_source, active, created_at, updated_at FROM users WHERE email = \$1`).
+		WithArgs("jane@example.org").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "username", "passwordhash", "auth_source", "active", "created_at", "updated_at"}).
+			AddRow(7, "jane@example.org", "jane", "", "local", true, now, now))
+	app.mock.ExpectBegin()
+	app.mock.ExpectQuery(`SELECT id, token_hash FROM password_reset_tokens`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "token_hash"}).AddRow(5, resetHash[:]))
+	app.mock.ExpectExec(`UPDATE password_reset_tokens SET used_at = NOW\(\)`).
+		WithArgs(int64(5)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	app.mock.ExpectCommit()
+	app.mock.ExpectExec(`UPDATE users SET passwordhash = \$1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := app.do(http.MethodPost, "/api/login/reset-password/confirm", "",
+		`{"email": "jane@example.org", "token": "reset-token", "password": "a new and long passphrase"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset: status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	if got := app.serveAuthenticated("user", token); got != http.StatusUnauthorized {
+		t.Fatalf("after the reset: status = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"password"
+	"stepup"
+	"user-service/data"
+)
+
+// Validation codes for profile fields, reported like password policy violations.
+const (
+	codeRequired = "required"
+	codeTooLong  = "too_long"
+	codeInvalid  = "invalid"
+	codeTaken    = "taken"
+)
+
+var (
+	phonePattern   = regexp.MustCompile(`^\+?[0-9][0-9 ()./-]*$`)
+	zipCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]*$`)
+)
+
+// GetProfile returns the authenticated user's full profile.
+func (app *Config) GetProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	app.writeProfile(w, userID)
+}
+
+// UpdateProfile applies a partial update to the authenticated user's profile and returns
+// the full result. A new email only takes effect once confirmed, see requestEmailChange.
+func (app *Config) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	app.updateProfile(w, r, userID, true, fmt.Sprintf("User %d updated their profile", userID))
+}
+
+// AdminGetProfile returns the full profile of any user.
+func (app *Config) AdminGetProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		return
+	}
+
+	app.writeProfile(w, userID)
+}
+
+// AdminUpdateProfile applies a partial update to any user's profile. Admins change email
+// addresses directly, which marks them unverified.
+func (app *Config) AdminUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid user ID"), http.StatusBadRequest)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	app.updateProfile(w, r, userID, false, fmt.Sprintf("Admin %d updated the profile of user %d", adminID, userID))
+}
+
+func (app *Config) writeProfile(w http.ResponseWriter, userID int64) {
+	user, err := app.Models.User.GetProfile(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, fmt.Errorf("user %d not found", userID), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	change, err := app.Models.EmailChange.GetPending(userID)
+	if err == nil {
+		user.PendingEmail = change.NewEmail
+	} else if !errors.Is(err, data.ErrEmailChangeNotFound) {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.withAvatar(user)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Profile retrieved successfully",
+		Data:    user,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// updateProfile applies a partial update. With confirmEmail, a new email address is only
+// requested, and replaces the current one once confirmed.
+func (app *Config) updateProfile(w http.ResponseWriter, r *http.Request, userID int64, confirmEmail bool, logMessage string) {
+	var update data.ProfileUpdate
+
+	err := app.readJSON(w, r, &update)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if fields := validateProfile(&update); len(fields) > 0 {
+		app.failedValidation(w, fields)
+		return
+	}
+
+	var change *data.EmailChange
+	if confirmEmail && update.Email != nil {
+		// A new email address can be used to take over the account, so changing it needs
+		// the same recent login as the other sensitive actions
+		if !stepup.Recent(r, stepup.MaxAge) {
+			stepup.Challenge(w, stepup.MaxAge)
+			return
+		}
+
+		change, err = app.requestEmailChange(userID, *update.Email)
+		if err != nil {
+			app.profileUpdateError(w, userID, err)
+			return
+		}
+		update.Email = nil
+	}
+
+	user, err := app.Models.User.UpdateProfile(userID, update)
+	if err != nil {
+		app.profileUpdateError(w, userID, err)
+		return
+	}
+	if change != nil {
+		user.PendingEmail = change.NewEmail
+	}
+
+	err = app.withAvatar(user)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "update_profile", logMessage)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	message := "Profile updated successfully"
+	if change != nil {
+		message += fmt.Sprintf("; confirm the new email address with the link sent to %s", change.NewEmail)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    user,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+func (app *Config) profileUpdateError(w http.ResponseWriter, userID int64, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		app.errorJSON(w, fmt.Errorf("user %d not found", userID), http.StatusNotFound)
+	case errors.Is(err, data.ErrDuplicateEmail):
+		app.failedValidation(w, map[string][]password.Violation{
+			"email": {{Code: codeTaken, Message: err.Error()}},
+		})
+	default:
+		app.errorJSON(w, err)
+	}
+}
+
+// validateProfile trims the fields of an update and checks them against the users columns.
+// It returns the problems found with each field.
+func validateProfile(update *data.ProfileUpdate) map[string][]password.Violation {
+	fields := make(map[string][]password.Violation)
+
+	check := func(name string, value *string, maxLength int, required bool, valid func(string) bool) {
+		if value == nil {
+			return
+		}
+		*value = strings.TrimSpace(*value)
+
+		switch {
+		case *value == "":
+			if required {
+				fields[name] = append(fields[name], password.Violation{Code: codeRequired, Message: fmt.Sprintf("%s cannot be empty", name)})
+			}
+		case utf8.RuneCountInString(*value) > maxLength:
+			fields[name] = append(fields[name], password.Violation{Code: codeTooLong, Message: fmt.Sprintf("%s must be at most %d characters", name, maxLength)})
+		case valid != nil && !valid(*value):
+			fields[name] = append(fields[name], password.Violation{Code: codeInvalid, Message: fmt.Sprintf("%s is not valid", name)})
+		}
+	}
+
+	check("username", update.UserName, 50, true, nil)
+	check("email", update.Email, 100, true, validEmail)
+	check("first_name", update.FirstName, 50, false, nil)
+	check("last_name", update.LastName, 50, false, nil)
+	check("phone", update.Phone, 20, false, phonePattern.MatchString)
+	check("address", update.Address, 255, false, nil)
+	check("city", update.City, 100, false, nil)
+	check("state", update.State, 100, false, nil)
+	check("zip_code", update.ZipCode, 20, false, zipCodePattern.MatchString)
+
+	return fields
+}
+
+// validEmail accepts a bare address such as jane@example.com, without a display name.
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
@@ -6,6 +6,10 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+
+	"stepup"
+	"user-service/data"
+	"user-service/storage"
 )
 
 func (app *Config) routes() http.Handler {
@@ -13,7 +17,7 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"POST", "PUT", "GET", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"POST", "PUT", "PATCH", "GET", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Content-Type", "Authorization", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
@@ -22,16 +26,131 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
+	// The local object store serves its signed URLs itself
+	if local, ok := app.ObjectStore.(*storage.Local); ok {
+		prefix := local.PathPrefix()
+		mux.Handle(prefix+"/*", http.StripPrefix(prefix, local))
+	}
+
 	mux.Post("/api/login/register", app.Register)
 	mux.Get("/api/login/check-email", app.CheckEmail)
 	mux.Post("/api/login/reset-password", app.ResetPassword)
+	mux.Post("/api/login/reset-password/confirm", app.UpdatePassword)
+	mux.Post("/api/login/email-change/confirm", app.ConfirmEmailChange)
+	mux.Post("/api/login/email-change/revert", app.RevertEmailChange)
 
 	mux.Route("/api/login", func(mux chi.Router) {
 		mux.Use(app.AuthMiddleware("user"))
-		
-		mux.Delete("/delete-user/{user_id}", app.DeleteUser)
-		mux.Put("/update/{user_id}", app.UpdateUser)
-		
+
+		mux.Get("/me", app.GetProfile)
+		mux.Get("/notification-preferences", app.GetNotificationPreferences)
+		mux.Get("/tenants", app.ListTenants)
+
+		// Sensitive account actions are not available to admins impersonating the user
+		// or to API keys
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.DenyImpersonation)
+			mux.Use(app.DenyAPIKey)
+
+			mux.Patch("/me", app.UpdateProfile)
+			mux.Get("/email-change", app.GetEmailChange)
+			mux.Delete("/email-change", app.CancelEmailChange)
+			mux.Put("/notification-preferences", app.UpdateNotificationPreferences)
+			mux.Put("/avatar", app.UploadAvatar)
+			mux.Delete("/avatar", app.DeleteAvatar)
+			mux.Get("/api-keys", app.ListAPIKeys)
+			mux.Delete("/api-keys/{key_id}", app.RevokeAPIKey)
+			mux.Get("/passkeys", app.ListPasskeys)
+			mux.Get("/identities", app.ListIdentities)
+			mux.Get("/data-requests", app.ListDataRequests)
+			mux.Post("/data-requests/export", app.RequestDataExport)
+			mux.Get("/data-requests/{request_id}", app.GetDataRequest)
+			mux.Get("/data-requests/{request_id}/archive", app.DownloadDataExport)
+			mux.Post("/data-requests/{request_id}/cancel", app.CancelDataRequest)
+			mux.Delete("/tenants/{tenant}", app.LeaveTenant)
+
+			// Members of the tenant the token is for, managed by its owners and admins
+			mux.Group(func(mux chi.Router) {
+				mux.Use(app.RequireTenantRole(data.TenantRoleOwner, data.TenantRoleAdmin))
+
+				mux.Get("/tenant/members", app.ListTenantMembers)
+				mux.Put("/tenant/members/{user_id}", app.UpdateTenantMember)
+				mux.Delete("/tenant/members/{user_id}", app.RemoveTenantMember)
+			})
+
+			// These also need a recent login, not just a refreshed token
+			mux.Group(func(mux chi.Router) {
+				mux.Use(stepup.Require(stepup.MaxAge))
+
+				mux.Delete("/delete-user/{user_id}", app.DeleteUser)
+				mux.Put("/update/{user_id}", app.UpdateUser)
+				mux.Post("/api-keys", app.CreateAPIKey)
+				mux.Delete("/passkeys/{passkey_id}", app.DeletePasskey)
+				mux.Delete("/identities/{identity_id}", app.DeleteIdentity)
+				mux.Post("/data-requests/erasure", app.RequestErasure)
+			})
+		})
+	})
+
+	mux.Route("/api/admin", func(mux chi.Router) {
+		mux.Use(app.AuthMiddleware("admin"))
+
+		mux.Get("/users", app.AdminListUsers)
+		mux.Get("/users/export", app.AdminExportUsers)
+		mux.Post("/users/import", app.AdminImportUsers)
+		mux.Get("/user-imports", app.AdminListUserImports)
+		mux.Get("/user-imports/{import_id}", app.AdminGetUserImport)
+		mux.Delete("/api-keys", app.AdminRevokeAllAPIKeys)
+		mux.Get("/scim-tokens", app.AdminListSCIMTokens)
+		mux.Post("/scim-tokens", app.AdminCreateSCIMToken)
+		mux.Delete("/scim-tokens/{token_id}", app.AdminRevokeSCIMToken)
+		mux.Get("/data-requests", app.AdminListDataRequests)
+		mux.Get("/data-requests/{request_id}", app.AdminGetDataRequest)
+		mux.Post("/data-requests/{request_id}/cancel", app.AdminCancelDataRequest)
+		mux.Get("/tenants", app.AdminListTenants)
+		mux.Post("/tenants", app.AdminCreateTenant)
+		mux.Get("/tenants/{tenant}/members", app.AdminListTenantMembers)
+		mux.Put("/tenants/{tenant}/members/{user_id}", app.AdminSetTenantMember)
+		mux.Delete("/tenants/{tenant}/members/{user_id}", app.AdminRemoveTenantMember)
+
+		// Tenant admins only see the users of their tenant
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.RequireUserInScope)
+
+			mux.Get("/users/{user_id}", app.AdminGetProfile)
+			mux.Get("/users/{user_id}/api-keys", app.AdminListAPIKeys)
+
+			// and only change the accounts of users who belong to no other tenant
+			mux.Group(func(mux chi.Router) {
+				mux.Use(app.RequireAccountInScope)
+
+				mux.Patch("/users/{user_id}", app.AdminUpdateProfile)
+				mux.Delete("/users/{user_id}/avatar", app.AdminDeleteAvatar)
+				mux.Delete("/users/{user_id}/api-keys/{key_id}", app.AdminRevokeAPIKey)
+				mux.Post("/users/{user_id}/erasure", app.AdminRequestErasure)
+			})
+		})
+	})
+
+	// SCIM 2.0 provisioning, authenticated with per-tenant SCIM tokens
+	mux.Route("/scim/v2", func(mux chi.Router) {
+		mux.Use(app.SCIMAuth)
+
+		mux.Get("/ServiceProviderConfig", app.SCIMServiceProviderConfig)
+
+		mux.Get("/Users", app.SCIMListUsers)
+		mux.Post("/Users", app.SCIMCreateUser)
+		mux.Get("/Users/{id}", app.SCIMGetUser)
+		mux.Put("/Users/{id}", app.SCIMReplaceUser)
+		mux.Patch("/Users/{id}", app.SCIMPatchUser)
+		mux.Delete("/Users/{id}", app.SCIMDeleteUser)
+
+		mux.Get("/Groups", app.SCIMListGroups)
+		mux.Post("/Groups", app.SCIMCreateGroup)
+		mux.Get("/Groups/{id}", app.SCIMGetGroup)
+		mux.Put("/Groups/{id}", app.SCIMReplaceGroup)
+		mux.Patch("/Groups/{id}", app.SCIMPatchGroup)
+		mux.Delete("/Groups/{id}", app.SCIMDeleteGroup)
 	})
 
 	return mux
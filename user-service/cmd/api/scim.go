@@ -0,0 +1,868 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"password"
+	"user-service/data"
+	"user-service/scim"
+)
+
+// The SCIM 2.0 API lets a tenant's HR system or identity provider create, update, deactivate
+// and delete users and groups. Clients authenticate with a per-tenant bearer token issued by
+// an admin, and only ever see the users their tenant provisioned.
+
+// ContextKeySCIMTenant is key to store the tenant a SCIM token belongs to
+const ContextKeySCIMTenant = contextKey("scimTenant")
+
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// SCIMAuth authenticates SCIM clients by their bearer token.
+func (app *Config) SCIMAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !strings.HasPrefix(tokenString, data.SCIMTokenPrefix) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			app.scimError(w, scim.NewError(http.StatusUnauthorized, "", "a SCIM bearer token is required"))
+			return
+		}
+
+		token, err := app.Models.SCIMToken.Authenticate(tokenString)
+		if err != nil {
+			if errors.Is(err, data.ErrSCIMTokenNotFound) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="scim", error="invalid_token"`)
+				app.scimError(w, scim.NewError(http.StatusUnauthorized, "", "invalid SCIM token"))
+				return
+			}
+			app.scimError(w, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ContextKeySCIMTenant, token.Tenant)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// SCIMServiceProviderConfig describes the supported SCIM features.
+func (app *Config) SCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	app.writeSCIM(w, http.StatusOK, scim.ServiceProviderConfig())
+}
+
+// SCIMListUsers returns a page of the tenant's users, optionally filtered.
+func (app *Config) SCIMListUsers(w http.ResponseWriter, r *http.Request) {
+	tenant := r.Context().Value(ContextKeySCIMTenant).(string)
+
+	filter, startIndex, count, err := scimQuery(r)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	users, total, err := app.Models.SCIM.ListUsers(tenant, filter, startIndex, count)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	ids := make([]int64, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	groups, err := app.Models.SCIM.GroupsOfUsers(ids)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	resources := make([]any, 0, len(users))
+	for _, u := range users {
+		resources = append(resources, userToSCIM(u, groups[u.ID]))
+	}
+
+	app.writeSCIM(w, http.StatusOK, scim.NewListResponse(resources, total, startIndex))
+}
+
+// SCIMGetUser returns one of the tenant's users.
+func (app *Config) SCIMGetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.scimUser(w, r)
+	if !ok {
+		return
+	}
+
+	resource, err := app.scimUserResource(user)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == resource.Meta.Version {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	app.writeSCIMResource(w, http.StatusOK, resource, resource.Meta)
+}
+
+// SCIMCreateUser provisions a user for the tenant.
+func (app *Config) SCIMCreateUser(w http.ResponseWriter, r *http.Request) {
+	tenant := r.Context().Value(ContextKeySCIMTenant).(string)
+
+	var resource scim.User
+	err := app.readJSON(w, r, &resource)
+	if err != nil {
+		app.scimError(w, scim.InvalidSyntax("%v", err))
+		return
+	}
+
+	user := &data.User{}
+	err = userFromSCIM(&resource, user)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	err = app.checkSCIMPassword(resource.Password, user)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	err = app.Models.SCIM.InsertUser(tenant, user, resource.Password)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	app.logUserRequest(user.ID, "scim_create_user", fmt.Sprintf("SCIM client of tenant %s created user %d (%s)", tenant, user.ID, user.Email))
+
+	created := userToSCIM(user, nil)
+	app.writeSCIMResource(w, http.StatusCreated, created, created.Meta)
+}
+
+// SCIMReplaceUser replaces all attributes of a user (PUT).
+func (app *Config) SCIMReplaceUser(w http.ResponseWriter, r *http.Request) {
+	app.updateSCIMUser(w, r, func(current *scim.User) error {
+		var resource scim.User
+		err := app.readJSON(w, r, &resource)
+		if err != nil {
+			return scim.InvalidSyntax("%v", err)
+		}
+		if resource.ID != "" && resource.ID != current.ID {
+			return scim.NewError(http.StatusBadRequest, "mutability", "id cannot be changed")
+		}
+
+		*current = resource
+		return nil
+	})
+}
+
+// SCIMPatchUser applies PATCH operations to a user.
+func (app *Config) SCIMPatchUser(w http.ResponseWriter, r *http.Request) {
+	app.updateSCIMUser(w, r, func(current *scim.User) error {
+		var request scim.PatchRequest
+		err := app.readJSON(w, r, &request)
+		if err != nil {
+			return scim.InvalidSyntax("%v", err)
+		}
+
+		err = request.Validate()
+		if err != nil {
+			return err
+		}
+
+		return scim.ApplyUserPatch(current, request.Operations)
+	})
+}
+
+// updateSCIMUser loads a user, lets change modify its SCIM representation and stores the
+// result, unless the user was modified in the meantime.
+func (app *Config) updateSCIMUser(w http.ResponseWriter, r *http.Request, change func(*scim.User) error) {
+	tenant := r.Context().Value(ContextKeySCIMTenant).(string)
+
+	user, ok := app.scimUser(w, r)
+	if !ok {
+		return
+	}
+	if !app.checkIfMatch(w, r, scim.ETag(user.UpdatedAt)) {
+		return
+	}
+
+	resource := userToSCIM(user, nil)
+	err := change(resource)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	updated := *user
+	err = userFromSCIM(resource, &updated)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	err = app.checkSCIMPassword(resource.Password, &updated)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	err = app.Models.SCIM.UpdateUser(tenant, &updated, resource.Password, user.UpdatedAt)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	if user.Active && !updated.Active {
+		app.logUserRequest(user.ID, "scim_deactivate_user", fmt.Sprintf("SCIM client of tenant %s deactivated user %d", tenant, user.ID))
+	}
+
+	result, err := app.scimUserResource(&updated)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	app.writeSCIMResource(w, http.StatusOK, result, result.Meta)
+}
+
+// SCIMDeleteUser deletes one of the tenant's users.
+func (app *Config) SCIMDeleteUser(w http.ResponseWriter, r *http.Request) {
+	tenant := r.Context().Value(ContextKeySCIMTenant).(string)
+
+	user, ok := app.scimUser(w, r)
+	if !ok {
+		return
+	}
+	if !app.checkIfMatch(w, r, scim.ETag(user.UpdatedAt)) {
+		return
+	}
+
+	err := app.Models.SCIM.DeleteUser(tenant, user.ID)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	app.logUserRequest(user.ID, "scim_delete_user", fmt.Sprintf("SCIM client of tenant %s deleted user %d (%s)", tenant, user.ID, user.Email))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SCIMListGroups returns a page of the tenant's groups, optionally filtered. Members are
+// left out if the client asks to exclude them, as directory syncs usually do.
+func (app *Config) SCIMListGroups(w http.ResponseWriter, r *http.Request) {
+	tenant := r.Context().Value(ContextKeySCIMTenant).(string)
+
+	filter, startIndex, count, err := scimQuery(r)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	withMembers := !strings.EqualFold(r.URL.Query().Get("excludedAttributes"), "members")
+
+	groups, total, err := app.Models.SCIM.ListGroups(tenant, filter, startIndex, count, withMembers)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	resources := make([]any, 0, len(groups))
+	for _, g := range groups {
+		resources = append(resources, groupToSCIM(g))
+	}
+
+	app.writeSCIM(w, http.StatusOK, scim.NewListResponse(resources, total, startIndex))
+}
+
+// SCIMGetGroup returns one of the tenant's groups.
+func (app *Config) SCIMGetGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.scimGroup(w, r)
+	if !ok {
+		return
+	}
+
+	resource := groupToSCIM(group)
+	if r.Header.Get("If-None-Match") == resource.Meta.Version {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	app.writeSCIMResource(w, http.StatusOK, resource, resource.Meta)
+}
+
+// SCIMCreateGroup creates a group for the tenant.
+func (app *Config) SCIMCreateGroup(w http.ResponseWriter, r *http.Request) {
+	tenant := r.Context().Value(ContextKeySCIMTenant).(string)
+
+	var resource scim.Group
+	err := app.readJSON(w, r, &resource)
+	if err != nil {
+		app.scimError(w, scim.InvalidSyntax("%v", err))
+		return
+	}
+
+	group := &data.SCIMGroup{}
+	err = groupFromSCIM(&resource, group)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	err = app.Models.SCIM.InsertGroup(tenant, group)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	app.logRequest("scim_create_group", fmt.Sprintf("SCIM client of tenant %s created group %d (%s)", tenant, group.ID, group.DisplayName))
+
+	created := groupToSCIM(group)
+	app.writeSCIMResource(w, http.StatusCreated, created, created.Meta)
+}
+
+// SCIMReplaceGroup replaces a group's attributes and members (PUT).
+func (app *Config) SCIMReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	app.updateSCIMGroup(w, r, func(current *scim.Group) error {
+		var resource scim.Group
+		err := app.readJSON(w, r, &resource)
+		if err != nil {
+			return scim.InvalidSyntax("%v", err)
+		}
+		if resource.ID != "" && resource.ID != current.ID {
+			return scim.NewError(http.StatusBadRequest, "mutability", "id cannot be changed")
+		}
+
+		*current = resource
+		return nil
+	})
+}
+
+// SCIMPatchGroup applies PATCH operations to a group, typically adding or removing members.
+func (app *Config) SCIMPatchGroup(w http.ResponseWriter, r *http.Request) {
+	app.updateSCIMGroup(w, r, func(current *scim.Group) error {
+		var request scim.PatchRequest
+		err := app.readJSON(w, r, &request)
+		if err != nil {
+			return scim.InvalidSyntax("%v", err)
+		}
+
+		err = request.Validate()
+		if err != nil {
+			return err
+		}
+
+		return scim.ApplyGroupPatch(current, request.Operations)
+	})
+}
+
+func (app *Config) updateSCIMGroup(w http.ResponseWriter, r *http.Request, change func(*scim.Group) error) {
+	tenant := r.Context().Value(ContextKeySCIMTenant).(string)
+
+	group, ok := app.scimGroup(w, r)
+	if !ok {
+		return
+	}
+	if !app.checkIfMatch(w, r, scim.ETag(group.UpdatedAt)) {
+		return
+	}
+
+	resource := groupToSCIM(group)
+	err := change(resource)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	updated := *group
+	err = groupFromSCIM(resource, &updated)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	err = app.Models.SCIM.UpdateGroup(tenant, &updated, group.UpdatedAt)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	result := groupToSCIM(&updated)
+	app.writeSCIMResource(w, http.StatusOK, result, result.Meta)
+}
+
+// SCIMDeleteGroup deletes one of the tenant's groups.
+func (app *Config) SCIMDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	tenant := r.Context().Value(ContextKeySCIMTenant).(string)
+
+	group, ok := app.scimGroup(w, r)
+	if !ok {
+		return
+	}
+	if !app.checkIfMatch(w, r, scim.ETag(group.UpdatedAt)) {
+		return
+	}
+
+	err := app.Models.SCIM.DeleteGroup(tenant, group.ID)
+	if err != nil {
+		app.scimError(w, err)
+		return
+	}
+
+	app.logRequest("scim_delete_group", fmt.Sprintf("SCIM client of tenant %s deleted group %d (%s)", tenant, group.ID, group.DisplayName))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminListSCIMTokens returns the active SCIM tokens of the tenant given in the tenant query
+// parameter, or of all tenants when it is omitted. Admins of a tenant only see its tokens.
+func (app *Config) AdminListSCIMTokens(w http.ResponseWriter, r *http.Request) {
+	tenant := r.URL.Query().Get("tenant")
+	if scope := tenantScope(r); !scope.Platform() {
+		if tenant != "" && tenant != scope.Tenant {
+			app.errorJSON(w, fmt.Errorf("you can only manage the SCIM tokens of tenant %s", scope.Tenant), http.StatusForbidden)
+			return
+		}
+		tenant = scope.Tenant
+	}
+
+	tokens, err := app.Models.SCIMToken.GetAll(tenant)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d SCIM tokens", len(tokens)),
+		Data:    tokens,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// AdminCreateSCIMToken issues a SCIM token for a tenant, creating the tenant if it is new.
+// Admins of a tenant can only issue tokens for it, and need not name it. The token itself
+// is only returned in this response.
+func (app *Config) AdminCreateSCIMToken(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Tenant string `json:"tenant"`
+		Name   string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if scope := tenantScope(r); !scope.Platform() {
+		if requestPayload.Tenant != "" && requestPayload.Tenant != scope.Tenant {
+			app.errorJSON(w, fmt.Errorf("you can only manage the SCIM tokens of tenant %s", scope.Tenant), http.StatusForbidden)
+			return
+		}
+		requestPayload.Tenant = scope.Tenant
+	}
+
+	if !tenantPattern.MatchString(requestPayload.Tenant) {
+		app.errorJSON(w, fmt.Errorf("tenant must be 1-63 lower case letters, digits or dashes"), http.StatusBadRequest)
+		return
+	}
+
+	requestPayload.Name = strings.TrimSpace(requestPayload.Name)
+	if requestPayload.Name == "" || len(requestPayload.Name) > 100 {
+		app.errorJSON(w, fmt.Errorf("name is required and must be at most 100 characters"), http.StatusBadRequest)
+		return
+	}
+
+	// Users provisioned with the token become members of the tenant
+	err = app.Models.Tenant.Ensure(requestPayload.Tenant)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	token, err := app.Models.SCIMToken.Insert(requestPayload.Tenant, requestPayload.Name)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	err = app.logRequest("create_scim_token", fmt.Sprintf("Admin %d created SCIM token %d (%s) for tenant %s", adminID, token.ID, token.Prefix, token.Tenant))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "SCIM token created, store it now as it will not be shown again",
+		Data:    token,
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// AdminRevokeSCIMToken revokes a SCIM token. Admins of a tenant can only revoke its tokens.
+func (app *Config) AdminRevokeSCIMToken(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "token_id"), 10, 64)
+	if err != nil || tokenID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid token ID"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.SCIMToken.Revoke(tokenID, tenantScope(r).Tenant)
+	if err != nil {
+		if errors.Is(err, data.ErrSCIMTokenNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	err = app.logRequest("revoke_scim_token", fmt.Sprintf("Admin %d revoked SCIM token %d", adminID, tokenID))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("SCIM token %d revoked successfully", tokenID),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// scimQuery reads the filter and pagination parameters of a list request.
+func scimQuery(r *http.Request) (scim.Filter, int, int, error) {
+	query := r.URL.Query()
+
+	startIndex, count, err := scim.Pagination(query.Get("startIndex"), query.Get("count"))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var filter scim.Filter
+	if f := query.Get("filter"); f != "" {
+		filter, err = scim.ParseFilter(f)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	return filter, startIndex, count, nil
+}
+
+// scimUser loads the user named in the URL, responding with an error if it cannot.
+func (app *Config) scimUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	tenant := r.Context().Value(ContextKeySCIMTenant).(string)
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.scimError(w, data.ErrSCIMNotFound)
+		return nil, false
+	}
+
+	user, err := app.Models.SCIM.GetUser(tenant, id)
+	if err != nil {
+		app.scimError(w, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// scimGroup loads the group named in the URL, responding with an error if it cannot.
+func (app *Config) scimGroup(w http.ResponseWriter, r *http.Request) (*data.SCIMGroup, bool) {
+	tenant := r.Context().Value(ContextKeySCIMTenant).(string)
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.scimError(w, data.ErrSCIMNotFound)
+		return nil, false
+	}
+
+	group, err := app.Models.SCIM.GetGroup(tenant, id)
+	if err != nil {
+		app.scimError(w, err)
+		return nil, false
+	}
+
+	return group, true
+}
+
+// scimUserResource converts a user together with their groups.
+func (app *Config) scimUserResource(user *data.User) (*scim.User, error) {
+	groups, err := app.Models.SCIM.GroupsOfUsers([]int64{user.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	return userToSCIM(user, groups[user.ID]), nil
+}
+
+// checkIfMatch enforces an If-Match precondition against the resource's current ETag.
+func (app *Config) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+
+	app.scimError(w, data.ErrSCIMVersionMismatch)
+	return false
+}
+
+// checkSCIMPassword runs the password policy against a password set by a SCIM client.
+func (app *Config) checkSCIMPassword(candidate string, user *data.User) error {
+	if candidate == "" {
+		return nil
+	}
+
+	violations := app.PasswordPolicy.Check(candidate, password.Identity{Email: user.Email, Username: user.UserName})
+	if len(violations) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(violations))
+	for _, v := range violations {
+		messages = append(messages, v.Message)
+	}
+
+	return scim.InvalidValue("password: %s", strings.Join(messages, "; "))
+}
+
+// writeSCIM writes a SCIM response body.
+func (app *Config) writeSCIM(w http.ResponseWriter, status int, body any) {
+	out, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Error encoding SCIM response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// writeSCIMResource writes a single resource with its ETag and location.
+func (app *Config) writeSCIMResource(w http.ResponseWriter, status int, body any, meta *scim.Meta) {
+	w.Header().Set("ETag", meta.Version)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", meta.Location)
+	}
+
+	app.writeSCIM(w, status, body)
+}
+
+// scimError responds with a SCIM error. Unexpected errors are logged and hidden from
+// the client.
+func (app *Config) scimError(w http.ResponseWriter, err error) {
+	var scimErr *scim.Error
+
+	switch {
+	case errors.As(err, &scimErr):
+	case errors.Is(err, data.ErrSCIMNotFound):
+		scimErr = scim.NewError(http.StatusNotFound, "", "%v", err)
+	case errors.Is(err, data.ErrSCIMConflict):
+		scimErr = scim.NewError(http.StatusConflict, "uniqueness", "%v", err)
+	case errors.Is(err, data.ErrSCIMVersionMismatch):
+		scimErr = scim.NewError(http.StatusPreconditionFailed, "", "%v", err)
+	case errors.Is(err, data.ErrSCIMUnknownMember):
+		scimErr = scim.InvalidValue("%v", err)
+	case errors.Is(err, password.ErrBusy):
+		w.Header().Set("Retry-After", strconv.Itoa(busyRetryAfter))
+		scimErr = scim.NewError(http.StatusServiceUnavailable, "", "%v", err)
+	default:
+		log.Printf("SCIM request failed: %v", err)
+		scimErr = scim.NewError(http.StatusInternalServerError, "", "internal error")
+	}
+
+	app.writeSCIM(w, scimErr.StatusCode(), scimErr)
+}
+
+// userToSCIM converts a user to a SCIM resource. The users row has one email, phone number
+// and address, which become the primary entries.
+func userToSCIM(u *data.User, groups []data.SCIMReference) *scim.User {
+	id := strconv.FormatInt(u.ID, 10)
+	active := u.Active
+
+	resource := &scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          id,
+		ExternalID:  u.ExternalID,
+		UserName:    u.UserName,
+		DisplayName: strings.TrimSpace(u.FirstName + " " + u.LastName),
+		Active:      &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      u.CreatedAt,
+			LastModified: u.UpdatedAt,
+			Location:     "/scim/v2/Users/" + id,
+			Version:      scim.ETag(u.UpdatedAt),
+		},
+	}
+
+	if u.FirstName != "" || u.LastName != "" {
+		resource.Name = &scim.Name{GivenName: u.FirstName, FamilyName: u.LastName, Formatted: resource.DisplayName}
+	}
+	if u.Email != "" {
+		resource.Emails = []scim.MultiValued{{Value: u.Email, Type: "work", Primary: true}}
+	}
+	if u.Phone != "" {
+		resource.PhoneNumbers = []scim.MultiValued{{Value: u.Phone, Type: "work", Primary: true}}
+	}
+	if u.Address != "" || u.City != "" || u.State != "" || u.ZipCode != "" {
+		resource.Addresses = []scim.Address{{
+			Type:          "work",
+			StreetAddress: u.Address,
+			Locality:      u.City,
+			Region:        u.State,
+			PostalCode:    u.ZipCode,
+			Primary:       true,
+		}}
+	}
+	for _, g := range groups {
+		resource.Groups = append(resource.Groups, scim.MultiValued{Value: strconv.FormatInt(g.ID, 10), Display: g.Display})
+	}
+
+	return resource
+}
+
+// userFromSCIM copies the attributes of a SCIM resource to u, checking they fit our columns.
+func userFromSCIM(resource *scim.User, u *data.User) error {
+	u.UserName = strings.TrimSpace(resource.UserName)
+	u.ExternalID = resource.ExternalID
+	u.FirstName, u.LastName = "", ""
+	if resource.Name != nil {
+		u.FirstName, u.LastName = resource.Name.GivenName, resource.Name.FamilyName
+	}
+
+	u.Email, u.Phone = "", ""
+	if len(resource.Emails) > 0 {
+		e := resource.Emails
+		u.Email = strings.TrimSpace(e[scim.PrimaryIndex(len(e), func(i int) bool { return e[i].Primary })].Value)
+	}
+	if len(resource.PhoneNumbers) > 0 {
+		p := resource.PhoneNumbers
+		u.Phone = p[scim.PrimaryIndex(len(p), func(i int) bool { return p[i].Primary })].Value
+	}
+
+	u.Address, u.City, u.State, u.ZipCode = "", "", "", ""
+	if len(resource.Addresses) > 0 {
+		a := resource.Addresses
+		address := a[scim.PrimaryIndex(len(a), func(i int) bool { return a[i].Primary })]
+		u.Address, u.City, u.State, u.ZipCode = address.StreetAddress, address.Locality, address.Region, address.PostalCode
+	}
+
+	u.Active = resource.Active == nil || *resource.Active
+
+	if u.UserName == "" {
+		return scim.InvalidValue("userName is required")
+	}
+	if u.Email == "" || !strings.Contains(u.Email, "@") {
+		return scim.InvalidValue("a valid email is required")
+	}
+
+	limits := []struct {
+		attribute string
+		value     string
+		max       int
+	}{
+		{"userName", u.UserName, 50},
+		{"externalId", u.ExternalID, 255},
+		{"name.givenName", u.FirstName, 50},
+		{"name.familyName", u.LastName, 50},
+		{"emails", u.Email, 100},
+		{"phoneNumbers", u.Phone, 20},
+		{"addresses.streetAddress", u.Address, 255},
+		{"addresses.locality", u.City, 100},
+		{"addresses.region", u.State, 100},
+		{"addresses.postalCode", u.ZipCode, 20},
+	}
+	for _, l := range limits {
+		if len([]rune(l.value)) > l.max {
+			return scim.InvalidValue("%s must be at most %d characters", l.attribute, l.max)
+		}
+	}
+
+	return nil
+}
+
+func groupToSCIM(g *data.SCIMGroup) *scim.Group {
+	id := strconv.FormatInt(g.ID, 10)
+
+	resource := &scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          id,
+		ExternalID:  g.ExternalID,
+		DisplayName: g.DisplayName,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      g.CreatedAt,
+			LastModified: g.UpdatedAt,
+			Location:     "/scim/v2/Groups/" + id,
+			Version:      scim.ETag(g.UpdatedAt),
+		},
+	}
+
+	for _, m := range g.Members {
+		resource.Members = append(resource.Members, scim.MultiValued{Value: strconv.FormatInt(m.ID, 10), Display: m.Display})
+	}
+
+	return resource
+}
+
+// groupFromSCIM copies the attributes and members of a SCIM resource to g.
+func groupFromSCIM(resource *scim.Group, g *data.SCIMGroup) error {
+	g.DisplayName = strings.TrimSpace(resource.DisplayName)
+	g.ExternalID = resource.ExternalID
+
+	if g.DisplayName == "" || len([]rune(g.DisplayName)) > 255 {
+		return scim.InvalidValue("displayName is required and must be at most 255 characters")
+	}
+	if len([]rune(g.ExternalID)) > 255 {
+		return scim.InvalidValue("externalId must be at most 255 characters")
+	}
+
+	g.Members = make([]data.SCIMReference, 0, len(resource.Members))
+	for _, m := range resource.Members {
+		id, err := strconv.ParseInt(m.Value, 10, 64)
+		if err != nil {
+			return scim.InvalidValue("unknown member %q", m.Value)
+		}
+		g.Members = append(g.Members, data.SCIMReference{ID: id})
+	}
+
+	return nil
+}
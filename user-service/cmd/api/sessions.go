@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"proto/sessions"
+)
+
+// authServiceAddress is where auth-service serves gRPC.
+const authServiceAddress = "authentication-service:50004"
+
+// revocationCacheTTL is how long a token's revocation status is trusted before auth-service
+// is asked again. A logged out or revoked token stops working here within this time.
+const revocationCacheTTL = 10 * time.Second
+
+// revocationCacheSize is how many tokens are remembered before expired entries are dropped.
+const revocationCacheSize = 10000
+
+// dialAuthService returns a client for auth-service's session service. The connection is
+// made on first use.
+func dialAuthService() (sessions.SessionServiceClient, error) {
+	conn, err := grpc.NewClient(authServiceAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions.NewSessionServiceClient(conn), nil
+}
+
+// revokeUserSessions asks auth-service to reject every token issued to the user so far,
+// logging them out everywhere.
+func (app *Config) revokeUserSessions(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := app.Sessions.RevokeUserSessions(ctx, &sessions.RevokeUserSessionsRequest{UserId: userID})
+	if err != nil {
+		return err
+	}
+
+	// Tokens checked before the revocation must not be trusted until their entry expires
+	app.revocations.forget()
+
+	return nil
+}
+
+// tokenRevoked asks auth-service whether a verified token has been logged out or belongs
+// to revoked sessions, remembering the answer for revocationCacheTTL.
+func (app *Config) tokenRevoked(ctx context.Context, token string) (bool, error) {
+	key := sha256.Sum256([]byte(token))
+
+	if revoked, ok := app.revocations.get(key); ok {
+		return revoked, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	response, err := app.Sessions.IsTokenRevoked(ctx, &sessions.IsTokenRevokedRequest{Token: token})
+	if err != nil {
+		return false, err
+	}
+
+	app.revocations.put(key, response.GetRevoked())
+
+	return response.GetRevoked(), nil
+}
+
+// revocationCache remembers recent answers from auth-service by token hash.
+type revocationCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]revocationEntry
+}
+
+type revocationEntry struct {
+	revoked bool
+	expires time.Time
+}
+
+func (c *revocationCache) get(key [sha256.Size]byte) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return false, false
+	}
+
+	return entry.revoked, true
+}
+
+func (c *revocationCache) put(key [sha256.Size]byte, revoked bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[[sha256.Size]byte]revocationEntry)
+	}
+	if len(c.entries) >= revocationCacheSize {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) >= revocationCacheSize {
+		clear(c.entries)
+	}
+
+	c.entries[key] = revocationEntry{revoked: revoked, expires: now.Add(revocationCacheTTL)}
+}
+
+// forget drops every remembered answer, after this service revoked sessions itself.
+func (c *revocationCache) forget() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
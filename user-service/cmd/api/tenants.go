@@ -0,0 +1,448 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"user-service/data"
+)
+
+// tenantErrorJSON answers with the status that fits a TenantModel error.
+func (app *Config) tenantErrorJSON(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, data.ErrTenantNotFound), errors.Is(err, data.ErrNotTenantMember):
+		app.errorJSON(w, err, http.StatusNotFound)
+	case errors.Is(err, data.ErrTenantExists), errors.Is(err, data.ErrTenantMemberTaken), errors.Is(err, data.ErrLastTenantOwner):
+		app.errorJSON(w, err, http.StatusConflict)
+	default:
+		app.errorJSON(w, err)
+	}
+}
+
+// memberIDParam reads the user_id URL parameter of the member routes.
+func memberIDParam(r *http.Request) (int64, error) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		return 0, fmt.Errorf("invalid user ID")
+	}
+	return userID, nil
+}
+
+// ListTenants returns the tenants the authenticated user is a member of, and which of them
+// their token is for.
+func (app *Config) ListTenants(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	memberships, err := app.Models.Tenant.Memberships(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var active string
+	if membership, ok := r.Context().Value(ContextKeyTenant).(*data.TenantMembership); ok {
+		active = membership.Tenant
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d tenants", len(memberships)),
+		Data: struct {
+			Active      string                   `json:"active,omitempty"`
+			Memberships []*data.TenantMembership `json:"memberships"`
+		}{active, memberships},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// LeaveTenant takes the authenticated user out of a tenant. The last owner has to hand the
+// tenant over first.
+func (app *Config) LeaveTenant(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(ContextKeyUserID).(int64)
+	if !ok {
+		app.errorJSON(w, fmt.Errorf("missing user ID in context"), http.StatusUnauthorized)
+		return
+	}
+
+	tenant := chi.URLParam(r, "tenant")
+	app.removeTenantMember(w, tenant, userID, fmt.Sprintf("User %d left tenant %s", userID, tenant))
+}
+
+// ListTenantMembers returns the members of the tenant the user is acting in, for its
+// owners and admins.
+func (app *Config) ListTenantMembers(w http.ResponseWriter, r *http.Request) {
+	membership := r.Context().Value(ContextKeyTenant).(*data.TenantMembership)
+	app.listTenantMembers(w, membership.Tenant)
+}
+
+// UpdateTenantMember changes the role of a member of the tenant the user is acting in.
+// Admins of the tenant manage its members; only owners hand out or take away the admin
+// and owner roles. Users join tenants through imports, SCIM or a platform admin, not by
+// being added here, so a tenant cannot pull in users it does not manage.
+func (app *Config) UpdateTenantMember(w http.ResponseWriter, r *http.Request) {
+	actor := r.Context().Value(ContextKeyTenant).(*data.TenantMembership)
+
+	userID, err := memberIDParam(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var requestPayload struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !data.ValidTenantRole(requestPayload.Role) {
+		app.errorJSON(w, fmt.Errorf("role must be %s, %s or %s", data.TenantRoleOwner, data.TenantRoleAdmin, data.TenantRoleMember), http.StatusBadRequest)
+		return
+	}
+
+	current, err := app.Models.Tenant.Membership(actor.Tenant, userID)
+	if err != nil {
+		app.tenantErrorJSON(w, err)
+		return
+	}
+
+	if !data.CanChangeMember(actor.Role, current.Role, requestPayload.Role) {
+		app.errorJSON(w, fmt.Errorf("as %s of tenant %s you cannot change a %s into a %s", actor.Role, actor.Tenant, current.Role, requestPayload.Role), http.StatusForbidden)
+		return
+	}
+
+	app.setTenantMemberRole(w, actor.Tenant, userID, requestPayload.Role,
+		fmt.Sprintf("User %d made user %d %s of tenant %s", actor.UserID, userID, requestPayload.Role, actor.Tenant))
+}
+
+// RemoveTenantMember takes a member out of the tenant the user is acting in.
+func (app *Config) RemoveTenantMember(w http.ResponseWriter, r *http.Request) {
+	actor := r.Context().Value(ContextKeyTenant).(*data.TenantMembership)
+
+	userID, err := memberIDParam(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	current, err := app.Models.Tenant.Membership(actor.Tenant, userID)
+	if err != nil {
+		app.tenantErrorJSON(w, err)
+		return
+	}
+
+	if !data.CanChangeMember(actor.Role, current.Role, "") {
+		app.errorJSON(w, fmt.Errorf("as %s of tenant %s you cannot remove a %s", actor.Role, actor.Tenant, current.Role), http.StatusForbidden)
+		return
+	}
+
+	app.removeTenantMember(w, actor.Tenant, userID,
+		fmt.Sprintf("User %d removed user %d from tenant %s", actor.UserID, userID, actor.Tenant))
+}
+
+// AdminListTenants returns the tenants the admin manages: all of them for platform admins,
+// their own for tenant admins.
+func (app *Config) AdminListTenants(w http.ResponseWriter, r *http.Request) {
+	tenants, err := app.Models.Tenant.List(tenantScope(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d tenants", len(tenants)),
+		Data:    tenants,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// AdminCreateTenant creates a tenant, optionally with its first owner. Only platform
+// admins create tenants.
+func (app *Config) AdminCreateTenant(w http.ResponseWriter, r *http.Request) {
+	if !tenantScope(r).Platform() {
+		app.errorJSON(w, fmt.Errorf("only platform admins can create tenants"), http.StatusForbidden)
+		return
+	}
+
+	var requestPayload struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+		OwnerID     int64  `json:"owner_id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	requestPayload.DisplayName = strings.TrimSpace(requestPayload.DisplayName)
+	if !data.ValidTenantName(requestPayload.Name) {
+		app.errorJSON(w, fmt.Errorf("name must be 1 to 63 lowercase letters, digits and dashes"), http.StatusBadRequest)
+		return
+	}
+	if requestPayload.DisplayName == "" {
+		requestPayload.DisplayName = requestPayload.Name
+	}
+	if len(requestPayload.DisplayName) > 200 {
+		app.errorJSON(w, fmt.Errorf("display_name must be at most 200 characters"), http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.OwnerID != 0 {
+		exists, err := app.Models.Tenant.InScope(data.TenantScope{}, requestPayload.OwnerID)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		if !exists {
+			app.errorJSON(w, fmt.Errorf("user %d not found", requestPayload.OwnerID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tenant, err := app.Models.Tenant.Insert(requestPayload.Name, requestPayload.DisplayName)
+	if err != nil {
+		app.tenantErrorJSON(w, err)
+		return
+	}
+
+	if requestPayload.OwnerID != 0 {
+		_, err = app.Models.Tenant.AddMember(tenant.Name, requestPayload.OwnerID, data.TenantRoleOwner)
+		if err != nil {
+			app.tenantErrorJSON(w, err)
+			return
+		}
+		tenant.Members = 1
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	err = app.logRequest("create_tenant", fmt.Sprintf("Admin %d created tenant %s", adminID, tenant.Name))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Tenant %s created", tenant.Name),
+		Data:    tenant,
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// adminTenantParam reads the tenant URL parameter, answering 404 for tenants outside the
+// admin's scope.
+func (app *Config) adminTenantParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tenant := chi.URLParam(r, "tenant")
+	if !tenantScope(r).Allows([]string{tenant}) {
+		app.errorJSON(w, data.ErrTenantNotFound, http.StatusNotFound)
+		return "", false
+	}
+	return tenant, true
+}
+
+// AdminListTenantMembers returns the members of a tenant.
+func (app *Config) AdminListTenantMembers(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := app.adminTenantParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.listTenantMembers(w, tenant)
+}
+
+// AdminSetTenantMember gives a user a role in a tenant. Tenant admins can change the roles
+// of their tenant's members; adding users to a tenant is up to platform admins.
+func (app *Config) AdminSetTenantMember(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := app.adminTenantParam(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := memberIDParam(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var requestPayload struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !data.ValidTenantRole(requestPayload.Role) {
+		app.errorJSON(w, fmt.Errorf("role must be %s, %s or %s", data.TenantRoleOwner, data.TenantRoleAdmin, data.TenantRoleMember), http.StatusBadRequest)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	logMessage := fmt.Sprintf("Admin %d made user %d %s of tenant %s", adminID, userID, requestPayload.Role, tenant)
+
+	_, err = app.Models.Tenant.Membership(tenant, userID)
+	if err == nil {
+		app.setTenantMemberRole(w, tenant, userID, requestPayload.Role, logMessage)
+		return
+	}
+	if !errors.Is(err, data.ErrNotTenantMember) {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !tenantScope(r).Platform() {
+		app.errorJSON(w, fmt.Errorf("user %d not found", userID), http.StatusNotFound)
+		return
+	}
+
+	if _, err := app.Models.Tenant.Get(tenant); err != nil {
+		app.tenantErrorJSON(w, err)
+		return
+	}
+	exists, err := app.Models.Tenant.InScope(data.TenantScope{}, userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if !exists {
+		app.errorJSON(w, fmt.Errorf("user %d not found", userID), http.StatusNotFound)
+		return
+	}
+
+	membership, err := app.Models.Tenant.AddMember(tenant, userID, requestPayload.Role)
+	if err != nil {
+		app.tenantErrorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "add_tenant_member", logMessage)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("User %d joined tenant %s", userID, tenant),
+		Data:    membership,
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// AdminRemoveTenantMember takes a user out of a tenant.
+func (app *Config) AdminRemoveTenantMember(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := app.adminTenantParam(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := memberIDParam(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	app.removeTenantMember(w, tenant, userID, fmt.Sprintf("Admin %d removed user %d from tenant %s", adminID, userID, tenant))
+}
+
+func (app *Config) listTenantMembers(w http.ResponseWriter, tenant string) {
+	members, err := app.Models.Tenant.Members(tenant)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d members of tenant %s", len(members), tenant),
+		Data:    members,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+func (app *Config) setTenantMemberRole(w http.ResponseWriter, tenant string, userID int64, role, logMessage string) {
+	membership, err := app.Models.Tenant.SetMemberRole(tenant, userID, role)
+	if err != nil {
+		app.tenantErrorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "update_tenant_member", logMessage)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("User %d is now %s of tenant %s", userID, role, tenant),
+		Data:    membership,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+func (app *Config) removeTenantMember(w http.ResponseWriter, tenant string, userID int64, logMessage string) {
+	err := app.Models.Tenant.RemoveMember(tenant, userID)
+	if err != nil {
+		app.tenantErrorJSON(w, err)
+		return
+	}
+
+	err = app.logUserRequest(userID, "remove_tenant_member", logMessage)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("User %d is no longer a member of tenant %s", userID, tenant),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
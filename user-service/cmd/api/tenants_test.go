@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"proto/users"
+	"user-service/data"
+)
+
+// expectInScope expects a check whether a user is within an admin's scope.
+func (a *testApp) expectInScope(scope data.TenantScope, userID int64, in bool) {
+	query := a.mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM users WHERE id = \$1`)
+	if scope.Platform() {
+		query.WithArgs(userID)
+	} else {
+		query.WithArgs(userID, scope.Tenant)
+	}
+	query.WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(in))
+}
+
+// expectOwnsAccount expects a check whether a user belongs to tenants other than tenant.
+func (a *testApp) expectOwnsAccount(tenant string, userID int64, owns bool) {
+	a.mock.ExpectQuery(`SELECT NOT EXISTS \(SELECT 1 FROM tenant_members WHERE user_id = \$1 AND tenant <> \$2\)`).
+		WithArgs(userID, tenant).
+		WillReturnRows(sqlmock.NewRows([]string{"not_exists"}).AddRow(owns))
+}
+
+// serveScoped runs a request for a user through the admin per-user middleware with scope,
+// answering 204 when it gets through.
+func (a *testApp) serveScoped(scope data.TenantScope, userID string, changesAccount bool) int {
+	mux := chi.NewRouter()
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ContextKeyTenantScope, scope)))
+		})
+	})
+	middlewares := chi.Middlewares{a.RequireUserInScope}
+	if changesAccount {
+		middlewares = append(middlewares, a.RequireAccountInScope)
+	}
+	mux.With(middlewares...).Get("/users/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/"+userID, nil))
+
+	return w.Code
+}
+
+func TestAdminScopeAcrossTenants(t *testing.T) {
+	acme := data.TenantScope{Tenant: "acme"}
+
+	for _, tt := range []struct {
+		name           string
+		scope          data.TenantScope
+		changesAccount bool
+		inScope        bool
+		owns           bool
+		want           int
+	}{
+		{"member of another tenant", acme, false, false, false, http.StatusNotFound},
+		{"change member of another tenant", acme, true, false, false, http.StatusNotFound},
+		{"member of the tenant", acme, false, true, false, http.StatusNoContent},
+		{"change member of the tenant only", acme, true, true, true, http.StatusNoContent},
+		{"change member shared with another tenant", acme, true, true, false, http.StatusForbidden},
+		{"platform admin changes shared member", data.TenantScope{}, true, true, false, http.StatusNoContent},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			app.expectInScope(tt.scope, 8, tt.inScope)
+			if tt.inScope && tt.changesAccount && !tt.scope.Platform() {
+				app.expectOwnsAccount(tt.scope.Tenant, 8, tt.owns)
+			}
+
+			if got := app.serveScoped(tt.scope, "8", tt.changesAccount); got != tt.want {
+				t.Fatalf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminRoutesAcrossTenants(t *testing.T) {
+	acme := data.TenantScope{Tenant: "acme"}
+
+	routes := []struct {
+		method, path, body string
+	}{
+		{http.MethodPatch, "/api/admin/users/8", `{"first_name": "Jane"}`},
+		{http.MethodDelete, "/api/admin/users/8/avatar", ""},
+		{http.MethodDelete, "/api/admin/users/8/api-keys/3", ""},
+		{http.MethodPost, "/api/admin/users/8/erasure", ""},
+	}
+
+	t.Run("member of another tenant", func(t *testing.T) {
+		for _, route := range append(routes, struct{ method, path, body string }{http.MethodGet, "/api/admin/users/8", ""}) {
+			app := newTestApp(t)
+			app.expectInScope(acme, 8, false)
+
+			w := app.do(route.method, route.path, app.token(t, "admin", 1, jwt.MapClaims{"tenant": "acme"}), route.body)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s %s: status = %d, want %d", route.method, route.path, w.Code, http.StatusNotFound)
+			}
+		}
+	})
+
+	t.Run("member shared with another tenant", func(t *testing.T) {
+		for _, route := range routes {
+			app := newTestApp(t)
+			app.expectInScope(acme, 8, true)
+			app.expectOwnsAccount("acme", 8, false)
+
+			w := app.do(route.method, route.path, app.token(t, "admin", 1, jwt.MapClaims{"tenant": "acme"}), route.body)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s %s: status = %d, want %d", route.method, route.path, w.Code, http.StatusForbidden)
+			}
+		}
+	})
+}
+
+func TestGetUserStaysInTenant(t *testing.T) {
+	app := newTestApp(t)
+	server := &UserServer{Models: app.Models}
+	app.expectInScope(data.TenantScope{Tenant: "acme"}, 8, false)
+
+	_, err := server.GetUser(context.Background(), &users.GetUserRequest{UserId: 8, Tenant: "acme"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("code = %v, want %v", code, codes.NotFound)
+	}
+}
+
+func TestUpdateUserStatusAcrossTenants(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		tenant  string
+		inScope bool
+		owns    bool
+		want    codes.Code
+	}{
+		{"member of another tenant", "acme", false, false, codes.NotFound},
+		{"member shared with another tenant", "acme", true, false, codes.PermissionDenied},
+		{"member of the tenant only", "acme", true, true, codes.OK},
+		{"platform admin", "", true, false, codes.OK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			scope := data.TenantScope{Tenant: tt.tenant}
+			app.expectInScope(scope, 8, tt.inScope)
+			if tt.inScope && !scope.Platform() {
+				app.expectOwnsAccount(tt.tenant, 8, tt.owns)
+			}
+
+			var changed bool
+			server := &UserServer{
+				Models: app.Models,
+				ChangeUserStatus: func(userID int64, change data.StatusChange, actor string) (*data.User, error) {
+					changed = true
+					return &data.User{ID: userID, Status: change.Status}, nil
+				},
+			}
+
+			_, err := server.UpdateUserStatus(context.Background(), &users.UpdateUserStatusRequest{
+				UserId: 8,
+				Status: data.UserStatusBanned,
+				Reason: "spam",
+				Actor:  "Admin 1",
+				Tenant: tt.tenant,
+			})
+			if code := status.Code(err); code != tt.want {
+				t.Fatalf("code = %v, want %v: %v", code, tt.want, err)
+			}
+			if changed != (tt.want == codes.OK) {
+				t.Fatalf("status changed = %v", changed)
+			}
+		})
+	}
+}
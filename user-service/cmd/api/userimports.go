@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"password"
+	"user-service/data"
+)
+
+const (
+	// userImportPollInterval is how often the worker looks for new imports.
+	userImportPollInterval = 5 * time.Second
+
+	// userImportMaxBytes is how large an import upload can be.
+	userImportMaxBytes = 10 << 20
+
+	// userImportListLimit is how many imports the listing shows.
+	userImportListLimit = 100
+
+	// userImportBusyDelay is how long a row waits before trying again when passwords
+	// cannot be hashed because logins are keeping the hashing pool busy.
+	userImportBusyDelay = time.Second
+)
+
+// uploadFormat returns the format of an import upload, from the format query parameter or
+// else the Content-Type.
+func uploadFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+
+	switch strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]) {
+	case "text/csv":
+		return data.ImportFormatCSV
+	case "application/x-ndjson", "application/ndjson":
+		return data.ImportFormatNDJSON
+	}
+	return ""
+}
+
+// AdminImportUsers starts an import of the users in a CSV or NDJSON upload. With
+// invite=true the users get an email to set their password, through the reset page of
+// client, and rows must not have one. The rows are checked and created in the background;
+// the report is at GET /api/admin/user-imports/{import_id}.
+func (app *Config) AdminImportUsers(w http.ResponseWriter, r *http.Request) {
+	format := uploadFormat(r)
+
+	invite := false
+	if v := r.URL.Query().Get("invite"); v != "" {
+		var err error
+		invite, err = strconv.ParseBool(v)
+		if err != nil {
+			app.errorJSON(w, fmt.Errorf("invite must be true or false"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	client := r.URL.Query().Get("client")
+	if _, err := app.resetLink(client, "", ""); err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rows, err := data.ParseImport(format, http.MaxBytesReader(w, r.Body, userImportMaxBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			app.errorJSON(w, fmt.Errorf("the upload cannot be larger than %d bytes", userImportMaxBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	requestedBy := fmt.Sprintf("admin:%d", adminID)
+
+	// Admins of a tenant import users into it
+	userImport, err := app.Models.UserImport.Insert(format, invite, client, requestedBy, tenantScope(r).Tenant, rows)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.logRequest("import_users", fmt.Sprintf("Admin %d started import %d of %d users", adminID, userImport.ID, userImport.Total))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Import %d of %d users started", userImport.ID, userImport.Total),
+		Data:    userImport,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// AdminListUserImports returns the latest imports in the admin's scope, without their rows.
+func (app *Config) AdminListUserImports(w http.ResponseWriter, r *http.Request) {
+	imports, err := app.Models.UserImport.List(tenantScope(r), userImportListLimit)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Found %d imports", len(imports)),
+		Data:    imports,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// AdminGetUserImport returns an import with what became of each row.
+func (app *Config) AdminGetUserImport(w http.ResponseWriter, r *http.Request) {
+	importID, err := strconv.ParseInt(chi.URLParam(r, "import_id"), 10, 64)
+	if err != nil || importID < 1 {
+		app.errorJSON(w, fmt.Errorf("invalid import ID"), http.StatusBadRequest)
+		return
+	}
+
+	userImport, err := app.Models.UserImport.Get(importID, true)
+	if err != nil {
+		if errors.Is(err, data.ErrUserImportNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	// Imports of other tenants are not the admin's to see
+	if scope := tenantScope(r); !scope.Platform() && userImport.Tenant != scope.Tenant {
+		app.errorJSON(w, data.ErrUserImportNotFound, http.StatusNotFound)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Import %d is %s", userImport.ID, userImport.Status),
+		Data:    userImport,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorJSON(w, err)
+	}
+}
+
+// AdminExportUsers streams the users matching the directory filters as CSV or NDJSON
+// (format), with the columns listed in columns, or all of them.
+func (app *Config) AdminExportUsers(w http.ResponseWriter, r *http.Request) {
+	query, err := directoryQuery(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	query.Tenant = tenantScope(r).Tenant
+	// The export is never paged
+	query.Limit = 0
+	query.Cursor = ""
+
+	columns, err := data.ParseExportColumns(r.URL.Query().Get("columns"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = data.ImportFormatCSV
+	}
+
+	var write func(user *data.User) error
+	var flush func() error
+
+	switch format {
+	case data.ImportFormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer := csv.NewWriter(w)
+		write = func(user *data.User) error {
+			return writer.Write(data.ExportRecord(user, columns))
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+		writer.Write(columns)
+	case data.ImportFormatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		write = func(user *data.User) error {
+			record := data.ExportRecord(user, columns)
+			object := make(map[string]any, len(columns))
+			for i, column := range columns {
+				switch {
+				case column == "id":
+					object[column] = user.ID
+				case strings.HasSuffix(column, "_at") && record[i] == "":
+					object[column] = nil
+				default:
+					object[column] = record[i]
+				}
+			}
+			return encoder.Encode(object)
+		}
+		flush = func() error { return nil }
+	default:
+		app.errorJSON(w, fmt.Errorf("format must be %s or %s", data.ImportFormatCSV, data.ImportFormatNDJSON), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	count := 0
+
+	// Headers are out, so errors can only cut the export short
+	err = app.Models.User.Directory(r.Context(), query, func(user *data.User, _ string) error {
+		err := write(user)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%500 == 0 && flusher != nil {
+			err = flush()
+			if err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		log.Printf("User export stopped after %d users: %v", count, err)
+		return
+	}
+
+	adminID, _ := r.Context().Value(ContextKeyUserID).(int64)
+	err = app.logRequest("export_users", fmt.Sprintf("Admin %d exported %d users", adminID, count))
+	if err != nil {
+		log.Printf("Failed to log user export: %v", err)
+	}
+}
+
+// runUserImports runs new imports, one at a time, until the process exits. Imports are
+// claimed in the database, so several instances can run it side by side, and an
+// interrupted import continues with the rows it has not processed.
+func (app *Config) runUserImports() {
+	ticker := time.NewTicker(userImportPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			userImport, err := app.Models.UserImport.Claim()
+			if err != nil {
+				log.Printf("Failed to claim a user import: %v", err)
+				break
+			}
+			if userImport == nil {
+				break
+			}
+
+			app.processUserImport(userImport)
+		}
+	}
+}
+
+// processUserImport creates the users of the import's pending rows and records the
+// outcome of each. It stops at the first error that is not about a row.
+func (app *Config) processUserImport(userImport *data.UserImport) {
+	rows, err := app.Models.UserImport.PendingRows(userImport.ID)
+	if err == nil {
+		for _, row := range rows {
+			err = app.importUserRow(userImport, row)
+			if err != nil {
+				break
+			}
+
+			err = app.Models.UserImport.FinishRow(userImport.ID, row)
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	message := ""
+	if err != nil {
+		log.Printf("User import %d failed: %v", userImport.ID, err)
+		message = err.Error()
+	}
+
+	err = app.Models.UserImport.Complete(userImport.ID, message)
+	if err != nil {
+		log.Printf("User import %d: failed to complete: %v", userImport.ID, err)
+		return
+	}
+
+	userImport, err = app.Models.UserImport.Get(userImport.ID, false)
+	if err != nil {
+		log.Printf("User import %d: failed to load the result: %v", userImport.ID, err)
+		return
+	}
+
+	err = app.logRequest("import_users", fmt.Sprintf("Import %d %s: %d users created, %d rows failed",
+		userImport.ID, userImport.Status, userImport.Created, userImport.Failed))
+	if err != nil {
+		log.Printf("User import %d: failed to log the result: %v", userImport.ID, err)
+	}
+}
+
+// importUserRow validates a row and creates its user, marking the row created or failed.
+// Only errors that are not the row's fault are returned.
+func (app *Config) importUserRow(userImport *data.UserImport, row *data.ImportRow) error {
+	record := row.Record
+
+	// Usernames default to the local part of the email
+	if strings.TrimSpace(record.Username) == "" {
+		record.Username, _, _ = strings.Cut(strings.TrimSpace(record.Email), "@")
+	}
+
+	update := data.ProfileUpdate{
+		Email:     &record.Email,
+		UserName:  &record.Username,
+		FirstName: &record.FirstName,
+		LastName:  &record.LastName,
+		Phone:     &record.Phone,
+		Address:   &record.Address,
+		City:      &record.City,
+		State:     &record.State,
+		ZipCode:   &record.ZipCode,
+	}
+	fields := validateProfile(&update)
+
+	switch {
+	case userImport.Invite && record.Password != "":
+		fields["password"] = append(fields["password"], password.Violation{Code: codeInvalid, Message: "passwords cannot be set when inviting users"})
+	case !userImport.Invite && record.Password == "":
+		fields["password"] = append(fields["password"], password.Violation{Code: codeRequired, Message: "password cannot be empty"})
+	case !userImport.Invite:
+		violations := app.PasswordPolicy.Check(record.Password, password.Identity{Email: record.Email, Username: record.Username})
+		fields["password"] = append(fields["password"], violations...)
+	}
+
+	if errs := violationMessages(fields); len(errs) > 0 {
+		row.Fail(errs...)
+		return nil
+	}
+
+	user := &data.User{
+		Email:     record.Email,
+		UserName:  record.Username,
+		FirstName: record.FirstName,
+		LastName:  record.LastName,
+		Phone:     record.Phone,
+		Address:   record.Address,
+		City:      record.City,
+		State:     record.State,
+		ZipCode:   record.ZipCode,
+	}
+
+	err := app.Models.User.InsertImported(user, record.Password, userImport.Tenant)
+	// Imports are not in a hurry, logins go first
+	for errors.Is(err, password.ErrBusy) {
+		time.Sleep(userImportBusyDelay)
+		err = app.Models.User.InsertImported(user, record.Password, userImport.Tenant)
+	}
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			row.Fail("email: " + err.Error())
+			return nil
+		}
+		return fmt.Errorf("line %d: %w", row.Line, err)
+	}
+
+	row.Status = data.UserImportCreated
+	row.UserID = user.ID
+
+	if userImport.Invite {
+		err = app.inviteUser(user, userImport.Client)
+		if err != nil {
+			log.Printf("User import %d: failed to invite user %d: %v", userImport.ID, user.ID, err)
+			row.Errors = append(row.Errors, "the user was created, but the invitation could not be sent: "+err.Error())
+		}
+	}
+
+	err = app.logUserRequest(user.ID, "registration", fmt.Sprintf("User %s was created by import %d", user.Email, userImport.ID))
+	if err != nil {
+		log.Printf("User import %d: failed to log the creation of user %d: %v", userImport.ID, user.ID, err)
+	}
+
+	return nil
+}
+
+// inviteUser emails a new user a link to set their password, through client's reset page.
+func (app *Config) inviteUser(user *data.User, client string) error {
+	token, err := app.Models.Token.CreateResetToken(user.ID, data.InvitationTTL)
+	if err != nil {
+		return err
+	}
+
+	link, err := app.resetLink(client, user.Email, token.PlainText)
+	if err != nil {
+		return err
+	}
+
+	return app.sendMail(user.Email, "You have been invited", fmt.Sprintf(
+		"An account has been created for you. Please use the following link to set your password. It is valid for %s: %s",
+		data.InvitationTTL, link))
+}
+
+// violationMessages flattens validation problems into "field: message" strings, ordered
+// by field.
+func violationMessages(fields map[string][]password.Violation) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var messages []string
+	for _, name := range names {
+		for _, v := range fields[name] {
+			messages = append(messages, name+": "+v.Message)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"user-service/data"
+)
+
+// suspensionExpiryInterval is how often suspensions that have run out are lifted.
+const suspensionExpiryInterval = time.Minute
+
+// changeUserStatus moves a user to another status. Users who can no longer log in lose
+// their sessions, and suspended, banned and reinstated users are told by email.
+func (app *Config) changeUserStatus(userID int64, change data.StatusChange, actor string) (*data.User, error) {
+	previous, err := app.Models.User.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.Models.User.SetStatus(userID, change)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.AccountStatus() != data.UserStatusActive {
+		err = app.revokeUserSessions(user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("the status was saved, but ending the user's sessions failed: %w", err)
+		}
+	}
+
+	app.notifyStatusChange(previous, user)
+
+	message := fmt.Sprintf("%s set the status of user %d to %s", actor, user.ID, user.AccountStatus())
+	if user.StatusReason != "" {
+		message += ": " + user.StatusReason
+	}
+	err = app.logUserRequest(user.ID, "update_user_status", message)
+	if err != nil {
+		log.Printf("Failed to log status change of user %d: %v", user.ID, err)
+	}
+
+	return user, nil
+}
+
+// notifyStatusChange emails a user whose account was suspended, banned, or reinstated
+// after either. Tenants tell their own users about deactivations.
+func (app *Config) notifyStatusChange(previous, user *data.User) {
+	var subject, message string
+
+	switch user.AccountStatus() {
+	case data.UserStatusSuspended:
+		subject = "Your account has been suspended"
+		message = fmt.Sprintf("Your account has been suspended until %s.\n\nReason: %s",
+			user.SuspendedUntil.UTC().Format("2 January 2006 15:04 MST"), user.StatusReason)
+	case data.UserStatusBanned:
+		subject = "Your account has been banned"
+		message = fmt.Sprintf("Your account has been banned and you can no longer log in.\n\nReason: %s", user.StatusReason)
+	case data.UserStatusActive:
+		if previous.Status == data.UserStatusActive {
+			return
+		}
+		subject = "Your account has been reinstated"
+		message = "Your account is active again and you can log in."
+	default:
+		return
+	}
+
+	err := app.sendMail(user.Email, subject, message)
+	if err != nil {
+		log.Printf("Failed to notify user %d of their status change: %v", user.ID, err)
+	}
+}
+
+// runSuspensionExpiry lifts suspensions once they run out and lets the users know.
+func (app *Config) runSuspensionExpiry() {
+	ticker := time.NewTicker(suspensionExpiryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired, err := app.Models.User.ExpireSuspensions()
+		if err != nil {
+			log.Printf("Failed to expire suspensions: %v", err)
+			continue
+		}
+
+		for _, user := range expired {
+			app.notifyStatusChange(&data.User{Status: data.UserStatusSuspended}, user)
+
+			err = app.logUserRequest(user.ID, "suspension_expired", fmt.Sprintf("The suspension of user %d has ended", user.ID))
+			if err != nil {
+				log.Printf("Failed to log the end of the suspension of user %d: %v", user.ID, err)
+			}
+		}
+	}
+}
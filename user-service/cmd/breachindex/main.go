@@ -0,0 +1,75 @@
+// Command breachindex converts a Have I Been Pwned "Pwned Passwords" SHA-1 download into the
+// compact index read by the password policy (PASSWORD_BREACH_INDEX).
+//
+//	breachindex -out breached.idx pwnedpasswords.txt
+//	breachindex -out breached.idx -min-count 10 ranges/*.txt
+//
+// Range files named after their five character hash prefix (as written by the HIBP
+// downloader) are recognised by their name and can be passed in any order.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"password"
+)
+
+func main() {
+	out := flag.String("out", "breached.idx", "path of the index to write")
+	minCount := flag.Int("min-count", 0, "skip hashes seen fewer times than this")
+	flag.Parse()
+
+	inputs := flag.Args()
+	if len(inputs) == 0 {
+		log.Fatal("usage: breachindex -out breached.idx [-min-count n] file...")
+	}
+	sort.Strings(inputs)
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	builder, err := password.NewBreachIndexBuilder(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	builder.MinCount = *minCount
+
+	for _, input := range inputs {
+		err := addFile(builder, input)
+		if err != nil {
+			log.Fatalf("%s: %v", input, err)
+		}
+	}
+
+	err = builder.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Wrote %d hashes to %s", builder.Len(), *out)
+}
+
+func addFile(builder *password.BreachIndexBuilder, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Range files only hold hash suffixes, their prefix is the file name
+	prefix := ""
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if len(name) == 5 {
+		prefix = strings.ToUpper(name)
+	}
+
+	return builder.Add(f, prefix)
+}
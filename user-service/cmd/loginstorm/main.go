@@ -0,0 +1,168 @@
+// Command loginstorm sends a burst of concurrent ValidateUser calls to user-service while
+// timing an unrelated request alongside, and prints the tail latency of both. With the
+// password hashing pool in place, logins beyond its queue are answered RESOURCE_EXHAUSTED
+// right away, and the unrelated request stays fast.
+//
+//	loginstorm -email jane@example.org -password secret
+//	loginstorm -grpc user-service:50001 -probe http://user-service/ping -concurrency 200 -duration 30s
+//
+// Compare the output with PASSWORD_HASH_WORKERS and PASSWORD_HASH_QUEUE at different
+// settings, and watch the pool on the service's METRICS_ADDR while it runs.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"proto/users"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// probeInterval is how often the unrelated request is timed during the storm.
+const probeInterval = 50 * time.Millisecond
+
+// results collects latencies by outcome.
+type results struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+}
+
+func (r *results) add(outcome string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[outcome] = append(r.latencies[outcome], d)
+}
+
+func (r *results) print(title string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	outcomes := make([]string, 0, len(r.latencies))
+	for outcome := range r.latencies {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Strings(outcomes)
+
+	fmt.Println(title)
+	for _, outcome := range outcomes {
+		d := r.latencies[outcome]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		fmt.Printf("  %-20s %7d  p50 %-12s p95 %-12s p99 %-12s max %s\n", outcome, len(d),
+			percentile(d, 50), percentile(d, 95), percentile(d, 99), d[len(d)-1])
+	}
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+func main() {
+	addr := flag.String("grpc", "localhost:50001", "user-service gRPC address")
+	probe := flag.String("probe", "http://localhost/ping", "URL of an unrelated request to time during the storm, empty for none")
+	email := flag.String("email", "", "email to log in with")
+	password := flag.String("password", "", "password to log in with, a wrong one is hashed just the same")
+	concurrency := flag.Int("concurrency", 100, "logins in flight at once")
+	duration := flag.Duration("duration", 10*time.Second, "how long the storm lasts")
+	timeout := flag.Duration("timeout", time.Second, "deadline of each login, as auth-service uses")
+	flag.Parse()
+
+	if *email == "" || *concurrency < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := users.NewUserServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	logins := &results{latencies: map[string][]time.Duration{}}
+	probes := &results{latencies: map[string][]time.Duration{}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				outcome, elapsed := login(ctx, client, *email, *password, *timeout)
+				// Logins cut short by the end of the storm say nothing
+				if ctx.Err() == nil {
+					logins.add(outcome, elapsed)
+				}
+			}
+		}()
+	}
+
+	if *probe != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(probeInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					probes.add(get(*probe))
+				}
+			}
+		}()
+	}
+
+	log.Printf("Sending %d concurrent logins to %s for %s", *concurrency, *addr, *duration)
+	wg.Wait()
+
+	logins.print("ValidateUser")
+	if *probe != "" {
+		probes.print("GET " + *probe)
+	}
+}
+
+// login times one ValidateUser call and names its outcome.
+func login(ctx context.Context, client users.UserServiceClient, email, password string, timeout time.Duration) (string, time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	response, err := client.ValidateUser(ctx, &users.ValidateUserRequest{Email: email, Password: password})
+	elapsed := time.Since(start)
+
+	switch {
+	case err != nil:
+		return status.Code(err).String(), elapsed
+	case response.GetIsValid():
+		return "valid", elapsed
+	default:
+		return "invalid", elapsed
+	}
+}
+
+// get times one GET request and names its outcome.
+func get(url string) (string, time.Duration) {
+	start := time.Now()
+	response, err := http.Get(url)
+	elapsed := time.Since(start)
+	if err != nil {
+		return "error", elapsed
+	}
+	response.Body.Close()
+
+	return response.Status, elapsed
+}
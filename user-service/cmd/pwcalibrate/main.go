@@ -0,0 +1,60 @@
+// Command pwcalibrate measures password hashing on the machine it runs on and prints the
+// cost parameters that make one hash take about the target time, as the environment
+// variables read by the user and admin services.
+//
+//	pwcalibrate -target 250ms
+//	pwcalibrate -target 500ms -memory 65536 -threads 4
+//	pwcalibrate -algorithm bcrypt -target 250ms
+//
+// Run it on the hardware the services are deployed to, with the CPU otherwise idle.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"runtime"
+	"time"
+
+	"password"
+)
+
+func main() {
+	algorithm := flag.String("algorithm", password.AlgorithmArgon2id, "argon2id or bcrypt")
+	target := flag.Duration("target", defaultTarget, "how long one hash should take")
+	memory := flag.Uint("memory", 64*1024, "argon2 memory in KiB, halved if a single pass is slower than the target")
+	threads := flag.Uint("threads", 1, "argon2 parallelism")
+	flag.Parse()
+
+	if *target <= 0 {
+		log.Fatal("target must be positive")
+	}
+
+	switch *algorithm {
+	case password.AlgorithmArgon2id:
+		if *threads < 1 || *threads > 255 || *memory < 8*(*threads) || uint64(*memory) > math.MaxUint32 {
+			log.Fatal("invalid memory or threads")
+		}
+		if int(*threads) > runtime.NumCPU() {
+			log.Printf("Warning: %d threads on %d CPUs", *threads, runtime.NumCPU())
+		}
+
+		params, elapsed := password.CalibrateArgon2(*target, uint32(*memory), uint8(*threads))
+		log.Printf("One hash takes %s", elapsed)
+
+		fmt.Printf("PASSWORD_HASH_ALGORITHM=%s\n", password.AlgorithmArgon2id)
+		fmt.Printf("PASSWORD_ARGON2_PARAMS=%s\n", params)
+	case password.AlgorithmBcrypt:
+		cost, elapsed := password.CalibrateBcrypt(*target)
+		log.Printf("One hash takes %s", elapsed)
+
+		fmt.Printf("PASSWORD_HASH_ALGORITHM=%s\n", password.AlgorithmBcrypt)
+		fmt.Printf("PASSWORD_BCRYPT_COST=%d\n", cost)
+	default:
+		log.Fatalf("unknown algorithm %q", *algorithm)
+	}
+}
+
+// defaultTarget keeps logins responsive while making offline guessing expensive.
+const defaultTarget = 250 * time.Millisecond
@@ -0,0 +1,128 @@
+// Package credentials checks a user's email and password against a chain of backends:
+// the local password hashes first, then any configured external directory.
+package credentials
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"password"
+	"user-service/data"
+)
+
+// Errors returned by backends. ErrUnknownUser lets the chain try the next backend, while
+// ErrInvalidCredentials stops it: the account exists but the password is wrong.
+var (
+	ErrUnknownUser        = errors.New("unknown user")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Identity is an account a backend has authenticated.
+type Identity struct {
+	Source    string // data.AuthSourceLocal or the external backend's name
+	UserID    int64  // only known for local accounts
+	Email     string
+	Username  string
+	FirstName string
+	LastName  string
+}
+
+// Backend authenticates an email and password.
+type Backend interface {
+	Name() string
+	Authenticate(ctx context.Context, email, password string) (*Identity, error)
+}
+
+// Chain tries each backend in turn until one knows the user.
+type Chain []Backend
+
+// Authenticate returns the identity from the first backend that knows the user, or
+// ErrUnknownUser if none does. Other errors are returned with the backend's name.
+func (c Chain) Authenticate(ctx context.Context, email, password string) (*Identity, error) {
+	for _, b := range c {
+		identity, err := b.Authenticate(ctx, email, password)
+		switch {
+		case err == nil:
+			return identity, nil
+		case errors.Is(err, ErrUnknownUser):
+			continue
+		case errors.Is(err, ErrInvalidCredentials):
+			return nil, err
+		default:
+			return nil, fmt.Errorf("%s backend: %w", b.Name(), err)
+		}
+	}
+
+	return nil, ErrUnknownUser
+}
+
+// ChainFromEnv builds the chain: local accounts first, then LDAP when LDAP_URL is set.
+func ChainFromEnv(users UserStore) (Chain, error) {
+	chain := Chain{&Local{Users: users}}
+
+	ldapConfig, err := LDAPConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if ldapConfig != nil {
+		chain = append(chain, &LDAP{Config: *ldapConfig})
+	}
+
+	names := make([]string, 0, len(chain))
+	for _, b := range chain {
+		names = append(names, b.Name())
+	}
+	log.Printf("Credential backends: %s", strings.Join(names, ", "))
+
+	return chain, nil
+}
+
+// UserStore is the part of data.UserModel the local backend needs.
+type UserStore interface {
+	GetUserByEmail(email string) (*data.User, error)
+	PasswordMatches(ctx context.Context, userID int64, plainText string) (bool, error)
+}
+
+// Local checks passwords against the hashes in the users table. Accounts provisioned
+// from an external directory are left to that directory's backend.
+type Local struct {
+	Users UserStore
+}
+
+func (l *Local) Name() string {
+	return data.AuthSourceLocal
+}
+
+func (l *Local) Authenticate(ctx context.Context, email, plainText string) (*Identity, error) {
+	user, err := l.Users.GetUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUnknownUser
+		}
+		return nil, err
+	}
+
+	if user.AuthSource != data.AuthSourceLocal {
+		return nil, ErrUnknownUser
+	}
+
+	valid, err := l.Users.PasswordMatches(ctx, user.ID, plainText)
+	// The password was never checked, so the login must not count as a wrong one
+	if errors.Is(err, password.ErrBusy) || ctx.Err() != nil {
+		return nil, err
+	}
+	if err != nil || !valid {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Source:   data.AuthSourceLocal,
+		UserID:   user.ID,
+		Email:    user.Email,
+		Username: user.UserName,
+	}, nil
+}
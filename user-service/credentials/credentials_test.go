@@ -0,0 +1,362 @@
+package credentials
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"password"
+	"user-service/data"
+
+	"github.com/jimlambrt/gldap"
+)
+
+const (
+	testServiceDN       = "cn=reader,dc=example,dc=org"
+	testServicePassword = "reader-secret"
+	testSearchBase      = "ou=people,dc=example,dc=org"
+)
+
+type testEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// startTestDirectory runs an in-process LDAP server holding entries. Searches match on the
+// mail attribute of the filter the LDAP backend sends.
+func startTestDirectory(t *testing.T, entries ...testEntry) string {
+	t.Helper()
+
+	server, err := gldap.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux, err := gldap.NewMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux.Bind(func(w *gldap.ResponseWriter, r *gldap.Request) {
+		resp := r.NewBindResponse(gldap.WithResponseCode(gldap.ResultInvalidCredentials))
+		defer w.Write(resp)
+
+		m, err := r.GetSimpleBindMessage()
+		if err != nil {
+			return
+		}
+
+		if m.UserName == testServiceDN && string(m.Password) == testServicePassword {
+			resp.SetResultCode(gldap.ResultSuccess)
+			return
+		}
+		for _, e := range entries {
+			if m.UserName == e.dn && string(m.Password) == e.password {
+				resp.SetResultCode(gldap.ResultSuccess)
+				return
+			}
+		}
+	})
+
+	mux.Search(func(w *gldap.ResponseWriter, r *gldap.Request) {
+		resp := r.NewSearchDoneResponse(gldap.WithResponseCode(gldap.ResultSuccess))
+		defer w.Write(resp)
+
+		m, err := r.GetSearchMessage()
+		if err != nil {
+			resp.SetResultCode(gldap.ResultOperationsError)
+			return
+		}
+		if m.BaseDN != testSearchBase {
+			resp.SetResultCode(gldap.ResultNoSuchObject)
+			return
+		}
+
+		for _, e := range entries {
+			for _, mail := range e.attrs["mail"] {
+				if !strings.Contains(m.Filter, "(mail="+mail+")") {
+					continue
+				}
+				entry := r.NewSearchResponseEntry(e.dn)
+				for name, values := range e.attrs {
+					entry.AddAttribute(name, values)
+				}
+				w.Write(entry)
+			}
+		}
+	})
+
+	err = server.Router(mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	go server.Run(addr)
+	t.Cleanup(func() { server.Stop() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !server.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("test directory did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return "ldap://" + addr
+}
+
+func newTestLDAP(t *testing.T, entries ...testEntry) *LDAP {
+	t.Helper()
+
+	config := DefaultLDAPConfig()
+	config.URL = startTestDirectory(t, entries...)
+	config.BindDN = testServiceDN
+	config.BindPassword = testServicePassword
+	config.SearchBase = testSearchBase
+
+	return &LDAP{Config: config}
+}
+
+var jane = testEntry{
+	dn:       "uid=jane,ou=people,dc=example,dc=org",
+	password: "directory-password",
+	attrs: map[string][]string{
+		"mail":      {"jane@example.org"},
+		"uid":       {"jane"},
+		"givenName": {"Jane"},
+		"sn":        {"Doe"},
+	},
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestLDAP(t, jane)
+
+	identity, err := backend.Authenticate(ctx, "jane@example.org", "directory-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Identity{
+		Source:    AuthSourceLDAP,
+		Email:     "jane@example.org",
+		Username:  "jane",
+		FirstName: "Jane",
+		LastName:  "Doe",
+	}
+	if *identity != want {
+		t.Fatalf("got %+v, want %+v", *identity, want)
+	}
+
+	if _, err := backend.Authenticate(ctx, "jane@example.org", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("wrong password: expected ErrInvalidCredentials, got %v", err)
+	}
+
+	if _, err := backend.Authenticate(ctx, "jane@example.org", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("empty password: expected ErrInvalidCredentials, got %v", err)
+	}
+
+	if _, err := backend.Authenticate(ctx, "john@example.org", "directory-password"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("unknown user: expected ErrUnknownUser, got %v", err)
+	}
+}
+
+func TestLDAPAttributeMapping(t *testing.T) {
+	entry := testEntry{
+		dn:       "cn=John Smith,ou=people,dc=example,dc=org",
+		password: "secret",
+		attrs: map[string][]string{
+			"mail":           {"john@example.org"},
+			"sAMAccountName": {"jsmith"},
+		},
+	}
+
+	backend := newTestLDAP(t, entry)
+	backend.Config.UsernameAttr = "sAMAccountName"
+	backend.Config.UserFilter = "(&(objectClass=user)(mail=%s))"
+
+	identity, err := backend.Authenticate(context.Background(), "john@example.org", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "jsmith" || identity.FirstName != "" {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+}
+
+func TestLDAPFilterEscaping(t *testing.T) {
+	backend := newTestLDAP(t, jane)
+
+	// Without escaping this would match any entry with a mail attribute
+	_, err := backend.Authenticate(context.Background(), "*)(mail=jane@example.org", "directory-password")
+	if !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected ErrUnknownUser, got %v", err)
+	}
+}
+
+type fakeUserStore struct {
+	users     map[string]*data.User
+	passwords map[int64]string
+	busy      bool
+}
+
+func (f *fakeUserStore) GetUserByEmail(email string) (*data.User, error) {
+	user, ok := f.users[email]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (f *fakeUserStore) PasswordMatches(ctx context.Context, userID int64, plainText string) (bool, error) {
+	if f.busy {
+		return false, password.ErrBusy
+	}
+	return f.passwords[userID] == plainText, nil
+}
+
+func TestChainTriesLocalThenLDAP(t *testing.T) {
+	ctx := context.Background()
+
+	store := &fakeUserStore{
+		users: map[string]*data.User{
+			"local@example.org": {ID: 1, Email: "local@example.org", UserName: "local", AuthSource: data.AuthSourceLocal},
+			"jane@example.org":  {ID: 2, Email: "jane@example.org", UserName: "jane", AuthSource: AuthSourceLDAP},
+		},
+		passwords: map[int64]string{1: "local-password", 2: "stale-local-password"},
+	}
+	chain := Chain{&Local{Users: store}, newTestLDAP(t, jane)}
+
+	tests := []struct {
+		email, password string
+		source          string
+		err             error
+	}{
+		{"local@example.org", "local-password", data.AuthSourceLocal, nil},
+		{"local@example.org", "wrong", "", ErrInvalidCredentials},
+		// Provisioned directory users are always checked against the directory
+		{"jane@example.org", "stale-local-password", "", ErrInvalidCredentials},
+		{"jane@example.org", "directory-password", AuthSourceLDAP, nil},
+		{"nobody@example.org", "password", "", ErrUnknownUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s", tt.email, tt.password), func(t *testing.T) {
+			identity, err := chain.Authenticate(ctx, tt.email, tt.password)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Source != tt.source {
+				t.Fatalf("expected source %s, got %s", tt.source, identity.Source)
+			}
+		})
+	}
+}
+
+func TestChainReportsBackendErrors(t *testing.T) {
+	config := DefaultLDAPConfig()
+	config.URL = "ldap://127.0.0.1:1"
+	config.SearchBase = testSearchBase
+	config.Timeout = time.Second
+
+	chain := Chain{&Local{Users: &fakeUserStore{}}, &LDAP{Config: config}}
+
+	_, err := chain.Authenticate(context.Background(), "jane@example.org", "directory-password")
+	if err == nil || errors.Is(err, ErrUnknownUser) || !strings.HasPrefix(err.Error(), "ldap backend:") {
+		t.Fatalf("expected an ldap backend error, got %v", err)
+	}
+}
+
+// startSilentDirectory accepts LDAP connections and never answers.
+func startSilentDirectory(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	return "ldap://" + listener.Addr().String()
+}
+
+func TestLDAPFollowsContext(t *testing.T) {
+	config := DefaultLDAPConfig()
+	config.URL = startSilentDirectory(t)
+	config.BindDN = testServiceDN
+	config.BindPassword = testServicePassword
+	config.SearchBase = testSearchBase
+	config.Timeout = time.Minute
+	backend := &LDAP{Config: config}
+
+	deadlineCtx, cancelDeadline := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelDeadline()
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{"deadline", deadlineCtx, context.DeadlineExceeded},
+		{"cancel", cancelCtx, context.Canceled},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			_, err := backend.Authenticate(tt.ctx, "jane@example.org", "directory-password")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("Authenticate took %s after the context ended", elapsed)
+			}
+		})
+	}
+}
+
+func TestChainStopsWhenHashingIsBusy(t *testing.T) {
+	store := &fakeUserStore{
+		users: map[string]*data.User{
+			"local@example.org": {ID: 1, Email: "local@example.org", AuthSource: data.AuthSourceLocal},
+		},
+		passwords: map[int64]string{1: "local-password"},
+		busy:      true,
+	}
+	chain := Chain{&Local{Users: store}}
+
+	// A shed login is neither a wrong password nor a reason to try the next backend
+	_, err := chain.Authenticate(context.Background(), "local@example.org", "local-password")
+	if !errors.Is(err, password.ErrBusy) || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrBusy, got %v", err)
+	}
+}
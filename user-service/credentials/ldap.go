@@ -0,0 +1,252 @@
+package credentials
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// AuthSourceLDAP marks users provisioned from the LDAP directory.
+const AuthSourceLDAP = "ldap"
+
+// LDAPConfig describes how to find and bind a user in the directory.
+type LDAPConfig struct {
+	URL                string // ldap:// or ldaps://
+	StartTLS           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+
+	// BindDN and BindPassword are the service account used to search for users.
+	// Without them the search is done anonymously.
+	BindDN       string
+	BindPassword string
+
+	SearchBase string
+	UserFilter string // every %s is replaced with the escaped email address
+
+	// Attribute mapping from the directory entry to the local user row
+	EmailAttr     string
+	UsernameAttr  string
+	FirstNameAttr string
+	LastNameAttr  string
+}
+
+// DefaultLDAPConfig returns the settings for an inetOrgPerson directory.
+func DefaultLDAPConfig() LDAPConfig {
+	return LDAPConfig{
+		Timeout:       5 * time.Second,
+		UserFilter:    "(&(objectClass=inetOrgPerson)(mail=%s))",
+		EmailAttr:     "mail",
+		UsernameAttr:  "uid",
+		FirstNameAttr: "givenName",
+		LastNameAttr:  "sn",
+	}
+}
+
+// LDAPConfigFromEnv returns the directory settings, or nil when LDAP_URL is not set:
+//
+//	LDAP_URL, LDAP_START_TLS, LDAP_INSECURE_SKIP_VERIFY    connection
+//	LDAP_BIND_DN, LDAP_BIND_PASSWORD                       service account for the search
+//	LDAP_SEARCH_BASE, LDAP_USER_FILTER                     where and how to find users
+//	LDAP_ATTR_EMAIL, LDAP_ATTR_USERNAME,
+//	LDAP_ATTR_FIRST_NAME, LDAP_ATTR_LAST_NAME              attribute mapping
+func LDAPConfigFromEnv() (*LDAPConfig, error) {
+	url := os.Getenv("LDAP_URL")
+	if url == "" {
+		return nil, nil
+	}
+
+	c := DefaultLDAPConfig()
+	c.URL = url
+	c.BindDN = os.Getenv("LDAP_BIND_DN")
+	c.BindPassword = os.Getenv("LDAP_BIND_PASSWORD")
+
+	c.SearchBase = os.Getenv("LDAP_SEARCH_BASE")
+	if c.SearchBase == "" {
+		return nil, fmt.Errorf("LDAP_SEARCH_BASE is required when LDAP_URL is set")
+	}
+
+	for env, field := range map[string]*bool{
+		"LDAP_START_TLS":            &c.StartTLS,
+		"LDAP_INSECURE_SKIP_VERIFY": &c.InsecureSkipVerify,
+	} {
+		if v := os.Getenv(env); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %q", env, v)
+			}
+			*field = b
+		}
+	}
+
+	for env, field := range map[string]*string{
+		"LDAP_USER_FILTER":     &c.UserFilter,
+		"LDAP_ATTR_EMAIL":      &c.EmailAttr,
+		"LDAP_ATTR_USERNAME":   &c.UsernameAttr,
+		"LDAP_ATTR_FIRST_NAME": &c.FirstNameAttr,
+		"LDAP_ATTR_LAST_NAME":  &c.LastNameAttr,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+
+	if !strings.Contains(c.UserFilter, "%s") {
+		return nil, fmt.Errorf("LDAP_USER_FILTER must contain %%s for the email address")
+	}
+
+	return &c, nil
+}
+
+// LDAP authenticates users by searching the directory for their entry and binding as it.
+type LDAP struct {
+	Config LDAPConfig
+}
+
+func (l *LDAP) Name() string {
+	return AuthSourceLDAP
+}
+
+func (l *LDAP) Authenticate(ctx context.Context, email, password string) (*Identity, error) {
+	// An empty password would be an unauthenticated bind, which many servers accept
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	timeout := l.timeout(ctx)
+	if timeout <= 0 {
+		return nil, ctx.Err()
+	}
+
+	conn, err := l.dial(ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Closing the connection fails whatever request is waiting on it
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	identity, err := l.authenticate(conn, email, password, timeout)
+	if err != nil && contextErr(ctx) != nil {
+		return nil, fmt.Errorf("%w: %v", contextErr(ctx), err)
+	}
+
+	return identity, err
+}
+
+// timeout is how long each step may take: the configured timeout, or less when the
+// caller's deadline is closer.
+func (l *LDAP) timeout(ctx context.Context) time.Duration {
+	timeout := l.Config.Timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); timeout <= 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	if ctx.Err() != nil {
+		return 0
+	}
+	return timeout
+}
+
+// contextErr is ctx.Err(), counting a deadline as exceeded as soon as it has passed. A
+// connection timeout set from the deadline can fire before the context notices.
+func contextErr(ctx context.Context) error {
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return ctx.Err()
+}
+
+func (l *LDAP) authenticate(conn *ldap.Conn, email, password string, timeout time.Duration) (*Identity, error) {
+	if l.Config.BindDN != "" {
+		err := conn.Bind(l.Config.BindDN, l.Config.BindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("service account bind failed: %w", err)
+		}
+	}
+
+	attributes := []string{l.Config.EmailAttr, l.Config.UsernameAttr, l.Config.FirstNameAttr, l.Config.LastNameAttr}
+	filter := strings.ReplaceAll(l.Config.UserFilter, "%s", ldap.EscapeFilter(email))
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.Config.SearchBase,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // one more than we need, to notice ambiguous filters
+		int(math.Ceil(timeout.Seconds())),
+		false,
+		filter,
+		attributes,
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, fmt.Errorf("user search failed: %w", err)
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return nil, ErrUnknownUser
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("more than one directory entry matches %s", email)
+	}
+
+	entry := result.Entries[0]
+
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("user bind failed: %w", err)
+	}
+
+	identity := &Identity{
+		Source:    AuthSourceLDAP,
+		Email:     entry.GetAttributeValue(l.Config.EmailAttr),
+		Username:  entry.GetAttributeValue(l.Config.UsernameAttr),
+		FirstName: entry.GetAttributeValue(l.Config.FirstNameAttr),
+		LastName:  entry.GetAttributeValue(l.Config.LastNameAttr),
+	}
+	if identity.Email == "" {
+		identity.Email = email
+	}
+	if identity.Username == "" {
+		identity.Username, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	return identity, nil
+}
+
+func (l *LDAP) dial(ctx context.Context, timeout time.Duration) (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: l.Config.InsecureSkipVerify}
+	dialer := &net.Dialer{Timeout: timeout}
+
+	conn, err := ldap.DialURL(l.Config.URL, ldap.DialWithTLSDialer(tlsConfig, dialer))
+	if err != nil {
+		if contextErr(ctx) != nil {
+			return nil, fmt.Errorf("%w: failed to connect to %s: %v", contextErr(ctx), l.Config.URL, err)
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", l.Config.URL, err)
+	}
+	conn.SetTimeout(timeout)
+
+	if l.Config.StartTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %w", err)
+		}
+	}
+
+	return conn, nil
+}
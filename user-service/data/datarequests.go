@@ -0,0 +1,490 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of data subject request.
+const (
+	DataRequestExport  = "export"
+	DataRequestErasure = "erasure"
+)
+
+// Statuses of data subject requests and their steps. Steps are only ever pending,
+// completed or failed.
+const (
+	DataRequestPending   = "pending"
+	DataRequestRunning   = "running"
+	DataRequestCompleted = "completed"
+	DataRequestFailed    = "failed"
+	DataRequestCancelled = "cancelled"
+)
+
+// Services that hold data about users, named as in data request steps.
+const (
+	ServiceUser   = "user-service"
+	ServiceLogger = "logger-service"
+	ServiceAuth   = "auth-service"
+)
+
+// dataRequestSteps lists the services each kind of request runs in, in order. Erasure
+// deletes the users row last, as the other services are looked up by its email.
+var dataRequestSteps = map[string][]string{
+	DataRequestExport:  {ServiceUser, ServiceLogger},
+	DataRequestErasure: {ServiceAuth, ServiceLogger, ServiceUser},
+}
+
+// Page sizes of data request listings.
+const (
+	DataRequestDefaultLimit = 50
+	DataRequestMaxLimit     = 500
+)
+
+// runningTimeout is how long a request may stay running before it is assumed that the
+// worker running it died, and it is picked up again.
+const runningTimeout = 15 * time.Minute
+
+// Errors returned by DataRequestModel.
+var (
+	ErrDataRequestNotFound = errors.New("data request not found")
+	ErrDataRequestOpen     = errors.New("a request of this kind is already open")
+	ErrDataRequestStarted  = errors.New("data request has already started")
+)
+
+// DataRequestModel represents the model for data subject requests: exports of everything
+// stored about a user, and erasures of it. Each request runs as a job with one step per
+// service, see dataRequestSteps.
+type DataRequestModel struct {
+	DB *sql.DB
+}
+
+// DataRequest is an export or erasure of a user's data. For erasures RunAfter is the end of
+// the grace period, until which the request can be cancelled.
+type DataRequest struct {
+	ID               int64              `json:"id"`
+	UserID           int64              `json:"user_id"`
+	Kind             string             `json:"kind"`
+	Status           string             `json:"status"`
+	RequestedBy      string             `json:"requested_by"`
+	RunAfter         time.Time          `json:"run_after"`
+	Attempts         int                `json:"attempts"`
+	ArchiveExpiresAt *time.Time         `json:"archive_expires_at,omitempty"`
+	Error            string             `json:"error,omitempty"`
+	Steps            []*DataRequestStep `json:"steps"`
+	CreatedAt        time.Time          `json:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at"`
+	CompletedAt      *time.Time         `json:"completed_at"`
+}
+
+// DataRequestStep is the part of a request one service carries out.
+type DataRequestStep struct {
+	Service   string    `json:"service"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DataRequestFilter selects requests for the admin listing. Zero fields match everything.
+// A Tenant limits the listing to requests of the tenant's members.
+type DataRequestFilter struct {
+	Tenant string
+	UserID int64
+	Kind   string
+	Status string
+}
+
+const dataRequestColumns = `id, user_id, kind, status, requested_by, run_after, attempts, archive_expires_at,
+			  COALESCE(error, ''), created_at, updated_at, completed_at`
+
+func scanDataRequest(row rowScanner) (*DataRequest, error) {
+	var r DataRequest
+	var archiveExpiresAt, completedAt sql.NullTime
+
+	err := row.Scan(
+		&r.ID,
+		&r.UserID,
+		&r.Kind,
+		&r.Status,
+		&r.RequestedBy,
+		&r.RunAfter,
+		&r.Attempts,
+		&archiveExpiresAt,
+		&r.Error,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+		&completedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if archiveExpiresAt.Valid {
+		r.ArchiveExpiresAt = &archiveExpiresAt.Time
+	}
+	if completedAt.Valid {
+		r.CompletedAt = &completedAt.Time
+	}
+	r.Steps = []*DataRequestStep{}
+
+	return &r, nil
+}
+
+// Insert opens a request for the user that runs after runAfter. requestedBy records who
+// asked for it.
+func (m *DataRequestModel) Insert(userID int64, kind, requestedBy string, runAfter time.Time) (*DataRequest, error) {
+	steps, ok := dataRequestSteps[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown data request kind %q", kind)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO data_requests (user_id, kind, requested_by, run_after)
+			  VALUES ($1, $2, $3, $4) RETURNING ` + dataRequestColumns
+
+	request, err := scanDataRequest(tx.QueryRowContext(ctx, query, userID, kind, requestedBy, runAfter))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDataRequestOpen
+		}
+		return nil, err
+	}
+
+	for i, service := range steps {
+		step := &DataRequestStep{Service: service, Status: DataRequestPending}
+
+		err = tx.QueryRowContext(ctx,
+			`INSERT INTO data_request_steps (request_id, service, position) VALUES ($1, $2, $3) RETURNING updated_at`,
+			request.ID, service, i,
+		).Scan(&step.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		request.Steps = append(request.Steps, step)
+	}
+
+	return request, tx.Commit()
+}
+
+// Get returns a request with its steps. A non-zero userID restricts it to that user's
+// requests, and the scope to requests of users within it.
+func (m *DataRequestModel) Get(id, userID int64, scope TenantScope) (*DataRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + dataRequestColumns + ` FROM data_requests WHERE id = $1 AND ($2 = 0 OR user_id = $2)
+			  AND ($3 = '' OR user_id IN (SELECT user_id FROM tenant_members WHERE tenant = $3))`
+
+	request, err := scanDataRequest(m.DB.QueryRowContext(ctx, query, id, userID, scope.Tenant))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDataRequestNotFound
+		}
+		return nil, err
+	}
+
+	err = m.loadSteps(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// List returns the requests matching the filter with their steps, newest first.
+func (m *DataRequestModel) List(filter DataRequestFilter, limit int) ([]*DataRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + dataRequestColumns + ` FROM data_requests
+			  WHERE ($1 = 0 OR user_id = $1) AND ($2 = '' OR kind = $2) AND ($3 = '' OR status = $3)
+			  AND ($5 = '' OR user_id IN (SELECT user_id FROM tenant_members WHERE tenant = $5))
+			  ORDER BY created_at DESC, id DESC LIMIT $4`
+
+	rows, err := m.DB.QueryContext(ctx, query, filter.UserID, filter.Kind, filter.Status, limit, filter.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []*DataRequest{}
+	for rows.Next() {
+		request, err := scanDataRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = m.loadSteps(ctx, requests...)
+	if err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+func (m *DataRequestModel) loadSteps(ctx context.Context, requests ...*DataRequest) error {
+	if len(requests) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*DataRequest, len(requests))
+	ids := make([]int64, 0, len(requests))
+	for _, r := range requests {
+		byID[r.ID] = r
+		ids = append(ids, r.ID)
+	}
+
+	query := `SELECT request_id, service, status, COALESCE(error, ''), updated_at FROM data_request_steps
+			  WHERE request_id = ANY($1) ORDER BY request_id, position`
+
+	rows, err := m.DB.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var requestID int64
+		var step DataRequestStep
+
+		err := rows.Scan(&requestID, &step.Service, &step.Status, &step.Error, &step.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		byID[requestID].Steps = append(byID[requestID].Steps, &step)
+	}
+
+	return rows.Err()
+}
+
+// Cancel cancels a pending request none of whose steps has completed yet. A non-zero
+// userID restricts it to that user's requests, and the scope to requests of users within it.
+func (m *DataRequestModel) Cancel(id, userID int64, scope TenantScope) (*DataRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE data_requests SET status = 'cancelled', updated_at = NOW()
+			  WHERE id = $1 AND ($2 = 0 OR user_id = $2) AND status = 'pending'
+			  AND ($3 = '' OR user_id IN (SELECT user_id FROM tenant_members WHERE tenant = $3))
+			  AND NOT EXISTS (SELECT 1 FROM data_request_steps WHERE request_id = $1 AND status = 'completed')
+			  RETURNING ` + dataRequestColumns
+
+	request, err := scanDataRequest(m.DB.QueryRowContext(ctx, query, id, userID, scope.Tenant))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		// Tell a request that does not exist apart from one that can no longer be cancelled
+		_, err = m.Get(id, userID, scope)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrDataRequestStarted
+	}
+
+	err = m.loadSteps(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// Claim marks the next due request as running and returns it, or returns nil if no
+// request is due. Requests left running by a worker that died are claimed again after
+// runningTimeout.
+func (m *DataRequestModel) Claim() (*DataRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE data_requests SET status = 'running', attempts = attempts + 1, updated_at = NOW()
+			  WHERE id = (
+				  SELECT id FROM data_requests
+				  WHERE (status = 'pending' AND run_after <= NOW()) OR (status = 'running' AND updated_at < $1)
+				  ORDER BY run_after LIMIT 1 FOR UPDATE SKIP LOCKED
+			  )
+			  RETURNING ` + dataRequestColumns
+
+	request, err := scanDataRequest(m.DB.QueryRowContext(ctx, query, time.Now().Add(-runningTimeout)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	err = m.loadSteps(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// CompleteStep records that a service has carried out its step. For exports, the
+// service's part of the archive is merged into it.
+func (m *DataRequestModel) CompleteStep(id int64, service string, archivePart map[string]any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE data_request_steps SET status = 'completed', error = NULL, updated_at = NOW() WHERE request_id = $1 AND service = $2`,
+		id, service,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(archivePart) > 0 {
+		part, err := json.Marshal(archivePart)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE data_requests SET archive = COALESCE(archive, '{}'::jsonb) || $2::jsonb, updated_at = NOW() WHERE id = $1`,
+			id, string(part),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// FailStep records why a service could not carry out its step. The request is then either
+// retried after retryAt or, if retryAt is nil, failed.
+func (m *DataRequestModel) FailStep(id int64, service string, stepErr error, retryAt *time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE data_request_steps SET status = 'failed', error = $3, updated_at = NOW() WHERE request_id = $1 AND service = $2`,
+		id, service, stepErr.Error(),
+	)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("%s: %v", service, stepErr)
+	if retryAt != nil {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE data_requests SET status = 'pending', error = $2, run_after = $3, updated_at = NOW() WHERE id = $1`,
+			id, message, *retryAt,
+		)
+	} else {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE data_requests SET status = 'failed', error = $2, updated_at = NOW(), completed_at = NOW() WHERE id = $1`,
+			id, message,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Complete marks a request whose steps have all completed as completed. Export archives
+// can be downloaded until archiveExpiresAt.
+func (m *DataRequestModel) Complete(id int64, archiveExpiresAt *time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE data_requests SET status = 'completed', error = NULL, archive_expires_at = $2,
+			  updated_at = NOW(), completed_at = NOW() WHERE id = $1`
+
+	_, err := m.DB.ExecContext(ctx, query, id, archiveExpiresAt)
+	return err
+}
+
+// Archive returns the archive of one of the user's completed exports, as a JSON object
+// with one member per part.
+func (m *DataRequestModel) Archive(id, userID int64) (map[string]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT archive FROM data_requests
+			  WHERE id = $1 AND user_id = $2 AND kind = 'export' AND status = 'completed'
+			  AND archive IS NOT NULL AND archive_expires_at > NOW()`
+
+	var raw []byte
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDataRequestNotFound
+		}
+		return nil, err
+	}
+
+	var archive map[string]json.RawMessage
+	err = json.Unmarshal(raw, &archive)
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// PurgeArchives deletes the archives of exports that have expired, and of exports that
+// failed or were cancelled, and returns how many were deleted.
+func (m *DataRequestModel) PurgeArchives() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE data_requests SET archive = NULL, updated_at = NOW()
+			  WHERE archive IS NOT NULL AND (archive_expires_at <= NOW() OR status IN ('failed', 'cancelled'))`
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ValidDataRequestKind reports whether kind is a known kind of data request.
+func ValidDataRequestKind(kind string) bool {
+	_, ok := dataRequestSteps[kind]
+	return ok
+}
+
+// ValidDataRequestStatus reports whether status is a known request status.
+func ValidDataRequestStatus(status string) bool {
+	switch status {
+	case DataRequestPending, DataRequestRunning, DataRequestCompleted, DataRequestFailed, DataRequestCancelled:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// archivePart matches the JSON of an archive part, and keeps it.
+type archivePart struct {
+	part *map[string]json.RawMessage
+}
+
+func (a archivePart) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && json.Unmarshal([]byte(s), a.part) == nil
+}
+
+func TestExportArchiveParts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m := &DataRequestModel{DB: db}
+
+	// Each service's step adds its part to the archive
+	var userPart, loggerPart map[string]json.RawMessage
+	for _, step := range []struct {
+		service string
+		part    *map[string]json.RawMessage
+		data    map[string]any
+	}{
+		{ServiceUser, &userPart, map[string]any{"profile": map[string]any{"email": "jane@example.com"}, "api_keys": []any{}}},
+		{ServiceLogger, &loggerPart, map[string]any{"logs": []any{map[string]any{"name": "login"}}}},
+	} {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE data_request_steps SET status = 'completed'`).
+			WithArgs(int64(3), step.service).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE data_requests SET archive = COALESCE\(archive, '\{\}'::jsonb\) \|\| \$2::jsonb`).
+			WithArgs(int64(3), archivePart{step.part}).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := m.CompleteStep(3, step.service, step.data)
+		if err != nil {
+			t.Fatalf("%s step: %v", step.service, err)
+		}
+	}
+
+	if string(userPart["profile"]) != `{"email":"jane@example.com"}` || string(userPart["api_keys"]) != `[]` {
+		t.Fatalf("user part = %v", userPart)
+	}
+	if string(loggerPart["logs"]) != `[{"name":"login"}]` {
+		t.Fatalf("logger part = %v", loggerPart)
+	}
+
+	// Steps without a part, like those of erasures, leave the archive alone
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE data_request_steps SET status = 'completed'`).
+		WithArgs(int64(4), ServiceAuth).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := m.CompleteStep(4, ServiceAuth, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive is the parts merged, one member per part, for the user who asked
+	merged, _ := json.Marshal(map[string]json.RawMessage{"profile": userPart["profile"], "api_keys": userPart["api_keys"], "logs": loggerPart["logs"]})
+	mock.ExpectQuery(`SELECT archive FROM data_requests`).
+		WithArgs(int64(3), int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"archive"}).AddRow(merged))
+	mock.ExpectQuery(`SELECT archive FROM data_requests`).
+		WithArgs(int64(3), int64(8)).
+		WillReturnRows(sqlmock.NewRows([]string{"archive"}))
+
+	archive, err := m.Archive(3, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []string{"profile", "api_keys", "logs"} {
+		if _, ok := archive[member]; !ok {
+			t.Errorf("archive has no %s", member)
+		}
+	}
+
+	_, err = m.Archive(3, 8)
+	if !errors.Is(err, ErrDataRequestNotFound) {
+		t.Fatalf("archive of another user: err = %v, want %v", err, ErrDataRequestNotFound)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
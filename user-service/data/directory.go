@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Page sizes of the user directory.
+const (
+	DirectoryDefaultLimit = 50
+	DirectoryMaxLimit     = 500
+)
+
+// ErrInvalidCursor is returned for cursors that were not issued for the same sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// directorySorts maps the sort orders of the directory to their SQL expression and the type
+// cursor values are cast back to. Text columns sort case-insensitively.
+var directorySorts = map[string]struct{ expression, cast string }{
+	"created_at": {"created_at", "timestamptz"},
+	"email":      {"LOWER(email)", "text"},
+	"username":   {"LOWER(username)", "text"},
+	"last_name":  {"LOWER(COALESCE(last_name, ''))", "text"},
+}
+
+// directorySearch is the expression the users_search_trgm_idx trigram index is built on.
+const directorySearch = `(email || ' ' || username || ' ' || COALESCE(first_name, '') || ' ' || COALESCE(last_name, ''))`
+
+// DirectoryQuery selects and orders users for the admin directory. Pages continue from
+// Cursor, which is opaque to clients. A zero Limit returns every matching user. A Tenant
+// limits the directory to the tenant's members.
+type DirectoryQuery struct {
+	Tenant        string
+	Search        string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Verified      *bool
+	Status        string
+	Sort          string
+	Descending    bool
+	Cursor        string
+	Limit         int
+}
+
+type directoryCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	ID         int64  `json:"i"`
+}
+
+// Validate fills in the default sort order and checks the query.
+func (q *DirectoryQuery) Validate() error {
+	if q.Sort == "" {
+		q.Sort = "created_at"
+	}
+	if _, ok := directorySorts[q.Sort]; !ok {
+		return fmt.Errorf("cannot sort by %q", q.Sort)
+	}
+
+	if q.Tenant != "" && !ValidTenantName(q.Tenant) {
+		return fmt.Errorf("invalid tenant %q", q.Tenant)
+	}
+
+	if q.Status != "" && !ValidUserStatus(q.Status) {
+		return fmt.Errorf("unknown status %q", q.Status)
+	}
+
+	if q.Cursor != "" {
+		_, err := q.decodeCursor()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (q *DirectoryQuery) decodeCursor() (*directoryCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c directoryCursor
+	err = json.Unmarshal(raw, &c)
+	if err != nil || c.Sort != q.Sort || c.Descending != q.Descending {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+func (q *DirectoryQuery) encodeCursor(sortValue string, id int64) string {
+	raw, _ := json.Marshal(directoryCursor{Sort: q.Sort, Descending: q.Descending, Value: sortValue, ID: id})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// sql builds the query. The sort key is selected first, as text, so cursors hold exactly
+// the value the database compares.
+func (q *DirectoryQuery) sql() (string, []any, error) {
+	sort := directorySorts[q.Sort]
+
+	var conditions []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if condition := (TenantScope{Tenant: q.Tenant}).userCondition("id", arg); condition != "" {
+		conditions = append(conditions, condition)
+	}
+	if q.Search != "" {
+		conditions = append(conditions, fmt.Sprintf("%s ILIKE %s", directorySearch, arg("%"+escapeLike(q.Search)+"%")))
+	}
+	if q.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= "+arg(*q.CreatedAfter))
+	}
+	if q.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+arg(*q.CreatedBefore))
+	}
+	if q.Verified != nil {
+		if *q.Verified {
+			conditions = append(conditions, "email_verified_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "email_verified_at IS NULL")
+		}
+	}
+	switch q.Status {
+	case UserStatusDeactivated:
+		conditions = append(conditions, "NOT active")
+	case UserStatusActive, UserStatusSuspended, UserStatusBanned:
+		conditions = append(conditions, "active AND status = "+arg(q.Status))
+	}
+
+	direction, comparison := "ASC", ">"
+	if q.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if q.Cursor != "" {
+		c, err := q.decodeCursor()
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s::%s, %s)", sort.expression, comparison, arg(c.Value), sort.cast, arg(c.ID)))
+	}
+
+	query := fmt.Sprintf(`SELECT (%s)::text, %s FROM users`, sort.expression, profileColumns)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s", sort.expression, direction, direction)
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+
+	return query, args, nil
+}
+
+// Directory runs the query and calls fn with each user, in order, and the cursor that
+// continues after them. It stops at the first error fn returns.
+func (u *UserModel) Directory(ctx context.Context, q DirectoryQuery, fn func(user *User, cursor string) error) error {
+	err := q.Validate()
+	if err != nil {
+		return err
+	}
+
+	query, args, err := q.sql()
+	if err != nil {
+		return err
+	}
+
+	rows, err := u.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sortValue string
+		user, err := scanProfile(rows, &sortValue)
+		if err != nil {
+			return err
+		}
+
+		err = fn(user, q.encodeCursor(sortValue, user.ID))
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,120 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirectoryQuerySQL(t *testing.T) {
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	verified := false
+
+	q := DirectoryQuery{
+		Search:       "50%_jane",
+		CreatedAfter: &after,
+		Verified:     &verified,
+		Status:       UserStatusDeactivated,
+		Sort:         "email",
+		Descending:   true,
+		Limit:        11,
+	}
+	q.Cursor = q.encodeCursor("jane@example.com", 7)
+
+	err := q.Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	query, args, err := q.sql()
+	if err != nil {
+		t.Fatalf("sql: %v", err)
+	}
+
+	for _, want := range []string{
+		directorySearch + ` ILIKE $1`,
+		`created_at >= $2`,
+		`email_verified_at IS NULL`,
+		`NOT active`,
+		`(LOWER(email), id) < ($3::text, $4)`,
+		`ORDER BY LOWER(email) DESC, id DESC LIMIT 11`,
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query does not contain %q:\n%s", want, query)
+		}
+	}
+
+	if len(args) != 4 || args[0] != `%50\%\_jane%` || args[2] != "jane@example.com" || args[3] != int64(7) {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestDirectoryQueryDefaults(t *testing.T) {
+	q := DirectoryQuery{}
+
+	err := q.Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	query, args, err := q.sql()
+	if err != nil {
+		t.Fatalf("sql: %v", err)
+	}
+	if strings.Contains(query, "WHERE") || !strings.HasSuffix(query, "ORDER BY created_at ASC, id ASC") || len(args) != 0 {
+		t.Errorf("query = %s, args = %v", query, args)
+	}
+}
+
+func TestDirectoryQueryTenant(t *testing.T) {
+	q := DirectoryQuery{Tenant: "acme", Search: "x' OR tenant <> 'acme"}
+
+	err := q.Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	query, args, err := q.sql()
+	if err != nil {
+		t.Fatalf("sql: %v", err)
+	}
+
+	// Other conditions narrow the tenant's members down, they cannot widen the listing
+	want := ` WHERE id IN (SELECT user_id FROM tenant_members WHERE tenant = $1) AND ` + directorySearch + ` ILIKE $2 ORDER BY`
+	if !strings.Contains(query, want) {
+		t.Errorf("query does not contain %q:\n%s", want, query)
+	}
+	if len(args) != 2 || args[0] != "acme" {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestDirectoryQueryValidate(t *testing.T) {
+	emailCursor := (&DirectoryQuery{Sort: "email"}).encodeCursor("a", 1)
+
+	tests := []struct {
+		name  string
+		query DirectoryQuery
+		want  error
+	}{
+		{"unknown sort", DirectoryQuery{Sort: "passwordhash"}, nil},
+		{"unknown status", DirectoryQuery{Status: "frozen"}, nil},
+		{"invalid tenant", DirectoryQuery{Tenant: "Acme Inc"}, nil},
+		{"garbage cursor", DirectoryQuery{Cursor: "not a cursor"}, ErrInvalidCursor},
+		{"cursor for another sort", DirectoryQuery{Sort: "username", Cursor: emailCursor}, ErrInvalidCursor},
+		{"cursor for another order", DirectoryQuery{Sort: "email", Descending: true, Cursor: emailCursor}, ErrInvalidCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.Validate()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
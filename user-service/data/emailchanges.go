@@ -0,0 +1,251 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"apikey"
+)
+
+// Lifetimes of the links sent for an email change. The revert link outlives the
+// confirmation, so the old address can undo a change an attacker confirmed.
+const (
+	EmailChangeTTL = 24 * time.Hour
+	EmailRevertTTL = 7 * 24 * time.Hour
+)
+
+// ErrEmailChangeNotFound is returned for unknown, expired or already used email change tokens.
+var ErrEmailChangeNotFound = errors.New("email change not found or expired")
+
+// EmailChangeModel represents the model for pending email changes. A change only reaches
+// the users table once the new address has been confirmed.
+type EmailChangeModel struct {
+	DB *sql.DB
+}
+
+// EmailChange is a request to move an account to a new email address. The plain text
+// tokens are only set on the change Insert returns, to be mailed out.
+type EmailChange struct {
+	ID              int64      `json:"id"`
+	UserID          int64      `json:"-"`
+	OldEmail        string     `json:"old_email"`
+	NewEmail        string     `json:"new_email"`
+	ConfirmToken    string     `json:"-"`
+	RevertToken     string     `json:"-"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	RevertExpiresAt time.Time  `json:"-"`
+	ConfirmedAt     *time.Time `json:"confirmed_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+const emailChangeColumns = `id, user_id, old_email, new_email, expires_at, revert_expires_at, confirmed_at, created_at`
+
+// emailChangePending matches changes that were neither confirmed, reverted nor cancelled.
+const emailChangePending = `confirmed_at IS NULL AND reverted_at IS NULL AND cancelled_at IS NULL`
+
+func scanEmailChange(row rowScanner) (*EmailChange, error) {
+	var c EmailChange
+	var confirmedAt sql.NullTime
+
+	err := row.Scan(&c.ID, &c.UserID, &c.OldEmail, &c.NewEmail, &c.ExpiresAt, &c.RevertExpiresAt, &confirmedAt, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if confirmedAt.Valid {
+		c.ConfirmedAt = &confirmedAt.Time
+	}
+
+	return &c, nil
+}
+
+func newEmailChangeToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)), nil
+}
+
+// Insert starts a change of the user's email address, cancelling any change still pending,
+// and returns it with both tokens set.
+func (m *EmailChangeModel) Insert(userID int64, oldEmail, newEmail string) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	confirmToken, err := newEmailChangeToken()
+	if err != nil {
+		return nil, err
+	}
+	revertToken, err := newEmailChangeToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE email_changes SET cancelled_at = NOW() WHERE user_id = $1 AND `+emailChangePending, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO email_changes (user_id, old_email, new_email, confirm_token_hash, revert_token_hash, expires_at, revert_expires_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING ` + emailChangeColumns
+
+	now := time.Now()
+	change, err := scanEmailChange(tx.QueryRowContext(ctx, query,
+		userID,
+		oldEmail,
+		newEmail,
+		apikey.Hash(confirmToken),
+		apikey.Hash(revertToken),
+		now.Add(EmailChangeTTL),
+		now.Add(EmailRevertTTL),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	change.ConfirmToken = confirmToken
+	change.RevertToken = revertToken
+
+	return change, tx.Commit()
+}
+
+// GetPending returns the user's pending change that can still be confirmed.
+func (m *EmailChangeModel) GetPending(userID int64) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + emailChangeColumns + ` FROM email_changes
+			  WHERE user_id = $1 AND expires_at > NOW() AND ` + emailChangePending
+
+	change, err := scanEmailChange(m.DB.QueryRowContext(ctx, query, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEmailChangeNotFound
+	}
+
+	return change, err
+}
+
+// Cancel cancels the user's pending change.
+func (m *EmailChangeModel) Cancel(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `UPDATE email_changes SET cancelled_at = NOW() WHERE user_id = $1 AND `+emailChangePending, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEmailChangeNotFound
+	}
+
+	return nil
+}
+
+// Confirm moves the account to the new address of the pending change the token belongs to,
+// marking the address verified. It returns ErrDuplicateEmail if another account has taken
+// the address in the meantime.
+func (m *EmailChangeModel) Confirm(token string) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + emailChangeColumns + ` FROM email_changes
+			  WHERE confirm_token_hash = $1 AND expires_at > NOW() AND ` + emailChangePending + `
+			  FOR UPDATE`
+
+	change, err := scanEmailChange(tx.QueryRowContext(ctx, query, apikey.Hash(token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrEmailChangeNotFound
+		}
+		return nil, err
+	}
+
+	err = setEmail(ctx, tx, change.UserID, change.NewEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `UPDATE email_changes SET confirmed_at = NOW() WHERE id = $1 RETURNING confirmed_at`, change.ID).Scan(&change.ConfirmedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return change, tx.Commit()
+}
+
+// Revert undoes the change the token belongs to: a pending change is cancelled, and a
+// confirmed one moves the account back to the old address, which clicking the link has
+// just verified.
+func (m *EmailChangeModel) Revert(token string) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + emailChangeColumns + ` FROM email_changes
+			  WHERE revert_token_hash = $1 AND revert_expires_at > NOW() AND reverted_at IS NULL AND cancelled_at IS NULL
+			  FOR UPDATE`
+
+	change, err := scanEmailChange(tx.QueryRowContext(ctx, query, apikey.Hash(token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrEmailChangeNotFound
+		}
+		return nil, err
+	}
+
+	if change.ConfirmedAt != nil {
+		err = setEmail(ctx, tx, change.UserID, change.OldEmail)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE email_changes SET reverted_at = NOW() WHERE id = $1`, change.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return change, tx.Commit()
+}
+
+// setEmail moves a user to an address they have just proven they own.
+func setEmail(ctx context.Context, tx *sql.Tx, userID int64, email string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE users SET email = $1, email_verified_at = NOW(), updated_at = NOW() WHERE id = $2`,
+		email, userID,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicateEmail
+	}
+
+	return err
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Errors returned by IdentityModel.
+var (
+	ErrIdentityNotFound = errors.New("external identity not found")
+	ErrIdentityLinked   = errors.New("external identity is already linked, or the user already has one from this provider")
+)
+
+// IdentityModel represents the model for external identity provider accounts linked to
+// users. The OIDC flow itself runs in auth-service.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// UserIdentity is an account at an external identity provider that can log in as a user.
+type UserIdentity struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"-"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// GetUserID returns the user a provider's subject is linked to and records the login.
+func (m *IdentityModel) GetUserID(provider, subject string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE user_identities SET last_login_at = NOW()
+			  WHERE provider = $1 AND subject = $2 RETURNING user_id`
+
+	var userID int64
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrIdentityNotFound
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Link connects a provider's subject to a user. Each subject belongs to one user, and a user
+// has at most one identity per provider.
+func (m *IdentityModel) Link(userID int64, provider, subject, email string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+			  VALUES ($1, $2, $3, $4, NOW())
+			  ON CONFLICT DO NOTHING RETURNING id`
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query, userID, provider, subject, email).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrIdentityLinked
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetAllForUser returns the identities linked to the user, oldest first.
+func (m *IdentityModel) GetAllForUser(userID int64) ([]*UserIdentity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, user_id, provider, subject, email, last_login_at, created_at
+			  FROM user_identities WHERE user_id = $1 ORDER BY created_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*UserIdentity{}
+	for rows.Next() {
+		var i UserIdentity
+		var lastLoginAt sql.NullTime
+
+		err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &lastLoginAt, &i.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if lastLoginAt.Valid {
+			i.LastLoginAt = &lastLoginAt.Time
+		}
+
+		identities = append(identities, &i)
+	}
+
+	return identities, rows.Err()
+}
+
+// Delete unlinks one of the user's identities.
+func (m *IdentityModel) Delete(id, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrIdentityNotFound
+	}
+
+	return nil
+}
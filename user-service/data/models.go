@@ -7,78 +7,89 @@ import (
 	"log"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"password"
 )
 
 const dbTimeout = time.Second * 3
 
+// AuthSourceLocal marks users whose password hash is stored in the users table.
+const AuthSourceLocal = "local"
+
+// ErrAuthSourceConflict is returned when an external directory login matches the email of
+// an account that belongs to another source.
+var ErrAuthSourceConflict = errors.New("email belongs to an account from another source")
+
 type UserModel struct {
 	DB *sql.DB
+	Hasher *password.Hasher
 }
 
 type Models struct {
 	User UserModel
 	Token TokenModel
+	APIKey APIKeyModel
+	WebAuthn WebAuthnCredentialModel
+	Identity IdentityModel
+	SCIM SCIMModel
+	SCIMToken SCIMTokenModel
+	DataRequest DataRequestModel
+	EmailChange EmailChangeModel
+	UserEvent UserEventModel
+	UserImport UserImportModel
+	NotificationPreference NotificationPreferenceModel
+	Tenant TenantModel
 }
 
-func New(db *sql.DB) Models {
+func New(db *sql.DB, hasher *password.Hasher) Models {
 	return Models{
-		User: UserModel{DB: db},
+		User: UserModel{DB: db, Hasher: hasher},
 		Token: TokenModel{DB: db},
+		APIKey: APIKeyModel{DB: db},
+		WebAuthn: WebAuthnCredentialModel{DB: db},
+		Identity: IdentityModel{DB: db},
+		SCIM: SCIMModel{DB: db, Hasher: hasher},
+		SCIMToken: SCIMTokenModel{DB: db},
+		DataRequest: DataRequestModel{DB: db},
+		EmailChange: EmailChangeModel{DB: db},
+		UserEvent: UserEventModel{DB: db},
+		UserImport: UserImportModel{DB: db},
+		NotificationPreference: NotificationPreferenceModel{DB: db},
+		Tenant: TenantModel{DB: db},
 	}
 }
 
 type User struct {
-	ID           int64     `json:"id"`
-	UserName     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"passwordhash"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"udpated_at"`
-}
-
-// GetAll returns a slice of all users, sorted by last name
-func (u *UserModel) GetAllUsers() ([]*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	query := `SELECT id, email, first_name, last_name, passwordhash,
-			  created_at, updated_at FROM users ORDER BY last_name`
-
-	rows, err := u.DB.QueryContext(ctx, query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []*User
-
-	for rows.Next() {
-		var user User
-		err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.UserName,
-			&user.PasswordHash,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		)
-		if err != nil {
-			log.Println("Error scanning", err)
-			return nil, err
-		}
-
-		users = append(users, &user)
-	}
-
-	return users, nil
+	ID              int64      `json:"id"`
+	UserName        string     `json:"username"`
+	Email           string     `json:"email"`
+	FirstName       string     `json:"first_name,omitempty"`
+	LastName        string     `json:"last_name,omitempty"`
+	PasswordHash    string     `json:"-"`
+	AuthSource      string     `json:"auth_source"`
+	Phone           string     `json:"phone,omitempty"`
+	Address         string     `json:"address,omitempty"`
+	City            string     `json:"city,omitempty"`
+	State           string     `json:"state,omitempty"`
+	ZipCode         string     `json:"zip_code,omitempty"`
+	ExternalID      string     `json:"external_id,omitempty"`
+	Active          bool       `json:"active"`
+	Status          string     `json:"status"`
+	StatusReason    string     `json:"status_reason,omitempty"`
+	SuspendedUntil  *time.Time `json:"suspended_until,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	SCIMTenant      string     `json:"scim_tenant,omitempty"`
+	PendingEmail    string     `json:"pending_email,omitempty"` // Awaiting confirmation, see EmailChangeModel
+	AvatarKey       string     `json:"-"`
+	Avatar          *Avatar    `json:"avatar,omitempty"` // Signed links, filled in by the API
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 func (u *UserModel) GetUserByEmail(email string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `SELECT id, email, username, passwordhash, created_at, updated_at FROM users WHERE email = $1`
+	query := `SELECT id, email, username, passwordhash, auth_source, active, created_at, updated_at FROM users WHERE email = $1`
 
 	var user User
 	row := u.DB.QueryRowContext(ctx, query, email)
@@ -88,6 +99,8 @@ func (u *UserModel) GetUserByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.UserName,
 		&user.PasswordHash,
+		&user.AuthSource,
+		&user.Active,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -103,11 +116,12 @@ func (u *UserModel) GetUserByID(id int64) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `SELECT id, email, username, passwordhash, created_at, updated_at 
+	query := `SELECT id, email, username, passwordhash, auth_source, active, status, COALESCE(status_reason, ''), suspended_until, created_at, updated_at 
 	          FROM users 
 	          WHERE id = $1`
 
 	var user User
+	var suspendedUntil sql.NullTime
 	row := u.DB.QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
@@ -115,6 +129,11 @@ func (u *UserModel) GetUserByID(id int64) (*User, error) {
 		&user.Email,
 		&user.UserName,
 		&user.PasswordHash,
+		&user.AuthSource,
+		&user.Active,
+		&user.Status,
+		&user.StatusReason,
+		&suspendedUntil,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -122,6 +141,9 @@ func (u *UserModel) GetUserByID(id int64) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	if suspendedUntil.Valid {
+		user.SuspendedUntil = &suspendedUntil.Time
+	}
 
 	return &user, nil
 }
@@ -130,7 +152,7 @@ func (u *UserModel) InsertUser(user User) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.PasswordHash), 12)
+	hashedPassword, err := u.Hasher.Hash(ctx, user.PasswordHash)
 	if err != nil {
 		return 0, err
 	}
@@ -154,38 +176,53 @@ func (u *UserModel) InsertUser(user User) (int, error) {
 	return newID, nil
 }
 
-func (u *UserModel) DeleteUserByID(id int64) error {
+// ProvisionExternalUser returns the ID of the user an external directory has authenticated,
+// creating the row on their first login. The account gets no usable password hash, so it
+// can only log in through its directory.
+func (u *UserModel) ProvisionExternalUser(user User) (int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `DELETE FROM users WHERE id = $1`
+	query := `INSERT INTO users (email, username, first_name, last_name, passwordhash, auth_source, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, '!', $5, $6, $6)
+			  ON CONFLICT (email) DO NOTHING RETURNING id`
 
-	_, err := u.DB.ExecContext(ctx, query, id)
+	var id int64
+	err := u.DB.QueryRowContext(ctx, query,
+		user.Email,
+		user.UserName,
+		user.FirstName,
+		user.LastName,
+		user.AuthSource,
+		time.Now(),
+	).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	// Already provisioned, or a local account with the same email
+	var source string
+	err = u.DB.QueryRowContext(ctx, `SELECT id, auth_source FROM users WHERE email = $1`, user.Email).Scan(&id, &source)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if source != user.AuthSource {
+		return 0, ErrAuthSourceConflict
 	}
 
-	return nil
+	return id, nil
 }
 
-func (u *UserModel) UpdateUser(user User) error {
+func (u *UserModel) DeleteUserByID(id int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `UPDATE users SET
-		email = $1,
-		username = $2,
-				updated_at = $4
-		WHERE id = $5
-	`
-
-	_, err := u.DB.ExecContext(ctx, query,
-		user.Email,
-		user.UserName,
-		time.Now(),
-		user.ID,
-	)
+	query := `DELETE FROM users WHERE id = $1`
 
+	_, err := u.DB.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -193,18 +230,15 @@ func (u *UserModel) UpdateUser(user User) error {
 	return nil
 }
 
-// UpdateUserPassword updates the user's password in the database.
-func (u *UserModel) UpdateUserPassword(email string, password string) error {
+// UpdateUserPassword updates the user's password in the database, given its hash from
+// Hasher.Hash. Hashing is left to the caller so it can happen before anything that cannot
+// be undone, such as using up a reset token.
+func (u *UserModel) UpdateUserPassword(email string, hashedPassword string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	if err != nil {
-		return err
-	}
-
 	stmt := `UPDATE users SET passwordhash = $1, updated_at = $2 WHERE email = $3`
-	_, err = u.DB.ExecContext(ctx, stmt, hashedPassword, time.Now(), email)
+	_, err := u.DB.ExecContext(ctx, stmt, hashedPassword, time.Now(), email)
 	if err != nil {
 		return err
 	}
@@ -230,9 +264,11 @@ func (u *UserModel) VerifyToken(token string) (bool, error) {
 	return isValid, nil
 }
 
-// PasswordMatches uses Go's bcrypt package to compare a user supplied password
-func (u *UserModel) PasswordMatches(userID int64, plainText string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// PasswordMatches compares a user supplied password with the stored hash. A matching hash
+// made with another algorithm or older parameters is replaced by a current one. It fails
+// with password.ErrBusy when too many passwords are being hashed already.
+func (u *UserModel) PasswordMatches(ctx context.Context, userID int64, plainText string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	var hashedPassword string
@@ -242,18 +278,35 @@ func (u *UserModel) PasswordMatches(userID int64, plainText string) (bool, error
 		return false, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainText))
-	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return false, nil
-		}
+	valid, err := u.Hasher.Verify(ctx, hashedPassword, plainText)
+	if err != nil || !valid {
 		return false, err
+	}
 
+	if u.Hasher.NeedsRehash(hashedPassword) {
+		err = u.rehashPassword(ctx, userID, hashedPassword, plainText)
+		// The old hash keeps working, a later login tries again. Under load that is expected.
+		if err != nil && !errors.Is(err, password.ErrBusy) {
+			log.Printf("Error rehashing password of user %d: %v", userID, err)
+		}
 	}
 
 	return true, nil
 }
 
+// rehashPassword replaces an outdated hash, unless the password was changed since it was
+// read.
+func (u *UserModel) rehashPassword(ctx context.Context, userID int64, oldHash, plainText string) error {
+	hashedPassword, err := u.Hasher.Hash(ctx, plainText)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE users SET passwordhash = $1 WHERE id = $2 AND passwordhash = $3`
+	_, err = u.DB.ExecContext(ctx, stmt, hashedPassword, userID, oldHash)
+	return err
+}
+
 // EmailExists checks if a user with the given email exists in the database.
 func (u *UserModel) EmailExists(email string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
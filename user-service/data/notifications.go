@@ -0,0 +1,310 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	// Time zones have to load in containers without zoneinfo
+	_ "time/tzdata"
+)
+
+// Channels users can be notified on.
+const (
+	NotificationChannelEmail   = "email"
+	NotificationChannelInApp   = "in_app"
+	NotificationChannelWebhook = "webhook"
+)
+
+// NotificationCategoryTransactional is for messages users cannot opt out of, such as
+// password resets and security notices. Preferences do not apply to them.
+const NotificationCategoryTransactional = "transactional"
+
+// How often notifications are collected into a digest instead of being sent one by one.
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Decisions about a notification.
+const (
+	NotificationSend     = "send"
+	NotificationSuppress = "suppress"
+	NotificationDefer    = "defer"
+	NotificationDigest   = "digest"
+)
+
+// NotificationMaxCategories is how many categories a user can set preferences for.
+const NotificationMaxCategories = 50
+
+var (
+	categoryPattern  = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+	clockTimePattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+)
+
+// NotificationChannels says which channels a user wants to be notified on.
+type NotificationChannels struct {
+	Email   bool `json:"email"`
+	InApp   bool `json:"in_app"`
+	Webhook bool `json:"webhook"`
+}
+
+// QuietHours is a daily stretch of local time, as HH:MM, during which only in-app
+// notifications are delivered. It can span midnight.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// NotificationPreferences are how a user wants to be notified. A category missing from
+// Categories is opted in.
+type NotificationPreferences struct {
+	Channels   NotificationChannels `json:"channels"`
+	WebhookURL string               `json:"webhook_url,omitempty"`
+	Categories map[string]bool      `json:"categories"`
+	Digest     string               `json:"digest"`
+	TimeZone   string               `json:"time_zone"`
+	QuietHours *QuietHours          `json:"quiet_hours"`
+	UpdatedAt  *time.Time           `json:"updated_at,omitempty"`
+}
+
+// DefaultNotificationPreferences returns the preferences of users who have not set any.
+func DefaultNotificationPreferences() *NotificationPreferences {
+	return &NotificationPreferences{
+		Channels:   NotificationChannels{Email: true, InApp: true},
+		Categories: map[string]bool{},
+		Digest:     DigestOff,
+		TimeZone:   "UTC",
+	}
+}
+
+// Validate checks the preferences.
+func (p *NotificationPreferences) Validate() error {
+	if p.Channels.Webhook {
+		u, err := url.Parse(p.WebhookURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.New("webhook_url must be an https URL when webhooks are on")
+		}
+	}
+
+	if len(p.Categories) > NotificationMaxCategories {
+		return fmt.Errorf("at most %d categories can be set", NotificationMaxCategories)
+	}
+	for category := range p.Categories {
+		if category == NotificationCategoryTransactional {
+			return errors.New("transactional messages cannot be opted out of")
+		}
+		if !categoryPattern.MatchString(category) {
+			return fmt.Errorf("invalid category %q", category)
+		}
+	}
+
+	switch p.Digest {
+	case DigestOff, DigestDaily, DigestWeekly:
+	default:
+		return fmt.Errorf("digest must be %s, %s or %s", DigestOff, DigestDaily, DigestWeekly)
+	}
+
+	if _, err := time.LoadLocation(p.TimeZone); err != nil || p.TimeZone == "" || p.TimeZone == "Local" {
+		return fmt.Errorf("unknown time zone %q", p.TimeZone)
+	}
+
+	if q := p.QuietHours; q != nil {
+		if !clockTimePattern.MatchString(q.Start) || !clockTimePattern.MatchString(q.End) {
+			return errors.New("quiet hours must start and end at a time given as HH:MM")
+		}
+		if q.Start == q.End {
+			return errors.New("quiet hours cannot start and end at the same time")
+		}
+	}
+
+	return nil
+}
+
+// NotificationDecision says what to do with a notification. DeliverAfter is set for
+// deferred notifications.
+type NotificationDecision struct {
+	Decision     string
+	DeliverAfter time.Time
+	Reason       string
+}
+
+// Decide says what to do with a notification of the category on the channel at now.
+// Transactional notifications are always sent. Others are suppressed on channels and
+// categories the user has turned off, held back during quiet hours except in-app, and
+// collected into the digest on email if the user has one.
+func (p *NotificationPreferences) Decide(channel, category string, now time.Time) NotificationDecision {
+	if category == "" || category == NotificationCategoryTransactional {
+		return NotificationDecision{Decision: NotificationSend}
+	}
+
+	var enabled bool
+	switch channel {
+	case NotificationChannelEmail:
+		enabled = p.Channels.Email
+	case NotificationChannelInApp:
+		enabled = p.Channels.InApp
+	case NotificationChannelWebhook:
+		enabled = p.Channels.Webhook && p.WebhookURL != ""
+	}
+	if !enabled {
+		return NotificationDecision{Decision: NotificationSuppress, Reason: fmt.Sprintf("the %s channel is off", channel)}
+	}
+
+	if optedIn, ok := p.Categories[category]; ok && !optedIn {
+		return NotificationDecision{Decision: NotificationSuppress, Reason: fmt.Sprintf("opted out of %s", category)}
+	}
+
+	if channel != NotificationChannelInApp {
+		if end, ok := p.quietHoursEnd(now); ok {
+			return NotificationDecision{Decision: NotificationDefer, DeliverAfter: end, Reason: "quiet hours"}
+		}
+	}
+
+	if channel == NotificationChannelEmail && p.Digest != DigestOff {
+		return NotificationDecision{Decision: NotificationDigest, Reason: p.Digest + " digest"}
+	}
+
+	return NotificationDecision{Decision: NotificationSend}
+}
+
+// quietHoursEnd returns when the quiet hours now falls in end, if it falls in them.
+func (p *NotificationPreferences) quietHoursEnd(now time.Time) (time.Time, bool) {
+	if p.QuietHours == nil {
+		return time.Time{}, false
+	}
+
+	location, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+
+	clock := func(s string) time.Time {
+		t, _ := time.Parse("15:04", s)
+		return time.Date(local.Year(), local.Month(), local.Day(), t.Hour(), t.Minute(), 0, 0, location)
+	}
+	start, end := clock(p.QuietHours.Start), clock(p.QuietHours.End)
+
+	if start.Before(end) {
+		if !local.Before(start) && local.Before(end) {
+			return end, true
+		}
+		return time.Time{}, false
+	}
+
+	// Spanning midnight: quiet from start until midnight, and from midnight until end
+	if !local.Before(start) {
+		return end.AddDate(0, 0, 1), true
+	}
+	if local.Before(end) {
+		return end, true
+	}
+	return time.Time{}, false
+}
+
+// NotificationPreferenceModel represents the model for users' notification preferences.
+type NotificationPreferenceModel struct {
+	DB *sql.DB
+}
+
+// Get returns the user's preferences, or the defaults if they have not set any.
+func (m *NotificationPreferenceModel) Get(userID int64) (*NotificationPreferences, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT email_enabled, in_app_enabled, webhook_enabled, COALESCE(webhook_url, ''), categories, digest, time_zone,
+			  quiet_hours_start, quiet_hours_end, updated_at
+			  FROM notification_preferences WHERE user_id = $1`
+
+	var p NotificationPreferences
+	var categories []byte
+	var quietStart, quietEnd sql.NullString
+	var updatedAt time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&p.Channels.Email,
+		&p.Channels.InApp,
+		&p.Channels.Webhook,
+		&p.WebhookURL,
+		&categories,
+		&p.Digest,
+		&p.TimeZone,
+		&quietStart,
+		&quietEnd,
+		&updatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DefaultNotificationPreferences(), nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(categories, &p.Categories)
+	if err != nil {
+		return nil, err
+	}
+	if quietStart.Valid && quietEnd.Valid {
+		p.QuietHours = &QuietHours{Start: quietStart.String, End: quietEnd.String}
+	}
+	p.UpdatedAt = &updatedAt
+
+	return &p, nil
+}
+
+// Set stores validated preferences for the user, replacing any they had.
+func (m *NotificationPreferenceModel) Set(userID int64, p *NotificationPreferences) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	categories := p.Categories
+	if categories == nil {
+		categories = map[string]bool{}
+	}
+	categoriesJSON, err := json.Marshal(categories)
+	if err != nil {
+		return err
+	}
+
+	var quietStart, quietEnd any
+	if p.QuietHours != nil {
+		quietStart, quietEnd = p.QuietHours.Start, p.QuietHours.End
+	}
+
+	query := `INSERT INTO notification_preferences (user_id, email_enabled, in_app_enabled, webhook_enabled, webhook_url,
+			  categories, digest, time_zone, quiet_hours_start, quiet_hours_end, updated_at)
+			  VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6::jsonb, $7, $8, $9, $10, NOW())
+			  ON CONFLICT (user_id) DO UPDATE SET email_enabled = $2, in_app_enabled = $3, webhook_enabled = $4,
+			  webhook_url = NULLIF($5, ''), categories = $6::jsonb, digest = $7, time_zone = $8,
+			  quiet_hours_start = $9, quiet_hours_end = $10, updated_at = NOW()
+			  RETURNING updated_at`
+
+	var updatedAt time.Time
+	err = m.DB.QueryRowContext(ctx, query,
+		userID,
+		p.Channels.Email,
+		p.Channels.InApp,
+		p.Channels.Webhook,
+		p.WebhookURL,
+		string(categoriesJSON),
+		p.Digest,
+		p.TimeZone,
+		quietStart,
+		quietEnd,
+	).Scan(&updatedAt)
+	if err != nil {
+		return err
+	}
+
+	p.Categories = categories
+	p.UpdatedAt = &updatedAt
+
+	return nil
+}
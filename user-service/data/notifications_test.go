@@ -0,0 +1,117 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotificationPreferencesValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(p *NotificationPreferences)
+		valid  bool
+	}{
+		{"defaults", func(p *NotificationPreferences) {}, true},
+		{"everything set", func(p *NotificationPreferences) {
+			p.Channels.Webhook = true
+			p.WebhookURL = "https://hooks.example.com/notify"
+			p.Categories = map[string]bool{"marketing": false, "reminders": true}
+			p.Digest = DigestWeekly
+			p.TimeZone = "Europe/Warsaw"
+			p.QuietHours = &QuietHours{Start: "22:00", End: "07:30"}
+		}, true},
+		{"webhook without URL", func(p *NotificationPreferences) { p.Channels.Webhook = true }, false},
+		{"plain HTTP webhook", func(p *NotificationPreferences) {
+			p.Channels.Webhook = true
+			p.WebhookURL = "http://hooks.example.com"
+		}, false},
+		{"transactional opt-out", func(p *NotificationPreferences) {
+			p.Categories = map[string]bool{NotificationCategoryTransactional: false}
+		}, false},
+		{"invalid category", func(p *NotificationPreferences) { p.Categories = map[string]bool{"Not A Category": false} }, false},
+		{"unknown digest", func(p *NotificationPreferences) { p.Digest = "hourly" }, false},
+		{"unknown time zone", func(p *NotificationPreferences) { p.TimeZone = "Mars/Olympus_Mons" }, false},
+		{"empty time zone", func(p *NotificationPreferences) { p.TimeZone = "" }, false},
+		{"quiet hours out of range", func(p *NotificationPreferences) { p.QuietHours = &QuietHours{Start: "24:00", End: "07:00"} }, false},
+		{"empty quiet hours", func(p *NotificationPreferences) { p.QuietHours = &QuietHours{Start: "08:00", End: "08:00"} }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := DefaultNotificationPreferences()
+			tt.change(p)
+
+			err := p.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestNotificationPreferencesDecide(t *testing.T) {
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := DefaultNotificationPreferences()
+	p.Categories = map[string]bool{"marketing": false, "reminders": true}
+	p.TimeZone = "Europe/Warsaw"
+	p.QuietHours = &QuietHours{Start: "22:00", End: "07:00"}
+
+	day := time.Date(2024, 6, 3, 12, 0, 0, 0, warsaw)
+	night := time.Date(2024, 6, 3, 23, 30, 0, 0, warsaw)
+	earlyMorning := time.Date(2024, 6, 4, 6, 0, 0, 0, warsaw)
+	morning := time.Date(2024, 6, 4, 7, 0, 0, 0, warsaw)
+
+	tests := []struct {
+		name         string
+		channel      string
+		category     string
+		now          time.Time
+		decision     string
+		deliverAfter time.Time
+	}{
+		{"transactional at night", NotificationChannelEmail, NotificationCategoryTransactional, night, NotificationSend, time.Time{}},
+		{"uncategorised", NotificationChannelEmail, "", night, NotificationSend, time.Time{}},
+		{"opted in", NotificationChannelEmail, "reminders", day, NotificationSend, time.Time{}},
+		{"not mentioned", NotificationChannelEmail, "product_updates", day, NotificationSend, time.Time{}},
+		{"opted out", NotificationChannelEmail, "marketing", day, NotificationSuppress, time.Time{}},
+		{"webhooks off", NotificationChannelWebhook, "reminders", day, NotificationSuppress, time.Time{}},
+		{"unknown channel", "sms", "reminders", day, NotificationSuppress, time.Time{}},
+		{"before midnight", NotificationChannelEmail, "reminders", night, NotificationDefer, morning},
+		{"after midnight", NotificationChannelEmail, "reminders", earlyMorning, NotificationDefer, morning},
+		{"quiet hours over", NotificationChannelEmail, "reminders", morning, NotificationSend, time.Time{}},
+		{"in-app at night", NotificationChannelInApp, "reminders", night, NotificationSend, time.Time{}},
+		{"UTC clock", NotificationChannelEmail, "reminders", night.UTC(), NotificationDefer, morning},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := p.Decide(tt.channel, tt.category, tt.now)
+			if got.Decision != tt.decision || !got.DeliverAfter.Equal(tt.deliverAfter) {
+				t.Errorf("Decide() = %s until %v (%s), want %s until %v", got.Decision, got.DeliverAfter, got.Reason, tt.decision, tt.deliverAfter)
+			}
+		})
+	}
+
+	p.Digest = DigestDaily
+	if got := p.Decide(NotificationChannelEmail, "reminders", day); got.Decision != NotificationDigest {
+		t.Errorf("with a daily digest, Decide() = %s, want %s", got.Decision, NotificationDigest)
+	}
+	if got := p.Decide(NotificationChannelInApp, "reminders", day); got.Decision != NotificationSend {
+		t.Errorf("with a daily digest, in-app Decide() = %s, want %s", got.Decision, NotificationSend)
+	}
+
+	// Quiet hours within a day
+	p.QuietHours = &QuietHours{Start: "13:00", End: "14:00"}
+	lunch := time.Date(2024, 6, 3, 13, 15, 0, 0, warsaw)
+	if got := p.Decide(NotificationChannelWebhook, "reminders", lunch); got.Decision != NotificationSuppress {
+		t.Errorf("webhooks off at lunch: Decide() = %s", got.Decision)
+	}
+	p.Digest = DigestOff
+	if got := p.Decide(NotificationChannelEmail, "reminders", lunch); got.Decision != NotificationDefer || !got.DeliverAfter.Equal(time.Date(2024, 6, 3, 14, 0, 0, 0, warsaw)) {
+		t.Errorf("at lunch, Decide() = %s until %v", got.Decision, got.DeliverAfter)
+	}
+}
@@ -0,0 +1,208 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrDuplicateEmail is returned when a profile update would reuse another user's email.
+var ErrDuplicateEmail = errors.New("email is already used by another account")
+
+// profileColumns are the users columns scanProfile reads, after any extra columns the
+// caller selects first.
+const profileColumns = `id, username, email, COALESCE(first_name, ''), COALESCE(last_name, ''),
+			  COALESCE(phone, ''), COALESCE(address, ''), COALESCE(city, ''), COALESCE(state, ''),
+			  COALESCE(zip_code, ''), COALESCE(external_id, ''), active, status, COALESCE(status_reason, ''), suspended_until,
+			  email_verified_at, auth_source, COALESCE(scim_tenant, ''), COALESCE(avatar_key, ''), created_at, updated_at`
+
+const profileSelect = `SELECT ` + profileColumns + ` FROM users`
+
+func scanProfile(row rowScanner, extra ...any) (*User, error) {
+	var u User
+	var emailVerifiedAt, suspendedUntil sql.NullTime
+
+	dest := append(extra,
+		&u.ID,
+		&u.UserName,
+		&u.Email,
+		&u.FirstName,
+		&u.LastName,
+		&u.Phone,
+		&u.Address,
+		&u.City,
+		&u.State,
+		&u.ZipCode,
+		&u.ExternalID,
+		&u.Active,
+		&u.Status,
+		&u.StatusReason,
+		&suspendedUntil,
+		&emailVerifiedAt,
+		&u.AuthSource,
+		&u.SCIMTenant,
+		&u.AvatarKey,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	if emailVerifiedAt.Valid {
+		u.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+	if suspendedUntil.Valid {
+		u.SuspendedUntil = &suspendedUntil.Time
+	}
+
+	return &u, nil
+}
+
+// Avatar links to each size of a user's profile picture, by size. The links stop working
+// at ExpiresAt.
+type Avatar struct {
+	URLs      map[string]string `json:"urls"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// SetAvatar records the key of the user's new avatar, or removes it if the key is empty,
+// and returns the key of the avatar it replaces, if any.
+func (u *UserModel) SetAvatar(id int64, key string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE users SET avatar_key = NULLIF($2, ''), updated_at = NOW()
+			  FROM (SELECT id, avatar_key FROM users WHERE id = $1 FOR UPDATE) AS previous
+			  WHERE users.id = previous.id
+			  RETURNING COALESCE(previous.avatar_key, '')`
+
+	var previous string
+	err := u.DB.QueryRowContext(ctx, query, id, key).Scan(&previous)
+	if err != nil {
+		return "", err
+	}
+
+	return previous, nil
+}
+
+// ProfileUpdate is a partial update of a user's profile. Nil fields are left unchanged, and
+// an empty string clears an optional field.
+type ProfileUpdate struct {
+	UserName  *string `json:"username"`
+	Email     *string `json:"email"`
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Phone     *string `json:"phone"`
+	Address   *string `json:"address"`
+	City      *string `json:"city"`
+	State     *string `json:"state"`
+	ZipCode   *string `json:"zip_code"`
+}
+
+// columns returns the columns the update sets, with their values, in a fixed order.
+func (p *ProfileUpdate) columns() ([]string, []any) {
+	fields := []struct {
+		column string
+		value  *string
+	}{
+		{"username", p.UserName},
+		{"email", p.Email},
+		{"first_name", p.FirstName},
+		{"last_name", p.LastName},
+		{"phone", p.Phone},
+		{"address", p.Address},
+		{"city", p.City},
+		{"state", p.State},
+		{"zip_code", p.ZipCode},
+	}
+
+	var columns []string
+	var values []any
+	for _, f := range fields {
+		if f.value != nil {
+			columns = append(columns, f.column)
+			values = append(values, *f.value)
+		}
+	}
+
+	return columns, values
+}
+
+// GetProfile returns the user's full profile.
+func (u *UserModel) GetProfile(id int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return scanProfile(u.DB.QueryRowContext(ctx, profileSelect+` WHERE id = $1`, id))
+}
+
+// UpdateProfile applies a partial update to the user's profile and returns the result.
+func (u *UserModel) UpdateProfile(id int64, update ProfileUpdate) (*User, error) {
+	columns, values := update.columns()
+	if len(columns) == 0 {
+		return u.GetProfile(id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	set := make([]string, 0, len(columns)+2)
+	for i, column := range columns {
+		set = append(set, fmt.Sprintf("%s = $%d", column, i+1))
+		// A new address has not been verified yet
+		if column == "email" {
+			set = append(set, fmt.Sprintf("email_verified_at = CASE WHEN email = $%d THEN email_verified_at END", i+1))
+		}
+	}
+	set = append(set, "updated_at = NOW()")
+
+	query := fmt.Sprintf(`UPDATE users SET %s WHERE id = $%d RETURNING %s`, strings.Join(set, ", "), len(values)+1, profileColumns)
+
+	user, err := scanProfile(u.DB.QueryRowContext(ctx, query, append(values, id)...))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// MarkEmailVerified records that the user has just proven they own their email address.
+func (u *UserModel) MarkEmailVerified(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx, `UPDATE users SET email_verified_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// GetProfiles returns the profiles of the users with the given IDs that exist, in no
+// particular order.
+func (u *UserModel) GetProfiles(ids []int64) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := u.DB.QueryContext(ctx, profileSelect+` WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
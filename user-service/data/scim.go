@@ -0,0 +1,704 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+
+	"apikey"
+	"password"
+	"user-service/scim"
+)
+
+// SCIMTokenPrefix marks SCIM provisioning tokens.
+const SCIMTokenPrefix = "scim_"
+
+// Errors returned by SCIMModel and SCIMTokenModel.
+var (
+	ErrSCIMNotFound        = errors.New("resource not found")
+	ErrSCIMConflict        = errors.New("a resource with the same unique attributes already exists")
+	ErrSCIMVersionMismatch = errors.New("resource was modified since it was read")
+	ErrSCIMUnknownMember   = errors.New("members must be users of the same tenant")
+	ErrSCIMTokenNotFound   = errors.New("scim token not found")
+)
+
+// SCIMModel stores the users and groups a tenant provisions over SCIM. Every query is
+// scoped to the tenant, so one tenant's client can never see or change another's users.
+type SCIMModel struct {
+	DB     *sql.DB
+	Hasher *password.Hasher
+}
+
+// SCIMReference points to a group from a user, or to a user from a group.
+type SCIMReference struct {
+	ID      int64
+	Display string
+}
+
+// SCIMGroup is a tenant's group of users.
+type SCIMGroup struct {
+	ID          int64
+	Tenant      string
+	DisplayName string
+	ExternalID  string
+	Members     []SCIMReference
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// scimUserColumns are the users columns SCIM filters can refer to.
+var scimUserColumns = scim.Columns{
+	"id":                      {Name: "id", Type: scim.Integer},
+	"externalid":              {Name: "external_id", CaseExact: true},
+	"username":                {Name: "username"},
+	"name.givenname":          {Name: "first_name"},
+	"name.familyname":         {Name: "last_name"},
+	"emails":                  {Name: "email"},
+	"emails.value":            {Name: "email"},
+	"phonenumbers":            {Name: "phone"},
+	"phonenumbers.value":      {Name: "phone"},
+	"addresses.streetaddress": {Name: "address"},
+	"addresses.locality":      {Name: "city"},
+	"addresses.region":        {Name: "state"},
+	"addresses.postalcode":    {Name: "zip_code"},
+	"active":                  {Name: "active", Type: scim.Boolean},
+	"meta.created":            {Name: "created_at", Type: scim.DateTime},
+	"meta.lastmodified":       {Name: "updated_at", Type: scim.DateTime},
+}
+
+// scimGroupColumns are the scim_groups columns SCIM filters can refer to.
+var scimGroupColumns = scim.Columns{
+	"id":                {Name: "id", Type: scim.Integer},
+	"externalid":        {Name: "external_id", CaseExact: true},
+	"displayname":       {Name: "display_name"},
+	"meta.created":      {Name: "created_at", Type: scim.DateTime},
+	"meta.lastmodified": {Name: "updated_at", Type: scim.DateTime},
+}
+
+// GetUser returns one of the tenant's users.
+func (m *SCIMModel) GetUser(tenant string, id int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	user, err := scanProfile(m.DB.QueryRowContext(ctx, profileSelect+` WHERE id = $1 AND scim_tenant = $2`, id, tenant))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSCIMNotFound
+	}
+
+	return user, err
+}
+
+// ListUsers returns a page of the tenant's users matching the filter, which may be nil, and
+// the total number of matches. startIndex is 1-based.
+func (m *SCIMModel) ListUsers(tenant string, filter scim.Filter, startIndex, count int) ([]*User, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	where, args, err := scimWhere("scim_tenant", tenant, filter, scimUserColumns)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = m.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`%s%s ORDER BY id LIMIT %d OFFSET %d`, profileSelect, where, count, startIndex-1)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanProfile(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+
+	return users, total, rows.Err()
+}
+
+// scimWhere builds the WHERE clause of a tenant's query, with the tenant as $1.
+func scimWhere(tenantColumn, tenant string, filter scim.Filter, columns scim.Columns) (string, []any, error) {
+	where := fmt.Sprintf(` WHERE %s = $1`, tenantColumn)
+	args := []any{tenant}
+
+	if filter != nil {
+		condition, filterArgs, err := scim.SQL(filter, columns, args)
+		if err != nil {
+			return "", nil, err
+		}
+		where += " AND " + condition
+		args = filterArgs
+	}
+
+	return where, args, nil
+}
+
+// InsertUser provisions a user for the tenant, as a member of it, and sets its ID and
+// timestamps. Without a password the account can only log in through single sign-on.
+func (m *SCIMModel) InsertUser(tenant string, user *User, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hash, err := scimPasswordHash(ctx, m.Hasher, password)
+	if err != nil {
+		return err
+	}
+
+	query := `WITH inserted AS (
+				  INSERT INTO users (username, email, first_name, last_name, phone, address, city, state, zip_code,
+				  external_id, active, passwordhash, auth_source, scim_tenant)
+				  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), $11, COALESCE($12::text, '!'), $13, $14)
+				  ON CONFLICT DO NOTHING RETURNING id, created_at, updated_at
+			  ), membership AS (
+				  INSERT INTO tenant_members (tenant, user_id) SELECT $14, id FROM inserted
+			  )
+			  SELECT id, created_at, updated_at FROM inserted`
+
+	err = m.DB.QueryRowContext(ctx, query,
+		user.UserName,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.Phone,
+		user.Address,
+		user.City,
+		user.State,
+		user.ZipCode,
+		user.ExternalID,
+		user.Active,
+		hash,
+		AuthSourceLocal,
+		tenant,
+	).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSCIMConflict
+		}
+		return err
+	}
+
+	user.AuthSource = AuthSourceLocal
+	user.SCIMTenant = tenant
+
+	return nil
+}
+
+// UpdateUser stores the user's attributes, and the password if one is given. It only
+// succeeds if the row was last modified at version, so concurrent changes are not lost.
+func (m *SCIMModel) UpdateUser(tenant string, user *User, password string, version time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hash, err := scimPasswordHash(ctx, m.Hasher, password)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE users SET username = $1, email = $2, first_name = $3, last_name = $4, phone = $5,
+			  address = $6, city = $7, state = $8, zip_code = $9, external_id = NULLIF($10, ''), active = $11,
+			  passwordhash = COALESCE($12::text, passwordhash), updated_at = NOW()
+			  WHERE id = $13 AND scim_tenant = $14 AND updated_at = $15
+			  RETURNING updated_at`
+
+	err = m.DB.QueryRowContext(ctx, query,
+		user.UserName,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.Phone,
+		user.Address,
+		user.City,
+		user.State,
+		user.ZipCode,
+		user.ExternalID,
+		user.Active,
+		hash,
+		user.ID,
+		tenant,
+		version,
+	).Scan(&user.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return m.missingOrModified(ctx, `SELECT 1 FROM users WHERE id = $1 AND scim_tenant = $2`, user.ID, tenant)
+		}
+		if isUniqueViolation(err) {
+			return ErrSCIMConflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+// DeleteUser deletes one of the tenant's users.
+func (m *SCIMModel) DeleteUser(tenant string, id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return m.deleteRow(ctx, `DELETE FROM users WHERE id = $1 AND scim_tenant = $2`, id, tenant)
+}
+
+// GroupsOfUsers returns the groups each of the users belongs to.
+func (m *SCIMModel) GroupsOfUsers(userIDs []int64) (map[int64][]SCIMReference, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT m.user_id, g.id, g.display_name FROM scim_group_members m
+			  JOIN scim_groups g ON g.id = m.group_id
+			  WHERE m.user_id = ANY($1) ORDER BY g.display_name`
+
+	rows, err := m.DB.QueryContext(ctx, query, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[int64][]SCIMReference)
+	for rows.Next() {
+		var userID int64
+		var group SCIMReference
+
+		err := rows.Scan(&userID, &group.ID, &group.Display)
+		if err != nil {
+			return nil, err
+		}
+		groups[userID] = append(groups[userID], group)
+	}
+
+	return groups, rows.Err()
+}
+
+const scimGroupSelect = `SELECT id, tenant, display_name, COALESCE(external_id, ''), created_at, updated_at FROM scim_groups`
+
+func scanSCIMGroup(row rowScanner) (*SCIMGroup, error) {
+	var g SCIMGroup
+
+	err := row.Scan(&g.ID, &g.Tenant, &g.DisplayName, &g.ExternalID, &g.CreatedAt, &g.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+// GetGroup returns one of the tenant's groups with its members.
+func (m *SCIMModel) GetGroup(tenant string, id int64) (*SCIMGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	group, err := scanSCIMGroup(m.DB.QueryRowContext(ctx, scimGroupSelect+` WHERE id = $1 AND tenant = $2`, id, tenant))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSCIMNotFound
+		}
+		return nil, err
+	}
+
+	err = m.loadMembers(ctx, []*SCIMGroup{group})
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// ListGroups returns a page of the tenant's groups matching the filter, which may be nil,
+// and the total number of matches. Members are only loaded if withMembers is set.
+func (m *SCIMModel) ListGroups(tenant string, filter scim.Filter, startIndex, count int, withMembers bool) ([]*SCIMGroup, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	where, args, err := scimWhere("tenant", tenant, filter, scimGroupColumns)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = m.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM scim_groups`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`%s%s ORDER BY id LIMIT %d OFFSET %d`, scimGroupSelect, where, count, startIndex-1)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	groups := []*SCIMGroup{}
+	for rows.Next() {
+		group, err := scanSCIMGroup(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if withMembers {
+		err = m.loadMembers(ctx, groups)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return groups, total, nil
+}
+
+func (m *SCIMModel) loadMembers(ctx context.Context, groups []*SCIMGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*SCIMGroup, len(groups))
+	ids := make([]int64, 0, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+		ids = append(ids, g.ID)
+	}
+
+	query := `SELECT m.group_id, u.id, u.username FROM scim_group_members m
+			  JOIN users u ON u.id = m.user_id
+			  WHERE m.group_id = ANY($1) ORDER BY u.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var groupID int64
+		var member SCIMReference
+
+		err := rows.Scan(&groupID, &member.ID, &member.Display)
+		if err != nil {
+			return err
+		}
+		byID[groupID].Members = append(byID[groupID].Members, member)
+	}
+
+	return rows.Err()
+}
+
+// InsertGroup creates a group with its members and sets its ID and timestamps.
+func (m *SCIMModel) InsertGroup(tenant string, group *SCIMGroup) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO scim_groups (tenant, display_name, external_id)
+			  VALUES ($1, $2, NULLIF($3, ''))
+			  ON CONFLICT DO NOTHING RETURNING id, created_at, updated_at`
+
+	err = tx.QueryRowContext(ctx, query, tenant, group.DisplayName, group.ExternalID).Scan(&group.ID, &group.CreatedAt, &group.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSCIMConflict
+		}
+		return err
+	}
+
+	err = setMembers(ctx, tx, tenant, group)
+	if err != nil {
+		return err
+	}
+
+	group.Tenant = tenant
+
+	return tx.Commit()
+}
+
+// UpdateGroup stores the group's attributes and members, if it was last modified at version.
+func (m *SCIMModel) UpdateGroup(tenant string, group *SCIMGroup, version time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE scim_groups SET display_name = $1, external_id = NULLIF($2, ''), updated_at = NOW()
+			  WHERE id = $3 AND tenant = $4 AND updated_at = $5 RETURNING updated_at`
+
+	err = tx.QueryRowContext(ctx, query, group.DisplayName, group.ExternalID, group.ID, tenant, version).Scan(&group.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return m.missingOrModified(ctx, `SELECT 1 FROM scim_groups WHERE id = $1 AND tenant = $2`, group.ID, tenant)
+		}
+		if isUniqueViolation(err) {
+			return ErrSCIMConflict
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM scim_group_members WHERE group_id = $1`, group.ID)
+	if err != nil {
+		return err
+	}
+
+	err = setMembers(ctx, tx, tenant, group)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setMembers adds the group's members, which have to be users of the tenant, and fills in
+// their display names.
+func setMembers(ctx context.Context, tx *sql.Tx, tenant string, group *SCIMGroup) error {
+	if len(group.Members) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(group.Members))
+	seen := make(map[int64]bool, len(group.Members))
+	for _, member := range group.Members {
+		if !seen[member.ID] {
+			seen[member.ID] = true
+			ids = append(ids, member.ID)
+		}
+	}
+
+	query := `WITH added AS (
+				INSERT INTO scim_group_members (group_id, user_id)
+				SELECT $1, id FROM users WHERE id = ANY($2) AND scim_tenant = $3
+				RETURNING user_id
+			  )
+			  SELECT u.id, u.username FROM added JOIN users u ON u.id = added.user_id ORDER BY u.id`
+
+	rows, err := tx.QueryContext(ctx, query, group.ID, ids, tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	members := make([]SCIMReference, 0, len(ids))
+	for rows.Next() {
+		var member SCIMReference
+		err := rows.Scan(&member.ID, &member.Display)
+		if err != nil {
+			return err
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(members) != len(ids) {
+		return ErrSCIMUnknownMember
+	}
+	group.Members = members
+
+	return nil
+}
+
+// DeleteGroup deletes one of the tenant's groups. Its members are not affected.
+func (m *SCIMModel) DeleteGroup(tenant string, id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return m.deleteRow(ctx, `DELETE FROM scim_groups WHERE id = $1 AND tenant = $2`, id, tenant)
+}
+
+func (m *SCIMModel) deleteRow(ctx context.Context, query string, id int64, tenant string) error {
+	result, err := m.DB.ExecContext(ctx, query, id, tenant)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSCIMNotFound
+	}
+
+	return nil
+}
+
+// missingOrModified tells apart the two reasons a versioned update matched no row.
+func (m *SCIMModel) missingOrModified(ctx context.Context, query string, id int64, tenant string) error {
+	var exists int
+	err := m.DB.QueryRowContext(ctx, query, id, tenant).Scan(&exists)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSCIMNotFound
+		}
+		return err
+	}
+
+	return ErrSCIMVersionMismatch
+}
+
+// scimPasswordHash returns the hash of a password, or nil if none was given.
+func scimPasswordHash(ctx context.Context, hasher *password.Hasher, plainText string) (any, error) {
+	if plainText == "" {
+		return nil, nil
+	}
+
+	hash, err := hasher.Hash(ctx, plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// SCIMTokenModel represents the model for the bearer tokens SCIM clients authenticate with.
+type SCIMTokenModel struct {
+	DB *sql.DB
+}
+
+// SCIMToken lets a tenant's provisioning client manage that tenant's users and groups. Like
+// API keys, only the hash is stored and the plain text is returned once.
+type SCIMToken struct {
+	ID         int64      `json:"id"`
+	Tenant     string     `json:"tenant"`
+	Name       string     `json:"name"`
+	PlainText  string     `json:"token,omitempty"`
+	Prefix     string     `json:"prefix"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Insert creates a token for the tenant and returns it with its plain text set.
+func (m *SCIMTokenModel) Insert(tenant, name string) (*SCIMToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	plainText, shown, err := apikey.Generate(SCIMTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &SCIMToken{
+		Tenant:    tenant,
+		Name:      name,
+		PlainText: plainText,
+		Prefix:    shown,
+	}
+
+	query := `INSERT INTO scim_tokens (tenant, name, token_hash, prefix)
+			  VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+
+	err = m.DB.QueryRowContext(ctx, query, tenant, name, apikey.Hash(token.PlainText), token.Prefix).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Authenticate looks up an unrevoked token by its plain text and records that it was used.
+func (m *SCIMTokenModel) Authenticate(plainText string) (*SCIMToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE scim_tokens SET last_used_at = NOW()
+			  WHERE token_hash = $1 AND revoked_at IS NULL
+			  RETURNING id, tenant, name, prefix, last_used_at, created_at`
+
+	token, err := scanSCIMToken(m.DB.QueryRowContext(ctx, query, apikey.Hash(plainText)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSCIMTokenNotFound
+	}
+
+	return token, err
+}
+
+// GetAll returns the unrevoked tokens of a tenant, or of all tenants if tenant is empty.
+func (m *SCIMTokenModel) GetAll(tenant string) ([]*SCIMToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, tenant, name, prefix, last_used_at, created_at FROM scim_tokens
+			  WHERE revoked_at IS NULL AND ($1 = '' OR tenant = $1) ORDER BY tenant, created_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*SCIMToken{}
+	for rows.Next() {
+		token, err := scanSCIMToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// Revoke revokes a token of the tenant, or of any tenant if tenant is empty.
+func (m *SCIMTokenModel) Revoke(id int64, tenant string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE scim_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL AND ($2 = '' OR tenant = $2)`
+
+	result, err := m.DB.ExecContext(ctx, query, id, tenant)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSCIMTokenNotFound
+	}
+
+	return nil
+}
+
+func scanSCIMToken(row rowScanner) (*SCIMToken, error) {
+	var token SCIMToken
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(&token.ID, &token.Tenant, &token.Name, &token.Prefix, &lastUsedAt, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,446 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Roles of tenant members. Owners and admins manage the tenant's members, but only owners
+// can hand out or take away the admin and owner roles.
+const (
+	TenantRoleOwner  = "owner"
+	TenantRoleAdmin  = "admin"
+	TenantRoleMember = "member"
+)
+
+// tenantNamePattern matches tenant names, which appear in URLs, tokens and SAML entity IDs.
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Errors returned by TenantModel.
+var (
+	ErrTenantNotFound    = errors.New("tenant not found")
+	ErrTenantExists      = errors.New("a tenant with this name already exists")
+	ErrNotTenantMember   = errors.New("user is not a member of the tenant")
+	ErrTenantMemberTaken = errors.New("user is already a member of the tenant")
+	ErrLastTenantOwner   = errors.New("a tenant must keep at least one owner")
+)
+
+// TenantModel represents the model for tenants, the organizations users belong to, and
+// their members.
+type TenantModel struct {
+	DB *sql.DB
+}
+
+// Tenant is an organization users are members of. Name is its short, unique identifier.
+type Tenant struct {
+	Name        string    `json:"name"`
+	DisplayName string    `json:"display_name"`
+	Members     int       `json:"members"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TenantMembership is a user's membership of a tenant.
+type TenantMembership struct {
+	Tenant      string    `json:"tenant"`
+	DisplayName string    `json:"display_name"`
+	UserID      int64     `json:"user_id"`
+	Role        string    `json:"role"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+// TenantMember is a member of a tenant as the tenant's managers see them.
+type TenantMember struct {
+	UserID    int64     `json:"user_id"`
+	Email     string    `json:"email"`
+	UserName  string    `json:"username"`
+	FirstName string    `json:"first_name,omitempty"`
+	LastName  string    `json:"last_name,omitempty"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// ValidTenantName reports whether name can name a tenant.
+func ValidTenantName(name string) bool {
+	return tenantNamePattern.MatchString(name)
+}
+
+// ValidTenantRole reports whether role is one of the tenant roles.
+func ValidTenantRole(role string) bool {
+	switch role {
+	case TenantRoleOwner, TenantRoleAdmin, TenantRoleMember:
+		return true
+	}
+	return false
+}
+
+// CanManageMembers reports whether a member with the role can see and change the tenant's
+// members.
+func CanManageMembers(role string) bool {
+	return role == TenantRoleOwner || role == TenantRoleAdmin
+}
+
+// CanChangeMember reports whether a member with role actor may change another member's
+// role from current to next. An empty current stands for adding the member, an empty next
+// for removing them.
+func CanChangeMember(actor, current, next string) bool {
+	return (current == "" || canAssignTenantRole(actor, current)) && (next == "" || canAssignTenantRole(actor, next))
+}
+
+// canAssignTenantRole reports whether a member with role actor may give role to another
+// member, or take it away from them.
+func canAssignTenantRole(actor, role string) bool {
+	switch actor {
+	case TenantRoleOwner:
+		return ValidTenantRole(role)
+	case TenantRoleAdmin:
+		return role == TenantRoleMember
+	}
+	return false
+}
+
+// TenantScope limits what an admin can see and change to the users of one tenant. The zero
+// value is the platform scope of admins who manage every tenant.
+type TenantScope struct {
+	Tenant string
+}
+
+// Platform reports whether the scope covers every tenant.
+func (s TenantScope) Platform() bool {
+	return s.Tenant == ""
+}
+
+// Allows reports whether a user who is a member of tenants is within the scope.
+func (s TenantScope) Allows(tenants []string) bool {
+	return s.Platform() || slices.Contains(tenants, s.Tenant)
+}
+
+// userCondition returns an SQL condition that holds for user IDs in column that are within
+// the scope, adding its argument with arg, or "" for the platform scope.
+func (s TenantScope) userCondition(column string, arg func(any) string) string {
+	if s.Platform() {
+		return ""
+	}
+	return fmt.Sprintf("%s IN (SELECT user_id FROM tenant_members WHERE tenant = %s)", column, arg(s.Tenant))
+}
+
+// Insert creates a tenant.
+func (m *TenantModel) Insert(name, displayName string) (*Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tenant := &Tenant{Name: name, DisplayName: displayName}
+
+	query := `INSERT INTO tenants (name, display_name) VALUES ($1, $2) RETURNING created_at`
+
+	err := m.DB.QueryRowContext(ctx, query, name, displayName).Scan(&tenant.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrTenantExists
+		}
+		return nil, err
+	}
+
+	return tenant, nil
+}
+
+// Ensure creates the tenant, with its name as display name, unless it exists already.
+func (m *TenantModel) Ensure(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `INSERT INTO tenants (name, display_name) VALUES ($1, $1) ON CONFLICT (name) DO NOTHING`, name)
+	return err
+}
+
+// Get returns a tenant with its number of members.
+func (m *TenantModel) Get(name string) (*Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT t.name, t.display_name, COUNT(m.user_id), t.created_at
+			  FROM tenants t LEFT JOIN tenant_members m ON m.tenant = t.name
+			  WHERE t.name = $1 GROUP BY t.name`
+
+	var t Tenant
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(&t.Name, &t.DisplayName, &t.Members, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// List returns the tenants within the scope, by name.
+func (m *TenantModel) List(scope TenantScope) ([]*Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT t.name, t.display_name, COUNT(m.user_id), t.created_at
+			  FROM tenants t LEFT JOIN tenant_members m ON m.tenant = t.name
+			  WHERE $1 = '' OR t.name = $1 GROUP BY t.name ORDER BY t.name`
+
+	rows, err := m.DB.QueryContext(ctx, query, scope.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []*Tenant{}
+	for rows.Next() {
+		var t Tenant
+		err := rows.Scan(&t.Name, &t.DisplayName, &t.Members, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, &t)
+	}
+
+	return tenants, rows.Err()
+}
+
+// Memberships returns the tenants a user is a member of, in the order they joined them.
+func (m *TenantModel) Memberships(userID int64) ([]*TenantMembership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT m.tenant, t.display_name, m.user_id, m.role, m.created_at
+			  FROM tenant_members m JOIN tenants t ON t.name = m.tenant
+			  WHERE m.user_id = $1 ORDER BY m.created_at, m.tenant`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*TenantMembership{}
+	for rows.Next() {
+		membership, err := scanTenantMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+
+	return memberships, rows.Err()
+}
+
+// Membership returns a user's membership of a tenant, or ErrNotTenantMember.
+func (m *TenantModel) Membership(tenant string, userID int64) (*TenantMembership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT m.tenant, t.display_name, m.user_id, m.role, m.created_at
+			  FROM tenant_members m JOIN tenants t ON t.name = m.tenant
+			  WHERE m.tenant = $1 AND m.user_id = $2`
+
+	membership, err := scanTenantMembership(m.DB.QueryRowContext(ctx, query, tenant, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotTenantMember
+	}
+
+	return membership, err
+}
+
+// TenantsOf returns the names of the tenants each of the users is a member of. Users who
+// belong to no tenant are missing from the map.
+func (m *TenantModel) TenantsOf(userIDs ...int64) (map[int64][]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT user_id, tenant FROM tenant_members WHERE user_id = ANY($1)`, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := make(map[int64][]string, len(userIDs))
+	for rows.Next() {
+		var userID int64
+		var tenant string
+		err := rows.Scan(&userID, &tenant)
+		if err != nil {
+			return nil, err
+		}
+		tenants[userID] = append(tenants[userID], tenant)
+	}
+
+	return tenants, rows.Err()
+}
+
+// InScope reports whether the user exists within the scope.
+func (m *TenantModel) InScope(scope TenantScope, userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	conditions := []string{"id = " + arg(userID)}
+	if condition := scope.userCondition("id", arg); condition != "" {
+		conditions = append(conditions, condition)
+	}
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE ` + strings.Join(conditions, " AND ") + `)`
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&exists)
+
+	return exists, err
+}
+
+// OwnsAccount reports whether the scope may change the user's account itself, such as their
+// email, status or credentials, rather than just their membership: always for the platform
+// scope, and for a tenant's scope only if the user belongs to no other tenant.
+func (m *TenantModel) OwnsAccount(scope TenantScope, userID int64) (bool, error) {
+	if scope.Platform() {
+		return true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var owns bool
+	query := `SELECT NOT EXISTS (SELECT 1 FROM tenant_members WHERE user_id = $1 AND tenant <> $2)`
+	err := m.DB.QueryRowContext(ctx, query, userID, scope.Tenant).Scan(&owns)
+
+	return owns, err
+}
+
+// Members returns the members of a tenant, by email.
+func (m *TenantModel) Members(tenant string) ([]*TenantMember, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT u.id, u.email, u.username, COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), m.role, m.created_at
+			  FROM tenant_members m JOIN users u ON u.id = m.user_id
+			  WHERE m.tenant = $1 ORDER BY LOWER(u.email)`
+
+	rows, err := m.DB.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*TenantMember{}
+	for rows.Next() {
+		var member TenantMember
+		err := rows.Scan(&member.UserID, &member.Email, &member.UserName, &member.FirstName, &member.LastName, &member.Role, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+
+	return members, rows.Err()
+}
+
+// AddMember makes a user a member of a tenant with the role. Both have to exist.
+func (m *TenantModel) AddMember(tenant string, userID int64, role string) (*TenantMembership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `INSERT INTO tenant_members (tenant, user_id, role) VALUES ($1, $2, $3)`, tenant, userID, role)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrTenantMemberTaken
+		}
+		return nil, err
+	}
+
+	return m.Membership(tenant, userID)
+}
+
+// SetMemberRole changes the role of a member, unless that would leave the tenant without
+// an owner.
+func (m *TenantModel) SetMemberRole(tenant string, userID int64, role string) (*TenantMembership, error) {
+	err := m.changeMember(tenant, userID, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE tenant_members SET role = $3 WHERE tenant = $1 AND user_id = $2`, tenant, userID, role)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Membership(tenant, userID)
+}
+
+// RemoveMember takes a user out of a tenant, unless they are its last owner.
+func (m *TenantModel) RemoveMember(tenant string, userID int64) error {
+	return m.changeMember(tenant, userID, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM tenant_members WHERE tenant = $1 AND user_id = $2`, tenant, userID)
+		return err
+	})
+}
+
+// changeMember runs change with the tenant locked, and rolls it back if the tenant had
+// owners before and has none after.
+func (m *TenantModel) changeMember(tenant string, userID int64, change func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Concurrent changes could otherwise each remove one of the last two owners
+	var name string
+	err = tx.QueryRowContext(ctx, `SELECT name FROM tenants WHERE name = $1 FOR UPDATE`, tenant).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTenantNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var role string
+	err = tx.QueryRowContext(ctx, `SELECT role FROM tenant_members WHERE tenant = $1 AND user_id = $2`, tenant, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotTenantMember
+	}
+	if err != nil {
+		return err
+	}
+
+	err = change(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if role == TenantRoleOwner {
+		var owners int
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM tenant_members WHERE tenant = $1 AND role = $2`, tenant, TenantRoleOwner).Scan(&owners)
+		if err != nil {
+			return err
+		}
+		if owners == 0 {
+			return ErrLastTenantOwner
+		}
+	}
+
+	return tx.Commit()
+}
+
+func scanTenantMembership(row rowScanner) (*TenantMembership, error) {
+	var membership TenantMembership
+
+	err := row.Scan(&membership.Tenant, &membership.DisplayName, &membership.UserID, &membership.Role, &membership.JoinedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &membership, nil
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidTenantName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"acme":                  true,
+		"acme-corp-2":           true,
+		"0day":                  true,
+		"":                      false,
+		"-acme":                 false,
+		"Acme":                  false,
+		"acme corp":             false,
+		"acme_corp":             false,
+		strings.Repeat("a", 63): true,
+		strings.Repeat("a", 64): false,
+	} {
+		if got := ValidTenantName(name); got != want {
+			t.Errorf("ValidTenantName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCanChangeMember(t *testing.T) {
+	tests := []struct {
+		actor, current, next string
+		want                 bool
+	}{
+		{TenantRoleOwner, TenantRoleMember, TenantRoleOwner, true},
+		{TenantRoleOwner, TenantRoleOwner, TenantRoleMember, true},
+		{TenantRoleOwner, TenantRoleAdmin, "", true},
+		{TenantRoleAdmin, TenantRoleMember, "", true},
+		{TenantRoleAdmin, TenantRoleMember, TenantRoleMember, true},
+		{TenantRoleAdmin, TenantRoleMember, TenantRoleAdmin, false},
+		{TenantRoleAdmin, TenantRoleAdmin, TenantRoleMember, false},
+		{TenantRoleAdmin, TenantRoleOwner, "", false},
+		{TenantRoleMember, TenantRoleMember, "", false},
+		{TenantRoleOwner, TenantRoleMember, "superuser", false},
+		{"", TenantRoleMember, "", false},
+	}
+
+	for _, tt := range tests {
+		if got := CanChangeMember(tt.actor, tt.current, tt.next); got != tt.want {
+			t.Errorf("CanChangeMember(%q, %q, %q) = %v, want %v", tt.actor, tt.current, tt.next, got, tt.want)
+		}
+	}
+
+	for role, want := range map[string]bool{TenantRoleOwner: true, TenantRoleAdmin: true, TenantRoleMember: false, "": false} {
+		if got := CanManageMembers(role); got != want {
+			t.Errorf("CanManageMembers(%q) = %v, want %v", role, got, want)
+		}
+	}
+}
+
+func TestTenantScopeAllows(t *testing.T) {
+	acme := TenantScope{Tenant: "acme"}
+
+	if !acme.Allows([]string{"globex", "acme"}) {
+		t.Error("acme scope denies a member of acme")
+	}
+	if acme.Allows([]string{"globex"}) {
+		t.Error("acme scope allows a user of globex only")
+	}
+	if acme.Allows(nil) {
+		t.Error("acme scope allows a user without tenants")
+	}
+
+	platform := TenantScope{}
+	if !platform.Platform() || !platform.Allows([]string{"globex"}) || !platform.Allows(nil) {
+		t.Error("platform scope does not allow every user")
+	}
+}
+
+func TestTenantScopeUserCondition(t *testing.T) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if got := (TenantScope{}).userCondition("id", arg); got != "" || len(args) != 0 {
+		t.Errorf("platform scope condition = %q with %d args, want none", got, len(args))
+	}
+
+	got := TenantScope{Tenant: "acme"}.userCondition("k.user_id", arg)
+	want := "k.user_id IN (SELECT user_id FROM tenant_members WHERE tenant = $1)"
+	if got != want {
+		t.Errorf("condition = %q, want %q", got, want)
+	}
+	if len(args) != 1 || args[0] != "acme" {
+		t.Errorf("args = %v, want [acme]", args)
+	}
+}
@@ -4,16 +4,36 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 )
 
+// Limits on password resets. An account can ask for a few resets an hour, and each token
+// is given up after a few wrong guesses, so a token cannot be brute forced. Invitations
+// are reset tokens that stay valid for longer.
+const (
+	ResetTokenTTL         = time.Hour
+	InvitationTTL         = 7 * 24 * time.Hour
+	ResetRequestsPerHour  = 3
+	ResetTokenMaxAttempts = 5
+)
+
+var (
+	// ErrResetTokenInvalid is returned for wrong, expired, used and revoked reset tokens.
+	ErrResetTokenInvalid = errors.New("invalid or expired token")
+
+	// ErrResetLimit is returned when an account has asked for too many resets recently.
+	ErrResetLimit = errors.New("too many password reset requests, try again later")
+)
 
-// TokenModel represents the model for working with tokens in the database.
+// TokenModel represents the model for password reset tokens, which are kept in the
+// password_reset_tokens table.
 type TokenModel struct {
 	DB *sql.DB
-}									
+}
 
 // Token represents a token used for actions like password reset.
 type Token struct {
@@ -24,9 +44,9 @@ type Token struct {
 }
 
 // GenerateToken generates a token with a specified time-to-live (TTL) for a user.
-func (m *TokenModel) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
+func (m *TokenModel) GenerateToken(userID int64, ttl time.Duration) (*Token, error) {
 	token := &Token{
-		UserID: int64(userID),
+		UserID: userID,
 		Expiry: time.Now().Add(ttl),
 	}
 
@@ -46,65 +66,98 @@ func (m *TokenModel) GenerateToken(userID int, ttl time.Duration) (*Token, error
 	return token, nil
 }
 
-// SaveResetToken saves the password reset token and its expiration to the users table.
-func (m *TokenModel) SaveResetToken(email string, token *Token) error {
+// CreateResetToken generates a password reset token for the user that is valid for ttl,
+// revoking the ones they got before. It returns ErrResetLimit if the user has asked for
+// too many recently.
+func (m *TokenModel) CreateResetToken(userID int64, ttl time.Duration) (*Token, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	// Update the reset_token and reset_token_expiration fields in the users table.
-	query := `UPDATE users SET reset_token = $1, reset_token_expiration = $2 WHERE email = $3`
-	_, err := m.DB.ExecContext(ctx, query, token.PlainText, token.Expiry, email)
+	token, err := m.GenerateToken(userID, ttl)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
-}
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-// GenerateAndSavePasswordResetToken generates a password reset token and saves it in the users table.
-func (m *TokenModel) GenerateAndSavePasswordResetToken(email string, userID int) (*Token, error) {
-	// Generate a token with a 1-hour TTL.
-	token, err := m.GenerateToken(userID, 1*time.Hour)
+	// Lock the user's row so concurrent requests are counted one after the other
+	_, err = tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Save the generated token in the users table.
-	err = m.SaveResetToken(email, token)
+	var recent int
+	query := `SELECT COUNT(*) FROM password_reset_tokens WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 hour'`
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&recent)
 	if err != nil {
 		return nil, err
 	}
+	if recent >= ResetRequestsPerHour {
+		return nil, ErrResetLimit
+	}
 
-	return token, nil
+	_, err = tx.ExecContext(ctx, `UPDATE password_reset_tokens SET revoked_at = NOW()
+								  WHERE user_id = $1 AND used_at IS NULL AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		userID, token.Hash, token.Expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, tx.Commit()
 }
 
-// ValidateResetToken validates a provided password reset token by checking it against the stored token and expiration.
-func (m *TokenModel) ValidateResetToken(email string, providedToken string) (bool, error) {
+// UseResetToken checks a password reset token against the user's current one and uses it
+// up. Wrong guesses count against the token, which stops working after
+// ResetTokenMaxAttempts of them.
+func (m *TokenModel) UseResetToken(userID int64, plainText string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	var storedToken string
-	var expiry time.Time
-
-	// Retrieve the stored token and its expiration from the users table.
-	query := `SELECT reset_token, reset_token_expiration FROM users WHERE email = $1`
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(&storedToken, &expiry)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var storedHash []byte
+	query := `SELECT id, token_hash FROM password_reset_tokens
+			  WHERE user_id = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > NOW() AND failed_attempts < $2
+			  ORDER BY created_at DESC LIMIT 1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, userID, ResetTokenMaxAttempts).Scan(&id, &storedHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrResetTokenInvalid
 		}
-		return false, err
+		return err
 	}
 
-	// Check if the token has expired.
-	if time.Now().After(expiry) {
-		return false, nil
+	hash := sha256.Sum256([]byte(plainText))
+	if subtle.ConstantTimeCompare(hash[:], storedHash) != 1 {
+		_, err = tx.ExecContext(ctx, `UPDATE password_reset_tokens SET failed_attempts = failed_attempts + 1 WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		return ErrResetTokenInvalid
 	}
 
-	// Compare the provided token with the stored one.
-	if providedToken != storedToken {
-		return false, nil
+	_, err = tx.ExecContext(ctx, `UPDATE password_reset_tokens SET used_at = NOW() WHERE id = $1`, id)
+	if err != nil {
+		return err
 	}
 
-	return true, nil
+	return tx.Commit()
 }
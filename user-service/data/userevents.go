@@ -0,0 +1,97 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Types of user events.
+const (
+	UserEventCreated       = "created"
+	UserEventUpdated       = "updated"
+	UserEventStatusChanged = "status_changed"
+	UserEventDeleted       = "deleted"
+)
+
+// UserEventRetention is how long events are kept for watchers to catch up on.
+const UserEventRetention = 7 * 24 * time.Hour
+
+// userEventSettleTime holds events back until the transactions that wrote events with
+// lower IDs have most likely committed, so a watcher that resumes after the highest ID it
+// has seen does not skip any.
+const userEventSettleTime = 2 * time.Second
+
+// UserEventModel represents the model for the user_events table, which a trigger on users
+// fills.
+type UserEventModel struct {
+	DB *sql.DB
+}
+
+// UserEvent is a change to a user.
+type UserEvent struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ValidUserEventType reports whether t is one of the user event types.
+func ValidUserEventType(t string) bool {
+	switch t {
+	case UserEventCreated, UserEventUpdated, UserEventStatusChanged, UserEventDeleted:
+		return true
+	}
+	return false
+}
+
+// LastID returns the ID of the latest event, or 0 if there are none.
+func (m *UserEventModel) LastID(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM user_events`).Scan(&id)
+	return id, err
+}
+
+// After returns up to limit events with an ID above afterID, oldest first.
+func (m *UserEventModel) After(ctx context.Context, afterID int64, limit int) ([]UserEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, user_id, type, occurred_at FROM user_events
+			  WHERE id > $1 AND occurred_at <= NOW() - $2 * INTERVAL '1 millisecond'
+			  ORDER BY id LIMIT $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, afterID, userEventSettleTime.Milliseconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []UserEvent
+	for rows.Next() {
+		var e UserEvent
+		err := rows.Scan(&e.ID, &e.UserID, &e.Type, &e.OccurredAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// Purge deletes the events older than UserEventRetention and returns how many it deleted.
+func (m *UserEventModel) Purge() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM user_events WHERE occurred_at < $1`, time.Now().Add(-UserEventRetention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	upload := "Email,username,password\n" +
+		"jane@example.com,jane,secret\n" +
+		"john@example.com,john\n" +
+		"\"bob@example.com\",bob,\"pa,ss\"\n" +
+		"JANE@example.com,jane2,secret\n"
+
+	rows, err := ParseImport(ImportFormatCSV, strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("ParseImport: %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 4", len(rows))
+	}
+
+	want := []struct {
+		line   int
+		email  string
+		status string
+	}{
+		{2, "jane@example.com", UserImportPending},
+		{3, "john@example.com", UserImportFailed},
+		{4, "bob@example.com", UserImportPending},
+		{5, "JANE@example.com", UserImportFailed},
+	}
+	for i, w := range want {
+		row := rows[i]
+		if row.Line != w.line || row.Email != w.email || row.Status != w.status {
+			t.Errorf("row %d = line %d, %s, %s (%v), want line %d, %s, %s", i, row.Line, row.Email, row.Status, row.Errors, w.line, w.email, w.status)
+		}
+	}
+
+	if rows[2].Record.Password != "pa,ss" || rows[0].Record.Username != "jane" {
+		t.Errorf("records were not read: %+v, %+v", rows[0].Record, rows[2].Record)
+	}
+	if len(rows[3].Errors) != 1 || !strings.Contains(rows[3].Errors[0], "line 2") {
+		t.Errorf("duplicate errors = %v", rows[3].Errors)
+	}
+}
+
+func TestParseImportNDJSON(t *testing.T) {
+	upload := `{"email": "jane@example.com", "first_name": "Jane"}` + "\n" +
+		"\n" +
+		`{"email": "john@example.com", "role": "admin"}` + "\n" +
+		`not json` + "\n"
+
+	rows, err := ParseImport(ImportFormatNDJSON, strings.NewReader(upload))
+	if err != nil {
+		t.Fatalf("ParseImport: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+
+	if rows[0].Line != 1 || rows[0].Status != UserImportPending || rows[0].Record.FirstName != "Jane" {
+		t.Errorf("row 0 = %+v", rows[0])
+	}
+	// Unknown fields and invalid JSON fail the row, not the upload
+	for _, row := range rows[1:] {
+		if row.Status != UserImportFailed || len(row.Errors) == 0 {
+			t.Errorf("row on line %d = %s %v, want failed", row.Line, row.Status, row.Errors)
+		}
+	}
+	if rows[1].Line != 3 || rows[2].Line != 4 {
+		t.Errorf("lines = %d, %d, want 3, 4", rows[1].Line, rows[2].Line)
+	}
+}
+
+func TestParseImportRejectsUnusableFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		upload string
+	}{
+		{"unknown format", "xml", "<users/>"},
+		{"empty", ImportFormatCSV, ""},
+		{"header only", ImportFormatCSV, "email\n"},
+		{"no email column", ImportFormatCSV, "username\njane\n"},
+		{"unknown column", ImportFormatCSV, "email,role\njane@example.com,admin\n"},
+		{"repeated column", ImportFormatCSV, "email,email\na@example.com,b@example.com\n"},
+		{"too many rows", ImportFormatCSV, "email\n" + strings.Repeat("a@example.com\n", UserImportMaxRows+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseImport(tt.format, strings.NewReader(tt.upload))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestExportColumns(t *testing.T) {
+	columns, err := ParseExportColumns(" email, id ,email,created_at,email_verified_at")
+	if err != nil {
+		t.Fatalf("ParseExportColumns: %v", err)
+	}
+	if want := []string{"email", "id", "created_at", "email_verified_at"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns = %v, want %v", columns, want)
+	}
+
+	_, err = ParseExportColumns("email,passwordhash")
+	if err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+
+	all, err := ParseExportColumns("")
+	if err != nil || !reflect.DeepEqual(all, ExportColumns) {
+		t.Errorf("empty selection = %v, %v", all, err)
+	}
+
+	user := &User{
+		ID:        7,
+		Email:     "jane@example.com",
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	record := ExportRecord(user, columns)
+	if want := []string{"jane@example.com", "7", "2024-01-02T03:04:05Z", ""}; !reflect.DeepEqual(record, want) {
+		t.Errorf("record = %v, want %v", record, want)
+	}
+}
@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Statuses of user accounts. Deactivated users were switched off by their tenant's
+// provisioning client; suspended and banned users were blocked by an admin.
+const (
+	UserStatusActive      = "active"
+	UserStatusDeactivated = "deactivated"
+	UserStatusSuspended   = "suspended"
+	UserStatusBanned      = "banned"
+)
+
+// StatusReasonMaxLength is how long the reason for a suspension or ban can be.
+const StatusReasonMaxLength = 1000
+
+// ValidUserStatus reports whether s is one of the user statuses.
+func ValidUserStatus(s string) bool {
+	switch s {
+	case UserStatusActive, UserStatusDeactivated, UserStatusSuspended, UserStatusBanned:
+		return true
+	}
+	return false
+}
+
+// AccountStatus returns the status the user is reported with: deactivated if their tenant
+// has switched them off, and their moderation status otherwise.
+func (u *User) AccountStatus() string {
+	if !u.Active {
+		return UserStatusDeactivated
+	}
+	return u.Status
+}
+
+// GetAccountStatus returns the status a user is reported with, see AccountStatus.
+func (u *UserModel) GetAccountStatus(id int64) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var user User
+	err := u.DB.QueryRowContext(ctx, `SELECT active, status FROM users WHERE id = $1`, id).Scan(&user.Active, &user.Status)
+	if err != nil {
+		return "", err
+	}
+
+	return user.AccountStatus(), nil
+}
+
+// StatusChange moves a user to another status. Suspensions and bans need a reason, and
+// suspensions an end in the future.
+type StatusChange struct {
+	Status         string
+	Reason         string
+	SuspendedUntil *time.Time
+}
+
+// Validate checks the change and trims the reason.
+func (c *StatusChange) Validate() error {
+	c.Reason = strings.TrimSpace(c.Reason)
+
+	switch c.Status {
+	case UserStatusActive, UserStatusDeactivated:
+		if c.SuspendedUntil != nil {
+			return fmt.Errorf("only suspensions can have an end")
+		}
+	case UserStatusSuspended:
+		if c.SuspendedUntil == nil || !c.SuspendedUntil.After(time.Now()) {
+			return fmt.Errorf("suspensions need an end in the future")
+		}
+		if c.Reason == "" {
+			return fmt.Errorf("a reason is required to suspend a user")
+		}
+	case UserStatusBanned:
+		if c.SuspendedUntil != nil {
+			return fmt.Errorf("only suspensions can have an end")
+		}
+		if c.Reason == "" {
+			return fmt.Errorf("a reason is required to ban a user")
+		}
+	default:
+		return fmt.Errorf("unknown status %q", c.Status)
+	}
+
+	if len(c.Reason) > StatusReasonMaxLength {
+		return fmt.Errorf("the reason cannot be longer than %d characters", StatusReasonMaxLength)
+	}
+
+	return nil
+}
+
+// SetStatus applies a validated status change and returns the user's profile. Activating
+// a user also lifts any suspension or ban; deactivating leaves them in place.
+func (u *UserModel) SetStatus(id int64, change StatusChange) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var set string
+	var args []any
+	switch change.Status {
+	case UserStatusActive:
+		set = `active = TRUE, status = 'active', status_reason = NULL, suspended_until = NULL`
+	case UserStatusDeactivated:
+		set = `active = FALSE`
+	case UserStatusSuspended, UserStatusBanned:
+		set = `status = $2, status_reason = $3, suspended_until = $4`
+		args = []any{change.Status, change.Reason, change.SuspendedUntil}
+	default:
+		return nil, errors.New("unknown status " + change.Status)
+	}
+
+	query := `UPDATE users SET ` + set + `, updated_at = NOW() WHERE id = $1 RETURNING ` + profileColumns
+
+	return scanProfile(u.DB.QueryRowContext(ctx, query, append([]any{id}, args...)...))
+}
+
+// ExpireSuspensions lifts the suspensions that have run out and returns the profiles of
+// the users concerned.
+func (u *UserModel) ExpireSuspensions() ([]*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE users SET status = 'active', status_reason = NULL, suspended_until = NULL, updated_at = NOW()
+			  WHERE status = 'suspended' AND suspended_until <= NOW()
+			  RETURNING ` + profileColumns
+
+	rows, err := u.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
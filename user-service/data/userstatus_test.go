@@ -0,0 +1,40 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusChangeValidate(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name   string
+		change StatusChange
+		valid  bool
+	}{
+		{"activate", StatusChange{Status: UserStatusActive}, true},
+		{"deactivate", StatusChange{Status: UserStatusDeactivated}, true},
+		{"suspend", StatusChange{Status: UserStatusSuspended, Reason: "spam", SuspendedUntil: &future}, true},
+		{"ban", StatusChange{Status: UserStatusBanned, Reason: "fraud"}, true},
+		{"unknown status", StatusChange{Status: "frozen"}, false},
+		{"suspend without end", StatusChange{Status: UserStatusSuspended, Reason: "spam"}, false},
+		{"suspend until the past", StatusChange{Status: UserStatusSuspended, Reason: "spam", SuspendedUntil: &past}, false},
+		{"suspend without reason", StatusChange{Status: UserStatusSuspended, Reason: "  ", SuspendedUntil: &future}, false},
+		{"ban without reason", StatusChange{Status: UserStatusBanned}, false},
+		{"ban with end", StatusChange{Status: UserStatusBanned, Reason: "fraud", SuspendedUntil: &future}, false},
+		{"activate with end", StatusChange{Status: UserStatusActive, SuspendedUntil: &future}, false},
+		{"reason too long", StatusChange{Status: UserStatusBanned, Reason: strings.Repeat("a", StatusReasonMaxLength+1)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.change.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
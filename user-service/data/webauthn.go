@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrCredentialNotFound is returned when a WebAuthn credential does not exist.
+var ErrCredentialNotFound = errors.New("webauthn credential not found")
+
+// WebAuthnCredentialModel represents the model for working with WebAuthn (passkey)
+// credentials in the database. The ceremonies themselves run in auth-service.
+type WebAuthnCredentialModel struct {
+	DB *sql.DB
+}
+
+// WebAuthnCredential is a public key credential registered by a user's authenticator.
+type WebAuthnCredential struct {
+	ID              int64      `json:"id"`
+	UserID          int64      `json:"-"`
+	Name            string     `json:"name"`
+	CredentialID    []byte     `json:"credential_id"`
+	PublicKey       []byte     `json:"-"`
+	AttestationType string     `json:"attestation_type"`
+	Transports      []string   `json:"transports"`
+	AAGUID          []byte     `json:"aaguid"`
+	SignCount       uint32     `json:"-"`
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"`
+	UserVerified    bool       `json:"-"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// GetAllForUser returns the user's credentials, oldest first.
+func (m *WebAuthnCredentialModel) GetAllForUser(userID int64) ([]*WebAuthnCredential, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, user_id, name, credential_id, public_key, attestation_type, transports, aaguid,
+			  sign_count, backup_eligible, backup_state, user_verified, last_used_at, created_at
+			  FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []*WebAuthnCredential{}
+	for rows.Next() {
+		var c WebAuthnCredential
+		var transports string
+		var signCount int64
+		var lastUsedAt sql.NullTime
+
+		err := rows.Scan(
+			&c.ID,
+			&c.UserID,
+			&c.Name,
+			&c.CredentialID,
+			&c.PublicKey,
+			&c.AttestationType,
+			&transports,
+			&c.AAGUID,
+			&signCount,
+			&c.BackupEligible,
+			&c.BackupState,
+			&c.UserVerified,
+			&lastUsedAt,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if transports != "" {
+			c.Transports = strings.Split(transports, ",")
+		}
+		c.SignCount = uint32(signCount)
+		if lastUsedAt.Valid {
+			c.LastUsedAt = &lastUsedAt.Time
+		}
+
+		credentials = append(credentials, &c)
+	}
+
+	return credentials, rows.Err()
+}
+
+// Insert stores a newly registered credential and returns its ID.
+func (m *WebAuthnCredentialModel) Insert(c *WebAuthnCredential) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, attestation_type,
+			  transports, aaguid, sign_count, backup_eligible, backup_state, user_verified)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query,
+		c.UserID,
+		c.Name,
+		c.CredentialID,
+		c.PublicKey,
+		c.AttestationType,
+		strings.Join(c.Transports, ","),
+		c.AAGUID,
+		int64(c.SignCount),
+		c.BackupEligible,
+		c.BackupState,
+		c.UserVerified,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// RecordUse stores the signature counter and backup state reported by a successful
+// assertion.
+func (m *WebAuthnCredentialModel) RecordUse(credentialID []byte, signCount uint32, backupState bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `UPDATE webauthn_credentials SET sign_count = $1, backup_state = $2, last_used_at = NOW()
+			  WHERE credential_id = $3`
+
+	result, err := m.DB.ExecContext(ctx, query, int64(signCount), backupState, credentialID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCredentialNotFound
+	}
+
+	return nil
+}
+
+// Delete removes one of the user's credentials.
+func (m *WebAuthnCredentialModel) Delete(id, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCredentialNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,404 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Filter is a parsed filter expression (RFC 7644 section 3.4.2.2).
+type Filter interface {
+	filter()
+}
+
+// Comparison compares an attribute with a value. Value is a string, float64, bool or nil,
+// and is unused for the "pr" (present) operator.
+type Comparison struct {
+	Path     string
+	Operator string
+	Value    any
+}
+
+// Logical combines two filters with "and" or "or".
+type Logical struct {
+	Operator    string
+	Left, Right Filter
+}
+
+// Not negates a filter.
+type Not struct {
+	Filter Filter
+}
+
+func (Comparison) filter() {}
+func (Logical) filter()    {}
+func (Not) filter()        {}
+
+var comparisonOperators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// InvalidFilter is returned for filters that cannot be parsed or evaluated.
+func InvalidFilter(format string, args ...any) *Error {
+	return NewError(http.StatusBadRequest, "invalidFilter", format, args...)
+}
+
+// ParseFilter parses a filter. Attribute paths are returned as written; value paths such as
+// emails[type eq "work"] are not supported.
+func ParseFilter(s string) (Filter, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, InvalidFilter("unexpected %q", p.tokens[p.pos].text)
+	}
+
+	return f, nil
+}
+
+type token struct {
+	text   string
+	quoted bool // a JSON string literal, already decoded
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		case c == '[' || c == ']':
+			return nil, InvalidFilter("value filters are not supported")
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, InvalidFilter("unterminated string")
+			}
+
+			var value string
+			err := json.Unmarshal([]byte(s[i:end+1]), &value)
+			if err != nil {
+				return nil, InvalidFilter("invalid string %s", s[i:end+1])
+			}
+			tokens = append(tokens, token{text: value, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t()[]\"", rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, token{text: s[i:end]})
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, keyword)
+}
+
+func (p *parser) or() (Filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = Logical{Operator: "or", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) and() (Filter, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = Logical{Operator: "and", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) factor() (Filter, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, InvalidFilter("unexpected end of filter")
+	}
+
+	if p.peekKeyword("not") {
+		p.pos++
+		if !p.peekKeyword("(") {
+			return nil, InvalidFilter("expected ( after not")
+		}
+		f, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Filter: f}, nil
+	}
+
+	if p.peekKeyword("(") {
+		p.pos++
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekKeyword(")") {
+			return nil, InvalidFilter("expected )")
+		}
+		p.pos++
+		return f, nil
+	}
+
+	path := p.tokens[p.pos]
+	if path.quoted || path.text == ")" {
+		return nil, InvalidFilter("expected an attribute, got %q", path.text)
+	}
+	p.pos++
+
+	if p.pos >= len(p.tokens) {
+		return nil, InvalidFilter("expected an operator after %s", path.text)
+	}
+	op := strings.ToLower(p.tokens[p.pos].text)
+	if p.tokens[p.pos].quoted || !comparisonOperators[op] {
+		return nil, InvalidFilter("unknown operator %q", p.tokens[p.pos].text)
+	}
+	p.pos++
+
+	if op == "pr" {
+		return Comparison{Path: path.text, Operator: op}, nil
+	}
+
+	if p.pos >= len(p.tokens) {
+		return nil, InvalidFilter("expected a value after %s %s", path.text, op)
+	}
+	v := p.tokens[p.pos]
+	p.pos++
+
+	if v.quoted {
+		return Comparison{Path: path.text, Operator: op, Value: v.text}, nil
+	}
+
+	var value any
+	err := json.Unmarshal([]byte(strings.ToLower(v.text)), &value)
+	if err != nil {
+		return nil, InvalidFilter("invalid value %q", v.text)
+	}
+	switch value.(type) {
+	case bool, float64, nil:
+	default:
+		return nil, InvalidFilter("invalid value %q", v.text)
+	}
+
+	return Comparison{Path: path.text, Operator: op, Value: value}, nil
+}
+
+// ColumnType says how filter values are compared with a column.
+type ColumnType int
+
+const (
+	String ColumnType = iota
+	Boolean
+	Integer
+	DateTime
+)
+
+// Column is the SQL column an attribute is stored in.
+type Column struct {
+	Name      string
+	Type      ColumnType
+	CaseExact bool
+}
+
+// Columns maps lower case attribute paths to columns.
+type Columns map[string]Column
+
+// lookup resolves an attribute path, which may be prefixed with its schema URN.
+func (c Columns) lookup(path string) (Column, error) {
+	p := strings.ToLower(path)
+	if strings.HasPrefix(p, "urn:") {
+		p = p[strings.LastIndex(p, ":")+1:]
+	}
+
+	column, ok := c[p]
+	if !ok {
+		return Column{}, InvalidFilter("filtering on %s is not supported", path)
+	}
+	return column, nil
+}
+
+// SQL translates a filter to a WHERE condition over the columns. Values become bind
+// parameters numbered after the args already given, and the extended args are returned.
+func SQL(f Filter, columns Columns, args []any) (string, []any, error) {
+	switch f := f.(type) {
+	case Logical:
+		left, args, err := SQL(f.Left, columns, args)
+		if err != nil {
+			return "", nil, err
+		}
+		right, args, err := SQL(f.Right, columns, args)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(f.Operator), right), args, nil
+
+	case Not:
+		inner, args, err := SQL(f.Filter, columns, args)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("NOT %s", inner), args, nil
+
+	case Comparison:
+		return comparisonSQL(f, columns, args)
+	}
+
+	return "", nil, InvalidFilter("unsupported filter")
+}
+
+func comparisonSQL(c Comparison, columns Columns, args []any) (string, []any, error) {
+	column, err := columns.lookup(c.Path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if c.Operator == "pr" {
+		if column.Type == String {
+			return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", column.Name, column.Name), args, nil
+		}
+		return fmt.Sprintf("%s IS NOT NULL", column.Name), args, nil
+	}
+
+	if c.Value == nil {
+		switch c.Operator {
+		case "eq":
+			return fmt.Sprintf("%s IS NULL", column.Name), args, nil
+		case "ne":
+			return fmt.Sprintf("%s IS NOT NULL", column.Name), args, nil
+		}
+		return "", nil, InvalidFilter("null can only be compared with eq or ne")
+	}
+
+	value, err := columnValue(column, c)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := column.Name
+	placeholder := func() string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	switch c.Operator {
+	case "co", "sw", "ew":
+		if column.Type != String {
+			return "", nil, InvalidFilter("%s can only be used with strings", c.Operator)
+		}
+		pattern := escapeLike(value.(string))
+		switch c.Operator {
+		case "co":
+			pattern = "%" + pattern + "%"
+		case "sw":
+			pattern = pattern + "%"
+		case "ew":
+			pattern = "%" + pattern
+		}
+		value = pattern
+
+		like := "ILIKE"
+		if column.CaseExact {
+			like = "LIKE"
+		}
+		return fmt.Sprintf("%s %s %s", name, like, placeholder()), args, nil
+	}
+
+	if column.Type == Boolean && c.Operator != "eq" && c.Operator != "ne" {
+		return "", nil, InvalidFilter("booleans can only be compared with eq or ne")
+	}
+
+	operators := map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+	if column.Type == String && !column.CaseExact {
+		return fmt.Sprintf("LOWER(%s) %s LOWER(%s)", name, operators[c.Operator], placeholder()), args, nil
+	}
+	return fmt.Sprintf("%s %s %s", name, operators[c.Operator], placeholder()), args, nil
+}
+
+// columnValue converts a filter value to the column's type.
+func columnValue(column Column, c Comparison) (any, error) {
+	switch column.Type {
+	case String:
+		if s, ok := c.Value.(string); ok {
+			return s, nil
+		}
+	case Boolean:
+		if b, ok := c.Value.(bool); ok {
+			return b, nil
+		}
+	case Integer:
+		switch v := c.Value.(type) {
+		case float64:
+			return int64(v), nil
+		case string:
+			// ids are strings in SCIM
+			var id int64
+			if _, err := fmt.Sscan(v, &id); err == nil && fmt.Sprint(id) == v {
+				return id, nil
+			}
+		}
+	case DateTime:
+		if s, ok := c.Value.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	return nil, InvalidFilter("invalid value for %s", c.Path)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,134 @@
+package scim
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var testColumns = Columns{
+	"id":           {Name: "id", Type: Integer},
+	"username":     {Name: "username"},
+	"externalid":   {Name: "external_id", CaseExact: true},
+	"emails.value": {Name: "email"},
+	"active":       {Name: "active", Type: Boolean},
+	"meta.created": {Name: "created_at", Type: DateTime},
+}
+
+func TestFilterSQL(t *testing.T) {
+	created, _ := time.Parse(time.RFC3339, "2024-01-02T03:04:05Z")
+
+	tests := []struct {
+		filter string
+		want   string
+		args   []any
+	}{
+		{`userName eq "Jane"`, `LOWER(username) = LOWER($2)`, []any{"Jane"}},
+		{`USERNAME Eq "Jane"`, `LOWER(username) = LOWER($2)`, []any{"Jane"}},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "jane"`, `LOWER(username) = LOWER($2)`, []any{"jane"}},
+		{`externalId eq "A-1"`, `external_id = $2`, []any{"A-1"}},
+		{`emails.value co "50%_off"`, `email ILIKE $2`, []any{`%50\%\_off%`}},
+		{`userName sw "j"`, `username ILIKE $2`, []any{"j%"}},
+		{`externalId ew "1"`, `external_id LIKE $2`, []any{"%1"}},
+		{`active eq false`, `active = $2`, []any{false}},
+		{`id eq "42"`, `id = $2`, []any{int64(42)}},
+		{`meta.created gt "2024-01-02T03:04:05Z"`, `created_at > $2`, []any{created}},
+		{`externalId pr`, `(external_id IS NOT NULL AND external_id <> '')`, nil},
+		{`externalId eq null`, `external_id IS NULL`, nil},
+		{
+			`userName eq "a" or userName eq "b" and active eq true`,
+			`(LOWER(username) = LOWER($2) OR (LOWER(username) = LOWER($3) AND active = $4))`,
+			[]any{"a", "b", true},
+		},
+		{
+			`(userName eq "a" or userName eq "b") and not (active eq true)`,
+			`((LOWER(username) = LOWER($2) OR LOWER(username) = LOWER($3)) AND NOT active = $4)`,
+			[]any{"a", "b", true},
+		},
+		{`userName eq "say \"hi\""`, `LOWER(username) = LOWER($2)`, []any{`say "hi"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+
+			got, args, err := SQL(f, testColumns, []any{"tenant"})
+			if err != nil {
+				t.Fatalf("SQL: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("SQL = %s, want %s", got, tt.want)
+			}
+			if fmt.Sprint(args[1:]) != fmt.Sprint(tt.args) {
+				t.Errorf("args = %v, want %v", args[1:], tt.args)
+			}
+		})
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName xx "a"`,
+		`userName eq "a" and`,
+		`(userName eq "a"`,
+		`userName eq "unterminated`,
+		`emails[type eq "work"].value eq "a"`,
+		`password eq "secret"`,
+		`active gt true`,
+		`active eq "yes"`,
+		`id eq "1x"`,
+		`meta.created gt "yesterday"`,
+		`id co "1"`,
+		`userName eq unquoted`,
+	}
+
+	for _, filter := range tests {
+		t.Run(filter, func(t *testing.T) {
+			f, err := ParseFilter(filter)
+			if err == nil {
+				_, _, err = SQL(f, testColumns, nil)
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			scimErr, ok := err.(*Error)
+			if !ok || scimErr.StatusCode() != 400 || scimErr.ScimType != "invalidFilter" {
+				t.Errorf("error = %#v, want a 400 invalidFilter error", err)
+			}
+		})
+	}
+}
+
+func TestPagination(t *testing.T) {
+	tests := []struct {
+		startIndex, count string
+		wantStart         int
+		wantCount         int
+		wantErr           bool
+	}{
+		{"", "", 1, DefaultCount, false},
+		{"0", "10", 1, 10, false},
+		{"11", "-1", 11, 0, false},
+		{"1", "100000", 1, MaxCount, false},
+		{"x", "", 0, 0, true},
+		{"", "ten", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		start, count, err := Pagination(tt.startIndex, tt.count)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Pagination(%q, %q) error = %v", tt.startIndex, tt.count, err)
+			continue
+		}
+		if start != tt.wantStart || count != tt.wantCount {
+			t.Errorf("Pagination(%q, %q) = %d, %d, want %d, %d", tt.startIndex, tt.count, start, count, tt.wantStart, tt.wantCount)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH request (RFC 7644 section 3.5.2).
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is a single add, replace or remove operation. Some clients capitalise
+// the op, so it is compared case-insensitively.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Validate checks the schema and operations of the request.
+func (p *PatchRequest) Validate() error {
+	if len(p.Schemas) != 1 || p.Schemas[0] != SchemaPatchOp {
+		return InvalidSyntax("schemas must be [%q]", SchemaPatchOp)
+	}
+	if len(p.Operations) == 0 {
+		return InvalidSyntax("at least one operation is required")
+	}
+
+	for i, op := range p.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if len(op.Value) == 0 {
+				return InvalidSyntax("operation %d has no value", i)
+			}
+		case "remove":
+			if op.Path == "" {
+				return NewError(http.StatusBadRequest, "noTarget", "operation %d has no path", i)
+			}
+		default:
+			return InvalidSyntax("operation %d has unknown op %q", i, op.Op)
+		}
+	}
+
+	return nil
+}
+
+// InvalidSyntax is returned for requests that do not follow the protocol.
+func InvalidSyntax(format string, args ...any) *Error {
+	return NewError(http.StatusBadRequest, "invalidSyntax", format, args...)
+}
+
+// invalidPath is returned for paths that do not name a supported attribute.
+func invalidPath(path string) *Error {
+	return NewError(http.StatusBadRequest, "invalidPath", "unsupported attribute path %q", path)
+}
+
+// normalizePath lower cases a path and strips its schema URN. A value filter such as
+// emails[type eq "work"].value is reduced to emails.value, as we store one value of each
+// multi-valued attribute; the filter itself is returned separately.
+func normalizePath(path string) (normalized, valueFilter string) {
+	p := path
+	if strings.HasPrefix(strings.ToLower(p), "urn:") {
+		if i := strings.Index(p, "["); i >= 0 {
+			p = p[strings.LastIndex(p[:i], ":")+1:]
+		} else {
+			p = p[strings.LastIndex(p, ":")+1:]
+		}
+	}
+
+	if open := strings.Index(p, "["); open >= 0 {
+		if close := strings.LastIndex(p, "]"); close > open {
+			valueFilter = p[open+1 : close]
+			p = p[:open] + p[close+1:]
+		}
+	}
+
+	return strings.ToLower(p), valueFilter
+}
+
+// ApplyUserPatch applies the operations to u. Attributes we do not store are rejected
+// rather than silently dropped, so the client knows its view has diverged.
+func ApplyUserPatch(u *User, ops []PatchOperation) error {
+	for _, op := range ops {
+		remove := strings.EqualFold(op.Op, "remove")
+
+		if op.Path == "" {
+			// The value is an object of attributes to add or replace
+			var attributes map[string]json.RawMessage
+			err := json.Unmarshal(op.Value, &attributes)
+			if err != nil {
+				return InvalidSyntax("a value without a path must be an object")
+			}
+			for path, value := range attributes {
+				err := setUserAttribute(u, path, value, false)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		err := setUserAttribute(u, op.Path, op.Value, remove)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setUserAttribute(u *User, path string, value json.RawMessage, remove bool) error {
+	p, _ := normalizePath(path)
+
+	if u.Name == nil {
+		u.Name = &Name{}
+	}
+
+	switch p {
+	case "username":
+		if remove {
+			return NewError(http.StatusBadRequest, "mutability", "userName is required")
+		}
+		return decodeValue(path, value, &u.UserName)
+	case "externalid":
+		return decodeString(path, value, remove, &u.ExternalID)
+	case "displayname":
+		return decodeString(path, value, remove, &u.DisplayName)
+	case "name":
+		if remove {
+			*u.Name = Name{}
+			return nil
+		}
+		return decodeValue(path, value, u.Name)
+	case "name.givenname":
+		return decodeString(path, value, remove, &u.Name.GivenName)
+	case "name.familyname":
+		return decodeString(path, value, remove, &u.Name.FamilyName)
+	case "name.formatted":
+		return decodeString(path, value, remove, &u.Name.Formatted)
+	case "active":
+		if remove {
+			return NewError(http.StatusBadRequest, "mutability", "active cannot be removed")
+		}
+		active, err := decodeBool(path, value)
+		if err != nil {
+			return err
+		}
+		u.Active = &active
+		return nil
+	case "password":
+		return decodeString(path, value, remove, &u.Password)
+	case "emails":
+		return decodeMultiValued(path, value, remove, &u.Emails)
+	case "emails.value":
+		return decodeSingleValue(path, value, remove, &u.Emails)
+	case "phonenumbers":
+		return decodeMultiValued(path, value, remove, &u.PhoneNumbers)
+	case "phonenumbers.value":
+		return decodeSingleValue(path, value, remove, &u.PhoneNumbers)
+	case "addresses":
+		if remove {
+			u.Addresses = nil
+			return nil
+		}
+		var addresses []Address
+		err := decodeOneOrMany(path, value, &addresses)
+		if err != nil {
+			return err
+		}
+		u.Addresses = addresses
+		return nil
+	case "addresses.streetaddress", "addresses.locality", "addresses.region", "addresses.postalcode":
+		if len(u.Addresses) == 0 {
+			u.Addresses = []Address{{Primary: true}}
+		}
+		address := &u.Addresses[PrimaryIndex(len(u.Addresses), func(i int) bool { return u.Addresses[i].Primary })]
+		field := map[string]*string{
+			"addresses.streetaddress": &address.StreetAddress,
+			"addresses.locality":      &address.Locality,
+			"addresses.region":        &address.Region,
+			"addresses.postalcode":    &address.PostalCode,
+		}[p]
+		return decodeString(path, value, remove, field)
+	}
+
+	return invalidPath(path)
+}
+
+// ApplyGroupPatch applies the operations to g. Members can be added, replaced, removed all
+// at once, or removed one by one with a members[value eq "id"] path.
+func ApplyGroupPatch(g *Group, ops []PatchOperation) error {
+	for _, op := range ops {
+		remove := strings.EqualFold(op.Op, "remove")
+		replace := strings.EqualFold(op.Op, "replace")
+
+		if op.Path == "" {
+			var attributes map[string]json.RawMessage
+			err := json.Unmarshal(op.Value, &attributes)
+			if err != nil {
+				return InvalidSyntax("a value without a path must be an object")
+			}
+			for path, value := range attributes {
+				err := setGroupAttribute(g, path, value, false, replace)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		err := setGroupAttribute(g, op.Path, op.Value, remove, replace)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setGroupAttribute(g *Group, path string, value json.RawMessage, remove, replace bool) error {
+	p, valueFilter := normalizePath(path)
+
+	switch p {
+	case "displayname":
+		if remove {
+			return NewError(http.StatusBadRequest, "mutability", "displayName is required")
+		}
+		return decodeValue(path, value, &g.DisplayName)
+	case "externalid":
+		return decodeString(path, value, remove, &g.ExternalID)
+	case "members":
+		if valueFilter != "" {
+			if !remove {
+				return invalidPath(path)
+			}
+			id, err := memberFilterValue(valueFilter)
+			if err != nil {
+				return err
+			}
+			g.Members = removeMembers(g.Members, map[string]bool{id: true})
+			return nil
+		}
+
+		if remove && len(value) == 0 {
+			g.Members = nil
+			return nil
+		}
+
+		var members []MultiValued
+		err := decodeOneOrMany(path, value, &members)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case remove:
+			ids := make(map[string]bool, len(members))
+			for _, m := range members {
+				ids[m.Value] = true
+			}
+			g.Members = removeMembers(g.Members, ids)
+		case replace:
+			g.Members = removeMembers(members, nil)
+		default:
+			g.Members = removeMembers(append(g.Members, members...), nil)
+		}
+		return nil
+	}
+
+	return invalidPath(path)
+}
+
+// memberFilterValue returns the member ID of a value filter of the form value eq "id".
+func memberFilterValue(filter string) (string, error) {
+	f, err := ParseFilter(filter)
+	if err != nil {
+		return "", err
+	}
+
+	c, ok := f.(Comparison)
+	if !ok || !strings.EqualFold(c.Path, "value") || c.Operator != "eq" {
+		return "", InvalidFilter("only members[value eq \"id\"] is supported")
+	}
+	id, ok := c.Value.(string)
+	if !ok {
+		return "", InvalidFilter("member IDs are strings")
+	}
+
+	return id, nil
+}
+
+// removeMembers drops the members whose value is in ids, along with duplicates.
+func removeMembers(members []MultiValued, ids map[string]bool) []MultiValued {
+	seen := make(map[string]bool, len(members))
+	kept := make([]MultiValued, 0, len(members))
+
+	for _, m := range members {
+		if ids[m.Value] || seen[m.Value] {
+			continue
+		}
+		seen[m.Value] = true
+		kept = append(kept, m)
+	}
+
+	return kept
+}
+
+// PrimaryIndex returns the index of the primary entry of a multi-valued attribute of
+// length n, or 0 when none is marked primary.
+func PrimaryIndex(n int, primary func(i int) bool) int {
+	for i := 0; i < n; i++ {
+		if primary(i) {
+			return i
+		}
+	}
+	return 0
+}
+
+func decodeValue(path string, value json.RawMessage, dst any) error {
+	err := json.Unmarshal(value, dst)
+	if err != nil {
+		return InvalidValue("invalid value for %s", path)
+	}
+	return nil
+}
+
+func decodeString(path string, value json.RawMessage, remove bool, dst *string) error {
+	if remove {
+		*dst = ""
+		return nil
+	}
+	return decodeValue(path, value, dst)
+}
+
+// decodeBool accepts JSON booleans and, as some clients send them, the strings "true" and
+// "false" in any case.
+func decodeBool(path string, value json.RawMessage) (bool, error) {
+	var b bool
+	if json.Unmarshal(value, &b) == nil {
+		return b, nil
+	}
+
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		b, err := strconv.ParseBool(strings.ToLower(s))
+		if err == nil {
+			return b, nil
+		}
+	}
+
+	return false, InvalidValue("invalid value for %s", path)
+}
+
+// decodeOneOrMany decodes an array, or a single object as an array of one.
+func decodeOneOrMany[T any](path string, value json.RawMessage, dst *[]T) error {
+	if strings.HasPrefix(strings.TrimSpace(string(value)), "{") {
+		var one T
+		err := decodeValue(path, value, &one)
+		if err != nil {
+			return err
+		}
+		*dst = []T{one}
+		return nil
+	}
+	return decodeValue(path, value, dst)
+}
+
+func decodeMultiValued(path string, value json.RawMessage, remove bool, dst *[]MultiValued) error {
+	if remove {
+		*dst = nil
+		return nil
+	}
+	return decodeOneOrMany(path, value, dst)
+}
+
+// decodeSingleValue sets the value of the primary entry, as in emails[type eq "work"].value.
+func decodeSingleValue(path string, value json.RawMessage, remove bool, dst *[]MultiValued) error {
+	if remove {
+		*dst = nil
+		return nil
+	}
+
+	var v string
+	err := decodeValue(path, value, &v)
+	if err != nil {
+		return err
+	}
+
+	if len(*dst) == 0 {
+		*dst = []MultiValued{{Value: v, Primary: true}}
+		return nil
+	}
+	entries := *dst
+	entries[PrimaryIndex(len(entries), func(i int) bool { return entries[i].Primary })].Value = v
+	return nil
+}
@@ -0,0 +1,241 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func patchOps(t *testing.T, body string) []PatchOperation {
+	t.Helper()
+
+	var request PatchRequest
+	err := json.Unmarshal([]byte(body), &request)
+	if err != nil {
+		t.Fatalf("invalid test body: %v", err)
+	}
+	err = request.Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	return request.Operations
+}
+
+func testUser() *User {
+	active := true
+	return &User{
+		UserName:     "jdoe",
+		Name:         &Name{GivenName: "Jane", FamilyName: "Doe"},
+		Emails:       []MultiValued{{Value: "jane@example.com", Type: "work", Primary: true}},
+		PhoneNumbers: []MultiValued{{Value: "555-0100", Type: "work", Primary: true}},
+		Active:       &active,
+	}
+}
+
+func TestApplyUserPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		check func(t *testing.T, u *User)
+	}{
+		{
+			"replace with path",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"replace","path":"name.givenName","value":"Janet"}]}`,
+			func(t *testing.T, u *User) {
+				if u.Name.GivenName != "Janet" || u.Name.FamilyName != "Doe" {
+					t.Errorf("name = %+v", u.Name)
+				}
+			},
+		},
+		{
+			"deactivate with a string boolean and capitalised op",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"Replace","path":"active","value":"False"}]}`,
+			func(t *testing.T, u *User) {
+				if u.Active == nil || *u.Active {
+					t.Errorf("active = %v, want false", u.Active)
+				}
+			},
+		},
+		{
+			"replace without path",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"replace","value":{"active":false,"name.familyName":"Smith","userName":"jsmith"}}]}`,
+			func(t *testing.T, u *User) {
+				if *u.Active || u.Name.FamilyName != "Smith" || u.UserName != "jsmith" {
+					t.Errorf("user = %+v", u)
+				}
+			},
+		},
+		{
+			"value filter path",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"replace","path":"emails[type eq \"work\"].value","value":"jane.doe@example.com"}]}`,
+			func(t *testing.T, u *User) {
+				if len(u.Emails) != 1 || u.Emails[0].Value != "jane.doe@example.com" {
+					t.Errorf("emails = %+v", u.Emails)
+				}
+			},
+		},
+		{
+			"urn prefixed path",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"add","path":"urn:ietf:params:scim:schemas:core:2.0:User:addresses[type eq \"work\"].locality","value":"Springfield"}]}`,
+			func(t *testing.T, u *User) {
+				if len(u.Addresses) != 1 || u.Addresses[0].Locality != "Springfield" {
+					t.Errorf("addresses = %+v", u.Addresses)
+				}
+			},
+		},
+		{
+			"remove",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"remove","path":"phoneNumbers"},{"op":"remove","path":"name.givenName"}]}`,
+			func(t *testing.T, u *User) {
+				if len(u.PhoneNumbers) != 0 || u.Name.GivenName != "" {
+					t.Errorf("user = %+v", u)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := testUser()
+			err := ApplyUserPatch(u, patchOps(t, tt.body))
+			if err != nil {
+				t.Fatalf("ApplyUserPatch: %v", err)
+			}
+			tt.check(t, u)
+		})
+	}
+}
+
+func TestApplyUserPatchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		scimType string
+	}{
+		{"unknown attribute", `[{"op":"replace","path":"nickName","value":"JD"}]`, "invalidPath"},
+		{"read-only groups", `[{"op":"add","path":"groups","value":[{"value":"1"}]}]`, "invalidPath"},
+		{"remove userName", `[{"op":"remove","path":"userName"}]`, "mutability"},
+		{"wrong type", `[{"op":"replace","path":"active","value":"maybe"}]`, "invalidValue"},
+		{"value not an object", `[{"op":"replace","value":"x"}]`, "invalidSyntax"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []PatchOperation
+			err := json.Unmarshal([]byte(tt.body), &ops)
+			if err != nil {
+				t.Fatalf("invalid test body: %v", err)
+			}
+
+			err = ApplyUserPatch(testUser(), ops)
+			scimErr, ok := err.(*Error)
+			if !ok || scimErr.ScimType != tt.scimType {
+				t.Errorf("error = %#v, want scimType %s", err, tt.scimType)
+			}
+		})
+	}
+}
+
+func TestPatchRequestValidate(t *testing.T) {
+	tests := []string{
+		`{"schemas":["urn:ietf:params:scim:api:messages:2.0:Other"],"Operations":[{"op":"add","path":"userName","value":"x"}]}`,
+		`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[]}`,
+		`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"move","path":"userName"}]}`,
+		`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"add","path":"userName"}]}`,
+		`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"remove"}]}`,
+	}
+
+	for _, body := range tests {
+		var request PatchRequest
+		err := json.Unmarshal([]byte(body), &request)
+		if err != nil {
+			t.Fatalf("invalid test body: %v", err)
+		}
+		if request.Validate() == nil {
+			t.Errorf("Validate(%s) succeeded, want an error", body)
+		}
+	}
+}
+
+func TestApplyGroupPatch(t *testing.T) {
+	group := func() *Group {
+		return &Group{DisplayName: "Engineering", Members: []MultiValued{{Value: "1"}, {Value: "2"}}}
+	}
+	members := func(g *Group) []string {
+		var ids []string
+		for _, m := range g.Members {
+			ids = append(ids, m.Value)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			"add members, ignoring duplicates",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"add","path":"members","value":[{"value":"2"},{"value":"3"}]}]}`,
+			[]string{"1", "2", "3"},
+		},
+		{
+			"remove member by value filter",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"remove","path":"members[value eq \"1\"]"}]}`,
+			[]string{"2"},
+		},
+		{
+			"remove members by value",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"Remove","path":"members","value":[{"value":"2"}]}]}`,
+			[]string{"1"},
+		},
+		{
+			"replace members",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"replace","path":"members","value":[{"value":"5"}]}]}`,
+			[]string{"5"},
+		},
+		{
+			"remove all members",
+			`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"remove","path":"members"}]}`,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := group()
+			err := ApplyGroupPatch(g, patchOps(t, tt.body))
+			if err != nil {
+				t.Fatalf("ApplyGroupPatch: %v", err)
+			}
+
+			got := members(g)
+			if len(got) != len(tt.want) {
+				t.Fatalf("members = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("members = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	g := group()
+	err := ApplyGroupPatch(g, patchOps(t, `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+		{"op":"replace","value":{"displayName":"Platform"}}]}`))
+	if err != nil || g.DisplayName != "Platform" {
+		t.Errorf("rename: %v, displayName = %q", err, g.DisplayName)
+	}
+}
@@ -0,0 +1,204 @@
+// Package scim implements the parts of SCIM 2.0 (RFC 7643 and RFC 7644) that user-service
+// needs to let customers' HR systems provision users and groups: the User and Group
+// resources mapped onto our own columns, filter expressions, and PATCH operations.
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Schema URNs.
+const (
+	SchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaSPConfig     = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// Pagination limits for list requests.
+const (
+	DefaultCount = 100
+	MaxCount     = 200
+)
+
+// Error is a SCIM error response (RFC 7644 section 3.12).
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// StatusCode returns the HTTP status of the error.
+func (e *Error) StatusCode() int {
+	code, err := strconv.Atoi(e.Status)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return code
+}
+
+// NewError returns an error with the HTTP status, optional scimType and detail.
+func NewError(status int, scimType, format string, args ...any) *Error {
+	return &Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   fmt.Sprintf(format, args...),
+	}
+}
+
+// InvalidValue is returned for attribute values that cannot be stored.
+func InvalidValue(format string, args ...any) *Error {
+	return NewError(http.StatusBadRequest, "invalidValue", format, args...)
+}
+
+// Meta is the metadata of a resource. Version is a weak ETag.
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version"`
+}
+
+// ETag returns the weak entity tag of a resource last modified at t.
+func ETag(t time.Time) string {
+	return fmt.Sprintf(`W/"%d"`, t.UnixMicro())
+}
+
+// Name is a user's name.
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// MultiValued is an entry of a multi-valued attribute such as emails.
+type MultiValued struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// Address is a postal address.
+type Address struct {
+	Type          string `json:"type,omitempty"`
+	StreetAddress string `json:"streetAddress,omitempty"`
+	Locality      string `json:"locality,omitempty"`
+	Region        string `json:"region,omitempty"`
+	PostalCode    string `json:"postalCode,omitempty"`
+	Primary       bool   `json:"primary,omitempty"`
+}
+
+// User is the SCIM User resource. Each users row stores a single email, phone number and
+// address, so only the primary (or first) entry of those attributes is kept.
+type User struct {
+	Schemas      []string      `json:"schemas"`
+	ID           string        `json:"id,omitempty"`
+	ExternalID   string        `json:"externalId,omitempty"`
+	UserName     string        `json:"userName"`
+	Name         *Name         `json:"name,omitempty"`
+	DisplayName  string        `json:"displayName,omitempty"`
+	Emails       []MultiValued `json:"emails,omitempty"`
+	PhoneNumbers []MultiValued `json:"phoneNumbers,omitempty"`
+	Addresses    []Address     `json:"addresses,omitempty"`
+	Active       *bool         `json:"active,omitempty"`
+	Password     string        `json:"password,omitempty"`
+	Groups       []MultiValued `json:"groups,omitempty"`
+	Meta         *Meta         `json:"meta,omitempty"`
+}
+
+// Group is the SCIM Group resource.
+type Group struct {
+	Schemas     []string      `json:"schemas"`
+	ID          string        `json:"id,omitempty"`
+	ExternalID  string        `json:"externalId,omitempty"`
+	DisplayName string        `json:"displayName"`
+	Members     []MultiValued `json:"members,omitempty"`
+	Meta        *Meta         `json:"meta,omitempty"`
+}
+
+// ListResponse is the result of a query.
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// NewListResponse wraps one page of resources.
+func NewListResponse(resources []any, total, startIndex int) *ListResponse {
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// Pagination parses the 1-based startIndex and count query parameters.
+func Pagination(startIndexParam, countParam string) (startIndex, count int, err error) {
+	startIndex, count = 1, DefaultCount
+
+	if startIndexParam != "" {
+		startIndex, err = strconv.Atoi(startIndexParam)
+		if err != nil {
+			return 0, 0, NewError(http.StatusBadRequest, "invalidValue", "startIndex must be an integer")
+		}
+		if startIndex < 1 {
+			startIndex = 1
+		}
+	}
+
+	if countParam != "" {
+		count, err = strconv.Atoi(countParam)
+		if err != nil {
+			return 0, 0, NewError(http.StatusBadRequest, "invalidValue", "count must be an integer")
+		}
+		if count < 0 {
+			count = 0
+		}
+		if count > MaxCount {
+			count = MaxCount
+		}
+	}
+
+	return startIndex, count, nil
+}
+
+// ServiceProviderConfig describes what this implementation supports.
+func ServiceProviderConfig() map[string]any {
+	supported := func(v bool) map[string]any { return map[string]any{"supported": v} }
+
+	return map[string]any{
+		"schemas":        []string{SchemaSPConfig},
+		"patch":          supported(true),
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": MaxCount},
+		"changePassword": supported(true),
+		"sort":           supported(false),
+		"etag":           supported(true),
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "A per-tenant SCIM token issued by an administrator",
+			"primary":     true,
+		}},
+	}
+}
@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for the local store, for the user-service container in project/docker-compose.yaml.
+const (
+	defaultLocalDir = "/data/objects"
+	defaultLocalURL = "http://localhost:8081/objects"
+)
+
+// Local keeps objects as files under Dir. It serves them itself: signed URLs point to
+// BaseURL, where the service has to mount Local as a handler, and are signed with an HMAC
+// of the key and expiry time.
+type Local struct {
+	Dir     string
+	BaseURL string
+	Secret  []byte
+
+	now func() time.Time
+}
+
+// LocalFromEnv reads the local store's settings: OBJECT_STORE_DIR, OBJECT_STORE_URL and
+// OBJECT_STORE_SECRET. Without a secret, signed URLs stop working when the service
+// restarts, and only work on the instance that made them.
+func LocalFromEnv() (*Local, error) {
+	l := &Local{
+		Dir:     os.Getenv("OBJECT_STORE_DIR"),
+		BaseURL: strings.TrimSuffix(os.Getenv("OBJECT_STORE_URL"), "/"),
+		Secret:  []byte(os.Getenv("OBJECT_STORE_SECRET")),
+	}
+	if l.Dir == "" {
+		l.Dir = defaultLocalDir
+	}
+	if l.BaseURL == "" {
+		l.BaseURL = defaultLocalURL
+	}
+
+	u, err := url.Parse(l.BaseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path == "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("invalid OBJECT_STORE_URL %q", l.BaseURL)
+	}
+
+	if len(l.Secret) == 0 {
+		log.Println("OBJECT_STORE_SECRET is not set, signed URLs will not survive a restart")
+		l.Secret = make([]byte, 32)
+		if _, err := rand.Read(l.Secret); err != nil {
+			return nil, err
+		}
+	}
+
+	err = os.MkdirAll(l.Dir, 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OBJECT_STORE_DIR: %w", err)
+	}
+
+	return l, nil
+}
+
+// PathPrefix is the path of BaseURL, which the service has to serve the store under.
+func (l *Local) PathPrefix() string {
+	u, err := url.Parse(l.BaseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+func (l *Local) path(key string) string {
+	return filepath.Join(l.Dir, filepath.FromSlash(key))
+}
+
+// Put writes the object to a temporary file first, so readers never see half an object.
+func (l *Local) Put(ctx context.Context, key, contentType string, body io.Reader, size int64) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	path := l.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, io.LimitReader(body, size+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("object %s is %d bytes, not %d", key, n, size)
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Get returns the object with the content type its key's extension stands for.
+func (l *Local) Get(ctx context.Context, key string) (*Object, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(l.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &Object{Body: f, ContentType: contentType, Size: info.Size()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	err := os.Remove(l.path(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) SignedURL(key string, ttl time.Duration) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	if err := checkTTL(ttl); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(l.clock().Add(ttl).Unix(), 10)
+	query := url.Values{"expires": {expires}, "signature": {l.signature(key, expires)}}
+
+	return l.BaseURL + "/" + key + "?" + query.Encode(), nil
+}
+
+// ServeHTTP serves objects to holders of signed URLs. Mount it with the path prefix of
+// BaseURL stripped.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	expires := r.URL.Query().Get("expires")
+	signature, err := hex.DecodeString(r.URL.Query().Get("signature"))
+	if err != nil || checkKey(key) != nil || !hmac.Equal(signature, l.mac(key, expires)) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || l.clock().Unix() > expiry {
+		http.Error(w, "link expired", http.StatusForbidden)
+		return
+	}
+
+	object, err := l.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("Failed to read object %s: %v", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer object.Body.Close()
+
+	w.Header().Set("Content-Type", object.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", max(expiry-l.clock().Unix(), 0)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	_, err = io.Copy(w, object.Body)
+	if err != nil {
+		log.Printf("Failed to send object %s: %v", key, err)
+	}
+}
+
+func (l *Local) mac(key, expires string) []byte {
+	mac := hmac.New(sha256.New, l.Secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return mac.Sum(nil)
+}
+
+func (l *Local) signature(key, expires string) string {
+	return hex.EncodeToString(l.mac(key, expires))
+}
+
+func (l *Local) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
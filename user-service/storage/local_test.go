@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStore(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	l := &Local{
+		Dir:     t.TempDir(),
+		BaseURL: "http://example.com/objects",
+		Secret:  []byte("secret"),
+		now:     func() time.Time { return now },
+	}
+	ctx := context.Background()
+
+	err := l.Put(ctx, "avatars/1/a.png", "image/png", strings.NewReader("png"), 3)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	object, err := l.Get(ctx, "avatars/1/a.png")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	body, _ := io.ReadAll(object.Body)
+	object.Body.Close()
+	if string(body) != "png" || object.ContentType != "image/png" || object.Size != 3 {
+		t.Errorf("Get = %q, %s, %d", body, object.ContentType, object.Size)
+	}
+
+	err = l.Put(ctx, "avatars/1/b.png", "image/png", strings.NewReader("short"), 10)
+	if err == nil {
+		t.Error("expected an error for a short body")
+	}
+	_, err = l.Get(ctx, "avatars/1/b.png")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("a failed Put left an object behind: %v", err)
+	}
+
+	for _, key := range []string{"../a.png", "avatars/../../a.png", "/etc/passwd", "avatars/.hidden", ""} {
+		if err := l.Put(ctx, key, "image/png", strings.NewReader("x"), 1); err == nil {
+			t.Errorf("Put(%q) should fail", key)
+		}
+	}
+
+	signed, err := l.SignedURL("avatars/1/a.png", time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	if !strings.HasPrefix(signed, "http://example.com/objects/avatars/1/a.png?") {
+		t.Fatalf("signed URL = %s", signed)
+	}
+	path := strings.TrimPrefix(signed, "http://example.com/objects")
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		l.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := serve(path)
+	if rec.Code != http.StatusOK || rec.Body.String() != "png" || rec.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("signed URL gave %d %q %s", rec.Code, rec.Body, rec.Header().Get("Content-Type"))
+	}
+
+	// The signature covers the key
+	if rec := serve(strings.Replace(path, "a.png", "b.png", 1)); rec.Code != http.StatusForbidden {
+		t.Errorf("another key gave %d, want 403", rec.Code)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if rec := serve(path); rec.Code != http.StatusForbidden {
+		t.Errorf("expired URL gave %d, want 403", rec.Code)
+	}
+
+	err = l.Delete(ctx, "avatars/1/a.png")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, err = l.Get(ctx, "avatars/1/a.png")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := l.Delete(ctx, "avatars/1/a.png"); err != nil {
+		t.Errorf("deleting a missing object: %v", err)
+	}
+}
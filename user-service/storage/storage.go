@@ -0,0 +1,85 @@
+// Package storage keeps files such as avatars in an object store: a local directory or an
+// S3-compatible bucket. Clients fetch objects through signed URLs that expire.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"time"
+)
+
+// MaxSignedURLTTL is the longest a signed URL can be valid for, which is the limit S3 sets.
+const MaxSignedURLTTL = 7 * 24 * time.Hour
+
+// ErrNotFound is returned for objects that do not exist.
+var ErrNotFound = errors.New("object not found")
+
+// keyPattern limits keys to slash-separated segments of letters, digits, dots, dashes and
+// underscores that do not start with a dot, so they need no escaping and cannot leave the
+// store's directory.
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`)
+
+// Object is an object read from a store. The caller must close Body.
+type Object struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+}
+
+// ObjectStore stores objects by key.
+type ObjectStore interface {
+	// Put stores size bytes from body under key, replacing any object already there.
+	Put(ctx context.Context, key, contentType string, body io.Reader, size int64) error
+
+	// Get returns the object stored under key, or ErrNotFound.
+	Get(ctx context.Context, key string) (*Object, error)
+
+	// Delete removes the object stored under key. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// SignedURL returns a URL anyone can fetch the object with until ttl has passed.
+	SignedURL(key string, ttl time.Duration) (string, error)
+}
+
+// FromEnv returns the object store set in OBJECT_STORE: "local" (the default) or "s3".
+func FromEnv() (ObjectStore, error) {
+	switch kind := os.Getenv("OBJECT_STORE"); kind {
+	case "", "local":
+		store, err := LocalFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Object store: local directory %s", store.Dir)
+		return store, nil
+
+	case "s3":
+		store, err := S3FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Object store: S3 bucket %s at %s", store.Bucket, store.Endpoint)
+		return store, nil
+
+	default:
+		return nil, fmt.Errorf("unknown OBJECT_STORE %q", kind)
+	}
+}
+
+func checkKey(key string) error {
+	if !keyPattern.MatchString(key) {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	return nil
+}
+
+func checkTTL(ttl time.Duration) error {
+	if ttl < time.Second || ttl > MaxSignedURLTTL {
+		return fmt.Errorf("signed URLs must be valid for between a second and %s", MaxSignedURLTTL)
+	}
+	return nil
+}